@@ -3,6 +3,7 @@ package main
 import (
 	"C"
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -109,10 +110,20 @@ func FLBPluginFlushCtx(ctx, data unsafe.Pointer, length C.int, tag *C.char) int
 			continue
 		}
 
-		record := domain.NewLog(logData)
+		record := domain.NewRecord(cfg.RecordType, logData)
 
 		err := rcv.Write(record)
 
+		if errors.Is(err, receiver.ErrBackpressure) {
+			slog.Warn("Buffer is full, asking fluent-bit to retry", "error", err)
+			return output.FLB_RETRY
+		}
+
+		if errors.Is(err, receiver.ErrSchemaViolation) {
+			slog.Warn("Record does not match the schema, skipping", "error", err)
+			continue
+		}
+
 		if err != nil {
 			slog.Error("Error writing record", "error", err)
 			return output.FLB_ERROR
@@ -20,8 +20,15 @@ type Buffer interface {
 	GetDLQ() (map[string][]domain.Record, error)
 	ClearDLQ() error
 	Get(key string) []domain.Record
+	// Take moves up to BufferSize records of key to in-flight like Get, returning how many were taken, so they can
+	// be read with Page without being decoded all at once.
+	Take(key string) int
+	Page(key string, offset int, size int) []domain.Record
 	Clear(key string, size int) error
+	Rollback(key string) error
 	Len(key string) int
+	Size(key string) int64
+	TotalSize() int64
 	Keys() []string
 	IsReady() bool
 	HasRecovery() bool
@@ -40,6 +47,18 @@ func New(ctx context.Context, cfg *config.Config) Buffer {
 			slog.Error("Error creating Redis buffer, using memory buffer instead")
 			ret = NewMem(ctx, cfg)
 		}
+	case config.BufferTypeRedisStream:
+		ret = NewRedisStream(ctx, cfg, nil)
+		if ret == nil {
+			slog.Error("Error creating Redis stream buffer, using memory buffer instead")
+			ret = NewMem(ctx, cfg)
+		}
+	case config.BufferTypeDisk:
+		ret = NewDisk(ctx, cfg)
+		if ret == nil {
+			slog.Error("Error creating Disk buffer, using memory buffer instead")
+			ret = NewMem(ctx, cfg)
+		}
 	case config.BufferTypeMem:
 		ret = NewMem(ctx, cfg)
 	default:
@@ -49,6 +68,11 @@ func New(ctx context.Context, cfg *config.Config) Buffer {
 	return ret
 }
 
+// RecordSize returns the approximate encoded size of a record, used to account buffer bytes.
+func RecordSize(item domain.Record) int64 {
+	return int64(len(item.ToMsgPack()))
+}
+
 type RecoveryData struct {
 	Key       string    `msg:"key"`
 	Data      []byte    `msg:"data"`
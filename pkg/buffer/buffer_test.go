@@ -9,6 +9,8 @@ import (
 	"fmt"
 	"log"
 	"math/rand"
+	"os"
+	"path/filepath"
 	"runtime/debug"
 	"testing"
 	"time"
@@ -48,6 +50,25 @@ func PrepareConfigRedis() *config.Config {
 	return ret
 }
 
+func PrepareConfigDisk(path string) *config.Config {
+	ret := &config.Config{}
+
+	err := ret.Set(map[string]string{
+		"RecordType":      config.RecordTypeLog,
+		"BufferType":      config.BufferTypeDisk,
+		"BufferSize":      fmt.Sprintf("%d", bfSize),
+		"DiskPath":        path,
+		"DiskSegmentSize": "65536",
+		"DiskSyncPolicy":  config.DiskSyncPolicyInterval,
+	})
+
+	if err != nil {
+		log.Fatalf("Error setting config: %s", err)
+	}
+
+	return ret
+}
+
 func TestMem(t *testing.T) {
 	cfg := PrepareConfigMem()
 	buf := buffer.New(context.Background(), cfg)
@@ -59,6 +80,52 @@ func TestMem(t *testing.T) {
 	testBuffer(buf, t)
 }
 
+func TestMemSize(t *testing.T) {
+	cfg := PrepareConfigMem()
+	buf := buffer.New(context.Background(), cfg)
+	key := "size-test"
+	data := generateData(10)
+
+	var expected int64
+
+	for _, item := range data {
+		expected += buffer.RecordSize(item)
+		_, err := buf.Push(key, item)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if buf.Size(key) != expected || buf.TotalSize() != expected {
+		t.Errorf("Buffer size should be %d, got %d (total %d)", expected, buf.Size(key), buf.TotalSize())
+	}
+
+	err := buf.Clear(key, 4)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, item := range data[:4] {
+		expected -= buffer.RecordSize(item)
+	}
+
+	if buf.Size(key) != expected || buf.TotalSize() != expected {
+		t.Errorf("Buffer size after clear should be %d, got %d (total %d)", expected, buf.Size(key), buf.TotalSize())
+	}
+
+	err = buf.Clear(key, -1)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if buf.Size(key) != 0 || buf.TotalSize() != 0 {
+		t.Errorf("Buffer size should be zero after clearing all records, got %d (total %d)", buf.Size(key), buf.TotalSize())
+	}
+}
+
 func TestRedis(t *testing.T) {
 	cfg := PrepareConfigRedis()
 
@@ -95,6 +162,764 @@ func TestRedis(t *testing.T) {
 	testBuffer(buf, t)
 }
 
+func TestDisk(t *testing.T) {
+	cfg := PrepareConfigDisk(t.TempDir())
+	buf := buffer.New(context.Background(), cfg)
+
+	if _, ok := buf.(*buffer.Disk); !ok {
+		t.Fatal("Buffer is not a disk buffer")
+	}
+
+	testBuffer(buf, t)
+
+	err := buf.Close()
+
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDiskReplay(t *testing.T) {
+	cfg := PrepareConfigDisk(t.TempDir())
+	buf := buffer.NewDisk(context.Background(), cfg)
+
+	if buf == nil {
+		t.Fatal("Buffer is nil")
+	}
+
+	if buffer.NewDisk(context.Background(), cfg) != nil {
+		t.Error("Disk buffer directory must be locked by the first instance")
+	}
+
+	key := "replay"
+	data := generateData(500)
+
+	for _, record := range data {
+		_, err := buf.Push(key, record)
+
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	err := buf.Clear(key, 200)
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = buf.Close()
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	buf = buffer.NewDisk(context.Background(), cfg)
+
+	if buf == nil {
+		t.Fatal("Buffer is nil after reopen")
+	}
+
+	defer buf.Close()
+
+	if buf.Len(key) != 300 {
+		t.Errorf("Replayed buffer length is %d, expected 300", buf.Len(key))
+	}
+
+	result := buf.Get(key)
+
+	for i, record := range result {
+		if data[i+200].ToJson() != record.ToJson() {
+			t.Errorf("Replayed record %d is not equal to source", i)
+			break
+		}
+	}
+
+	err = buf.Clear(key, -1)
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	if buf.Len(key) != 0 {
+		t.Errorf("Buffer length is %d after clear, expected 0", buf.Len(key))
+	}
+}
+
+func TestDiskTornHeader(t *testing.T) {
+	dir := t.TempDir()
+	cfg := PrepareConfigDisk(dir)
+	buf := buffer.NewDisk(context.Background(), cfg)
+
+	if buf == nil {
+		t.Fatal("Buffer is nil")
+	}
+
+	key := "torn"
+
+	for _, record := range generateData(10) {
+		_, err := buf.Push(key, record)
+
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	err := buf.Close()
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	segments, err := filepath.Glob(filepath.Join(dir, "data", "*", "*.seg"))
+
+	if err != nil || len(segments) == 0 {
+		t.Fatalf("No disk buffer segment found: %v", err)
+	}
+
+	last := segments[len(segments)-1]
+	info, err := os.Stat(last)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.OpenFile(last, os.O_APPEND|os.O_WRONLY, 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A header with a length of almost 4 GiB and a few bytes of payload
+	_, err = file.Write([]byte{0xf0, 0xff, 0xff, 0xff, 0, 0, 0, 0, 1, 2, 3})
+	file.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf = buffer.NewDisk(context.Background(), cfg)
+
+	if buf == nil {
+		t.Fatal("Buffer is nil after reopen")
+	}
+
+	defer buf.Close()
+
+	if buf.Len(key) != 10 {
+		t.Errorf("Replayed buffer length is %d, expected 10", buf.Len(key))
+	}
+
+	truncated, err := os.Stat(last)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if truncated.Size() != info.Size() {
+		t.Errorf("Segment size is %d after replay, expected the torn tail to be truncated to %d", truncated.Size(), info.Size())
+	}
+}
+
+func startRedis(t *testing.T) string {
+	ctx := context.Background()
+	req := testcontainers.ContainerRequest{
+		Image:        "redis:latest",
+		ExposedPorts: []string{"6379/tcp"},
+		WaitingFor:   wait.ForLog("Ready to accept connections"),
+	}
+	redisC, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: req,
+		Started:          true,
+	})
+	if err != nil {
+		t.Fatalf("Could not start redis: %s", err)
+	}
+
+	t.Cleanup(func() {
+		if err := redisC.Terminate(ctx); err != nil {
+			t.Errorf("Could not stop redis: %s", err)
+		}
+	})
+
+	endpoint, err := redisC.Endpoint(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return endpoint
+}
+
+func TestRedisStream(t *testing.T) {
+	cfg := PrepareConfigRedis()
+	cfg.BufferType = config.BufferTypeRedisStream
+	endpoint := startRedis(t)
+
+	client := redis.NewClient(&redis.Options{
+		Addr: endpoint,
+	})
+
+	buf := buffer.NewRedisStream(context.Background(), cfg, client)
+
+	testBuffer(buf, t)
+}
+
+func TestRedisStreamConsumers(t *testing.T) {
+	cfg := PrepareConfigRedis()
+	cfg.BufferType = config.BufferTypeRedisStream
+	cfg.RedisStreamClaimIdle = 1
+	endpoint := startRedis(t)
+
+	bufA := buffer.NewRedisStream(context.Background(), cfg, redis.NewClient(&redis.Options{Addr: endpoint}))
+	bufB := buffer.NewRedisStream(context.Background(), cfg, redis.NewClient(&redis.Options{Addr: endpoint}))
+
+	if bufA == nil || bufB == nil {
+		t.Fatal("Buffer is nil")
+	}
+
+	key := "shared"
+
+	for _, record := range generateData(100) {
+		_, err := bufA.Push(key, record)
+
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	if got := len(bufA.Get(key)); got != 100 {
+		t.Errorf("First consumer got %d records, expected 100", got)
+	}
+
+	if got := len(bufB.Get(key)); got != 0 {
+		t.Errorf("Second consumer got %d records already delivered to the first one", got)
+	}
+
+	time.Sleep(1500 * time.Millisecond)
+
+	if got := len(bufB.Get(key)); got != 100 {
+		t.Errorf("Second consumer claimed %d idle records, expected 100", got)
+	}
+
+	err := bufB.Clear(key, -1)
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	if bufA.Len(key) != 0 {
+		t.Errorf("Stream length is %d after acknowledge, expected 0", bufA.Len(key))
+	}
+
+	// The first consumer clears entries already acknowledged by the second one
+	if err = bufA.Clear(key, -1); err != nil {
+		t.Error(err)
+	}
+
+	if size := bufA.Size(key); size != 0 {
+		t.Errorf("Stream size is %d after acknowledge, expected 0", size)
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: endpoint})
+	// Newer servers reply more consumer fields than XInfoConsumers parses
+	consumers, err := client.Do(context.Background(), "XINFO", "CONSUMERS", fmt.Sprintf("%s:{%s}", cfg.RedisStreamPrefix, key), cfg.RedisStreamGroup).Slice()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(consumers) != 1 {
+		t.Errorf("Group has %d consumers, the idle one without pending entries must be pruned", len(consumers))
+	}
+}
+
+func TestRedisStreamInvalidEntries(t *testing.T) {
+	cfg := PrepareConfigRedis()
+	cfg.BufferType = config.BufferTypeRedisStream
+	endpoint := startRedis(t)
+	client := redis.NewClient(&redis.Options{Addr: endpoint})
+
+	buf := buffer.NewRedisStream(context.Background(), cfg, client)
+
+	if buf == nil {
+		t.Fatal("Buffer is nil")
+	}
+
+	key := "invalid"
+
+	for _, record := range generateData(10) {
+		if _, err := buf.Push(key, record); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// An entry that can not be decoded, counted on the size as pushed ones
+	stream := fmt.Sprintf("%s:{%s}", cfg.RedisStreamPrefix, key)
+	client.XAdd(context.Background(), &redis.XAddArgs{Stream: stream, Values: map[string]interface{}{"data": "invalid"}})
+	client.IncrBy(context.Background(), fmt.Sprintf("%s:bytes:{%s}", cfg.RedisStreamPrefix, key), int64(len("invalid")))
+
+	if got := buf.Take(key); got != 11 {
+		t.Fatalf("Take returned %d records, expected 11", got)
+	}
+
+	if got := len(buf.Page(key, 0, 11)); got != 10 {
+		t.Errorf("Page returned %d records, expected 10 without the invalid entry", got)
+	}
+
+	if err := buf.Clear(key, 11); err != nil {
+		t.Error(err)
+	}
+
+	if size := buf.Size(key); size != 0 {
+		t.Errorf("Stream size is %d after clearing an invalid entry, expected 0", size)
+	}
+}
+
+func TestRedisStreamDeletedEntries(t *testing.T) {
+	cfg := PrepareConfigRedis()
+	cfg.BufferType = config.BufferTypeRedisStream
+	endpoint := startRedis(t)
+	client := redis.NewClient(&redis.Options{Addr: endpoint})
+
+	buf := buffer.NewRedisStream(context.Background(), cfg, client)
+
+	if buf == nil {
+		t.Fatal("Buffer is nil")
+	}
+
+	key := "deleted"
+
+	for _, record := range generateData(10) {
+		_, err := buf.Push(key, record)
+
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	if got := buf.Take(key); got != 10 {
+		t.Fatalf("Take returned %d records, expected 10", got)
+	}
+
+	stream := fmt.Sprintf("%s:{%s}", cfg.RedisStreamPrefix, key)
+	entries, err := client.XRange(context.Background(), stream, "-", "+").Result()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Entries deleted while pending are read back without values
+	for _, entry := range entries[:4] {
+		client.XDel(context.Background(), stream, entry.ID)
+	}
+
+	if got := buf.Take(key); got != 6 {
+		t.Errorf("Take returned %d records after deleting 4 pending entries, expected 6", got)
+	}
+
+	pending, err := client.XPending(context.Background(), stream, cfg.RedisStreamGroup).Result()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pending.Count != 6 {
+		t.Errorf("%d entries are pending, deleted entries must be acknowledged", pending.Count)
+	}
+}
+
+func TestRedisClientConfig(t *testing.T) {
+	cfg := PrepareConfigRedis()
+	cfg.RedisMode = config.RedisModeSentinel
+
+	if buffer.NewRedis(context.Background(), cfg, nil) != nil {
+		t.Error("Sentinel mode without a master name must fail")
+	}
+
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+
+	err := os.WriteFile(ca, []byte("not a certificate"), 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg = PrepareConfigRedis()
+	cfg.RedisTLS = true
+	cfg.RedisTLSCAPath = ca
+
+	if buffer.NewRedis(context.Background(), cfg, nil) != nil {
+		t.Error("TLS with a CA file without certificates must fail")
+	}
+}
+
+func TestRedisScan(t *testing.T) {
+	cfg := PrepareConfigRedis()
+	cfg.RedisScanCount = 2
+	endpoint := startRedis(t)
+	client := redis.NewClient(&redis.Options{Addr: endpoint})
+
+	buf := buffer.NewRedis(context.Background(), cfg, client)
+
+	if buf == nil {
+		t.Fatal("Buffer is nil")
+	}
+
+	data := generateData(25)
+
+	for i, record := range data {
+		err := buf.PushDLQ(fmt.Sprintf("dlq-%d", i), record)
+
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	for i := 0; i < 5; i++ {
+		err := buf.PushRecovery(fmt.Sprintf("recovery-%d", i), bytes.NewBufferString("data"))
+
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	dlq, err := buf.GetDLQ()
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(dlq) != len(data) {
+		t.Errorf("GetDLQ returned %d keys, expected %d scanned in pages of 2", len(dlq), len(data))
+	}
+
+	recovery, err := buf.GetRecovery()
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(recovery) != 5 {
+		t.Errorf("GetRecovery returned %d keys, expected 5", len(recovery))
+	}
+
+	// Keys carry the record key as a hash tag, to be on the same cluster slot
+	if n := client.Exists(context.Background(), fmt.Sprintf("%s:{dlq-0}", cfg.RedisDLQPrefix)).Val(); n != 1 {
+		t.Error("DLQ key must use the record key as a hash tag")
+	}
+
+	err = buf.ClearDLQ()
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	if keys := client.Keys(context.Background(), cfg.RedisDLQPrefix+":*").Val(); len(keys) != 0 {
+		t.Errorf("%d DLQ keys remain after clear", len(keys))
+	}
+}
+
+func TestRedisLegacyKeys(t *testing.T) {
+	cfg := PrepareConfigRedis()
+	endpoint := startRedis(t)
+	client := redis.NewClient(&redis.Options{Addr: endpoint})
+	ctx := context.Background()
+	key := "legacy"
+	data := generateData(10)
+
+	// Lists written before keys had a hash tag, as prefix:key
+	client.SAdd(ctx, cfg.RedisKeys, key)
+
+	for _, record := range data[:5] {
+		client.RPush(ctx, fmt.Sprintf("%s:%s", cfg.RedisDataPrefix, key), record.ToMsgPack())
+	}
+
+	client.RPush(ctx, fmt.Sprintf("%s:%s", cfg.RedisDLQPrefix, key), data[5].ToMsgPack())
+	client.LPush(ctx, fmt.Sprintf("%s:%s", cfg.RedisRecoveryKey, key), (&buffer.RecoveryData{Key: key, Data: []byte("data")}).ToMsgPack())
+
+	buf := buffer.NewRedis(ctx, cfg, client)
+
+	if buf == nil {
+		t.Fatal("Buffer is nil")
+	}
+
+	for _, record := range data[6:] {
+		if _, err := buf.Push(key, record); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if buf.Len(key) != 9 {
+		t.Errorf("Buffer length is %d, expected 9 with the legacy records", buf.Len(key))
+	}
+
+	if buf.Size(key) <= 0 {
+		t.Error("Size must count the legacy records")
+	}
+
+	records := buf.Get(key)
+
+	if len(records) != 9 || records[0].Key() != data[0].Key() || records[5].Key() != data[6].Key() {
+		t.Errorf("Legacy records must be delivered first, in order, got %d records", len(records))
+	}
+
+	dlq, err := buf.GetDLQ()
+
+	if err != nil || len(dlq) != 1 {
+		t.Errorf("GetDLQ returned %d keys, expected the legacy one: %v", len(dlq), err)
+	}
+
+	recovery, err := buf.GetRecovery()
+
+	if err != nil || len(recovery) != 1 {
+		t.Errorf("GetRecovery returned %d items, expected the legacy one: %v", len(recovery), err)
+	}
+
+	for _, prefix := range []string{cfg.RedisDataPrefix, cfg.RedisDLQPrefix, cfg.RedisRecoveryKey} {
+		if n := client.Exists(ctx, fmt.Sprintf("%s:%s", prefix, key)).Val(); n != 0 {
+			t.Errorf("Legacy key %s:%s must be removed", prefix, key)
+		}
+	}
+}
+
+func TestRedisACLUser(t *testing.T) {
+	endpoint := startRedis(t)
+	client := redis.NewClient(&redis.Options{Addr: endpoint})
+
+	err := client.Do(context.Background(), "ACL", "SETUSER", "writer", "on", ">secret", "~*", "&*", "+@all").Err()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := PrepareConfigRedis()
+	cfg.RedisHost = endpoint
+	cfg.RedisUsername = "writer"
+	cfg.RedisPassword = "secret"
+
+	buf := buffer.NewRedis(context.Background(), cfg, nil)
+
+	if buf == nil {
+		t.Fatal("Buffer is nil with a valid ACL user")
+	}
+
+	_, err = buf.Push("acl", generateData(1)[0])
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	buf.Close()
+
+	cfg.RedisPassword = "wrong"
+
+	if buffer.NewRedis(context.Background(), cfg, nil) != nil {
+		t.Error("Buffer must not be ready with a wrong ACL password")
+	}
+}
+
+func TestRedisInflight(t *testing.T) {
+	cfg := PrepareConfigRedis()
+	endpoint := startRedis(t)
+
+	buf := buffer.NewRedis(context.Background(), cfg, redis.NewClient(&redis.Options{Addr: endpoint}))
+
+	if buf == nil {
+		t.Fatal("Buffer is nil")
+	}
+
+	key := "inflight"
+	data := generateData(bfSize + 100)
+
+	for _, record := range data {
+		_, err := buf.Push(key, record)
+
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	if got := len(buf.Get(key)); got != bfSize {
+		t.Errorf("Get returned %d records, expected %d", got, bfSize)
+	}
+
+	err := buf.Rollback(key)
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	result := buf.Get(key)
+
+	if result[0].ToJson() != data[0].ToJson() {
+		t.Error("Rollback must return in-flight records to the head of the queue")
+	}
+
+	err = buf.Clear(key, 400)
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	if buf.Len(key) != len(data)-400 {
+		t.Errorf("Buffer length is %d after partial commit, expected %d", buf.Len(key), len(data)-400)
+	}
+
+	result = buf.Get(key)
+
+	if result[0].ToJson() != data[400].ToJson() {
+		t.Error("Uncommitted in-flight records must return to the head of the queue")
+	}
+}
+
+func TestRedisCorruptedPages(t *testing.T) {
+	cfg := PrepareConfigRedis()
+	endpoint := startRedis(t)
+	client := redis.NewClient(&redis.Options{Addr: endpoint})
+
+	buf := buffer.NewRedis(context.Background(), cfg, client)
+
+	if buf == nil {
+		t.Fatal("Buffer is nil")
+	}
+
+	key := "corrupted"
+
+	for _, record := range generateData(10) {
+		_, err := buf.Push(key, record)
+
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	client.RPush(context.Background(), fmt.Sprintf("%s:{%s}", cfg.RedisDataPrefix, key), "not msgpack")
+	corrupted := fmt.Sprintf("%s:corrupted:{%s}", cfg.RedisInflightPrefix, key)
+
+	// Pages read again after a rollback must not copy the corrupted record twice
+	for i := 0; i < 2; i++ {
+		if got := buf.Take(key); got != 11 {
+			t.Errorf("Take returned %d records, expected 11", got)
+		}
+
+		for j := 0; j < 2; j++ {
+			if got := len(buf.Page(key, 0, 100)); got != 10 {
+				t.Errorf("Page returned %d records, expected 10", got)
+			}
+		}
+
+		if i == 0 {
+			if err := buf.Rollback(key); err != nil {
+				t.Error(err)
+			}
+
+			if n := client.LLen(context.Background(), corrupted).Val(); n != 0 {
+				t.Errorf("Corrupted list has %d records before commit, expected 0", n)
+			}
+		}
+	}
+
+	if err := buf.Clear(key, -1); err != nil {
+		t.Error(err)
+	}
+
+	if n := client.LLen(context.Background(), corrupted).Val(); n != 1 {
+		t.Errorf("Corrupted list has %d records after commit, expected 1", n)
+	}
+}
+
+func TestMemPages(t *testing.T) {
+	testPages(buffer.New(context.Background(), PrepareConfigMem()), t)
+}
+
+func TestDiskPages(t *testing.T) {
+	buf := buffer.New(context.Background(), PrepareConfigDisk(t.TempDir()))
+
+	testPages(buf, t)
+
+	err := buf.Close()
+
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRedisPages(t *testing.T) {
+	endpoint := startRedis(t)
+
+	testPages(buffer.NewRedis(context.Background(), PrepareConfigRedis(), redis.NewClient(&redis.Options{Addr: endpoint})), t)
+}
+
+func TestRedisStreamPages(t *testing.T) {
+	cfg := PrepareConfigRedis()
+	cfg.BufferType = config.BufferTypeRedisStream
+	cfg.BufferPageSize = 300
+	endpoint := startRedis(t)
+
+	testPages(buffer.NewRedisStream(context.Background(), cfg, redis.NewClient(&redis.Options{Addr: endpoint})), t)
+}
+
+func testPages(buf buffer.Buffer, t *testing.T) {
+	if buf == nil {
+		t.Fatal("Buffer is nil")
+	}
+
+	key := "pages"
+	data := generateData(bfSize + 100)
+
+	for _, record := range data {
+		_, err := buf.Push(key, record)
+
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	size := buf.Take(key)
+
+	if size != bfSize {
+		t.Errorf("Take returned %d records, expected %d", size, bfSize)
+	}
+
+	read := 0
+
+	for offset := 0; offset < size; offset += 300 {
+		page := buf.Page(key, offset, 300)
+
+		for i, record := range page {
+			if record.ToJson() != data[offset+i].ToJson() {
+				t.Fatalf("Record %d of page at %d is not the pushed one", i, offset)
+			}
+		}
+
+		read += len(page)
+	}
+
+	if read != bfSize {
+		t.Errorf("Pages returned %d records, expected %d", read, bfSize)
+	}
+
+	err := buf.Clear(key, size)
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	if buf.Len(key) != 100 {
+		t.Errorf("Buffer length is %d after clear, expected 100", buf.Len(key))
+	}
+
+	if size = buf.Take(key); size != 100 {
+		t.Errorf("Take returned %d records, expected 100", size)
+	}
+
+	page := buf.Page(key, 0, 1)
+
+	if len(page) != 1 || page[0].ToJson() != data[bfSize].ToJson() {
+		t.Error("Next take must start on the first record not cleared")
+	}
+}
+
 func testBuffer(buf buffer.Buffer, t *testing.T) {
 	if buf == nil {
 		t.Error("Buffer is nil")
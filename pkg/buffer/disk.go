@@ -0,0 +1,1076 @@
+package buffer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"data2parquet/pkg/config"
+	"data2parquet/pkg/domain"
+)
+
+// Disk is a Buffer backed by an append-only, segmented write-ahead-log on local disk.
+// Each key has its own directory with numbered segment files and a cursor file that
+// points to the first record not yet cleared, so un-cleared records are replayed on startup.
+type Disk struct {
+	config      *config.Config
+	ctx         context.Context
+	dataDir     string
+	dlqDir      string
+	recoveryDir string
+	lockFile    *os.File
+	logs        map[string]*diskLog
+	total       int64
+	seq         uint64
+	mu          sync.Mutex
+	stop        chan struct{}
+	Ready       bool
+}
+
+type diskEntry struct {
+	record  domain.Record
+	segment uint64
+	end     int64
+	size    int64
+}
+
+type diskLog struct {
+	dir      string
+	entries  []*diskEntry
+	taken    int
+	segments []uint64
+	active   *os.File
+	activeId uint64
+	size     int64
+	bytes    int64
+	dirty    bool
+}
+
+const diskSegmentExt = ".seg"
+const diskDLQExt = ".dlq"
+const diskRecoveryExt = ".rec"
+const diskCursorFile = "cursor"
+const diskLockFile = "LOCK"
+const diskFrameHeaderSize = 8
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+func NewDisk(ctx context.Context, cfg *config.Config) Buffer {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	ret := &Disk{
+		config:      cfg,
+		ctx:         ctx,
+		dataDir:     filepath.Join(cfg.DiskPath, "data"),
+		dlqDir:      filepath.Join(cfg.DiskPath, "dlq"),
+		recoveryDir: filepath.Join(cfg.DiskPath, "recovery"),
+		logs:        make(map[string]*diskLog),
+		stop:        make(chan struct{}),
+	}
+
+	for _, dir := range []string{ret.dataDir, ret.dlqDir, ret.recoveryDir} {
+		err := os.MkdirAll(dir, 0755)
+
+		if err != nil {
+			slog.Error("Error creating disk buffer directory", "error", err, "dir", dir, "module", "buffer.disk", "function", "NewDisk")
+			return nil
+		}
+	}
+
+	err := ret.acquireLock()
+
+	if err != nil {
+		slog.Error("Error locking disk buffer directory, is another instance using it?", "error", err, "path", cfg.DiskPath, "module", "buffer.disk", "function", "NewDisk")
+		return nil
+	}
+
+	err = ret.replay()
+
+	if err != nil {
+		slog.Error("Error replaying disk buffer", "error", err, "path", cfg.DiskPath, "module", "buffer.disk", "function", "NewDisk")
+		ret.releaseLock()
+		return nil
+	}
+
+	if cfg.DiskSyncPolicy == config.DiskSyncPolicyInterval {
+		go ret.runSync()
+	}
+
+	ret.Ready = true
+
+	return ret
+}
+
+func (d *Disk) acquireLock() error {
+	file, err := os.OpenFile(filepath.Join(d.config.DiskPath, diskLockFile), os.O_CREATE|os.O_RDWR, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	d.lockFile = file
+
+	return nil
+}
+
+func (d *Disk) releaseLock() {
+	if d.lockFile == nil {
+		return
+	}
+
+	err := syscall.Flock(int(d.lockFile.Fd()), syscall.LOCK_UN)
+
+	if err != nil {
+		slog.Warn("Error unlocking disk buffer directory", "error", err, "module", "buffer.disk", "function", "releaseLock")
+	}
+
+	d.lockFile.Close()
+	d.lockFile = nil
+}
+
+func (d *Disk) Close() error {
+	slog.Debug("Closing buffer", "module", "buffer.disk", "function", "Close")
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.Ready {
+		return nil
+	}
+
+	d.Ready = false
+	close(d.stop)
+
+	var ret error
+
+	for key, l := range d.logs {
+		err := l.close()
+
+		if err != nil {
+			slog.Error("Error closing disk buffer segment", "error", err, "key", key, "module", "buffer.disk", "function", "Close")
+			ret = err
+		}
+	}
+
+	d.releaseLock()
+
+	return ret
+}
+
+func (d *Disk) runSync() {
+	interval := time.Duration(d.config.DiskSyncInterval) * time.Millisecond
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+			d.mu.Lock()
+			for key, l := range d.logs {
+				err := l.sync()
+
+				if err != nil {
+					slog.Error("Error syncing disk buffer segment", "error", err, "key", key, "module", "buffer.disk", "function", "runSync")
+				}
+			}
+			d.mu.Unlock()
+		}
+	}
+}
+
+func (d *Disk) Len(key string) int {
+	slog.Debug("Getting buffer length", "key", key, "module", "buffer.disk", "function", "Len")
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	l, ok := d.logs[key]
+
+	if !ok {
+		return 0
+	}
+
+	return len(l.entries)
+}
+
+func (d *Disk) Size(key string) int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	l, ok := d.logs[key]
+
+	if !ok {
+		return 0
+	}
+
+	return l.bytes
+}
+
+// TotalSize returns the bytes of every un-cleared record, they are kept decoded in memory after being written to disk.
+func (d *Disk) TotalSize() int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.total
+}
+
+func (d *Disk) Push(key string, item domain.Record) (int, error) {
+	if len(key) == 0 {
+		slog.Warn("Key is empty", "module", "buffer.disk", "function", "Push")
+		return 0, errors.New("key is empty")
+	}
+
+	if item == nil {
+		slog.Warn("Item is nil", "key", key, "module", "buffer.disk", "function", "Push")
+		return 0, errors.New("item is nil")
+	}
+
+	data := item.ToMsgPack()
+
+	if data == nil {
+		return 0, errors.New("error encoding record")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	l, ok := d.logs[key]
+
+	if !ok {
+		var err error
+		l, err = d.openLog(key)
+
+		if err != nil {
+			slog.Error("Error opening disk buffer log", "error", err, "key", key, "module", "buffer.disk", "function", "Push")
+			return 0, err
+		}
+
+		d.logs[key] = l
+	}
+
+	err := l.append(item, data, d.config)
+
+	if err != nil {
+		slog.Error("Error appending to disk buffer", "error", err, "key", key, "module", "buffer.disk", "function", "Push")
+		return 0, err
+	}
+
+	d.total += int64(len(data))
+
+	return len(l.entries), nil
+}
+
+func (d *Disk) PushDLQ(key string, item domain.Record) error {
+	if item == nil {
+		slog.Warn("Item is nil", "key", key, "module", "buffer.disk", "function", "PushDLQ")
+		return errors.New("item is nil")
+	}
+
+	data := item.ToMsgPack()
+
+	if data == nil {
+		return errors.New("error encoding record")
+	}
+
+	slog.Debug("Pushing to DLQ", "key", key, "module", "buffer.disk", "function", "PushDLQ", "size", len(data))
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	file, err := os.OpenFile(filepath.Join(d.dlqDir, encodeDiskKey(key)+diskDLQExt), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+
+	if err != nil {
+		slog.Error("Error opening DLQ file", "error", err, "key", key, "module", "buffer.disk", "function", "PushDLQ")
+		return err
+	}
+
+	defer file.Close()
+
+	_, err = file.Write(makeFrame(data))
+
+	if err != nil {
+		slog.Error("Error writing DLQ file", "error", err, "key", key, "module", "buffer.disk", "function", "PushDLQ")
+		return err
+	}
+
+	if d.config.DiskSyncPolicy != config.DiskSyncPolicyNone {
+		return file.Sync()
+	}
+
+	return nil
+}
+
+func (d *Disk) GetDLQ() (map[string][]domain.Record, error) {
+	slog.Debug("GetDLQ data", "module", "buffer.disk", "function", "GetDLQ")
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	files, err := listFiles(d.dlqDir, diskDLQExt)
+
+	if err != nil {
+		slog.Error("GetDLQ - Error listing DLQ files", "error", err, "module", "buffer.disk", "function", "GetDLQ")
+		return nil, err
+	}
+
+	ret := make(map[string][]domain.Record)
+
+	for _, name := range files {
+		key, err := decodeDiskKey(strings.TrimSuffix(name, diskDLQExt))
+
+		if err != nil {
+			slog.Warn("GetDLQ - Invalid DLQ file name, skipping", "file", name, "error", err, "module", "buffer.disk", "function", "GetDLQ")
+			continue
+		}
+
+		_, err = readFrames(filepath.Join(d.dlqDir, name), 0, func(payload []byte, _ int64) error {
+			record := domain.NewObj(d.config.RecordType)
+			err := record.FromMsgPack(payload)
+
+			if err != nil {
+				return err
+			}
+
+			ret[key] = append(ret[key], record)
+			return nil
+		})
+
+		if err != nil {
+			slog.Error("GetDLQ - Error reading DLQ file", "error", err, "file", name, "module", "buffer.disk", "function", "GetDLQ")
+			return nil, err
+		}
+	}
+
+	return ret, nil
+}
+
+func (d *Disk) ClearDLQ() error {
+	slog.Debug("Clearing DLQ", "module", "buffer.disk", "function", "ClearDLQ")
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return removeFiles(d.dlqDir, diskDLQExt)
+}
+
+func (d *Disk) Get(key string) []domain.Record {
+	slog.Debug("Getting buffer", "key", key, "module", "buffer.disk", "function", "Get")
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	l, ok := d.logs[key]
+
+	if !ok {
+		return nil
+	}
+
+	size := len(l.entries)
+
+	if size > d.config.BufferSize {
+		size = d.config.BufferSize
+	}
+
+	ret := make([]domain.Record, size)
+
+	for i := 0; i < size; i++ {
+		ret[i] = l.entries[i].record
+	}
+
+	return ret
+}
+
+func (d *Disk) Take(key string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	l, ok := d.logs[key]
+
+	if !ok {
+		return 0
+	}
+
+	l.taken = len(l.entries)
+
+	if l.taken > d.config.BufferSize {
+		l.taken = d.config.BufferSize
+	}
+
+	return l.taken
+}
+
+func (d *Disk) Page(key string, offset int, size int) []domain.Record {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	l, ok := d.logs[key]
+
+	if !ok || offset >= l.taken {
+		return nil
+	}
+
+	end := offset + size
+
+	if end > l.taken {
+		end = l.taken
+	}
+
+	ret := make([]domain.Record, 0, end-offset)
+
+	for _, entry := range l.entries[offset:end] {
+		ret = append(ret, entry.record)
+	}
+
+	return ret
+}
+
+func (d *Disk) Clear(key string, size int) error {
+	slog.Debug("Clearing buffer", "key", key, "size", size, "module", "buffer.disk", "function", "Clear")
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	l, ok := d.logs[key]
+
+	if ok {
+		l.taken = 0
+	}
+
+	if !ok || len(l.entries) == 0 || size == 0 {
+		return nil
+	}
+
+	if size == -1 || size > len(l.entries) {
+		size = len(l.entries)
+	}
+
+	last := l.entries[size-1]
+
+	err := l.writeCursor(last.segment, last.end)
+
+	if err != nil {
+		slog.Error("Error writing disk buffer cursor", "error", err, "key", key, "module", "buffer.disk", "function", "Clear")
+		return err
+	}
+
+	var cleared int64
+	for _, entry := range l.entries[:size] {
+		cleared += entry.size
+	}
+
+	l.entries = l.entries[size:]
+	l.bytes -= cleared
+	d.total -= cleared
+
+	return l.compact(last.segment)
+}
+
+// Rollback does nothing, Get never moves the cursor, only Clear does.
+func (d *Disk) Rollback(key string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if l, ok := d.logs[key]; ok {
+		l.taken = 0
+	}
+
+	return nil
+}
+
+func (d *Disk) Keys() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	keys := make([]string, 0, len(d.logs))
+
+	for k := range d.logs {
+		keys = append(keys, k)
+	}
+
+	return keys
+}
+
+func (d *Disk) IsReady() bool {
+	return d.Ready
+}
+
+func (d *Disk) HasRecovery() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	files, err := listFiles(d.recoveryDir, diskRecoveryExt)
+
+	if err != nil {
+		slog.Error("Error listing recovery files", "error", err, "module", "buffer.disk", "function", "HasRecovery")
+		return false
+	}
+
+	return len(files) > 0
+}
+
+func (d *Disk) PushRecovery(key string, buf *bytes.Buffer) error {
+	slog.Debug("Pushing data to post recovery", "key", key, "module", "buffer.disk", "function", "PushRecovery", "size", buf.Len())
+
+	data := &RecoveryData{
+		Key:       key,
+		Data:      buf.Bytes(),
+		Timestamp: time.Now(),
+	}
+
+	msg := data.ToMsgPack()
+
+	if msg == nil {
+		return errors.New("error encoding recovery data")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.seq++
+	name := fmt.Sprintf("%020d-%08d%s", time.Now().UnixNano(), d.seq, diskRecoveryExt)
+
+	err := writeFileAtomic(filepath.Join(d.recoveryDir, name), makeFrame(msg))
+
+	if err != nil {
+		slog.Error("PushRecovery - Error writing recovery file", "error", err, "key", key, "module", "buffer.disk", "function", "PushRecovery")
+		return err
+	}
+
+	return nil
+}
+
+func (d *Disk) GetRecovery() ([]*RecoveryData, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	files, err := listFiles(d.recoveryDir, diskRecoveryExt)
+
+	if err != nil {
+		slog.Error("GetRecovery - Error listing recovery files", "error", err, "module", "buffer.disk", "function", "GetRecovery")
+		return []*RecoveryData{}, err
+	}
+
+	ret := make([]*RecoveryData, 0, len(files))
+
+	for _, name := range files {
+		_, err := readFrames(filepath.Join(d.recoveryDir, name), 0, func(payload []byte, _ int64) error {
+			item := &RecoveryData{}
+			err := item.FromMsgPack(payload)
+
+			if err != nil {
+				return err
+			}
+
+			ret = append(ret, item)
+			return nil
+		})
+
+		if err != nil {
+			slog.Error("GetRecovery - Error reading recovery file", "error", err, "file", name, "module", "buffer.disk", "function", "GetRecovery")
+			return ret, err
+		}
+	}
+
+	return ret, nil
+}
+
+func (d *Disk) ClearRecoveryData() error {
+	slog.Debug("Clearing recovery data", "module", "buffer.disk", "function", "ClearRecoveryData")
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return removeFiles(d.recoveryDir, diskRecoveryExt)
+}
+
+// CheckLock returns true while this instance holds the exclusive lock on the buffer directory,
+// a disk buffer is never shared between instances.
+func (d *Disk) CheckLock(key string) bool {
+	return d.Ready && d.lockFile != nil
+}
+
+func (d *Disk) replay() error {
+	dirs, err := os.ReadDir(d.dataDir)
+
+	if err != nil {
+		return err
+	}
+
+	total := 0
+
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+
+		key, err := decodeDiskKey(dir.Name())
+
+		if err != nil {
+			slog.Warn("Invalid disk buffer directory name, skipping", "dir", dir.Name(), "error", err, "module", "buffer.disk", "function", "replay")
+			continue
+		}
+
+		l, err := d.openLog(key)
+
+		if err != nil {
+			return err
+		}
+
+		if len(l.entries) == 0 {
+			slog.Debug("Disk buffer key fully consumed, removing", "key", key, "module", "buffer.disk", "function", "replay")
+			l.close()
+			os.RemoveAll(l.dir)
+			continue
+		}
+
+		d.logs[key] = l
+		d.total += l.bytes
+		total += len(l.entries)
+	}
+
+	slog.Info("Disk buffer replayed", "keys", len(d.logs), "records", total, "path", d.config.DiskPath, "module", "buffer.disk", "function", "replay")
+
+	return nil
+}
+
+func (d *Disk) openLog(key string) (*diskLog, error) {
+	l := &diskLog{
+		dir:     filepath.Join(d.dataDir, encodeDiskKey(key)),
+		entries: make([]*diskEntry, 0),
+	}
+
+	err := os.MkdirAll(l.dir, 0755)
+
+	if err != nil {
+		return nil, err
+	}
+
+	curSegment, curOffset := l.readCursor()
+
+	files, err := listFiles(l.dir, diskSegmentExt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range files {
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, diskSegmentExt), 10, 64)
+
+		if err != nil {
+			slog.Warn("Invalid segment file name, skipping", "file", name, "key", key, "module", "buffer.disk", "function", "openLog")
+			continue
+		}
+
+		if id < curSegment {
+			err = os.Remove(filepath.Join(l.dir, name))
+
+			if err != nil {
+				slog.Warn("Error removing consumed segment", "error", err, "file", name, "key", key, "module", "buffer.disk", "function", "openLog")
+			}
+			continue
+		}
+
+		l.segments = append(l.segments, id)
+	}
+
+	sort.Slice(l.segments, func(i, j int) bool { return l.segments[i] < l.segments[j] })
+
+	for i, id := range l.segments {
+		var offset int64
+
+		if id == curSegment {
+			offset = curOffset
+		}
+
+		path := l.segmentPath(id)
+		valid, err := readFrames(path, offset, func(payload []byte, end int64) error {
+			record := domain.NewObj(d.config.RecordType)
+			err := record.FromMsgPack(payload)
+
+			if err != nil {
+				slog.Error("Error decoding replayed record, skipping", "error", err, "file", path, "key", key, "module", "buffer.disk", "function", "openLog")
+				return nil
+			}
+
+			l.entries = append(l.entries, &diskEntry{record: record, segment: id, end: end, size: int64(len(payload))})
+			l.bytes += int64(len(payload))
+			return nil
+		})
+
+		if err != nil {
+			if i < len(l.segments)-1 {
+				slog.Error("Corrupted disk buffer segment, skipping remaining records", "error", err, "file", path, "key", key, "module", "buffer.disk", "function", "openLog")
+				continue
+			}
+
+			slog.Warn("Truncating torn write at the tail of disk buffer segment", "error", err, "file", path, "offset", valid, "key", key, "module", "buffer.disk", "function", "openLog")
+
+			err = os.Truncate(path, valid)
+
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(l.segments) == 0 {
+		next := curSegment
+
+		if next == 0 {
+			next = 1
+		}
+
+		return l, l.openSegment(next)
+	}
+
+	last := l.segments[len(l.segments)-1]
+	l.segments = l.segments[:len(l.segments)-1]
+
+	return l, l.openSegment(last)
+}
+
+func (l *diskLog) segmentPath(id uint64) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%020d%s", id, diskSegmentExt))
+}
+
+func (l *diskLog) openSegment(id uint64) error {
+	file, err := os.OpenFile(l.segmentPath(id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	l.active = file
+	l.activeId = id
+	l.size = info.Size()
+	l.segments = append(l.segments, id)
+
+	return syncDir(l.dir)
+}
+
+func (l *diskLog) rotate() error {
+	err := l.sync()
+
+	if err != nil {
+		return err
+	}
+
+	err = l.active.Close()
+
+	if err != nil {
+		return err
+	}
+
+	return l.openSegment(l.activeId + 1)
+}
+
+func (l *diskLog) append(record domain.Record, data []byte, cfg *config.Config) error {
+	if l.size > 0 && l.size+int64(len(data)+diskFrameHeaderSize) > cfg.DiskSegmentSize {
+		err := l.rotate()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	n, err := l.active.Write(makeFrame(data))
+	l.size += int64(n)
+
+	if err != nil {
+		return err
+	}
+
+	l.entries = append(l.entries, &diskEntry{record: record, segment: l.activeId, end: l.size, size: int64(len(data))})
+	l.bytes += int64(len(data))
+	l.dirty = true
+
+	if cfg.DiskSyncPolicy == config.DiskSyncPolicyAlways {
+		return l.sync()
+	}
+
+	return nil
+}
+
+func (l *diskLog) sync() error {
+	if !l.dirty || l.active == nil {
+		return nil
+	}
+
+	l.dirty = false
+
+	return l.active.Sync()
+}
+
+func (l *diskLog) close() error {
+	if l.active == nil {
+		return nil
+	}
+
+	err := l.sync()
+
+	if err != nil {
+		return err
+	}
+
+	err = l.active.Close()
+	l.active = nil
+
+	return err
+}
+
+// compact removes every segment that precedes the cursor segment, those records were already cleared.
+func (l *diskLog) compact(cursor uint64) error {
+	remains := make([]uint64, 0, len(l.segments))
+
+	for _, id := range l.segments {
+		if id >= cursor || id == l.activeId {
+			remains = append(remains, id)
+			continue
+		}
+
+		err := os.Remove(l.segmentPath(id))
+
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Error("Error removing consumed segment", "error", err, "segment", id, "dir", l.dir, "module", "buffer.disk", "function", "compact")
+			remains = append(remains, id)
+		}
+	}
+
+	l.segments = remains
+
+	return nil
+}
+
+func (l *diskLog) readCursor() (uint64, int64) {
+	data, err := os.ReadFile(filepath.Join(l.dir, diskCursorFile))
+
+	if err != nil {
+		return 0, 0
+	}
+
+	if len(data) != 20 || crc32.Checksum(data[:16], crcTable) != binary.LittleEndian.Uint32(data[16:]) {
+		slog.Warn("Invalid disk buffer cursor, replaying from the first segment", "dir", l.dir, "module", "buffer.disk", "function", "readCursor")
+		return 0, 0
+	}
+
+	return binary.LittleEndian.Uint64(data[:8]), int64(binary.LittleEndian.Uint64(data[8:16]))
+}
+
+func (l *diskLog) writeCursor(segment uint64, offset int64) error {
+	data := make([]byte, 20)
+	binary.LittleEndian.PutUint64(data[:8], segment)
+	binary.LittleEndian.PutUint64(data[8:16], uint64(offset))
+	binary.LittleEndian.PutUint32(data[16:], crc32.Checksum(data[:16], crcTable))
+
+	return writeFileAtomic(filepath.Join(l.dir, diskCursorFile), data)
+}
+
+func makeFrame(payload []byte) []byte {
+	frame := make([]byte, diskFrameHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(frame[:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(frame[4:8], crc32.Checksum(payload, crcTable))
+	copy(frame[diskFrameHeaderSize:], payload)
+
+	return frame
+}
+
+// readFrames calls fn for each valid frame after offset and returns the offset of the end of the last valid frame. A
+// frame longer than the rest of the file is a torn or corrupted header, and is not allocated.
+func readFrames(path string, offset int64, fn func(payload []byte, end int64) error) (int64, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer file.Close()
+
+	info, err := file.Stat()
+
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = file.Seek(offset, io.SeekStart)
+
+	if err != nil {
+		return 0, err
+	}
+
+	reader := bufio.NewReader(file)
+	header := make([]byte, diskFrameHeaderSize)
+	valid := offset
+
+	for {
+		_, err = io.ReadFull(reader, header)
+
+		if err == io.EOF {
+			return valid, nil
+		}
+
+		if err != nil {
+			return valid, fmt.Errorf("short frame header at offset %d: %w", valid, err)
+		}
+
+		size := binary.LittleEndian.Uint32(header[:4])
+
+		if int64(size) > info.Size()-valid-int64(diskFrameHeaderSize) {
+			return valid, fmt.Errorf("frame length %d exceeds the end of the file at offset %d", size, valid)
+		}
+
+		payload := make([]byte, size)
+
+		_, err = io.ReadFull(reader, payload)
+
+		if err != nil {
+			return valid, fmt.Errorf("short frame payload at offset %d: %w", valid, err)
+		}
+
+		if crc32.Checksum(payload, crcTable) != binary.LittleEndian.Uint32(header[4:8]) {
+			return valid, fmt.Errorf("checksum mismatch at offset %d", valid)
+		}
+
+		end := valid + int64(diskFrameHeaderSize) + int64(size)
+
+		err = fn(payload, end)
+
+		if err != nil {
+			return valid, err
+		}
+
+		valid = end
+	}
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(data)
+
+	if err == nil {
+		err = file.Sync()
+	}
+
+	if errClose := file.Close(); err == nil {
+		err = errClose
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	err = os.Rename(tmp, path)
+
+	if err != nil {
+		return err
+	}
+
+	return syncDir(filepath.Dir(path))
+}
+
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	return file.Sync()
+}
+
+func listFiles(dir string, ext string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]string, 0, len(entries))
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ext) {
+			continue
+		}
+
+		ret = append(ret, entry.Name())
+	}
+
+	sort.Strings(ret)
+
+	return ret, nil
+}
+
+func removeFiles(dir string, ext string) error {
+	files, err := listFiles(dir, ext)
+
+	if err != nil {
+		return err
+	}
+
+	for _, name := range files {
+		err = os.Remove(filepath.Join(dir, name))
+
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Error("Error removing file", "error", err, "file", name, "module", "buffer.disk", "function", "removeFiles")
+			return err
+		}
+	}
+
+	return syncDir(dir)
+}
+
+func encodeDiskKey(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func decodeDiskKey(name string) (string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(name)
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
@@ -17,6 +17,10 @@ import (
 type Mem struct {
 	config   *config.Config
 	data     map[string][]domain.Record
+	sizes    map[string][]int64
+	bytes    map[string]int64
+	taken    map[string]int
+	total    int64
 	dlq      map[string][]domain.Record
 	recovery []*RecoveryData
 	mu       sync.Mutex
@@ -27,6 +31,9 @@ type Mem struct {
 func NewMem(ctx context.Context, config *config.Config) Buffer {
 	ret := &Mem{
 		data:     make(map[string][]domain.Record),
+		sizes:    make(map[string][]int64),
+		bytes:    make(map[string]int64),
+		taken:    make(map[string]int),
 		dlq:      make(map[string][]domain.Record),
 		recovery: make([]*RecoveryData, 0),
 		config:   config,
@@ -60,10 +67,21 @@ func (m *Mem) Len(key string) int {
 	return len(m.data[key])
 }
 
-func (m *Mem) Push(key string, item domain.Record) (int, error) {
+func (m *Mem) Size(key string) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.bytes[key]
+}
+
+func (m *Mem) TotalSize() int64 {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	return m.total
+}
+
+func (m *Mem) Push(key string, item domain.Record) (int, error) {
 	if len(key) == 0 {
 		slog.Warn("Key is empty", "module", "buffer.mem", "function", "Push")
 		return 0, errors.New("key is empty")
@@ -74,6 +92,11 @@ func (m *Mem) Push(key string, item domain.Record) (int, error) {
 		return 0, errors.New("item is nil")
 	}
 
+	size := RecordSize(item)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	values, ok := m.data[key]
 	if !ok {
 		values = make([]domain.Record, 0, m.config.BufferSize)
@@ -82,6 +105,9 @@ func (m *Mem) Push(key string, item domain.Record) (int, error) {
 	values = append(values, item)
 
 	m.data[key] = values
+	m.sizes[key] = append(m.sizes[key], size)
+	m.bytes[key] += size
+	m.total += size
 
 	return len(values), nil
 }
@@ -161,6 +187,40 @@ func (m *Mem) Get(key string) []domain.Record {
 	return m.data[key]
 }
 
+func (m *Mem) Take(key string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l := len(m.data[key])
+
+	if l > m.config.BufferSize {
+		l = m.config.BufferSize
+	}
+
+	m.taken[key] = l
+
+	return l
+}
+
+func (m *Mem) Page(key string, offset int, size int) []domain.Record {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data := m.data[key][:m.taken[key]]
+
+	if offset >= len(data) {
+		return nil
+	}
+
+	end := offset + size
+
+	if end > len(data) {
+		end = len(data)
+	}
+
+	return data[offset:end]
+}
+
 func (m *Mem) Clear(key string, size int) error {
 	slog.Debug("Clearing buffer", "key", key, "size", size, "module", "buffer.mem", "function", "Clear")
 	if m.data == nil {
@@ -173,15 +233,39 @@ func (m *Mem) Clear(key string, size int) error {
 		return nil
 	}
 
+	delete(m.taken, key)
+
 	if size == -1 || size > len(m.data[key]) {
+		m.total -= m.bytes[key]
 		delete(m.data, key)
+		delete(m.sizes, key)
+		delete(m.bytes, key)
 		return nil
 	}
 
+	var cleared int64
+	for _, s := range m.sizes[key][:size] {
+		cleared += s
+	}
+
 	m.data[key] = m.data[key][size:]
+	m.sizes[key] = m.sizes[key][size:]
+	m.bytes[key] -= cleared
+	m.total -= cleared
 
 	return nil
 }
+
+// Rollback does nothing, Get never removes records from a memory buffer.
+func (m *Mem) Rollback(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.taken, key)
+
+	return nil
+}
+
 func (m *Mem) Keys() []string {
 	keys := make([]string, 0, len(m.data))
 
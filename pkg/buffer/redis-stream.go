@@ -0,0 +1,511 @@
+package buffer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"data2parquet/pkg/config"
+	"data2parquet/pkg/domain"
+)
+
+// RedisStream is a Buffer backed by Redis Streams and consumer groups, so many instances can
+// share the load of the same key. Entries delivered by Get stay pending on the consumer group
+// until Clear acknowledges them, pending entries of dead consumers are reclaimed with XAUTOCLAIM and the consumers
+// are removed from the group.
+type RedisStream struct {
+	*Redis
+	inflight map[string][]string
+	groups   map[string]bool
+	mu       sync.Mutex
+}
+
+const redisStreamField = "data"
+
+func NewRedisStream(ctx context.Context, config *config.Config, client redis.UniversalClient) Buffer {
+	base := NewRedis(ctx, config, client)
+
+	if base == nil {
+		slog.Error("Redis is not ready", "module", "buffer.redis-stream", "function", "NewRedisStream")
+		return nil
+	}
+
+	ret := &RedisStream{
+		Redis:    base.(*Redis),
+		inflight: make(map[string][]string),
+		groups:   make(map[string]bool),
+	}
+
+	slog.Info("Redis stream buffer created", "group", config.RedisStreamGroup, "consumer", ret.instanceId, "module", "buffer.redis-stream", "function", "NewRedisStream")
+
+	return ret
+}
+
+func (r *RedisStream) makeStreamKey(key string) string {
+	return fmt.Sprintf("%s:{%s}", r.config.RedisStreamPrefix, key)
+}
+
+func (r *RedisStream) makeStreamBytesKey(key string) string {
+	return fmt.Sprintf("%s:bytes:{%s}", r.config.RedisStreamPrefix, key)
+}
+
+func (r *RedisStream) ensureGroup(key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.groups[key] {
+		return nil
+	}
+
+	cmd := r.getClient().XGroupCreateMkStream(r.ctx, r.makeStreamKey(key), r.config.RedisStreamGroup, "0")
+
+	if cmd.Err() != nil && !strings.HasPrefix(cmd.Err().Error(), "BUSYGROUP") {
+		slog.Error("Error creating consumer group", "error", cmd.Err(), "key", key, "group", r.config.RedisStreamGroup, "module", "buffer.redis-stream", "function", "ensureGroup")
+		return cmd.Err()
+	}
+
+	r.groups[key] = true
+
+	return nil
+}
+
+func (r *RedisStream) Len(key string) int {
+	cmd := r.getClient().XLen(r.ctx, r.makeStreamKey(key))
+
+	if cmd.Err() != nil {
+		slog.Error("Error getting stream length", "error", cmd.Err(), "key", key, "module", "buffer.redis-stream", "function", "Len")
+		return 0
+	}
+
+	return int(cmd.Val())
+}
+
+func (r *RedisStream) Push(key string, item domain.Record) (int, error) {
+	if len(key) == 0 {
+		slog.Warn("Key is empty", "module", "buffer.redis-stream", "function", "Push")
+		return 0, errors.New("key is empty")
+	}
+
+	if item == nil {
+		slog.Warn("Item is nil", "module", "buffer.redis-stream", "function", "Push")
+		return 0, errors.New("item is nil")
+	}
+
+	err := r.ensureGroup(key)
+
+	if err != nil {
+		return 0, err
+	}
+
+	stream := r.makeStreamKey(key)
+	data := item.ToMsgPack()
+	var xlen *redis.IntCmd
+
+	_, err = r.getClient().Pipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		pipe.SAdd(r.ctx, r.config.RedisKeys, key)
+		pipe.XAdd(r.ctx, &redis.XAddArgs{
+			Stream: stream,
+			Values: map[string]interface{}{redisStreamField: data},
+		})
+		pipe.IncrBy(r.ctx, r.makeStreamBytesKey(key), int64(len(data)))
+		xlen = pipe.XLen(r.ctx, stream)
+		return nil
+	})
+
+	if err != nil {
+		slog.Error("Error adding to stream", "error", err, "key", key, "module", "buffer.redis-stream", "function", "Push")
+		return 0, err
+	}
+
+	return int(xlen.Val()), nil
+}
+
+// Size returns the bytes of entries not yet acknowledged on the stream of a key.
+func (r *RedisStream) Size(key string) int64 {
+	cmd := r.getClient().Get(r.ctx, r.makeStreamBytesKey(key))
+
+	if cmd.Err() != nil {
+		if cmd.Err() != redis.Nil {
+			slog.Error("Error getting stream size", "error", cmd.Err(), "key", key, "module", "buffer.redis-stream", "function", "Size")
+		}
+		return 0
+	}
+
+	ret, err := cmd.Int64()
+
+	if err != nil {
+		slog.Error("Error parsing stream size", "error", err, "key", key, "module", "buffer.redis-stream", "function", "Size")
+		return 0
+	}
+
+	return ret
+}
+
+// CheckLock always allows the flush, the consumer group distributes entries between instances.
+func (r *RedisStream) CheckLock(key string) bool {
+	return r.ensureGroup(key) == nil
+}
+
+// Get claims stale entries from dead consumers, then returns this consumer's pending entries
+// (delivered before but never acknowledged) followed by new entries, up to BufferSize.
+func (r *RedisStream) Get(key string) []domain.Record {
+	err := r.ensureGroup(key)
+
+	if err != nil {
+		return make([]domain.Record, 0)
+	}
+
+	stream := r.makeStreamKey(key)
+	size := int64(r.config.BufferSize)
+
+	r.claim(key, stream, size)
+
+	msgs, err := r.readGroup(stream, "0", size)
+
+	if err != nil {
+		return make([]domain.Record, 0)
+	}
+
+	if int64(len(msgs)) < size {
+		news, err := r.readGroup(stream, ">", size-int64(len(msgs)))
+
+		if err == nil {
+			msgs = append(msgs, news...)
+		}
+	}
+
+	ret := make([]domain.Record, 0, len(msgs))
+	ids := make([]string, 0, len(msgs))
+	invalid := make([]string, 0)
+
+	for _, msg := range msgs {
+		value, ok := msg.Values[redisStreamField]
+
+		if !ok {
+			slog.Error("Stream entry without data, discarding", "id", msg.ID, "key", key, "module", "buffer.redis-stream", "function", "Get")
+			invalid = append(invalid, msg.ID)
+			continue
+		}
+
+		data := []byte(fmt.Sprint(value))
+		rec := domain.NewObj(r.config.RecordType)
+		err = rec.FromMsgPack(data)
+
+		if err != nil {
+			slog.Error("Error decoding stream entry, discarding", "error", err, "id", msg.ID, "key", key, "module", "buffer.redis-stream", "function", "Get")
+			invalid = append(invalid, msg.ID)
+			continue
+		}
+
+		ret = append(ret, rec)
+		ids = append(ids, msg.ID)
+	}
+
+	if len(invalid) > 0 {
+		r.ack(key, invalid)
+	}
+
+	r.mu.Lock()
+	r.inflight[key] = ids
+	r.mu.Unlock()
+
+	slog.Debug("Got stream entries", "key", key, "records", len(ret), "module", "buffer.redis-stream", "function", "Get")
+
+	return ret
+}
+
+func (r *RedisStream) readGroup(stream string, id string, count int64) ([]redis.XMessage, error) {
+	cmd := r.getClient().XReadGroup(r.ctx, &redis.XReadGroupArgs{
+		Group:    r.config.RedisStreamGroup,
+		Consumer: r.instanceId,
+		Streams:  []string{stream, id},
+		Count:    count,
+		Block:    -1,
+	})
+
+	if cmd.Err() == redis.Nil {
+		return []redis.XMessage{}, nil
+	}
+
+	if cmd.Err() != nil {
+		slog.Error("Error reading stream", "error", cmd.Err(), "stream", stream, "id", id, "module", "buffer.redis-stream", "function", "readGroup")
+		return nil, cmd.Err()
+	}
+
+	ret := make([]redis.XMessage, 0)
+
+	// Pending entries deleted from the stream come without values, they are returned to be acknowledged as invalid
+	for _, s := range cmd.Val() {
+		ret = append(ret, s.Messages...)
+	}
+
+	return ret, nil
+}
+
+// ackScript acknowledges and deletes the entries ARGV[3..] of the stream on KEYS[1] for the group ARGV[1], subtracting
+// the bytes of their ARGV[2] field from the size counter on KEYS[2]. Sizes are read from the stored entries, entries
+// already deleted by other consumers were subtracted by them and entries that can not be decoded count as well.
+var ackScript = redis.NewScript(`
+local bytes = 0
+for i = 3, #ARGV do
+	local entry = redis.call('XRANGE', KEYS[1], ARGV[i], ARGV[i])
+	if #entry > 0 then
+		local fields = entry[1][2]
+		for j = 1, #fields, 2 do
+			if fields[j] == ARGV[2] then
+				bytes = bytes + string.len(fields[j + 1])
+			end
+		end
+	end
+end
+for i = 3, #ARGV, 1000 do
+	local ids = {unpack(ARGV, i, math.min(i + 999, #ARGV))}
+	redis.call('XACK', KEYS[1], ARGV[1], unpack(ids))
+	redis.call('XDEL', KEYS[1], unpack(ids))
+end
+if bytes > 0 and redis.call('DECRBY', KEYS[2], bytes) <= 0 then
+	redis.call('DEL', KEYS[2])
+end
+return bytes
+`)
+
+func (r *RedisStream) ack(key string, ids []string) error {
+	stream := r.makeStreamKey(key)
+	args := make([]interface{}, 0, len(ids)+2)
+	args = append(args, r.config.RedisStreamGroup, redisStreamField)
+
+	for _, id := range ids {
+		args = append(args, id)
+	}
+
+	err := ackScript.Run(r.ctx, r.getClient(), []string{stream, r.makeStreamBytesKey(key)}, args...).Err()
+
+	if err != nil && err != redis.Nil {
+		slog.Error("Error acknowledging stream entries", "error", err, "stream", stream, "count", len(ids), "module", "buffer.redis-stream", "function", "ack")
+		return err
+	}
+
+	return nil
+}
+
+// claim moves to this consumer up to count entries pending on consumers idle for RedisStreamClaimIdle, then prunes
+// the idle consumers left without entries. XAUTOCLAIM runs as a raw command, Redis 7 replies a third element with the
+// deleted entries that XAutoClaim does not parse.
+func (r *RedisStream) claim(key string, stream string, count int64) {
+	idle := time.Duration(r.config.RedisStreamClaimIdle) * time.Second
+	cmd := r.getClient().Do(r.ctx, "XAUTOCLAIM", stream, r.config.RedisStreamGroup, r.instanceId, idle.Milliseconds(), "0-0", "COUNT", count, "JUSTID")
+	reply, err := cmd.Slice()
+
+	if err != nil && err != redis.Nil {
+		slog.Warn("Error claiming pending entries", "error", err, "key", key, "module", "buffer.redis-stream", "function", "claim")
+	} else if len(reply) > 1 {
+		if ids, _ := reply[1].([]interface{}); len(ids) > 0 {
+			slog.Info("Claimed pending entries from idle consumers", "key", key, "count", len(ids), "consumer", r.instanceId, "module", "buffer.redis-stream", "function", "claim")
+		}
+	}
+
+	r.pruneConsumers(key, stream)
+}
+
+// pruneScript deletes the consumers of the group ARGV[1] on the stream KEYS[1] idle for more than ARGV[2]
+// milliseconds and without pending entries, but ARGV[3], the calling one. It runs as a script so no entry is
+// delivered to a consumer between its check and its removal.
+var pruneScript = redis.NewScript(`
+local pruned = 0
+for _, consumer in ipairs(redis.call('XINFO', 'CONSUMERS', KEYS[1], ARGV[1])) do
+	local info = {}
+	for i = 1, #consumer, 2 do
+		info[consumer[i]] = consumer[i + 1]
+	end
+	if info['name'] ~= ARGV[3] and tonumber(info['pending']) == 0 and tonumber(info['idle']) > tonumber(ARGV[2]) then
+		redis.call('XGROUP', 'DELCONSUMER', KEYS[1], ARGV[1], info['name'])
+		pruned = pruned + 1
+	end
+end
+return pruned
+`)
+
+// pruneConsumers removes the consumers left on the group by instances that are gone, once XAUTOCLAIM reclaimed their
+// entries, so the group does not grow with each instance restart.
+func (r *RedisStream) pruneConsumers(key string, stream string) {
+	idle := time.Duration(r.config.RedisStreamClaimIdle) * time.Second
+	cmd := pruneScript.Run(r.ctx, r.getClient(), []string{stream}, r.config.RedisStreamGroup, idle.Milliseconds(), r.instanceId)
+
+	if cmd.Err() != nil && cmd.Err() != redis.Nil {
+		slog.Warn("Error pruning idle consumers", "error", cmd.Err(), "key", key, "module", "buffer.redis-stream", "function", "pruneConsumers")
+		return
+	}
+
+	if pruned, _ := cmd.Int(); pruned > 0 {
+		slog.Info("Pruned idle consumers without pending entries", "key", key, "count", pruned, "module", "buffer.redis-stream", "function", "pruneConsumers")
+	}
+}
+
+// Take delivers up to BufferSize entries of key to this consumer like Get, reading them in pages of BufferPageSize
+// and keeping only their ids, Page reads them back by id.
+func (r *RedisStream) Take(key string) int {
+	err := r.ensureGroup(key)
+
+	if err != nil {
+		return 0
+	}
+
+	stream := r.makeStreamKey(key)
+	size := r.config.BufferSize
+	page := r.config.BufferPageSize
+
+	if page < 1 || page > size {
+		page = size
+	}
+
+	r.claim(key, stream, int64(size))
+
+	ids := make([]string, 0)
+	invalid := make([]string, 0)
+	start := "0"
+
+	for len(ids) < size {
+		count := page
+
+		if size-len(ids) < count {
+			count = size - len(ids)
+		}
+
+		msgs, err := r.readGroup(stream, start, int64(count))
+
+		if err != nil {
+			break
+		}
+
+		if len(msgs) == 0 {
+			if start == ">" {
+				break
+			}
+
+			start = ">"
+			continue
+		}
+
+		for _, msg := range msgs {
+			if _, ok := msg.Values[redisStreamField]; !ok {
+				slog.Error("Stream entry without data, discarding", "id", msg.ID, "key", key, "module", "buffer.redis-stream", "function", "Take")
+				invalid = append(invalid, msg.ID)
+				continue
+			}
+
+			ids = append(ids, msg.ID)
+		}
+
+		if start != ">" {
+			start = msgs[len(msgs)-1].ID
+		}
+	}
+
+	if len(invalid) > 0 {
+		r.ack(key, invalid)
+	}
+
+	r.mu.Lock()
+	r.inflight[key] = ids
+	r.mu.Unlock()
+
+	slog.Debug("Took stream entries", "key", key, "records", len(ids), "module", "buffer.redis-stream", "function", "Take")
+
+	return len(ids)
+}
+
+// Page reads the entries delivered by Take, entries that can not be decoded are skipped and acknowledged on Clear.
+func (r *RedisStream) Page(key string, offset int, size int) []domain.Record {
+	r.mu.Lock()
+	ids := r.inflight[key]
+
+	if offset >= len(ids) {
+		r.mu.Unlock()
+		return nil
+	}
+
+	end := offset + size
+
+	if end > len(ids) {
+		end = len(ids)
+	}
+
+	ids = ids[offset:end]
+	r.mu.Unlock()
+
+	stream := r.makeStreamKey(key)
+	cmds := make([]*redis.XMessageSliceCmd, 0, len(ids))
+
+	_, err := r.getClient().Pipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		for _, id := range ids {
+			cmds = append(cmds, pipe.XRange(r.ctx, stream, id, id))
+		}
+		return nil
+	})
+
+	if err != nil && err != redis.Nil {
+		slog.Error("Error reading stream entries", "error", err, "key", key, "offset", offset, "module", "buffer.redis-stream", "function", "Page")
+		return make([]domain.Record, 0)
+	}
+
+	ret := make([]domain.Record, 0, len(ids))
+
+	for _, cmd := range cmds {
+		for _, msg := range cmd.Val() {
+			rec := domain.NewObj(r.config.RecordType)
+			err = rec.FromMsgPack([]byte(fmt.Sprint(msg.Values[redisStreamField])))
+
+			if err != nil {
+				slog.Error("Error decoding stream entry, skipping", "error", err, "id", msg.ID, "key", key, "module", "buffer.redis-stream", "function", "Page")
+				continue
+			}
+
+			ret = append(ret, rec)
+		}
+	}
+
+	return ret
+}
+
+// Clear acknowledges and deletes the first size entries returned by the last Get for this key.
+func (r *RedisStream) Clear(key string, size int) error {
+	r.mu.Lock()
+	ids := r.inflight[key]
+
+	if size == -1 || size > len(ids) {
+		size = len(ids)
+	}
+
+	done := ids[:size]
+	r.inflight[key] = ids[size:]
+	r.mu.Unlock()
+
+	if len(done) == 0 {
+		return nil
+	}
+
+	err := r.ack(key, done)
+
+	if err != nil {
+		return err
+	}
+
+	slog.Debug("Acknowledged stream entries", "key", key, "size", len(done), "module", "buffer.redis-stream", "function", "Clear")
+
+	return nil
+}
+
+// Rollback forgets the in-flight entries, they stay pending on the consumer group and are delivered again on next Get.
+func (r *RedisStream) Rollback(key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.inflight, key)
+
+	return nil
+}
@@ -3,7 +3,13 @@ package buffer
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"os"
+	"strings"
+	"sync"
 
 	//"log/slog"
 	"math/rand"
@@ -18,21 +24,30 @@ import (
 
 type Redis struct {
 	config     *config.Config
-	client     *redis.Client
+	client     redis.UniversalClient
 	ctx        context.Context
 	instanceId string
+	mu         sync.Mutex
+	corrupted  map[string]map[int]bool
 }
 
-func NewRedis(ctx context.Context, config *config.Config, client *redis.Client) Buffer {
+func NewRedis(ctx context.Context, config *config.Config, client redis.UniversalClient) Buffer {
 	ret := &Redis{
-		config: config,
-		ctx:    ctx,
+		config:    config,
+		ctx:       ctx,
+		corrupted: make(map[string]map[int]bool),
 	}
 
 	if client != nil {
 		ret.client = client
 	} else {
-		ret.client = createClient(config)
+		var err error
+		ret.client, err = createClient(config)
+
+		if err != nil {
+			slog.Error("Error creating redis client", "error", err, "module", "buffer", "function", "NewRedis")
+			return nil
+		}
 	}
 
 	ret.makeInstanceName()
@@ -42,6 +57,8 @@ func NewRedis(ctx context.Context, config *config.Config, client *redis.Client)
 		return nil
 	}
 
+	ret.migrateKeys()
+
 	return ret
 }
 
@@ -59,19 +76,106 @@ func (r *Redis) Close() error {
 	return nil
 }
 
-func createClient(cfg *config.Config) *redis.Client {
-	return redis.NewClient(&redis.Options{
-		Addr:        cfg.RedisHost,
-		Password:    cfg.RedisPassword,
-		DB:          cfg.RedisDB,
-		ReadTimeout: time.Duration(cfg.RedisTimeout),
-	})
+func createClient(cfg *config.Config) (redis.UniversalClient, error) {
+	tlsConfig, err := createTLSConfig(cfg)
+
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := splitHosts(cfg.RedisHost)
+
+	switch cfg.RedisMode {
+	case config.RedisModeCluster:
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:       addrs,
+			Username:    cfg.RedisUsername,
+			Password:    cfg.RedisPassword,
+			ReadTimeout: time.Duration(cfg.RedisTimeout),
+			TLSConfig:   tlsConfig,
+		}), nil
+	case config.RedisModeSentinel:
+		if len(cfg.RedisSentinelMaster) == 0 {
+			return nil, errors.New("redis sentinel master name is empty")
+		}
+
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       cfg.RedisSentinelMaster,
+			SentinelAddrs:    addrs,
+			SentinelUsername: cfg.RedisUsername,
+			SentinelPassword: cfg.RedisSentinelPassword,
+			Username:         cfg.RedisUsername,
+			Password:         cfg.RedisPassword,
+			DB:               cfg.RedisDB,
+			ReadTimeout:      time.Duration(cfg.RedisTimeout),
+			TLSConfig:        tlsConfig,
+		}), nil
+	default:
+		return redis.NewClient(&redis.Options{
+			Addr:        cfg.RedisHost,
+			Username:    cfg.RedisUsername,
+			Password:    cfg.RedisPassword,
+			DB:          cfg.RedisDB,
+			ReadTimeout: time.Duration(cfg.RedisTimeout),
+			TLSConfig:   tlsConfig,
+		}), nil
+	}
 }
 
-func (r *Redis) getClient() *redis.Client {
+func createTLSConfig(cfg *config.Config) (*tls.Config, error) {
+	if !cfg.RedisTLS {
+		return nil, nil
+	}
+
+	ret := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: cfg.RedisTLSInsecure,
+	}
+
+	if len(cfg.RedisTLSCAPath) > 0 {
+		pem, err := os.ReadFile(cfg.RedisTLSCAPath)
+
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no valid certificates found on %s", cfg.RedisTLSCAPath)
+		}
+
+		ret.RootCAs = pool
+	}
+
+	return ret, nil
+}
+
+func splitHosts(hosts string) []string {
+	ret := make([]string, 0)
+
+	for _, host := range strings.Split(hosts, ",") {
+		host = strings.TrimSpace(host)
+
+		if len(host) > 0 {
+			ret = append(ret, host)
+		}
+	}
+
+	return ret
+}
+
+func (r *Redis) getClient() redis.UniversalClient {
 	if r.client == nil {
-		slog.Info("Connecting to redis", "host", r.config.RedisHost, "db", r.config.RedisDB)
-		r.client = createClient(r.config)
+		slog.Info("Connecting to redis", "host", r.config.RedisHost, "db", r.config.RedisDB, "mode", r.config.RedisMode)
+		client, err := createClient(r.config)
+
+		if err != nil {
+			slog.Error("Error creating redis client", "error", err, "module", "buffer.redis", "function", "getClient")
+			return nil
+		}
+
+		r.client = client
 
 		slog.Debug("Connected to redis")
 	}
@@ -79,37 +183,197 @@ func (r *Redis) getClient() *redis.Client {
 	return r.client
 }
 
+// scanKeys iterates over keys matching pattern with SCAN, on Redis Cluster every master node is scanned.
+func (r *Redis) scanKeys(pattern string) ([]string, error) {
+	client := r.getClient()
+
+	if client == nil {
+		return nil, errors.New("redis client is not available")
+	}
+
+	ret := make([]string, 0)
+	mu := sync.Mutex{}
+
+	scan := func(ctx context.Context, c redis.Cmdable) error {
+		iter := c.Scan(ctx, 0, pattern, r.config.RedisScanCount).Iterator()
+
+		for iter.Next(ctx) {
+			mu.Lock()
+			ret = append(ret, iter.Val())
+			mu.Unlock()
+		}
+
+		return iter.Err()
+	}
+
+	var err error
+
+	if cluster, ok := client.(*redis.ClusterClient); ok {
+		err = cluster.ForEachMaster(r.ctx, func(ctx context.Context, c *redis.Client) error {
+			return scan(ctx, c)
+		})
+	} else {
+		err = scan(r.ctx, client)
+	}
+
+	if err != nil {
+		slog.Error("Error scanning keys", "error", err, "pattern", pattern, "module", "buffer.redis", "function", "scanKeys")
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+// deleteKeys deletes keys one by one in a pipeline, a multi-key DEL fails on Redis Cluster when keys live in different slots.
+func (r *Redis) deleteKeys(keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	_, err := r.getClient().Pipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Del(r.ctx, key)
+		}
+		return nil
+	})
+
+	return err
+}
+
 func (r *Redis) makeInstanceName() {
 	entropy := rand.New(rand.NewSource(time.Now().UnixNano()))
 	r.instanceId = fmt.Sprintf("%s-%s", r.config.RedisLockInstanceName, ulid.MustNew(ulid.Timestamp(time.Now()), entropy).String())
 }
 
+// Every key derived from a record key carries it as a hash tag, so data, in-flight, lock, DLQ and recovery
+// keys of the same record key land on the same Redis Cluster slot and can be used together on scripts.
 func (r *Redis) makeDataKey(key string) string {
-	return fmt.Sprintf("%s:%s", r.config.RedisDataPrefix, key)
+	return fmt.Sprintf("%s:{%s}", r.config.RedisDataPrefix, key)
 }
 
 func (r *Redis) makeRecoveryKey(key string) string {
-	return fmt.Sprintf("%s:%s", r.config.RedisRecoveryKey, key)
+	return fmt.Sprintf("%s:{%s}", r.config.RedisRecoveryKey, key)
 }
 
 func (r *Redis) makeDLQKey(key string) string {
-	return fmt.Sprintf("%s:%s", r.config.RedisDLQPrefix, key)
+	return fmt.Sprintf("%s:{%s}", r.config.RedisDLQPrefix, key)
+}
+
+func (r *Redis) makeInflightKey(key string) string {
+	return fmt.Sprintf("%s:{%s}", r.config.RedisInflightPrefix, key)
+}
+
+func (r *Redis) makeCorruptedKey(key string) string {
+	return fmt.Sprintf("%s:corrupted:{%s}", r.config.RedisInflightPrefix, key)
+}
+
+func (r *Redis) makeBytesKey(key string) string {
+	return fmt.Sprintf("%s:bytes:{%s}", r.config.RedisDataPrefix, key)
 }
 
 func (r *Redis) makeLockKey(key string) string {
-	return fmt.Sprintf("%s:%s", r.config.RedisLockPrefix, key)
+	return fmt.Sprintf("%s:{%s}", r.config.RedisLockPrefix, key)
+}
+
+// makeLegacyKey returns the name of a list written before keys had a hash tag, as `prefix:key`.
+func makeLegacyKey(prefix string, key string) string {
+	return fmt.Sprintf("%s:%s", prefix, key)
+}
+
+// migrateKeys moves the data, DLQ and recovery lists written before keys had a hash tag to their current names, so
+// records buffered by older versions are not left behind on upgrade. Old lists are deleted once empty, next starts
+// find nothing to move. Stream buffers keep their records on streams, only their DLQ and recovery lists are moved.
+func (r *Redis) migrateKeys() {
+	moved := 0
+
+	if r.config.BufferType != config.BufferTypeRedisStream {
+		for _, key := range r.Keys() {
+			moved += r.migrateList(makeLegacyKey(r.config.RedisDataPrefix, key), r.makeDataKey(key), r.makeBytesKey(key))
+		}
+	}
+
+	for _, prefix := range []string{r.config.RedisDLQPrefix, r.config.RedisRecoveryKey} {
+		names, err := r.scanKeys(makeLegacyKey(prefix, "*"))
+
+		if err != nil {
+			continue
+		}
+
+		for _, name := range names {
+			key := strings.TrimPrefix(name, prefix+":")
+
+			if strings.HasPrefix(key, "{") {
+				continue
+			}
+
+			if prefix == r.config.RedisDLQPrefix {
+				moved += r.migrateList(name, r.makeDLQKey(key), "")
+			} else {
+				moved += r.migrateList(name, r.makeRecoveryKey(key), "")
+			}
+		}
+	}
+
+	if moved > 0 {
+		slog.Warn("Moved records from keys without hash tag", "records", moved, "module", "buffer.redis", "function", "migrateKeys")
+	}
+}
+
+// migrateList pops the items of the legacy list one by one and pushes them in front of the current list, keeping
+// their order, so instances starting together never move an item twice. The bytes of data items are added to the
+// counter on bytesKey, when it is set.
+func (r *Redis) migrateList(legacy string, current string, bytesKey string) int {
+	client := r.getClient()
+	count := 0
+
+	for {
+		item, err := client.RPop(r.ctx, legacy).Result()
+
+		if err == redis.Nil {
+			return count
+		}
+
+		if err != nil {
+			slog.Error("Error reading legacy key", "error", err, "key", legacy, "module", "buffer.redis", "function", "migrateList")
+			return count
+		}
+
+		_, err = client.TxPipelined(r.ctx, func(pipe redis.Pipeliner) error {
+			pipe.LPush(r.ctx, current, item)
+
+			if len(bytesKey) > 0 {
+				pipe.IncrBy(r.ctx, bytesKey, int64(len(item)))
+			}
+			return nil
+		})
+
+		if err != nil {
+			slog.Error("Error moving legacy key, keeping it to the next start", "error", err, "key", legacy, "to", current, "module", "buffer.redis", "function", "migrateList")
+			client.RPush(r.ctx, legacy, item)
+			return count
+		}
+
+		count++
+	}
 }
 
 func (r *Redis) Len(key string) int {
 	client := r.getClient()
-	cmd := client.LLen(r.ctx, r.makeDataKey(key))
 
-	if cmd.Err() != nil {
-		slog.Error("Error getting key length", "error", cmd.Err())
+	var data, inflight *redis.IntCmd
+
+	_, err := client.Pipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		data = pipe.LLen(r.ctx, r.makeDataKey(key))
+		inflight = pipe.LLen(r.ctx, r.makeInflightKey(key))
+		return nil
+	})
+
+	if err != nil {
+		slog.Error("Error getting key length", "error", err)
 		return 0
 	}
 
-	return int(cmd.Val())
+	return int(data.Val() + inflight.Val())
 }
 
 func (r *Redis) Push(key string, item domain.Record) (int, error) {
@@ -134,11 +398,17 @@ func (r *Redis) Push(key string, item domain.Record) (int, error) {
 	rkey := r.makeDataKey(key)
 	data := item.ToMsgPack()
 
-	ret := client.RPush(r.ctx, rkey, data)
+	var ret *redis.IntCmd
 
-	if ret.Err() != nil {
-		slog.Error("Error pushing to key", "error", ret.Err())
-		return 0, ret.Err()
+	_, err := client.TxPipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		ret = pipe.RPush(r.ctx, rkey, data)
+		pipe.IncrBy(r.ctx, r.makeBytesKey(key), int64(len(data)))
+		return nil
+	})
+
+	if err != nil {
+		slog.Error("Error pushing to key", "error", err)
+		return 0, err
 	}
 
 	l := int(ret.Val())
@@ -146,6 +416,32 @@ func (r *Redis) Push(key string, item domain.Record) (int, error) {
 	return l, nil
 }
 
+// Size returns the bytes of queued and in-flight records of a key, tracked by a counter next to the data list.
+func (r *Redis) Size(key string) int64 {
+	cmd := r.getClient().Get(r.ctx, r.makeBytesKey(key))
+
+	if cmd.Err() != nil {
+		if cmd.Err() != redis.Nil {
+			slog.Error("Error getting key size", "error", cmd.Err(), "key", key, "module", "buffer.redis", "function", "Size")
+		}
+		return 0
+	}
+
+	ret, err := cmd.Int64()
+
+	if err != nil {
+		slog.Error("Error parsing key size", "error", err, "key", key, "module", "buffer.redis", "function", "Size")
+		return 0
+	}
+
+	return ret
+}
+
+// TotalSize is always zero, records are held by the Redis server and not by this process.
+func (r *Redis) TotalSize() int64 {
+	return 0
+}
+
 func (r *Redis) PushDLQ(key string, item domain.Record) error {
 	rKey := r.makeDLQKey(key)
 
@@ -166,17 +462,17 @@ func (r *Redis) PushDLQ(key string, item domain.Record) error {
 func (r *Redis) GetDLQ() (map[string][]domain.Record, error) {
 	ctx := r.ctx
 
-	keys := r.client.Keys(ctx, r.makeDLQKey("*"))
+	keys, err := r.scanKeys(r.makeDLQKey("*"))
 
-	if keys.Err() != nil {
-		slog.Error("GetDLQ - Error getting keys", "error", keys.Err())
-		return nil, keys.Err()
+	if err != nil {
+		slog.Error("GetDLQ - Error getting keys", "error", err)
+		return nil, err
 	}
 
 	ret := make(map[string][]domain.Record)
 	client := r.getClient()
 
-	for _, key := range keys.Val() {
+	for _, key := range keys {
 		result := client.LRange(ctx, key, 0, -1)
 
 		if result.Err() != nil {
@@ -243,61 +539,216 @@ func (r *Redis) CheckLock(key string) bool {
 	return ret
 }
 
+// getScript moves up to ARGV[1] records from the head of the data list into the in-flight list,
+// when the in-flight list already exists (a previous flush was not committed) it is delivered again.
+var getScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[2]) == 1 then
+	return redis.call('LRANGE', KEYS[2], 0, -1)
+end
+local items = redis.call('LRANGE', KEYS[1], 0, tonumber(ARGV[1]) - 1)
+if #items == 0 then
+	return items
+end
+redis.call('LTRIM', KEYS[1], #items, -1)
+for i = 1, #items, 1000 do
+	redis.call('RPUSH', KEYS[2], unpack(items, i, math.min(i + 999, #items)))
+end
+return items
+`)
+
+// takeScript moves up to ARGV[1] records from the data list to the in-flight list like getScript, returning the
+// in-flight length instead of the records. Records already in-flight are taken again.
+var takeScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[2]) == 0 then
+	local items = redis.call('LRANGE', KEYS[1], 0, tonumber(ARGV[1]) - 1)
+	if #items > 0 then
+		redis.call('LTRIM', KEYS[1], #items, -1)
+		for i = 1, #items, 1000 do
+			redis.call('RPUSH', KEYS[2], unpack(items, i, math.min(i + 999, #items)))
+		end
+	end
+end
+return redis.call('LLEN', KEYS[2])
+`)
+
+// commitScript drops the first ARGV[1] records of the in-flight list and returns the remaining ones to the
+// head of the data list, keeping their original order. A negative size commits every in-flight record.
+// The bytes of dropped records are subtracted from the size counter on KEYS[3], and dropped records at the in-flight
+// indexes of the next arguments are copied to the corrupted list on KEYS[4].
+var commitScript = redis.NewScript(`
+local total = redis.call('LLEN', KEYS[2])
+local size = tonumber(ARGV[1])
+if size < 0 or size > total then
+	size = total
+end
+for i = 2, #ARGV do
+	local index = tonumber(ARGV[i])
+	if index < size then
+		local item = redis.call('LINDEX', KEYS[2], index)
+		if item then
+			redis.call('RPUSH', KEYS[4], item)
+		end
+	end
+end
+if size > 0 then
+	local bytes = 0
+	for _, item in ipairs(redis.call('LRANGE', KEYS[2], 0, size - 1)) do
+		bytes = bytes + string.len(item)
+	end
+	if redis.call('DECRBY', KEYS[3], bytes) <= 0 then
+		redis.call('DEL', KEYS[3])
+	end
+end
+local rest = redis.call('LRANGE', KEYS[2], size, -1)
+for i = #rest, 1, -1 do
+	redis.call('LPUSH', KEYS[1], rest[i])
+end
+redis.call('DEL', KEYS[2])
+return size
+`)
+
 func (r *Redis) Get(key string) []domain.Record {
 	rkey := r.makeDataKey(key)
+	inflight := r.makeInflightKey(key)
 	client := r.getClient()
 
-	cmd := client.LLen(r.ctx, rkey)
+	result := getScript.Run(r.ctx, client, []string{rkey, inflight}, r.config.BufferSize)
 
-	if cmd.Err() != nil {
-		slog.Error("Error getting key", "error", cmd.Err())
+	if result.Err() != nil && result.Err() != redis.Nil {
+		slog.Error("Error moving data to in-flight", "error", result.Err(), "key", key, "module", "buffer.redis", "function", "Get")
+		return make([]domain.Record, 0)
+	}
+
+	vals, err := result.StringSlice()
+
+	if err != nil && err != redis.Nil {
+		slog.Error("Error reading in-flight data", "error", err, "key", key, "module", "buffer.redis", "function", "Get")
 		return make([]domain.Record, 0)
 	}
 
-	size := cmd.Val()
+	ret := make([]domain.Record, 0, len(vals))
+	corrupted := make([]string, 0)
+
+	for _, v := range vals {
+		rec := domain.NewObj(r.config.RecordType)
+		err = rec.FromMsgPack([]byte(v))
+		if err != nil {
+			slog.Error("Error decoding record, moving to corrupted list", "error", err, "module", "buffer.redis", "function", "Get", "key", key)
+			corrupted = append(corrupted, v)
+			continue
+		}
+		ret = append(ret, rec)
+	}
+
+	if len(corrupted) > 0 {
+		r.moveCorrupted(key, corrupted)
+	}
+
+	slog.Debug("Got buffer", "key", key, "records", len(ret), "corrupted", len(corrupted))
+
+	return ret
+}
+
+func (r *Redis) moveCorrupted(key string, values []string) {
+	inflight := r.makeInflightKey(key)
+	corrupted := r.makeCorruptedKey(key)
 
-	if size > int64(r.config.BufferSize) {
-		slog.Debug("Buffer size is bigger than config, will get partial data", "key", key, "buffer-size", size, "get-size", r.config.BufferSize)
-		size = int64(r.config.BufferSize)
+	_, err := r.getClient().TxPipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		for _, v := range values {
+			pipe.LRem(r.ctx, inflight, 1, v)
+			pipe.RPush(r.ctx, corrupted, v)
+			pipe.DecrBy(r.ctx, r.makeBytesKey(key), int64(len(v)))
+		}
+		return nil
+	})
+
+	if err != nil {
+		slog.Error("Error moving corrupted records", "error", err, "key", key, "count", len(values), "module", "buffer.redis", "function", "moveCorrupted")
 	}
+}
 
-	result := client.LRange(r.ctx, rkey, 0, size-1)
+// Take moves up to BufferSize records of key to the in-flight list and returns how many are in-flight, they are read
+// with Page.
+func (r *Redis) Take(key string) int {
+	cmd := takeScript.Run(r.ctx, r.getClient(), []string{r.makeDataKey(key), r.makeInflightKey(key)}, r.config.BufferSize)
 
-	if result.Err() != nil {
-		slog.Error("Error getting key", "error", result.Err())
+	if cmd.Err() != nil && cmd.Err() != redis.Nil {
+		slog.Error("Error moving data to in-flight", "error", cmd.Err(), "key", key, "module", "buffer.redis", "function", "Take")
+		return 0
+	}
+
+	ret, _ := cmd.Int()
+
+	return ret
+}
+
+// Page decodes in-flight records of key, corrupted ones are skipped and copied to the corrupted list when they are
+// dropped on Clear, keeping the offsets of the next pages.
+func (r *Redis) Page(key string, offset int, size int) []domain.Record {
+	client := r.getClient()
+	vals, err := client.LRange(r.ctx, r.makeInflightKey(key), int64(offset), int64(offset+size-1)).Result()
+
+	if err != nil && err != redis.Nil {
+		slog.Error("Error reading in-flight data", "error", err, "key", key, "offset", offset, "module", "buffer.redis", "function", "Page")
 		return make([]domain.Record, 0)
 	}
 
-	ret := make([]domain.Record, size)
+	ret := make([]domain.Record, 0, len(vals))
 
-	var err error
-	for i, v := range result.Val() {
+	for i, v := range vals {
 		rec := domain.NewObj(r.config.RecordType)
 		err = rec.FromMsgPack([]byte(v))
 		if err != nil {
-			slog.Error("Error decoding record", "error", err, "module", "buffer.redis", "function", "Get", "record", v)
-			return ret
+			slog.Error("Error decoding record, it goes to the corrupted list on commit", "error", err, "module", "buffer.redis", "function", "Page", "key", key)
+			r.markCorrupted(key, offset+i)
+			continue
 		}
-		ret[i] = rec
+		ret = append(ret, rec)
 	}
 
-	slog.Debug("Got buffer", "key", key, "size", size, "records", len(ret))
-
 	return ret
 }
 
+func (r *Redis) markCorrupted(key string, index int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.corrupted[key]; !ok {
+		r.corrupted[key] = make(map[int]bool)
+	}
+
+	r.corrupted[key][index] = true
+}
+
+// Clear commits the in-flight batch: the first size records are dropped and any remaining one returns to the head of the queue.
 func (r *Redis) Clear(key string, size int) error {
-	rkey := r.makeDataKey(key)
+	return r.commit(key, size)
+}
+
+// Rollback returns the whole in-flight batch to the head of the queue, to be delivered again on next Get.
+func (r *Redis) Rollback(key string) error {
+	return r.commit(key, 0)
+}
+
+func (r *Redis) commit(key string, size int) error {
 	client := r.getClient()
+	args := []interface{}{size}
+
+	r.mu.Lock()
+	for index := range r.corrupted[key] {
+		args = append(args, index)
+	}
+	delete(r.corrupted, key)
+	r.mu.Unlock()
 
-	cmd := client.LPopCount(r.ctx, rkey, size)
+	cmd := commitScript.Run(r.ctx, client, []string{r.makeDataKey(key), r.makeInflightKey(key), r.makeBytesKey(key), r.makeCorruptedKey(key)}, args...)
 
 	if cmd.Err() != nil {
-		slog.Error("Error clearing key", "error", cmd.Err())
+		slog.Error("Error committing in-flight data", "error", cmd.Err(), "key", key, "size", size, "module", "buffer.redis", "function", "commit")
 		return cmd.Err()
 	}
 
-	slog.Debug("Cleared buffer", "key", key, "size", size, "module", "buffer.redis", "function", "Clear")
+	slog.Debug("Committed in-flight data", "key", key, "size", size, "cleared", cmd.Val(), "module", "buffer.redis", "function", "commit")
 	return nil
 }
 
@@ -319,6 +770,11 @@ func (r *Redis) Keys() []string {
 
 func (r *Redis) IsReady() bool {
 	client := r.getClient()
+
+	if client == nil {
+		return false
+	}
+
 	cmd := client.Ping(r.ctx)
 
 	if cmd.Err() != nil {
@@ -331,15 +787,14 @@ func (r *Redis) IsReady() bool {
 }
 
 func (r *Redis) HasRecovery() bool {
-	client := r.getClient()
-	cmd := client.Keys(r.ctx, r.makeRecoveryKey("*"))
+	keys, err := r.scanKeys(r.makeRecoveryKey("*"))
 
-	if cmd.Err() != nil {
-		slog.Error("Error getting keys", "error", cmd.Err())
+	if err != nil {
+		slog.Error("Error getting keys", "error", err)
 		return false
 	}
 
-	return len(cmd.Val()) > 0
+	return len(keys) > 0
 }
 
 func (r *Redis) PushRecovery(key string, buf *bytes.Buffer) error {
@@ -368,17 +823,16 @@ func (r *Redis) PushRecovery(key string, buf *bytes.Buffer) error {
 
 func (r *Redis) GetRecovery() ([]*RecoveryData, error) {
 	client := r.getClient()
-	keys := client.Keys(r.ctx, r.makeRecoveryKey("*"))
+	recKeys, err := r.scanKeys(r.makeRecoveryKey("*"))
 
-	if keys.Err() != nil {
-		slog.Error("GetRecovery - Error getting keys", "error", keys.Err())
-		return []*RecoveryData{}, keys.Err()
+	if err != nil {
+		slog.Error("GetRecovery - Error getting keys", "error", err)
+		return []*RecoveryData{}, err
 	}
 
-	recKeys := keys.Val()
 	ret := make([]*RecoveryData, 0)
 
-	for i, key := range recKeys {
+	for _, key := range recKeys {
 		result := client.LRange(r.ctx, key, 0, -1)
 
 		if result.Err() != nil {
@@ -386,11 +840,8 @@ func (r *Redis) GetRecovery() ([]*RecoveryData, error) {
 			return ret, result.Err()
 		}
 
-		vals := result.Val()
-		item := &RecoveryData{}
-		items := make([]*RecoveryData, len(vals))
-
-		for _, v := range vals {
+		for _, v := range result.Val() {
+			item := &RecoveryData{}
 			err := item.FromMsgPack([]byte(v))
 
 			if err != nil {
@@ -398,31 +849,26 @@ func (r *Redis) GetRecovery() ([]*RecoveryData, error) {
 				return ret, err
 			}
 
-			items[i] = item
+			ret = append(ret, item)
 		}
-
-		ret = append(ret, items...)
 	}
 
 	return ret, nil
 }
 
 func (r *Redis) ClearRecoveryData() error {
-	client := r.getClient()
-	keys := client.Keys(r.ctx, r.makeRecoveryKey("*"))
+	recKeys, err := r.scanKeys(r.makeRecoveryKey("*"))
 
-	if keys.Err() != nil {
-		slog.Error("ClearRecoveryData - Error getting keys", "error", keys.Err())
-		return keys.Err()
+	if err != nil {
+		slog.Error("ClearRecoveryData - Error getting keys", "error", err)
+		return err
 	}
 
-	recKeys := keys.Val()
+	err = r.deleteKeys(recKeys)
 
-	popRet := client.Del(r.ctx, recKeys...)
-
-	if popRet.Err() != nil {
-		slog.Error("Error deleting key from recovery data", "error", popRet.Err())
-		return popRet.Err()
+	if err != nil {
+		slog.Error("Error deleting key from recovery data", "error", err)
+		return err
 	}
 
 	slog.Debug("Cleared recovery data", "module", "buffer.redis", "function", "ClearRecoveryData")
@@ -431,21 +877,18 @@ func (r *Redis) ClearRecoveryData() error {
 }
 
 func (r *Redis) ClearDLQ() error {
-	client := r.getClient()
-	keys := client.Keys(r.ctx, r.makeDLQKey("*"))
+	recKeys, err := r.scanKeys(r.makeDLQKey("*"))
 
-	if keys.Err() != nil {
-		slog.Error("ClearDLQ - Error getting keys", "error", keys.Err())
-		return keys.Err()
+	if err != nil {
+		slog.Error("ClearDLQ - Error getting keys", "error", err)
+		return err
 	}
 
-	recKeys := keys.Val()
-
-	popRet := client.Del(r.ctx, recKeys...)
+	err = r.deleteKeys(recKeys)
 
-	if popRet.Err() != nil {
-		slog.Error("Error deleting key from DLQ", "error", popRet.Err())
-		return popRet.Err()
+	if err != nil {
+		slog.Error("Error deleting key from DLQ", "error", err)
+		return err
 	}
 
 	slog.Debug("Cleared DLQ", "module", "buffer.redis", "function", "ClearDLQ")
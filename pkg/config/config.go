@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"regexp"
+	"strconv"
 
 	"data2parquet/pkg/logger" //"log/slog"
 	"os"
@@ -14,149 +15,541 @@ var slog = logger.GetLogger()
 
 type Config struct {
 	//Address: HTTP server Address configuration tag, describe the address of the server, its an optional field only used for HTTP server. The default value is empty.
+	//AzureAccessTier: AzureAccessTier configuration tag, describe the access tier of the blobs written by the `azure-blob` writer, this fields accepte four values, `hot`, `cool`, `cold` or `archive`. The default value is empty, using the default tier of the storage account.
+	//AzureAccountKey: AzureAccountKey configuration tag, describe the shared key of the Azure storage account, its an optional field only used if `AzureAuthMode` is `shared-key`. The default value is empty.
+	//AzureAccountName: AzureAccountName configuration tag, describe the name of the Azure storage account, its an optional field. The default value is empty but need to be set if you use `azure-blob` as a writer.
+	//AzureAuthMode: AzureAuthMode configuration tag, describe how the `azure-blob` writer authenticates, this fields accepte three values, `shared-key` (`AzureAccountKey`), `sas` (`AzureSASToken`) or `managed-identity` (a token of the managed identity of the host, with `AzureClientID` for user assigned identities). The default value is `shared-key` when `AzureAccountKey` is set, `sas` when `AzureSASToken` is set, otherwise `managed-identity`.
+	//AzureBlockSize: AzureBlockSize configuration tag, describe the size in bytes of each block staged by the `azure-blob` writer, files bigger than one block are staged in blocks and committed with a block list, its an optional field. The minimum value is `1048576` (1M), and the default value is `8388608` (8M).
+	//AzureClientID: AzureClientID configuration tag, describe the client id of the user assigned managed identity used by the `azure-blob` writer, its an optional field only used if `AzureAuthMode` is `managed-identity`. The default value is empty, using the system assigned identity.
+	//AzureContainerName: AzureContainerName configuration tag, describe the container of the blobs written by the `azure-blob` writer, its an optional field. The default value is empty but need to be set if you use `azure-blob` as a writer.
+	//AzureEndpoint: AzureEndpoint configuration tag, describe the blob service endpoint, as `http://127.0.0.1:10000/devstoreaccount1` for Azurite, its an optional field. The default value is `https://<AzureAccountName>.blob.core.windows.net`.
+	//AzurePrefix: AzurePrefix configuration tag, describe the prefix added to the name of the blobs written by the `azure-blob` writer, its an optional field. The default value is empty.
+	//AzureSASToken: AzureSASToken configuration tag, describe the shared access signature appended to each request, with at least the create, write and list permissions on the container, its an optional field only used if `AzureAuthMode` is `sas`. The default value is empty.
+	//AzureUploadConcurrency: AzureUploadConcurrency configuration tag, describe how many blocks of a blob are staged at the same time, each one holding `AzureBlockSize` bytes in memory, its an optional field. The default value is `4`.
+	//BufferBackpressure: BufferBackpressure configuration tag, describe what to do with new records when `BufferMemoryLimit` is reached, this fields accepte two values, `reject` (return an error to the caller) or `block` (wait for flushes until `BufferBackpressureTimeout`). The default value is `reject`.
+	//BufferBackpressureTimeout: BufferBackpressureTimeout configuration tag, describe the max time in seconds a write waits when `BufferBackpressure` is `block`, before being rejected. The default value is the `FlushInterval` value.
+	//BufferFlushBytes: BufferFlushBytes configuration tag, describe the approximate encoded size in bytes of a key buffer that triggers a flush, even before `BufferSize` records, its an optional field. The default value is `0` (disabled).
+	//BufferMemoryLimit: BufferMemoryLimit configuration tag, describe the approximate encoded size in bytes of all records held in memory by the buffer, when reached backpressure is applied to writes, its an optional field. The default value is `0` (disabled). Redis buffers keep records on the server and are not limited.
+	//BufferPageSize: BufferPageSize configuration tag, describe how many records are read from the buffer at a time on each flush, they are converted and streamed to the writer page by page, keeping memory bounded regardless of `BufferSize`. The default value is `1000`.
 	//BufferSize: BufferSize configuration tag, describe the size of the buffer, its an important field for control buffer and page size to flush data. The default value is `100`.
-	//BufferType: BufferType configuration tag, describe the type of the buffer, this fields accepte two values, `mem` or `redis`. The default value is `mem`.
+	//BufferType: BufferType configuration tag, describe the type of the buffer, this fields accepte four values, `mem`, `redis`, `redis-stream` or `disk`. The default value is `mem`.
 	//Debug: Debug configuration tag, describe the debug mode, its an optional field. The debug mode will generate a lot of information. The default value is `false`.
+	//DiskPath: DiskPath configuration tag, describe the directory used by the disk buffer to store its write-ahead-log segments, its an optional field only used if `BufferType` is `disk`. The default value is `./data/buffer`.
+	//DiskSegmentSize: DiskSegmentSize configuration tag, describe the max size in bytes of each disk buffer segment before rotate to a new one, its an optional field. The default value is `67108864` (64M).
+	//DiskSyncInterval: DiskSyncInterval configuration tag, describe the interval in milliseconds to fsync disk buffer segments when `DiskSyncPolicy` is `interval`, its an optional field. The default value is `1000`.
+	//DiskSyncPolicy: DiskSyncPolicy configuration tag, describe when the disk buffer calls fsync, this fields accepte three values, `always` (each push), `interval` or `none` (let the OS decide). The default value is `interval`.
+	//DynamicKeyFields: DynamicKeyFields configuration tag, describe the fields of `dynamic` records used as their key, a comma separated list of JSONPath like expressions as `$.resource.service`, `labels['app.name']` or `tags[0]`, each one with an optional default value after `|`. The first four values are the capability, domain, service and application of the record. The default value is `capability|dynamic_capability,domain|dynamic_domain,service|dynamic_service,application|dynamic_application`.
+	//DynamicSchemaMode: DynamicSchemaMode configuration tag, describe how the parquet schema of `dynamic` records is defined, this fields accepte two values, `file` (the xitongsys JSON schema at `JsonSchemaPath`) or `infer` (derived from each flushed batch and cached by key). The default value is `file` when `JsonSchemaPath` is set, otherwise `infer`.
+	//DynamicSchemaSampleSize: DynamicSchemaSampleSize configuration tag, describe how many records of each flushed batch are sampled to infer the schema of `dynamic` records, its an optional field. The default value is `1000`.
+	//DynamicTimeField: DynamicTimeField configuration tag, describe the field of `dynamic` records that holds the event time used to partition files, its an optional field. The default value is `time`.
 	//FlushInterval: FlushInterval configuration tag, describe the interval to flush data in seconds, its an important field to control the time to flush data. The default value is `5`.
+	//GCSBucketName: GCSBucketName configuration tag, describe the bucket name in Google Cloud Storage, its an optional field. The default value is empty but need to be set if you use `gcs` as a writer.
+	//GCSChunkSize: GCSChunkSize configuration tag, describe the size in bytes of each chunk of GCS resumable uploads, rounded to a multiple of `262144` (256K), files bigger than one chunk use resumable uploads. The default value is `16777216` (16M).
+	//GCSCredentialsFile: GCSCredentialsFile configuration tag, describe the path of a service account JSON key used by the `gcs` writer, its an optional field. The default value is empty, using the default credentials (`GOOGLE_APPLICATION_CREDENTIALS` or the metadata server).
+	//GCSEndpoint: GCSEndpoint configuration tag, describe the endpoint of the Google Cloud Storage JSON API, its an optional field. The default value is `https://storage.googleapis.com`, or `STORAGE_EMULATOR_HOST` without credentials when it is set.
+	//GCSPrefix: GCSPrefix configuration tag, describe the prefix added to the name of the objects written by the `gcs` writer, its an optional field. The default value is empty.
 	//IgnoredFields: IgnoredFields configuration tag, describe the fields to ignore in the data, its an optional field. The default value is empty. Fields must be separated by comma.
-	//JsonSchemaPath: JsonSchemaPath configuration tag, describe the path to the JSON schema file, its an optional field. The default value is empty. *This feature is not implemented yet.
+	//JsonSchemaPath: JsonSchemaPath configuration tag, describe the path to the JSON schema file, its an optional field. The default value is empty. Used by `dynamic` records when `DynamicSchemaMode` is `file`, accepts standard JSON Schema (draft 2020-12) documents, validating each record before the buffer, or the xitongsys parquet schema format.
 	//LogFormatter: LogFormatter configuration tag, describe the log formatter, this fields accepte four values, `color`, `text`, `json` or `multi`. The default value is `color`.
+	//LogSchemaVersion: LogSchemaVersion configuration tag, describe the parquet layout of `log` records, `1` is the legacy layout with `duration` and `http-response` as strings and `2` writes them as INT64 nanoseconds and INT32. The default value is `2`.
 	//MaskFields: MaskFields configuration tag, describe the fields to mask in the data, its an optional field. The default value is empty. Fields must be separated by comma.
+	//PartitionTimezone: PartitionTimezone configuration tag, describe the timezone used to build the `year/month/day/hour` partitions from the record event time, this fields accepte `utc`, `local` or an IANA name like `America/Sao_Paulo`. The default value is `utc`.
 	//Port: Port configuration tag, describe the port of the server, its an optional field only used for HTTP server. The default value is `8080``.
-	//RecordType: RecordType configuration tag, describe the type of the record, this fields accepte two values, `log` or `dynamic``. The default value is log. *Dynamic type is not implemented yet.
+	//RecordType: RecordType configuration tag, describe the type of the record, this fields accepte three values, `log`, `log_legacy` (previous log schema generation) or `dynamic``. The default value is log.
 	//RecoveryAttempts: RecoveryAttempts configuration tag, describe the number of attempts to recover data, its an optional field. The default value is `0``.
 	//RedisDataPrefix: RedisDataPrefix configuration tag, describe the prefix of the data key in Redis, its an optional field. The default value is `data`.
 	//RedisDB: RedisDB configuration tag, describe the database number in Redis, its an optional field. The default value is `0`.
 	//RedisDLQPrefix: RedisDLQPrefix configuration tag, describe the prefix of the DLQ key in Redis, its an optional field. The default value is `dlq`.
-	//RedisHost: RedisHost configuration tag, describe the host of the Redis server, its an optional field if you use 'BufferType` as `mem`, but became required if `BufferType` is `redis`. The default value is empty but need to be set if `BufferType` is `redis`.
+	//RedisHost: RedisHost configuration tag, describe the host of the Redis server, its an optional field if you use 'BufferType` as `mem`, but became required if `BufferType` is `redis`. The default value is empty but need to be set if `BufferType` is `redis`. For `cluster` and `sentinel` modes, use a comma separated list of `host:port` (cluster nodes or sentinels).
+	//RedisInflightPrefix: RedisInflightPrefix configuration tag, describe the prefix of the in-flight list key in Redis, where a batch waits to be committed after a successful write, its an optional field. The default value is `inflight`.
 	//RedisKeys: RedisKeys configuration tag, describe the keys of the Redis server, its an optional field. The default value is `keys`.
 	//RedisLockInstanceName: RedisLockInstanceName configuration tag, describe the instance name of the lock key in Redis, its an optional field. The default value is empty and in this case, instance hostname will be considered.
 	//RedisLockPrefix: RedisLockPrefix configuration tag, describe the prefix of the lock key in Redis, its an optional field. The default value is `lock`.
 	//RedisLockTTL: RedisLockTTL configuration tag, describe the TTL of the lock key in Redis, its an optional field. The default value is `1.5x` 'FlushInterval` value.
+	//RedisMode: RedisMode configuration tag, describe the topology of the Redis server, this fields accepte three values, `single`, `cluster` or `sentinel`. The default value is `single`.
 	//RedisPassword: RedisPassword configuration tag, describe the password of the Redis server, its an optional field. The default value is empty.
 	//RedisRecoveryKey: RedisRecoveryKey configuration tag, describe the recovery key in Redis, its an optional field. The default value is `recovery`.
+	//RedisScanCount: RedisScanCount configuration tag, describe the COUNT hint used on each SCAN call to find DLQ and recovery keys, its an optional field. The default value is `1000`.
+	//RedisSentinelMaster: RedisSentinelMaster configuration tag, describe the master name monitored by the sentinels, its an optional field but became required if `RedisMode` is `sentinel`. The default value is empty.
+	//RedisSentinelPassword: RedisSentinelPassword configuration tag, describe the password of the sentinel servers, its an optional field. The default value is empty.
+	//RedisStreamClaimIdle: RedisStreamClaimIdle configuration tag, describe the time in seconds that a pending stream entry must be idle before being claimed from a dead consumer, consumers idle for this time without pending entries are removed from the group, its an optional field only used if `BufferType` is `redis-stream`. The default value is `3x` 'FlushInterval` value.
+	//RedisStreamGroup: RedisStreamGroup configuration tag, describe the consumer group name shared by all instances reading the Redis streams, its an optional field. The default value is `data2parquet`.
+	//RedisStreamPrefix: RedisStreamPrefix configuration tag, describe the prefix of the stream key in Redis, its an optional field. The default value is `stream`.
 	//RedisTimeout: RedisTimeout configuration tag, describe the timeout of the Redis server, its an optional field. The default value is empty, in this case, `0` will be the value (Redis defaults).
+	//RedisTLS: RedisTLS configuration tag, describe the use of TLS to connect to Redis, its an optional field. The default value is `false`.
+	//RedisTLSCAPath: RedisTLSCAPath configuration tag, describe the path of a PEM file with the CA certificates used to verify the Redis server, its an optional field. The default value is empty, in this case, system CAs will be used.
+	//RedisTLSInsecure: RedisTLSInsecure configuration tag, describe if the Redis server certificate verification must be skipped, its an optional field. The default value is `false`.
+	//RedisUsername: RedisUsername configuration tag, describe the ACL username of the Redis server, its an optional field. The default value is empty (`default` user).
+	//S3AccessKeyID: S3AccessKeyID configuration tag, describe the access key of the S3 server, its an optional field only used if `S3CredentialMode` is `static`. The default value is empty.
 	//S3BucketName: S3BucketName configuration tag, describe the bucket name in S3, its an optional field. The default value is empty but need to be set if you use `aws-s3` as a writer.
+	//S3ChecksumAlgorithm: S3ChecksumAlgorithm configuration tag, describe the checksum sent with each object and part written to S3 and verified with the full object checksum returned, this fields accepte three values, `crc32c`, `sha256` or `none`. The default value is `crc32c`.
+	//S3CreateBucket: S3CreateBucket configuration tag, describe if the `aws-s3` writer creates `S3BucketName` on `S3Region` when it does not exist, buckets are never created when the access is denied. The default value is `false`.
+	//S3CredentialMode: S3CredentialMode configuration tag, describe how the `aws-s3` writer gets its credentials, this fields accepte four values, `static` (`S3AccessKeyID` and `S3SecretAccessKey`), `default-chain` (environment, shared files, instance profile or container role), `assume-role` (assume `S3RoleARN` from the default chain) or `web-identity` (assume `S3RoleARN` with the `S3WebIdentityTokenFile` token, as IRSA). The default value is `assume-role` when `S3RoleARN` is set, otherwise `default-chain`.
 	//S3Endpoint: S3Endpoint configuration tag, describe the endpoint of the S3 server, its an optional field. The default value is empty but need to be set if you use `aws-s3` as a writer.
+	//S3ExternalID: S3ExternalID configuration tag, describe the external id sent when assuming `S3RoleARN`, its an optional field only used if `S3CredentialMode` is `assume-role`. The default value is empty.
+	//S3KMSKeyID: S3KMSKeyID configuration tag, describe the id, ARN or alias of the KMS key used to encrypt objects when `S3ServerSideEncryption` is `sse-kms`, its an optional field. The default value is empty, in this case, the AWS managed key of S3 is used.
+	//S3MaxAttempts: S3MaxAttempts configuration tag, describe the max number of attempts of each S3 request before failing, retrying throttles, timeouts and server errors, its an optional field. The default value is `3`.
+	//S3Metadata: S3Metadata configuration tag, describe the user metadata set on each object written to S3, a comma separated list of `name=value` where values accept the record info and file placeholders of `WriterPathTemplate`, as `capability={capability},records={records},hash={hash}`. The default value is empty.
+	//S3MultipartThreshold: S3MultipartThreshold configuration tag, describe the size in bytes from which files are sent to S3 with a multipart upload instead of a single `PutObject`, its an optional field. The default value is `16777216` (16M).
+	//S3PartSize: S3PartSize configuration tag, describe the size in bytes of each part of S3 multipart uploads, at least `5242880` (5M), its an optional field. The default value is `8388608` (8M).
+	//S3ProbePrefix: S3ProbePrefix configuration tag, describe the prefix where the `aws-s3` writer writes and deletes a tiny sentinel object on start, to check it has the permissions to write files. The default value is `_data2parquet`.
 	//S3Region: S3Region configuration tag, describe the region of the S3 server, its an optional field. The default value is empty but need to be set if you use `aws-s3` as a writer.
-	//S3RoleARN: S3RoleName configuration tag, describe the role name of the S3 server, its an optional field. The default value is empty but need to be set if you use `aws-s3` as a writer.
+	//S3RetryMaxBackoff: S3RetryMaxBackoff configuration tag, describe the max time in milliseconds to wait between attempts of a S3 request, the wait grows exponentially with jitter up to it, its an optional field. The default value is `20000`.
+	//S3RoleARN: S3RoleName configuration tag, describe the role assumed by the `aws-s3` writer when `S3CredentialMode` is `assume-role` or `web-identity`, its an optional field. The default value is empty, in this case the default AWS credentials chain is used without assuming a role.
+	//S3SecretAccessKey: S3SecretAccessKey configuration tag, describe the secret key of the S3 server, its an optional field only used if `S3CredentialMode` is `static`. The default value is empty.
+	//S3ServerSideEncryption: S3ServerSideEncryption configuration tag, describe the server side encryption of objects written to S3, this fields accepte four values, `none` (bucket default), `sse-s3`, `sse-kms` (with `S3KMSKeyID`) or `sse-c` (with `S3SSECustomerKey`). The default value is `none`.
+	//S3SessionDuration: S3SessionDuration configuration tag, describe the duration in seconds of the credentials of an assumed role, its an optional field only used if `S3CredentialMode` is `assume-role` or `web-identity`. The default value is `0`, in this case, `900` (AWS SDK default).
+	//S3SessionToken: S3SessionToken configuration tag, describe the session token of temporary static credentials, its an optional field only used if `S3CredentialMode` is `static`. The default value is empty.
+	//S3SSECustomerKey: S3SSECustomerKey configuration tag, describe the base64 encoded 256 bits key used to encrypt objects when `S3ServerSideEncryption` is `sse-c`, the same key is needed to read them. The default value is empty but need to be set if `S3ServerSideEncryption` is `sse-c`.
 	//S3STSEndpoint: S3STSEndpoint configuration tag, describe the endpoint of the STS server, its an optional field. The default value is empty but need to be set if you use `aws-s3` as a writer.
+	//S3StorageClass: S3StorageClass configuration tag, describe the storage class of objects written to S3, as `STANDARD`, `STANDARD_IA`, `INTELLIGENT_TIERING` or `GLACIER_IR`. The default value is empty, in this case, the bucket default is used.
+	//S3Tags: S3Tags configuration tag, describe the tags set on each object written to S3, up to 10 as a comma separated list of `name=value` where values accept the record info and file placeholders of `WriterPathTemplate`, as `classification=internal,service={service}`. The default value is empty.
+	//S3UploadConcurrency: S3UploadConcurrency configuration tag, describe how many parts of a S3 multipart upload are sent at the same time, each one holding `S3PartSize` bytes in memory, its an optional field. The default value is `4`.
+	//S3WebIdentityTokenFile: S3WebIdentityTokenFile configuration tag, describe the file with the OIDC token used to assume `S3RoleARN`, its an optional field only used if `S3CredentialMode` is `web-identity`. The default value is the `AWS_WEB_IDENTITY_TOKEN_FILE` environment variable, as `S3RoleARN` defaults to `AWS_ROLE_ARN`.
+	//SchemaRegistryCacheTTL: SchemaRegistryCacheTTL configuration tag, describe the time in seconds a schema read from the registry is used before checking for a new version, its an optional field. The default value is `60`.
+	//SchemaRegistryPath: SchemaRegistryPath configuration tag, describe the directory of the `local` schema registry, with one folder for each key as `capability/domain/service/application/v1.json`, its an optional field. The default value is `./schemas`.
+	//SchemaRegistryType: SchemaRegistryType configuration tag, describe where `dynamic` records find the JSON Schema of their key, this fields accepte `local` (a directory) or `http` (a registry service), keys without schema use `DynamicSchemaMode`. The default value is empty (disabled).
+	//SchemaRegistryURL: SchemaRegistryURL configuration tag, describe the base URL of the `http` schema registry, that must answer `GET {url}/subjects/{key}/versions/{version|latest}` with `{"subject": "...", "version": 1, "schema": "..."}`. The default value is empty but need to be set if `SchemaRegistryType` is `http`.
 	//TryAutoRecover: TryAutoRecover configuration tag, describe the auto recover mode, its an optional field. The default value is `false`. If set to `true` the system will try to recover the data that failed to write after flash, using recovery cache.
 	//UseDLQ: UseDLQ configuration tag, describe the use of DLQ, its an optional field. The default value is `false`. If set to `true` the system will use the DLQ to store the data that failed to write after flash.
 	//UseHash: UseHash configuration tag, describe the use of hash, its an optional field. The default value is `false`. If set to `true` the system will use the hash to store the data in the buffer.
 	//UseHMAC: UseHMAC configuration tag, describe the use of HMAC, its an optional field. The default value is `false`. If set to `true` the system will use the HMAC to sign the data.
 	//WriterCompressionType: WriterCompressionType configuration tag, describe the compression type of the writer, its an optional field. The default and recommended value is `snappy`. This fields accepte two values, `snappy`, `gzip` or `none`.
+	//WriterDirMode: WriterDirMode configuration tag, describe the octal permissions of the directories created by the `file` writer, its an optional field. The default value is `0755`.
+	//WriterFileMarker: WriterFileMarker configuration tag, describe the marker the `file` writer keeps in each partition directory after a file is written, this fields accepte three values, `none`, `success` (an empty `_SUCCESS` file) or `manifest` (a `_manifest.json` with the name, size, records and MD5 of each file). The default value is `none`.
+	//WriterFileMode: WriterFileMode configuration tag, describe the octal permissions of the files written by the `file` writer, its an optional field. The default value is `0644`.
 	//WriterFilePath: WriterFilePath configuration tag, describe the file path of the writer, its an optional field. The default value is `./out`.
+	//WriterPathTemplate: WriterPathTemplate configuration tag, describe the path of each parquet file created by `file`, `aws-s3`, `gcs` and `azure-blob` writers, using placeholders as `env=prod/domain={domain}/dt={date}/{id}.parquet`. Placeholders are `{record_type}`, `{key}`, `{capability}`, `{domain}`, `{service}`, `{application}`, `{year}`, `{month}`, `{day}`, `{hour}`, `{date}`, `{id}` (required), `{hash}`, `{records}`, `{host}` and `{field:name}` for any record field. The default value is empty, keeping the record type layout.
 	//WriterRowGroupSize: WriterRowGroupSize configuration tag, describe the row group size of the writer, its an optional field. The default value is `134217728` (128M).
-	//WriterType: WriterType configuration tag, describe the type of the writer, this fields accepte two values, `file` or `aws-s3`. The default value is `file`.
-
-	Address               string `json:"address,omitempty"`
-	BufferSize            int    `json:"buffer_size"`
-	BufferType            string `json:"buffer_type"`
-	Debug                 bool   `json:"debug,omitempty"`
-	FlushInterval         int    `json:"flush_interval"`
-	IgnoredFields         string `json:"ignored_fields,omitempty"`
-	JsonSchemaPath        string `json:"json_schema_path,omitempty"`
-	LogFormatter          string `json:"log_formatter,omitempty"`
-	MaskFields            string `json:"mask_fields,omitempty"`
-	Port                  int    `json:"port,omitempty"`
-	RecordType            string `json:"record_type"`
-	RecoveryAttempts      int    `json:"recovery_attempts,omitempty"`
-	RedisDataPrefix       string `json:"redis_data_prefix,omitempty"`
-	RedisDB               int    `json:"redis_db,omitempty"`
-	RedisDLQPrefix        string `json:"redis_dlq_prefix,omitempty"`
-	RedisHost             string `json:"redis_host,omitempty"`
-	RedisKeys             string `json:"redis_keys,omitempty"`
-	RedisLockInstanceName string `json:"redis_lock_instance_name,omitempty"`
-	RedisLockPrefix       string `json:"redis_lock_prefix,omitempty"`
-	RedisLockTTL          int    `json:"redis_lock_ttl,omitempty"`
-	RedisPassword         string `json:"redis_password,omitempty"`
-	RedisRecoveryKey      string `json:"redis_recovery_key,omitempty"`
-	RedisTimeout          int    `json:"redis_timeout,omitempty"`
-	S3BuketName           string `json:"s3_bucket_name"`
-	S3DefaultCapability   string `json:"s3_default_capability,omitempty"`
-	S3Endpoint            string `json:"s3_endpoint,omitempty"`
-	S3Region              string `json:"s3_region"`
-	S3RoleARN             string `json:"s3_role_arn,omitempty"`
-	S3STSEndpoint         string `json:"s3_sts_endpoint,omitempty"`
-	TryAutoRecover        bool   `json:"try_auto_recover,omitempty"`
-	UseDLQ                bool   `json:"use_dlq,omitempty"`
-	UseHash               bool   `json:"use_hash,omitempty"`
-	UseHMAC               bool   `json:"use_hmac,omitempty"`
-	WriterCompressionType string `json:"writer_compression_type,omitempty"`
-	WriterFilePath        string `json:"writer_file_path,omitempty"`
-	WriterRowGroupSize    int64  `json:"writer_row_group_size,omitempty"`
-	WriterType            string `json:"writer_type"`
+	//WriterStatusInterval: WriterStatusInterval configuration tag, describe the interval in milliseconds after which the status of an unready object storage writer is checked again, so a failed write does not keep it unready, its an optional field. The default value is `30000`.
+	//WriterTableCommitAttempts: WriterTableCommitAttempts configuration tag, describe how many times a file is committed to the table of `WriterTableFormat` when other writers commit at the same time, up to `100`, its an optional field. The default value is `10`.
+	//WriterTableFormat: WriterTableFormat configuration tag, describe the table format the `file` and `aws-s3` writers keep for the written files, this fields accepte three values, `none` (plain files), `iceberg` (an Apache Iceberg table with a filesystem catalog, as Hadoop tables) or `delta` (a Delta Lake table with its `_delta_log`). The default value is `none`.
+	//WriterTablePath: WriterTablePath configuration tag, describe the path of the table of `WriterTableFormat` in `WriterFilePath` or the S3 bucket, data files are written under it and its metadata in its `metadata` (`iceberg`) or `_delta_log` (`delta`) directory. The default value is empty, the table is the root of the writer.
+	//WriterTargets: WriterTargets configuration tag, describe the destinations of the `fan-out` writer, a JSON list as `[{"name":"s3","policy":"required","max_attempts":3,"settings":{"WriterType":"aws-s3","S3BucketName":"data"}}]`. Each target is a writer configured with this configuration and its `settings`, its `policy` is `required` (a failure fails the write) or `best-effort` (failures are only logged and accounted) and `max_attempts` is how many times each file is tried on it. The default value is empty, targets default to the `file` writer, `required` and `3` attempts.
+	//WriterType: WriterType configuration tag, describe the type of the writer, this fields accepte five values, `file`, `aws-s3`, `gcs`, `azure-blob` or `fan-out` (each file written to all `WriterTargets`). The default value is `file`.
+
+	Address                   string `json:"address,omitempty"`
+	AzureAccessTier           string `json:"azure_access_tier,omitempty"`
+	AzureAccountKey           string `json:"azure_account_key,omitempty"`
+	AzureAccountName          string `json:"azure_account_name,omitempty"`
+	AzureAuthMode             string `json:"azure_auth_mode,omitempty"`
+	AzureBlockSize            int64  `json:"azure_block_size,omitempty"`
+	AzureClientID             string `json:"azure_client_id,omitempty"`
+	AzureContainerName        string `json:"azure_container_name,omitempty"`
+	AzureEndpoint             string `json:"azure_endpoint,omitempty"`
+	AzurePrefix               string `json:"azure_prefix,omitempty"`
+	AzureSASToken             string `json:"azure_sas_token,omitempty"`
+	AzureUploadConcurrency    int    `json:"azure_upload_concurrency,omitempty"`
+	BufferBackpressure        string `json:"buffer_backpressure,omitempty"`
+	BufferBackpressureTimeout int    `json:"buffer_backpressure_timeout,omitempty"`
+	BufferFlushBytes          int64  `json:"buffer_flush_bytes,omitempty"`
+	BufferMemoryLimit         int64  `json:"buffer_memory_limit,omitempty"`
+	BufferPageSize            int    `json:"buffer_page_size,omitempty"`
+	BufferSize                int    `json:"buffer_size"`
+	BufferType                string `json:"buffer_type"`
+	Debug                     bool   `json:"debug,omitempty"`
+	DiskPath                  string `json:"disk_path,omitempty"`
+	DiskSegmentSize           int64  `json:"disk_segment_size,omitempty"`
+	DiskSyncInterval          int    `json:"disk_sync_interval,omitempty"`
+	DiskSyncPolicy            string `json:"disk_sync_policy,omitempty"`
+	DynamicKeyFields          string `json:"dynamic_key_fields,omitempty"`
+	DynamicSchemaMode         string `json:"dynamic_schema_mode,omitempty"`
+	DynamicSchemaSampleSize   int    `json:"dynamic_schema_sample_size,omitempty"`
+	DynamicTimeField          string `json:"dynamic_time_field,omitempty"`
+	FlushInterval             int    `json:"flush_interval"`
+	GCSBucketName             string `json:"gcs_bucket_name,omitempty"`
+	GCSChunkSize              int64  `json:"gcs_chunk_size,omitempty"`
+	GCSCredentialsFile        string `json:"gcs_credentials_file,omitempty"`
+	GCSEndpoint               string `json:"gcs_endpoint,omitempty"`
+	GCSPrefix                 string `json:"gcs_prefix,omitempty"`
+	IgnoredFields             string `json:"ignored_fields,omitempty"`
+	JsonSchemaPath            string `json:"json_schema_path,omitempty"`
+	LogFormatter              string `json:"log_formatter,omitempty"`
+	LogSchemaVersion          int    `json:"log_schema_version,omitempty"`
+	MaskFields                string `json:"mask_fields,omitempty"`
+	PartitionTimezone         string `json:"partition_timezone,omitempty"`
+	Port                      int    `json:"port,omitempty"`
+	RecordType                string `json:"record_type"`
+	RecoveryAttempts          int    `json:"recovery_attempts,omitempty"`
+	RedisDataPrefix           string `json:"redis_data_prefix,omitempty"`
+	RedisDB                   int    `json:"redis_db,omitempty"`
+	RedisDLQPrefix            string `json:"redis_dlq_prefix,omitempty"`
+	RedisHost                 string `json:"redis_host,omitempty"`
+	RedisInflightPrefix       string `json:"redis_inflight_prefix,omitempty"`
+	RedisKeys                 string `json:"redis_keys,omitempty"`
+	RedisLockInstanceName     string `json:"redis_lock_instance_name,omitempty"`
+	RedisLockPrefix           string `json:"redis_lock_prefix,omitempty"`
+	RedisLockTTL              int    `json:"redis_lock_ttl,omitempty"`
+	RedisMode                 string `json:"redis_mode,omitempty"`
+	RedisPassword             string `json:"redis_password,omitempty"`
+	RedisRecoveryKey          string `json:"redis_recovery_key,omitempty"`
+	RedisScanCount            int64  `json:"redis_scan_count,omitempty"`
+	RedisSentinelMaster       string `json:"redis_sentinel_master,omitempty"`
+	RedisSentinelPassword     string `json:"redis_sentinel_password,omitempty"`
+	RedisStreamClaimIdle      int    `json:"redis_stream_claim_idle,omitempty"`
+	RedisStreamGroup          string `json:"redis_stream_group,omitempty"`
+	RedisStreamPrefix         string `json:"redis_stream_prefix,omitempty"`
+	RedisTimeout              int    `json:"redis_timeout,omitempty"`
+	RedisTLS                  bool   `json:"redis_tls,omitempty"`
+	RedisTLSCAPath            string `json:"redis_tls_ca_path,omitempty"`
+	RedisTLSInsecure          bool   `json:"redis_tls_insecure,omitempty"`
+	RedisUsername             string `json:"redis_username,omitempty"`
+	S3AccessKeyID             string `json:"s3_access_key_id,omitempty"`
+	S3BuketName               string `json:"s3_bucket_name"`
+	S3DefaultCapability       string `json:"s3_default_capability,omitempty"`
+	S3ChecksumAlgorithm       string `json:"s3_checksum_algorithm,omitempty"`
+	S3CreateBucket            bool   `json:"s3_create_bucket,omitempty"`
+	S3CredentialMode          string `json:"s3_credential_mode,omitempty"`
+	S3Endpoint                string `json:"s3_endpoint,omitempty"`
+	S3ExternalID              string `json:"s3_external_id,omitempty"`
+	S3KMSKeyID                string `json:"s3_kms_key_id,omitempty"`
+	S3MaxAttempts             int    `json:"s3_max_attempts,omitempty"`
+	S3Metadata                string `json:"s3_metadata,omitempty"`
+	S3MultipartThreshold      int64  `json:"s3_multipart_threshold,omitempty"`
+	S3PartSize                int64  `json:"s3_part_size,omitempty"`
+	S3ProbePrefix             string `json:"s3_probe_prefix,omitempty"`
+	S3Region                  string `json:"s3_region"`
+	S3RetryMaxBackoff         int    `json:"s3_retry_max_backoff,omitempty"`
+	S3RoleARN                 string `json:"s3_role_arn,omitempty"`
+	S3SecretAccessKey         string `json:"s3_secret_access_key,omitempty"`
+	S3ServerSideEncryption    string `json:"s3_server_side_encryption,omitempty"`
+	S3SessionDuration         int    `json:"s3_session_duration,omitempty"`
+	S3SessionToken            string `json:"s3_session_token,omitempty"`
+	S3SSECustomerKey          string `json:"s3_sse_customer_key,omitempty"`
+	S3StorageClass            string `json:"s3_storage_class,omitempty"`
+	S3STSEndpoint             string `json:"s3_sts_endpoint,omitempty"`
+	S3Tags                    string `json:"s3_tags,omitempty"`
+	S3UploadConcurrency       int    `json:"s3_upload_concurrency,omitempty"`
+	S3WebIdentityTokenFile    string `json:"s3_web_identity_token_file,omitempty"`
+	SchemaRegistryCacheTTL    int    `json:"schema_registry_cache_ttl,omitempty"`
+	SchemaRegistryPath        string `json:"schema_registry_path,omitempty"`
+	SchemaRegistryType        string `json:"schema_registry_type,omitempty"`
+	SchemaRegistryURL         string `json:"schema_registry_url,omitempty"`
+	TryAutoRecover            bool   `json:"try_auto_recover,omitempty"`
+	UseDLQ                    bool   `json:"use_dlq,omitempty"`
+	UseHash                   bool   `json:"use_hash,omitempty"`
+	UseHMAC                   bool   `json:"use_hmac,omitempty"`
+	WriterCompressionType     string `json:"writer_compression_type,omitempty"`
+	WriterDirMode             string `json:"writer_dir_mode,omitempty"`
+	WriterFileMarker          string `json:"writer_file_marker,omitempty"`
+	WriterFileMode            string `json:"writer_file_mode,omitempty"`
+	WriterFilePath            string `json:"writer_file_path,omitempty"`
+	WriterPathTemplate        string `json:"writer_path_template,omitempty"`
+	WriterRowGroupSize        int64  `json:"writer_row_group_size,omitempty"`
+	WriterStatusInterval      int    `json:"writer_status_interval,omitempty"`
+	WriterTableCommitAttempts int    `json:"writer_table_commit_attempts,omitempty"`
+	WriterTableFormat         string `json:"writer_table_format,omitempty"`
+	WriterTablePath           string `json:"writer_table_path,omitempty"`
+	WriterTargets             string `json:"writer_targets,omitempty"`
+	WriterType                string `json:"writer_type"`
 }
 
 const BufferTypeMem = "mem"
 const BufferTypeRedis = "redis"
+const BufferTypeDisk = "disk"
+const BufferTypeRedisStream = "redis-stream"
 
 var BufferTypes = map[string]int{
-	BufferTypeMem:   1,
-	BufferTypeRedis: 2,
+	BufferTypeMem:         1,
+	BufferTypeRedis:       2,
+	BufferTypeDisk:        3,
+	BufferTypeRedisStream: 4,
+}
+
+const DiskSyncPolicyAlways = "always"
+const DiskSyncPolicyInterval = "interval"
+const DiskSyncPolicyNone = "none"
+
+const BufferBackpressureReject = "reject"
+const BufferBackpressureBlock = "block"
+
+var BufferBackpressures = map[string]int{
+	BufferBackpressureReject: 1,
+	BufferBackpressureBlock:  2,
+}
+
+const RedisModeSingle = "single"
+const RedisModeCluster = "cluster"
+const RedisModeSentinel = "sentinel"
+
+var RedisModes = map[string]int{
+	RedisModeSingle:   1,
+	RedisModeCluster:  2,
+	RedisModeSentinel: 3,
+}
+
+var DiskSyncPolicies = map[string]int{
+	DiskSyncPolicyAlways:   1,
+	DiskSyncPolicyInterval: 2,
+	DiskSyncPolicyNone:     3,
 }
 
 const WriterTypeAWSS3 = "aws-s3"
+const WriterTypeGCS = "gcs"
+const WriterTypeAzureBlob = "azure-blob"
+const WriterTypeFanOut = "fan-out"
 const WriterTypeFile = "file"
 
 var WriterTypes = map[string]int{
-	WriterTypeFile:  1,
-	WriterTypeAWSS3: 2,
+	WriterTypeFile:      1,
+	WriterTypeAWSS3:     2,
+	WriterTypeGCS:       3,
+	WriterTypeAzureBlob: 4,
+	WriterTypeFanOut:    5,
+}
+
+// WriterTarget is a destination of the `fan-out` writer, its settings override the configuration of the writer.
+type WriterTarget struct {
+	Name        string            `json:"name"`
+	Policy      string            `json:"policy,omitempty"`
+	MaxAttempts int               `json:"max_attempts,omitempty"`
+	Settings    map[string]string `json:"settings"`
+}
+
+const WriterTargetPolicyRequired = "required"
+const WriterTargetPolicyBestEffort = "best-effort"
+
+var WriterTargetPolicies = map[string]int{
+	WriterTargetPolicyRequired:   1,
+	WriterTargetPolicyBestEffort: 2,
+}
+
+const WriterTableFormatNone = "none"
+const WriterTableFormatIceberg = "iceberg"
+const WriterTableFormatDelta = "delta"
+
+// MaxWriterTableCommitAttempts limits WriterTableCommitAttempts, each attempt waits up to 5 seconds.
+const MaxWriterTableCommitAttempts = 100
+
+var WriterTableFormats = map[string]int{
+	WriterTableFormatNone:    1,
+	WriterTableFormatIceberg: 2,
+	WriterTableFormatDelta:   3,
+}
+
+const WriterFileMarkerNone = "none"
+const WriterFileMarkerSuccess = "success"
+const WriterFileMarkerManifest = "manifest"
+
+var WriterFileMarkers = map[string]int{
+	WriterFileMarkerNone:     1,
+	WriterFileMarkerSuccess:  2,
+	WriterFileMarkerManifest: 3,
+}
+
+const AzureAuthModeSharedKey = "shared-key"
+const AzureAuthModeSAS = "sas"
+const AzureAuthModeManagedIdentity = "managed-identity"
+
+var AzureAuthModes = map[string]int{
+	AzureAuthModeSharedKey:       1,
+	AzureAuthModeSAS:             2,
+	AzureAuthModeManagedIdentity: 3,
+}
+
+const AzureAccessTierHot = "hot"
+const AzureAccessTierCool = "cool"
+const AzureAccessTierCold = "cold"
+const AzureAccessTierArchive = "archive"
+
+var AzureAccessTiers = map[string]int{
+	AzureAccessTierHot:     1,
+	AzureAccessTierCool:    2,
+	AzureAccessTierCold:    3,
+	AzureAccessTierArchive: 4,
+}
+
+const S3ChecksumCRC32C = "crc32c"
+const S3ChecksumSHA256 = "sha256"
+const S3ChecksumNone = "none"
+
+var S3ChecksumAlgorithms = map[string]int{
+	S3ChecksumCRC32C: 1,
+	S3ChecksumSHA256: 2,
+	S3ChecksumNone:   3,
+}
+
+const S3CredentialModeStatic = "static"
+const S3CredentialModeDefaultChain = "default-chain"
+const S3CredentialModeAssumeRole = "assume-role"
+const S3CredentialModeWebIdentity = "web-identity"
+
+var S3CredentialModes = map[string]int{
+	S3CredentialModeStatic:       1,
+	S3CredentialModeDefaultChain: 2,
+	S3CredentialModeAssumeRole:   3,
+	S3CredentialModeWebIdentity:  4,
+}
+
+const S3ServerSideEncryptionNone = "none"
+const S3ServerSideEncryptionS3 = "sse-s3"
+const S3ServerSideEncryptionKMS = "sse-kms"
+const S3ServerSideEncryptionCustomer = "sse-c"
+
+var S3ServerSideEncryptions = map[string]int{
+	S3ServerSideEncryptionNone:     1,
+	S3ServerSideEncryptionS3:       2,
+	S3ServerSideEncryptionKMS:      3,
+	S3ServerSideEncryptionCustomer: 4,
 }
 
 const RecordTypeLog = "log"
 const RecordTypeLogLegacy = "log_legacy"
 const RecordTypeDynamic = "dynamic"
 
+const DefaultDynamicKeyFields = "capability|dynamic_capability,domain|dynamic_domain,service|dynamic_service,application|dynamic_application"
+
 var RecordTypes = map[string]int{
 	RecordTypeLog:       1,
 	RecordTypeDynamic:   2,
 	RecordTypeLogLegacy: 3,
 }
 
+const SchemaRegistryTypeLocal = "local"
+const SchemaRegistryTypeHTTP = "http"
+
+var SchemaRegistryTypes = map[string]int{
+	SchemaRegistryTypeLocal: 1,
+	SchemaRegistryTypeHTTP:  2,
+}
+
+const DynamicSchemaModeFile = "file"
+const DynamicSchemaModeInfer = "infer"
+
+var DynamicSchemaModes = map[string]int{
+	DynamicSchemaModeFile:  1,
+	DynamicSchemaModeInfer: 2,
+}
+
+const LogSchemaVersionLegacy = 1
+const LogSchemaVersionTyped = 2
+const LogSchemaVersionCurrent = LogSchemaVersionTyped
+
+var LogSchemaVersions = map[int]string{
+	LogSchemaVersionLegacy: "legacy",
+	LogSchemaVersionTyped:  "typed",
+}
+
 var keys = []string{
+	"AzureAccessTier",
+	"AzureAccountKey",
+	"AzureAccountName",
+	"AzureAuthMode",
+	"AzureBlockSize",
+	"AzureClientID",
+	"AzureContainerName",
+	"AzureEndpoint",
+	"AzurePrefix",
+	"AzureSASToken",
+	"AzureUploadConcurrency",
+	"BufferBackpressure",
+	"BufferBackpressureTimeout",
+	"BufferFlushBytes",
+	"BufferMemoryLimit",
+	"BufferPageSize",
 	"BufferSize",
 	"BufferType",
 	"Debug",
 	"DisableLogColors",
+	"DiskPath",
+	"DiskSegmentSize",
+	"DiskSyncInterval",
+	"DiskSyncPolicy",
+	"DynamicKeyFields",
+	"DynamicSchemaMode",
+	"DynamicSchemaSampleSize",
+	"DynamicTimeField",
 	"FlushInterval",
+	"GCSBucketName",
+	"GCSChunkSize",
+	"GCSCredentialsFile",
+	"GCSEndpoint",
+	"GCSPrefix",
 	"IgnoredFields",
 	"JsonSchemaPath",
 	"LogFormatter",
+	"LogSchemaVersion",
 	"MaskFields",
+	"PartitionTimezone",
 	"RecordType",
 	"RecoveryAttempts",
 	"RedisDataPrefix",
 	"RedisDB",
 	"RedisHost",
+	"RedisInflightPrefix",
 	"RedisKeys",
 	"RedisLockInstanceName",
 	"RedisLockPrefix",
 	"RedisLockTTL",
+	"RedisMode",
 	"RedisPassword",
 	"RedisRecoveryKey",
+	"RedisScanCount",
+	"RedisSentinelMaster",
+	"RedisSentinelPassword",
 	"RedisSQLPrefix",
+	"RedisStreamClaimIdle",
+	"RedisStreamGroup",
+	"RedisStreamPrefix",
 	"RedisTimeout",
+	"RedisTLS",
+	"RedisTLSCAPath",
+	"RedisTLSInsecure",
+	"RedisUsername",
+	"S3AccessKeyID",
 	"S3BucketName",
 	"S3DefaultCapability",
+	"S3ChecksumAlgorithm",
+	"S3CreateBucket",
+	"S3CredentialMode",
 	"S3Endpoint",
+	"S3ExternalID",
+	"S3KMSKeyID",
+	"S3MaxAttempts",
+	"S3Metadata",
+	"S3MultipartThreshold",
+	"S3PartSize",
+	"S3ProbePrefix",
 	"S3Region",
+	"S3RetryMaxBackoff",
 	"S3RoleARN",
+	"S3SecretAccessKey",
+	"S3ServerSideEncryption",
+	"S3SessionDuration",
+	"S3SessionToken",
+	"S3SSECustomerKey",
+	"S3StorageClass",
 	"S3STSEndpoint",
+	"S3Tags",
+	"S3UploadConcurrency",
+	"S3WebIdentityTokenFile",
+	"SchemaRegistryCacheTTL",
+	"SchemaRegistryPath",
+	"SchemaRegistryType",
+	"SchemaRegistryURL",
 	"TryAutoRecover",
 	"UseDLQ",
 	"UseHash",
 	"UseHMAC",
 	"WriterCompressionType",
+	"WriterDirMode",
+	"WriterFileMarker",
+	"WriterFileMode",
 	"WriterFilePath",
+	"WriterPathTemplate",
 	"WriterRowGroupSize",
+	"WriterStatusInterval",
+	"WriterTableCommitAttempts",
+	"WriterTableFormat",
+	"WriterTablePath",
+	"WriterTargets",
 	"WriterType",
 }
 
 var UseHMAC = false
 var IgnoredFields = make(map[string]any)
 var MaskFields = make(map[string]any)
+var DynamicKeyFields = DefaultDynamicKeyFields
 
 func NewConfig() *Config {
 	ret := &Config{}
@@ -188,8 +581,24 @@ func ConfigClientFromFile(filename string) (*Config, error) {
 	return ConfigFromJSON(string(data))
 }
 
+// secrets returns the settings holding credentials by key, they are masked by ToJSON and ToString, also on writer
+// targets.
+func (c *Config) secrets() map[string]*string {
+	return map[string]*string{
+		"AzureAccountKey":       &c.AzureAccountKey,
+		"AzureSASToken":         &c.AzureSASToken,
+		"RedisPassword":         &c.RedisPassword,
+		"RedisSentinelPassword": &c.RedisSentinelPassword,
+		"S3SecretAccessKey":     &c.S3SecretAccessKey,
+		"S3SessionToken":        &c.S3SessionToken,
+		"S3SSECustomerKey":      &c.S3SSECustomerKey,
+	}
+}
+
+const redactedValue = "REDACTED"
+
 func (c *Config) ToJSON() string {
-	data, err := json.MarshalIndent(c, "", " ")
+	data, err := json.MarshalIndent(c.Redacted(), "", " ")
 	if err != nil {
 		return ""
 	}
@@ -197,16 +606,93 @@ func (c *Config) ToJSON() string {
 }
 
 func (c *Config) ToString() string {
-	return fmt.Sprintf("%+v", c)
+	return fmt.Sprintf("%+v", c.Redacted())
+}
+
+// Redacted returns a copy of the config to be logged, with the values of its secrets masked.
+func (c *Config) Redacted() *Config {
+	ret := *c
+	secrets := ret.secrets()
+
+	for _, value := range secrets {
+		if len(*value) > 0 {
+			*value = redactedValue
+		}
+	}
+
+	if len(ret.WriterTargets) > 0 {
+		targets, err := ret.GetWriterTargets()
+
+		if err != nil {
+			ret.WriterTargets = redactedValue
+			return &ret
+		}
+
+		for _, target := range targets {
+			for key := range target.Settings {
+				if _, ok := secrets[key]; ok {
+					target.Settings[key] = redactedValue
+				}
+			}
+		}
+
+		data, _ := json.Marshal(targets)
+		ret.WriterTargets = string(data)
+	}
+
+	return &ret
+}
+
+// GetWriterTargets parses WriterTargets, naming targets without name and setting their default policy and attempts.
+func (c *Config) GetWriterTargets() ([]WriterTarget, error) {
+	ret := make([]WriterTarget, 0)
+
+	if len(c.WriterTargets) == 0 {
+		return ret, nil
+	}
+
+	if err := json.Unmarshal([]byte(c.WriterTargets), &ret); err != nil {
+		return nil, fmt.Errorf("error parsing WriterTargets: %w", err)
+	}
+
+	for i := range ret {
+		target := &ret[i]
+
+		if len(target.Name) == 0 {
+			target.Name = fmt.Sprintf("target-%d", i+1)
+		}
+
+		target.Policy = strings.ToLower(target.Policy)
+
+		if len(target.Policy) == 0 {
+			target.Policy = WriterTargetPolicyRequired
+		}
+
+		if _, ok := WriterTargetPolicies[target.Policy]; !ok {
+			return nil, fmt.Errorf("invalid policy %q of writer target %s, use required or best-effort", target.Policy, target.Name)
+		}
+
+		if target.MaxAttempts < 1 {
+			target.MaxAttempts = 3
+		}
+	}
+
+	return ret, nil
 }
 
 func (c *Config) GetKeys() []string {
 	return keys
 }
 
+// WriteToFile writes the config with its secrets, ToJSON masks them.
 func (c *Config) WriteToFile(filename string) error {
-	data := c.ToJSON()
-	err := os.WriteFile(filename, []byte(data), 0644)
+	data, err := json.MarshalIndent(c, "", " ")
+
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(filename, data, 0600)
 	if err != nil {
 		slog.Error("Error writing to file", "error", err, "module", "config", "function", "WriteToFile")
 		return err
@@ -222,24 +708,120 @@ func (c *Config) Set(cfg map[string]string) error {
 		case "TryAutoRecover":
 			c.TryAutoRecover = strings.ToLower(value) == "true"
 		case "RecoveryAttempts":
+		case "WriterTargets":
+			c.WriterTargets = value
 		case "WriterType":
 			c.WriterType = value
 		case "BufferType":
 			c.BufferType = value
+		case "DiskPath":
+			c.DiskPath = value
+		case "DiskSegmentSize":
+			_, err := fmt.Sscanf(value, "%d", &c.DiskSegmentSize)
+			if err != nil {
+				slog.Warn("Error parsing DiskSegmentSize", "error", err)
+				c.DiskSegmentSize = 64 * 1024 * 1024
+			}
+		case "DiskSyncInterval":
+			_, err := fmt.Sscanf(value, "%d", &c.DiskSyncInterval)
+			if err != nil {
+				slog.Warn("Error parsing DiskSyncInterval", "error", err)
+				c.DiskSyncInterval = 1000
+			}
+		case "DiskSyncPolicy":
+			c.DiskSyncPolicy = strings.ToLower(value)
+		case "DynamicKeyFields":
+			c.DynamicKeyFields = value
+		case "DynamicSchemaMode":
+			c.DynamicSchemaMode = strings.ToLower(value)
+		case "DynamicSchemaSampleSize":
+			_, err := fmt.Sscanf(value, "%d", &c.DynamicSchemaSampleSize)
+			if err != nil {
+				slog.Warn("Error parsing DynamicSchemaSampleSize", "error", err)
+				c.DynamicSchemaSampleSize = 1000
+			}
+		case "DynamicTimeField":
+			c.DynamicTimeField = value
+		case "PartitionTimezone":
+			c.PartitionTimezone = value
 		case "FlushInterval":
 			_, err := fmt.Sscanf(value, "%d", &c.FlushInterval)
 			if err != nil {
 				slog.Warn("Error parsing FlushInterval", "error", err)
 				c.FlushInterval = 5
 			}
+		case "GCSBucketName":
+			c.GCSBucketName = value
+		case "GCSChunkSize":
+			_, err := fmt.Sscanf(value, "%d", &c.GCSChunkSize)
+			if err != nil {
+				slog.Warn("Error parsing GCSChunkSize", "error", err)
+				c.GCSChunkSize = 16 * 1024 * 1024
+			}
+		case "GCSCredentialsFile":
+			c.GCSCredentialsFile = value
+		case "GCSEndpoint":
+			c.GCSEndpoint = value
+		case "GCSPrefix":
+			c.GCSPrefix = value
 		case "BufferSize":
 			_, err := fmt.Sscanf(value, "%d", &c.BufferSize)
 			if err != nil {
 				slog.Warn("Error parsing BufferSize", "error", err)
 				c.BufferSize = 100
 			}
+		case "BufferFlushBytes":
+			_, err := fmt.Sscanf(value, "%d", &c.BufferFlushBytes)
+			if err != nil {
+				slog.Warn("Error parsing BufferFlushBytes", "error", err)
+				c.BufferFlushBytes = 0
+			}
+		case "BufferMemoryLimit":
+			_, err := fmt.Sscanf(value, "%d", &c.BufferMemoryLimit)
+			if err != nil {
+				slog.Warn("Error parsing BufferMemoryLimit", "error", err)
+				c.BufferMemoryLimit = 0
+			}
+		case "BufferPageSize":
+			_, err := fmt.Sscanf(value, "%d", &c.BufferPageSize)
+			if err != nil {
+				slog.Warn("Error parsing BufferPageSize", "error", err)
+				c.BufferPageSize = 1000
+			}
+		case "BufferBackpressure":
+			c.BufferBackpressure = strings.ToLower(value)
+		case "BufferBackpressureTimeout":
+			_, err := fmt.Sscanf(value, "%d", &c.BufferBackpressureTimeout)
+			if err != nil {
+				slog.Warn("Error parsing BufferBackpressureTimeout", "error", err)
+				c.BufferBackpressureTimeout = 0
+			}
+		case "WriterDirMode":
+			c.WriterDirMode = value
+		case "WriterFileMarker":
+			c.WriterFileMarker = strings.ToLower(value)
+		case "WriterStatusInterval":
+			_, err := fmt.Sscanf(value, "%d", &c.WriterStatusInterval)
+			if err != nil {
+				slog.Warn("Error parsing WriterStatusInterval", "error", err)
+				c.WriterStatusInterval = 30000
+			}
+		case "WriterTableCommitAttempts":
+			_, err := fmt.Sscanf(value, "%d", &c.WriterTableCommitAttempts)
+			if err != nil {
+				slog.Warn("Error parsing WriterTableCommitAttempts", "error", err)
+				c.WriterTableCommitAttempts = 10
+			}
+		case "WriterTableFormat":
+			c.WriterTableFormat = strings.ToLower(value)
+		case "WriterTablePath":
+			c.WriterTablePath = value
+		case "WriterFileMode":
+			c.WriterFileMode = value
 		case "WriterFilePath":
 			c.WriterFilePath = value
+		case "WriterPathTemplate":
+			c.WriterPathTemplate = value
 		case "WriterCompression_type":
 			c.WriterCompressionType = value
 		case "WriterRowGroupSize":
@@ -248,6 +830,36 @@ func (c *Config) Set(cfg map[string]string) error {
 				slog.Warn("Error parsing WriterRowGroupSize", "error", err)
 				c.WriterRowGroupSize = 128 * 1024 * 1024
 			}
+		case "AzureAccessTier":
+			c.AzureAccessTier = strings.ToLower(value)
+		case "AzureAccountKey":
+			c.AzureAccountKey = value
+		case "AzureAccountName":
+			c.AzureAccountName = value
+		case "AzureAuthMode":
+			c.AzureAuthMode = strings.ToLower(value)
+		case "AzureBlockSize":
+			_, err := fmt.Sscanf(value, "%d", &c.AzureBlockSize)
+			if err != nil {
+				slog.Warn("Error parsing AzureBlockSize", "error", err)
+				c.AzureBlockSize = 8 * 1024 * 1024
+			}
+		case "AzureClientID":
+			c.AzureClientID = value
+		case "AzureContainerName":
+			c.AzureContainerName = value
+		case "AzureEndpoint":
+			c.AzureEndpoint = value
+		case "AzurePrefix":
+			c.AzurePrefix = value
+		case "AzureSASToken":
+			c.AzureSASToken = value
+		case "AzureUploadConcurrency":
+			_, err := fmt.Sscanf(value, "%d", &c.AzureUploadConcurrency)
+			if err != nil {
+				slog.Warn("Error parsing AzureUploadConcurrency", "error", err)
+				c.AzureUploadConcurrency = 4
+			}
 		case "Address":
 			c.Address = value
 		case "Port":
@@ -260,6 +872,26 @@ func (c *Config) Set(cfg map[string]string) error {
 			c.RedisHost = value
 		case "RedisPassword":
 			c.RedisPassword = value
+		case "RedisUsername":
+			c.RedisUsername = value
+		case "RedisMode":
+			c.RedisMode = strings.ToLower(value)
+		case "RedisSentinelMaster":
+			c.RedisSentinelMaster = value
+		case "RedisSentinelPassword":
+			c.RedisSentinelPassword = value
+		case "RedisTLS":
+			c.RedisTLS = strings.ToLower(value) == "true"
+		case "RedisTLSCAPath":
+			c.RedisTLSCAPath = value
+		case "RedisTLSInsecure":
+			c.RedisTLSInsecure = strings.ToLower(value) == "true"
+		case "RedisScanCount":
+			_, err := fmt.Sscanf(value, "%d", &c.RedisScanCount)
+			if err != nil {
+				slog.Warn("Error parsing RedisScanCount", "error", err)
+				c.RedisScanCount = 1000
+			}
 		case "RedisDB":
 			_, err := fmt.Sscanf(value, "%d", &c.RedisDB)
 			if err != nil {
@@ -270,8 +902,20 @@ func (c *Config) Set(cfg map[string]string) error {
 			c.RedisRecoveryKey = value
 		case "RedisDataPrefix":
 			c.RedisDataPrefix = value
+		case "RedisInflightPrefix":
+			c.RedisInflightPrefix = value
 		case "RedisKeys":
 			c.RedisKeys = value
+		case "RedisStreamClaimIdle":
+			_, err := fmt.Sscanf(value, "%d", &c.RedisStreamClaimIdle)
+			if err != nil {
+				slog.Warn("Error parsing RedisStreamClaimIdle", "error", err)
+				c.RedisStreamClaimIdle = 0
+			}
+		case "RedisStreamGroup":
+			c.RedisStreamGroup = value
+		case "RedisStreamPrefix":
+			c.RedisStreamPrefix = value
 		case "S3BucketName":
 			c.S3BuketName = value
 		case "S3Region":
@@ -282,8 +926,86 @@ func (c *Config) Set(cfg map[string]string) error {
 			c.S3STSEndpoint = value
 		case "S3Endpoint":
 			c.S3Endpoint = value
+		case "S3AccessKeyID":
+			c.S3AccessKeyID = value
+		case "S3CreateBucket":
+			c.S3CreateBucket = strings.ToLower(value) == "true"
+		case "S3ProbePrefix":
+			c.S3ProbePrefix = value
+		case "S3CredentialMode":
+			c.S3CredentialMode = strings.ToLower(value)
+		case "S3ExternalID":
+			c.S3ExternalID = value
+		case "S3SecretAccessKey":
+			c.S3SecretAccessKey = value
+		case "S3SessionDuration":
+			_, err := fmt.Sscanf(value, "%d", &c.S3SessionDuration)
+			if err != nil {
+				slog.Warn("Error parsing S3SessionDuration", "error", err)
+				c.S3SessionDuration = 0
+			}
+		case "S3SessionToken":
+			c.S3SessionToken = value
+		case "S3WebIdentityTokenFile":
+			c.S3WebIdentityTokenFile = value
+		case "S3KMSKeyID":
+			c.S3KMSKeyID = value
+		case "S3Metadata":
+			c.S3Metadata = value
+		case "S3ServerSideEncryption":
+			c.S3ServerSideEncryption = strings.ToLower(value)
+		case "S3SSECustomerKey":
+			c.S3SSECustomerKey = value
+		case "S3StorageClass":
+			c.S3StorageClass = strings.ToUpper(value)
+		case "S3Tags":
+			c.S3Tags = value
+		case "S3ChecksumAlgorithm":
+			c.S3ChecksumAlgorithm = strings.ToLower(value)
+		case "S3MaxAttempts":
+			_, err := fmt.Sscanf(value, "%d", &c.S3MaxAttempts)
+			if err != nil {
+				slog.Warn("Error parsing S3MaxAttempts", "error", err)
+				c.S3MaxAttempts = 3
+			}
+		case "S3MultipartThreshold":
+			_, err := fmt.Sscanf(value, "%d", &c.S3MultipartThreshold)
+			if err != nil {
+				slog.Warn("Error parsing S3MultipartThreshold", "error", err)
+				c.S3MultipartThreshold = 16 * 1024 * 1024
+			}
+		case "S3PartSize":
+			_, err := fmt.Sscanf(value, "%d", &c.S3PartSize)
+			if err != nil {
+				slog.Warn("Error parsing S3PartSize", "error", err)
+				c.S3PartSize = 8 * 1024 * 1024
+			}
+		case "S3RetryMaxBackoff":
+			_, err := fmt.Sscanf(value, "%d", &c.S3RetryMaxBackoff)
+			if err != nil {
+				slog.Warn("Error parsing S3RetryMaxBackoff", "error", err)
+				c.S3RetryMaxBackoff = 20000
+			}
+		case "S3UploadConcurrency":
+			_, err := fmt.Sscanf(value, "%d", &c.S3UploadConcurrency)
+			if err != nil {
+				slog.Warn("Error parsing S3UploadConcurrency", "error", err)
+				c.S3UploadConcurrency = 4
+			}
 		case "JsonSchemaPath":
 			c.JsonSchemaPath = value
+		case "SchemaRegistryCacheTTL":
+			_, err := fmt.Sscanf(value, "%d", &c.SchemaRegistryCacheTTL)
+			if err != nil {
+				slog.Warn("Error parsing SchemaRegistryCacheTTL", "error", err)
+				c.SchemaRegistryCacheTTL = 60
+			}
+		case "SchemaRegistryPath":
+			c.SchemaRegistryPath = value
+		case "SchemaRegistryType":
+			c.SchemaRegistryType = strings.ToLower(value)
+		case "SchemaRegistryURL":
+			c.SchemaRegistryURL = value
 		case "RecordType":
 			c.RecordType = value
 		case "RedisDLQPrefix":
@@ -318,6 +1040,12 @@ func (c *Config) Set(cfg map[string]string) error {
 
 		case "LogFormatter":
 			c.LogFormatter = strings.ToLower(value)
+		case "LogSchemaVersion":
+			_, err := fmt.Sscanf(value, "%d", &c.LogSchemaVersion)
+			if err != nil {
+				slog.Warn("Error parsing LogSchemaVersion", "error", err)
+				c.LogSchemaVersion = LogSchemaVersionCurrent
+			}
 
 		case "IgnoredFields":
 			c.IgnoredFields = value
@@ -337,14 +1065,45 @@ func (c *Config) Get() map[string]interface{} {
 	ret := make(map[string]interface{})
 
 	ret["Address"] = c.Address
+	ret["AzureAccessTier"] = c.AzureAccessTier
+	ret["AzureAccountKey"] = c.AzureAccountKey
+	ret["AzureAccountName"] = c.AzureAccountName
+	ret["AzureAuthMode"] = c.AzureAuthMode
+	ret["AzureBlockSize"] = c.AzureBlockSize
+	ret["AzureClientID"] = c.AzureClientID
+	ret["AzureContainerName"] = c.AzureContainerName
+	ret["AzureEndpoint"] = c.AzureEndpoint
+	ret["AzurePrefix"] = c.AzurePrefix
+	ret["AzureSASToken"] = c.AzureSASToken
+	ret["AzureUploadConcurrency"] = c.AzureUploadConcurrency
+	ret["BufferBackpressure"] = c.BufferBackpressure
+	ret["BufferBackpressureTimeout"] = c.BufferBackpressureTimeout
+	ret["BufferFlushBytes"] = c.BufferFlushBytes
+	ret["BufferMemoryLimit"] = c.BufferMemoryLimit
+	ret["BufferPageSize"] = c.BufferPageSize
 	ret["BufferSize"] = c.BufferSize
 	ret["BufferType"] = c.BufferType
 	ret["Debug"] = c.Debug
+	ret["DiskPath"] = c.DiskPath
+	ret["DiskSegmentSize"] = c.DiskSegmentSize
+	ret["DiskSyncInterval"] = c.DiskSyncInterval
+	ret["DiskSyncPolicy"] = c.DiskSyncPolicy
+	ret["DynamicKeyFields"] = c.DynamicKeyFields
+	ret["DynamicSchemaMode"] = c.DynamicSchemaMode
+	ret["DynamicSchemaSampleSize"] = c.DynamicSchemaSampleSize
+	ret["DynamicTimeField"] = c.DynamicTimeField
 	ret["FlushInterval"] = c.FlushInterval
+	ret["GCSBucketName"] = c.GCSBucketName
+	ret["GCSChunkSize"] = c.GCSChunkSize
+	ret["GCSCredentialsFile"] = c.GCSCredentialsFile
+	ret["GCSEndpoint"] = c.GCSEndpoint
+	ret["GCSPrefix"] = c.GCSPrefix
 	ret["IgnoredFields"] = c.IgnoredFields
 	ret["JsonSchemaPath"] = c.JsonSchemaPath
 	ret["LogFormatter"] = c.LogFormatter
+	ret["LogSchemaVersion"] = c.LogSchemaVersion
 	ret["MaskFields"] = c.MaskFields
+	ret["PartitionTimezone"] = c.PartitionTimezone
 	ret["Port"] = c.Port
 	ret["RecordType"] = c.RecordType
 	ret["RecoveryAttempts"] = c.RecoveryAttempts
@@ -352,26 +1111,72 @@ func (c *Config) Get() map[string]interface{} {
 	ret["RedisDB"] = c.RedisDB
 	ret["RedisDLQPrefix"] = c.RedisDLQPrefix
 	ret["RedisHost"] = c.RedisHost
+	ret["RedisInflightPrefix"] = c.RedisInflightPrefix
 	ret["RedisKeys"] = c.RedisKeys
 	ret["RedisLockInstanceName"] = c.RedisLockInstanceName
 	ret["RedisLockPrefix"] = c.RedisLockPrefix
 	ret["RedisLockTTL"] = c.RedisLockTTL
+	ret["RedisMode"] = c.RedisMode
 	ret["RedisPassword"] = c.RedisPassword
 	ret["RedisRecoveryKey"] = c.RedisRecoveryKey
+	ret["RedisScanCount"] = c.RedisScanCount
+	ret["RedisSentinelMaster"] = c.RedisSentinelMaster
+	ret["RedisSentinelPassword"] = c.RedisSentinelPassword
+	ret["RedisStreamClaimIdle"] = c.RedisStreamClaimIdle
+	ret["RedisStreamGroup"] = c.RedisStreamGroup
+	ret["RedisStreamPrefix"] = c.RedisStreamPrefix
 	ret["RedisTimeout"] = c.RedisTimeout
+	ret["RedisTLS"] = c.RedisTLS
+	ret["RedisTLSCAPath"] = c.RedisTLSCAPath
+	ret["RedisTLSInsecure"] = c.RedisTLSInsecure
+	ret["RedisUsername"] = c.RedisUsername
+	ret["S3AccessKeyID"] = c.S3AccessKeyID
 	ret["S3BucketName"] = c.S3BuketName
 	ret["S3DefaultCapability"] = c.S3DefaultCapability
+	ret["S3ChecksumAlgorithm"] = c.S3ChecksumAlgorithm
+	ret["S3CreateBucket"] = c.S3CreateBucket
+	ret["S3CredentialMode"] = c.S3CredentialMode
 	ret["S3Endpoint"] = c.S3Endpoint
+	ret["S3ExternalID"] = c.S3ExternalID
+	ret["S3KMSKeyID"] = c.S3KMSKeyID
+	ret["S3MaxAttempts"] = c.S3MaxAttempts
+	ret["S3Metadata"] = c.S3Metadata
+	ret["S3MultipartThreshold"] = c.S3MultipartThreshold
+	ret["S3PartSize"] = c.S3PartSize
+	ret["S3ProbePrefix"] = c.S3ProbePrefix
 	ret["S3Region"] = c.S3Region
+	ret["S3RetryMaxBackoff"] = c.S3RetryMaxBackoff
 	ret["S3RoleARN"] = c.S3RoleARN
+	ret["S3SecretAccessKey"] = c.S3SecretAccessKey
+	ret["S3ServerSideEncryption"] = c.S3ServerSideEncryption
+	ret["S3SessionDuration"] = c.S3SessionDuration
+	ret["S3SessionToken"] = c.S3SessionToken
+	ret["S3SSECustomerKey"] = c.S3SSECustomerKey
+	ret["S3StorageClass"] = c.S3StorageClass
 	ret["S3STSEndpoint"] = c.S3STSEndpoint
+	ret["S3Tags"] = c.S3Tags
+	ret["S3UploadConcurrency"] = c.S3UploadConcurrency
+	ret["S3WebIdentityTokenFile"] = c.S3WebIdentityTokenFile
+	ret["SchemaRegistryCacheTTL"] = c.SchemaRegistryCacheTTL
+	ret["SchemaRegistryPath"] = c.SchemaRegistryPath
+	ret["SchemaRegistryType"] = c.SchemaRegistryType
+	ret["SchemaRegistryURL"] = c.SchemaRegistryURL
 	ret["TryAutoRecover"] = c.TryAutoRecover
 	ret["UseDLQ"] = c.UseDLQ
 	ret["UseHash"] = c.UseHash
 	ret["UseHMAC"] = c.UseHMAC
 	ret["WriterCompressionType"] = c.WriterCompressionType
+	ret["WriterDirMode"] = c.WriterDirMode
+	ret["WriterFileMarker"] = c.WriterFileMarker
+	ret["WriterFileMode"] = c.WriterFileMode
 	ret["WriterFilePath"] = c.WriterFilePath
+	ret["WriterPathTemplate"] = c.WriterPathTemplate
 	ret["WriterRowGroupSize"] = c.WriterRowGroupSize
+	ret["WriterStatusInterval"] = c.WriterStatusInterval
+	ret["WriterTableCommitAttempts"] = c.WriterTableCommitAttempts
+	ret["WriterTableFormat"] = c.WriterTableFormat
+	ret["WriterTablePath"] = c.WriterTablePath
+	ret["WriterTargets"] = c.WriterTargets
 	ret["WriterType"] = c.WriterType
 
 	return ret
@@ -387,11 +1192,72 @@ func (c *Config) SetDefaults() {
 		c.WriterType = "file"
 	}
 
+	if _, err := c.GetWriterTargets(); err != nil {
+		slog.Error("Writer targets are invalid", "error", err)
+	}
+
+	if c.WriterType == WriterTypeFanOut && len(c.WriterTargets) == 0 {
+		slog.Error("Writer type is fan-out but WriterTargets is empty")
+	}
+
 	if c.WriterFilePath == "" {
 		slog.Debug("Writer file path is empty, setting to ./out")
 		c.WriterFilePath = "./out"
 	}
 
+	if _, err := strconv.ParseUint(c.WriterDirMode, 8, 32); err != nil {
+		if len(c.WriterDirMode) > 0 {
+			slog.Error("Writer dir mode is invalid, please set it to octal permissions as 0755", "mode", c.WriterDirMode)
+		} else {
+			slog.Debug("Writer dir mode is empty, setting to 0755")
+			c.WriterDirMode = "0755"
+		}
+	}
+
+	if _, err := strconv.ParseUint(c.WriterFileMode, 8, 32); err != nil {
+		if len(c.WriterFileMode) > 0 {
+			slog.Error("Writer file mode is invalid, please set it to octal permissions as 0644", "mode", c.WriterFileMode)
+		} else {
+			slog.Debug("Writer file mode is empty, setting to 0644")
+			c.WriterFileMode = "0644"
+		}
+	}
+
+	c.WriterFileMarker = strings.ToLower(c.WriterFileMarker)
+
+	if len(c.WriterFileMarker) == 0 {
+		slog.Debug("Writer file marker is empty, setting to none")
+		c.WriterFileMarker = WriterFileMarkerNone
+	} else if _, ok := WriterFileMarkers[c.WriterFileMarker]; !ok {
+		slog.Error("Writer file marker is invalid, please set it to none, success or manifest", "marker", c.WriterFileMarker)
+	}
+
+	c.WriterTableFormat = strings.ToLower(c.WriterTableFormat)
+
+	if len(c.WriterTableFormat) == 0 {
+		slog.Debug("Writer table format is empty, setting to none")
+		c.WriterTableFormat = WriterTableFormatNone
+	} else if _, ok := WriterTableFormats[c.WriterTableFormat]; !ok {
+		slog.Error("Writer table format is invalid, please set it to none, iceberg or delta", "format", c.WriterTableFormat)
+	} else if c.WriterTableFormat != WriterTableFormatNone && c.WriterType != WriterTypeFile && c.WriterType != WriterTypeAWSS3 {
+		slog.Error("Writer table format is only supported by file and aws-s3 writers", "format", c.WriterTableFormat, "type", c.WriterType)
+	}
+
+	if c.WriterStatusInterval < 1 {
+		slog.Debug("Writer status interval is less than 1, setting to 30000")
+		c.WriterStatusInterval = 30000
+	}
+
+	if c.WriterTableCommitAttempts < 1 {
+		slog.Debug("Writer table commit attempts is less than 1, setting to 10")
+		c.WriterTableCommitAttempts = 10
+	}
+
+	if c.WriterTableCommitAttempts > MaxWriterTableCommitAttempts {
+		slog.Warn("Writer table commit attempts is too high, setting to the max", "attempts", c.WriterTableCommitAttempts, "max", MaxWriterTableCommitAttempts)
+		c.WriterTableCommitAttempts = MaxWriterTableCommitAttempts
+	}
+
 	if c.WriterCompressionType == "" {
 		slog.Debug("Writer compression type is empty, setting to snappy")
 		c.WriterCompressionType = "snappy"
@@ -412,11 +1278,62 @@ func (c *Config) SetDefaults() {
 		c.BufferSize = 100
 	}
 
+	if c.BufferType == BufferTypeDisk {
+		if len(c.DiskPath) == 0 {
+			slog.Debug("Disk path is empty, setting to ./data/buffer")
+			c.DiskPath = "./data/buffer"
+		}
+
+		if c.DiskSegmentSize < 1024 {
+			slog.Debug("Disk segment size is less than 1024, setting to 64M")
+			c.DiskSegmentSize = 64 * 1024 * 1024 //64M
+		}
+
+		c.DiskSyncPolicy = strings.ToLower(c.DiskSyncPolicy)
+
+		if _, ok := DiskSyncPolicies[c.DiskSyncPolicy]; !ok {
+			slog.Debug("Disk sync policy is empty or invalid, setting to interval", "policy", c.DiskSyncPolicy)
+			c.DiskSyncPolicy = DiskSyncPolicyInterval
+		}
+
+		if c.DiskSyncInterval < 1 {
+			slog.Debug("Disk sync interval is less than 1ms, setting to 1000ms")
+			c.DiskSyncInterval = 1000
+		}
+	}
+
 	if c.FlushInterval < 5 {
 		slog.Debug("Flush interval is less than 5 seconds, setting to 5")
 		c.FlushInterval = 5
 	}
 
+	if c.BufferFlushBytes < 0 {
+		slog.Debug("Buffer flush bytes is less than 0, disabling it")
+		c.BufferFlushBytes = 0
+	}
+
+	if c.BufferPageSize < 1 {
+		slog.Debug("Buffer page size is less than 1, setting to 1000")
+		c.BufferPageSize = 1000
+	}
+
+	if c.BufferMemoryLimit < 0 {
+		slog.Debug("Buffer memory limit is less than 0, disabling it")
+		c.BufferMemoryLimit = 0
+	}
+
+	c.BufferBackpressure = strings.ToLower(c.BufferBackpressure)
+
+	if _, ok := BufferBackpressures[c.BufferBackpressure]; !ok {
+		slog.Debug("Buffer backpressure is empty or invalid, setting to reject", "backpressure", c.BufferBackpressure)
+		c.BufferBackpressure = BufferBackpressureReject
+	}
+
+	if c.BufferBackpressureTimeout < 1 {
+		slog.Debug("Buffer backpressure timeout is less than 1 second, setting to the flush interval")
+		c.BufferBackpressureTimeout = c.FlushInterval
+	}
+
 	if len(c.RedisKeys) == 0 {
 		slog.Debug("Redis keys is empty, setting to keys")
 		c.RedisKeys = "keys"
@@ -437,6 +1354,11 @@ func (c *Config) SetDefaults() {
 		c.RedisDataPrefix = "data"
 	}
 
+	if len(c.RedisInflightPrefix) == 0 {
+		slog.Debug("Redis inflight prefix is empty, setting to inflight")
+		c.RedisInflightPrefix = "inflight"
+	}
+
 	if len(c.RedisRecoveryKey) == 0 {
 		slog.Debug("Redis recovery key is empty, setting to recovery")
 		c.RedisRecoveryKey = "recovery"
@@ -454,7 +1376,78 @@ func (c *Config) SetDefaults() {
 
 	c.RecordType = strings.ToLower(c.RecordType)
 
-	if c.BufferType == BufferTypeRedis {
+	if _, ok := LogSchemaVersions[c.LogSchemaVersion]; !ok {
+		slog.Debug("Log schema version is empty or invalid, setting to current", "version", c.LogSchemaVersion, "current", LogSchemaVersionCurrent)
+		c.LogSchemaVersion = LogSchemaVersionCurrent
+	}
+
+	c.DynamicSchemaMode = strings.ToLower(c.DynamicSchemaMode)
+
+	if _, ok := DynamicSchemaModes[c.DynamicSchemaMode]; !ok {
+		if len(c.JsonSchemaPath) > 0 {
+			slog.Debug("Dynamic schema mode is empty or invalid, setting to file", "mode", c.DynamicSchemaMode)
+			c.DynamicSchemaMode = DynamicSchemaModeFile
+		} else {
+			slog.Debug("Dynamic schema mode is empty or invalid, setting to infer", "mode", c.DynamicSchemaMode)
+			c.DynamicSchemaMode = DynamicSchemaModeInfer
+		}
+	}
+
+	if c.DynamicSchemaSampleSize < 1 {
+		slog.Debug("Dynamic schema sample size is less than 1, setting to 1000")
+		c.DynamicSchemaSampleSize = 1000
+	}
+
+	c.SchemaRegistryType = strings.ToLower(c.SchemaRegistryType)
+
+	if len(c.SchemaRegistryType) > 0 {
+		if _, ok := SchemaRegistryTypes[c.SchemaRegistryType]; !ok {
+			slog.Warn("Schema registry type is invalid, disabling it", "type", c.SchemaRegistryType)
+			c.SchemaRegistryType = ""
+		}
+	}
+
+	if c.SchemaRegistryType == SchemaRegistryTypeLocal && len(c.SchemaRegistryPath) == 0 {
+		slog.Debug("Schema registry path is empty, setting to ./schemas")
+		c.SchemaRegistryPath = "./schemas"
+	}
+
+	if c.SchemaRegistryCacheTTL < 1 {
+		slog.Debug("Schema registry cache TTL is less than 1 second, setting to 60")
+		c.SchemaRegistryCacheTTL = 60
+	}
+
+	if len(strings.TrimSpace(c.DynamicKeyFields)) == 0 {
+		slog.Debug("Dynamic key fields is empty, setting to default")
+		c.DynamicKeyFields = DefaultDynamicKeyFields
+	}
+
+	if len(c.DynamicTimeField) == 0 {
+		slog.Debug("Dynamic time field is empty, setting to time")
+		c.DynamicTimeField = "time"
+	}
+
+	if len(c.PartitionTimezone) == 0 {
+		slog.Debug("Partition timezone is empty, setting to utc")
+		c.PartitionTimezone = "utc"
+	}
+
+	if len(c.RedisStreamPrefix) == 0 {
+		slog.Debug("Redis stream prefix is empty, setting to stream")
+		c.RedisStreamPrefix = "stream"
+	}
+
+	if len(c.RedisStreamGroup) == 0 {
+		slog.Debug("Redis stream group is empty, setting to data2parquet")
+		c.RedisStreamGroup = "data2parquet"
+	}
+
+	if c.RedisStreamClaimIdle < 1 {
+		slog.Debug("Redis stream claim idle is less than 1 second, setting to 3 times the flush interval")
+		c.RedisStreamClaimIdle = c.FlushInterval * 3
+	}
+
+	if c.BufferType == BufferTypeRedis || c.BufferType == BufferTypeRedisStream {
 		if c.RedisLockTTL < int(c.FlushInterval+c.FlushInterval/2) {
 			slog.Debug("Redis lock TTL is less than 1.5 times the flush interval, setting to 1.5 times the flush interval")
 		}
@@ -478,6 +1471,22 @@ func (c *Config) SetDefaults() {
 		if len(c.RedisHost) == 0 {
 			slog.Error("Redis host is empty, please set it")
 		}
+
+		c.RedisMode = strings.ToLower(c.RedisMode)
+
+		if _, ok := RedisModes[c.RedisMode]; !ok {
+			slog.Debug("Redis mode is empty or invalid, setting to single", "mode", c.RedisMode)
+			c.RedisMode = RedisModeSingle
+		}
+
+		if c.RedisMode == RedisModeSentinel && len(c.RedisSentinelMaster) == 0 {
+			slog.Error("Redis mode is sentinel but sentinel master name is empty, please set it")
+		}
+
+		if c.RedisScanCount < 1 {
+			slog.Debug("Redis scan count is less than 1, setting to 1000")
+			c.RedisScanCount = 1000
+		}
 	}
 
 	if len(c.S3DefaultCapability) == 0 {
@@ -485,6 +1494,111 @@ func (c *Config) SetDefaults() {
 		c.S3DefaultCapability = "undefined"
 	}
 
+	c.S3CredentialMode = strings.ToLower(c.S3CredentialMode)
+
+	if len(c.S3CredentialMode) == 0 {
+		c.S3CredentialMode = S3CredentialModeDefaultChain
+
+		if len(c.S3RoleARN) > 0 {
+			c.S3CredentialMode = S3CredentialModeAssumeRole
+		}
+
+		slog.Debug("S3 credential mode is empty, setting to "+c.S3CredentialMode, "roleArn", c.S3RoleARN)
+	} else if _, ok := S3CredentialModes[c.S3CredentialMode]; !ok {
+		slog.Error("S3 credential mode is invalid, please set it to static, default-chain, assume-role or web-identity", "mode", c.S3CredentialMode)
+	}
+
+	if c.S3SessionDuration < 0 {
+		slog.Debug("S3 session duration is less than 0, setting to 0 (AWS SDK default)")
+		c.S3SessionDuration = 0
+	}
+
+	if len(strings.Trim(c.S3ProbePrefix, "/")) == 0 {
+		slog.Debug("S3 probe prefix is empty, setting to _data2parquet")
+		c.S3ProbePrefix = "_data2parquet"
+	}
+
+	c.S3ServerSideEncryption = strings.ToLower(c.S3ServerSideEncryption)
+
+	if len(c.S3ServerSideEncryption) == 0 {
+		slog.Debug("S3 server side encryption is empty, setting to none")
+		c.S3ServerSideEncryption = S3ServerSideEncryptionNone
+	} else if _, ok := S3ServerSideEncryptions[c.S3ServerSideEncryption]; !ok {
+		slog.Error("S3 server side encryption is invalid, please set it to none, sse-s3, sse-kms or sse-c", "encryption", c.S3ServerSideEncryption)
+	}
+
+	c.S3StorageClass = strings.ToUpper(c.S3StorageClass)
+
+	c.S3ChecksumAlgorithm = strings.ToLower(c.S3ChecksumAlgorithm)
+
+	if _, ok := S3ChecksumAlgorithms[c.S3ChecksumAlgorithm]; !ok {
+		slog.Debug("S3 checksum algorithm is empty or invalid, setting to crc32c", "algorithm", c.S3ChecksumAlgorithm)
+		c.S3ChecksumAlgorithm = S3ChecksumCRC32C
+	}
+
+	if c.S3MaxAttempts < 1 {
+		slog.Debug("S3 max attempts is less than 1, setting to 3")
+		c.S3MaxAttempts = 3
+	}
+
+	if c.S3PartSize < 5*1024*1024 {
+		slog.Debug("S3 part size is less than 5M, setting to 8M")
+		c.S3PartSize = 8 * 1024 * 1024 //8M
+	}
+
+	if c.S3MultipartThreshold < 1 {
+		slog.Debug("S3 multipart threshold is less than 1, setting to 16M")
+		c.S3MultipartThreshold = 16 * 1024 * 1024 //16M
+	}
+
+	if c.S3RetryMaxBackoff < 1 {
+		slog.Debug("S3 retry max backoff is less than 1ms, setting to 20000ms")
+		c.S3RetryMaxBackoff = 20000
+	}
+
+	if c.S3UploadConcurrency < 1 {
+		slog.Debug("S3 upload concurrency is less than 1, setting to 4")
+		c.S3UploadConcurrency = 4
+	}
+
+	c.AzureAuthMode = strings.ToLower(c.AzureAuthMode)
+
+	if len(c.AzureAuthMode) == 0 {
+		switch {
+		case len(c.AzureAccountKey) > 0:
+			c.AzureAuthMode = AzureAuthModeSharedKey
+		case len(c.AzureSASToken) > 0:
+			c.AzureAuthMode = AzureAuthModeSAS
+		default:
+			c.AzureAuthMode = AzureAuthModeManagedIdentity
+		}
+
+		slog.Debug("Azure auth mode is empty, setting to " + c.AzureAuthMode)
+	} else if _, ok := AzureAuthModes[c.AzureAuthMode]; !ok {
+		slog.Error("Azure auth mode is invalid, please set it to shared-key, sas or managed-identity", "mode", c.AzureAuthMode)
+	}
+
+	c.AzureAccessTier = strings.ToLower(c.AzureAccessTier)
+
+	if _, ok := AzureAccessTiers[c.AzureAccessTier]; !ok && len(c.AzureAccessTier) > 0 {
+		slog.Error("Azure access tier is invalid, please set it to hot, cool, cold or archive", "tier", c.AzureAccessTier)
+	}
+
+	if c.AzureBlockSize < 1 {
+		slog.Debug("Azure block size is less than 1, setting to 8M")
+		c.AzureBlockSize = 8 * 1024 * 1024 //8M
+	}
+
+	if c.AzureUploadConcurrency < 1 {
+		slog.Debug("Azure upload concurrency is less than 1, setting to 4")
+		c.AzureUploadConcurrency = 4
+	}
+
+	if c.GCSChunkSize < 256*1024 {
+		slog.Debug("GCS chunk size is less than 256K, setting to 16M")
+		c.GCSChunkSize = 16 * 1024 * 1024 //16M
+	}
+
 	if c.UseDLQ {
 		slog.Info("DLQ is enabled")
 		if len(c.RedisDLQPrefix) == 0 {
@@ -495,6 +1609,7 @@ func (c *Config) SetDefaults() {
 	slog.SetFormatterByName(c.LogFormatter)
 
 	UseHMAC = c.UseHMAC
+	DynamicKeyFields = c.DynamicKeyFields
 	rgxFields := regexp.MustCompile(`;|:|,| |\||\/|\\`)
 
 	if len(c.IgnoredFields) > 0 {
@@ -515,5 +1630,5 @@ func (c *Config) SetDefaults() {
 		}
 	}
 
-	slog.Debug("Config", "data", c.Get())
+	slog.Debug("Config", "data", c.Redacted().Get())
 }
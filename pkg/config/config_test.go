@@ -0,0 +1,115 @@
+package config_test
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"data2parquet/pkg/config"
+	"data2parquet/pkg/logger"
+)
+
+func TestConfigRedacted(t *testing.T) {
+	cfg := &config.Config{
+		RedisPassword:     "redis-secret",
+		S3SecretAccessKey: "s3-secret",
+		S3AccessKeyID:     "s3-key-id",
+		S3SSECustomerKey:  "sse-secret",
+		S3SessionToken:    "session-token",
+		AzureAccountKey:   "azure-key",
+		AzureSASToken:     "?sig=azure-sas",
+		WriterTargets:     `[{"name":"s3","settings":{"S3SecretAccessKey":"target-secret","S3SessionToken":"target-token","S3BucketName":"bucket"}}]`,
+	}
+	secrets := []string{"redis-secret", "s3-secret", "sse-secret", "session-token", "azure-key", "azure-sas", "target-secret", "target-token"}
+
+	// Dumps don't apply the config, the globals set by SetDefaults are kept.
+	config.DynamicKeyFields = "custom"
+	defer func() { config.DynamicKeyFields = config.DefaultDynamicKeyFields }()
+
+	for name, dump := range map[string]string{"ToJSON": cfg.ToJSON(), "ToString": cfg.ToString()} {
+		for _, secret := range secrets {
+			if strings.Contains(dump, secret) {
+				t.Errorf("%s must not contain %q: %s", name, secret, dump)
+			}
+		}
+
+		if !strings.Contains(dump, "s3-key-id") || !strings.Contains(dump, "bucket") {
+			t.Errorf("%s must keep the other settings: %s", name, dump)
+		}
+	}
+
+	if cfg.S3SecretAccessKey != "s3-secret" || cfg.S3SessionToken != "session-token" || !strings.Contains(cfg.WriterTargets, "target-secret") {
+		t.Error("Redacted must not change the config")
+	}
+
+	if config.DynamicKeyFields != "custom" || len(cfg.DynamicKeyFields) > 0 {
+		t.Errorf("Redacted must not set the defaults, got %q", config.DynamicKeyFields)
+	}
+
+	// The debug dump of SetDefaults is redacted too.
+	if debug := captureDebug(t, cfg.SetDefaults); !strings.Contains(debug, "REDACTED") {
+		t.Errorf("Expected the debug dump of the config, got %s", debug)
+	} else {
+		for _, secret := range secrets {
+			if strings.Contains(debug, secret) {
+				t.Errorf("Debug output must not contain %q: %s", secret, debug)
+			}
+		}
+	}
+
+	filename := filepath.Join(t.TempDir(), "config.json")
+
+	if err := cfg.WriteToFile(filename); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := config.ConfigClientFromFile(filename)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded.S3SecretAccessKey != "s3-secret" {
+		t.Errorf("WriteToFile must keep the secrets, got %q", loaded.S3SecretAccessKey)
+	}
+}
+
+func TestConfigTableCommitAttempts(t *testing.T) {
+	for value, expected := range map[int]int{0: 10, 5: 5, 1000: config.MaxWriterTableCommitAttempts} {
+		cfg := &config.Config{WriterTableCommitAttempts: value}
+		cfg.SetDefaults()
+
+		if cfg.WriterTableCommitAttempts != expected {
+			t.Errorf("WriterTableCommitAttempts %d is set to %d, expected %d", value, cfg.WriterTableCommitAttempts, expected)
+		}
+	}
+}
+
+// captureDebug returns what the logger prints while fn runs at debug level.
+func captureDebug(t *testing.T, fn func()) string {
+	log := logger.GetLogger()
+	level := log.GetLogLoggerLevel()
+	stdout := os.Stdout
+	r, w, err := os.Pipe()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.Stdout = w
+	log.SetLogLoggerLevel(logger.LevelDebug)
+	fn()
+
+	// The logger prints from its own goroutine.
+	time.Sleep(200 * time.Millisecond)
+	log.SetLogLoggerLevel(level)
+	os.Stdout = stdout
+	w.Close()
+
+	data, _ := io.ReadAll(r)
+
+	return string(data)
+}
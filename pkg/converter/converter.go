@@ -1,12 +1,19 @@
 package converter
 
 import (
+	"context"
 	"data2parquet/pkg/config"
 	"data2parquet/pkg/domain"
 	"data2parquet/pkg/logger" //"log/slog"
+	"data2parquet/pkg/registry"
+	"encoding/json"
+	"fmt"
 	"io"
 	"os"
+	"sync"
+	"time"
 
+	"github.com/xitongsys/parquet-go/marshal"
 	"github.com/xitongsys/parquet-go/parquet"
 	"github.com/xitongsys/parquet-go/writer"
 )
@@ -31,6 +38,9 @@ func GetCompressionType(compressionType string) parquet.CompressionCodec {
 	}
 }
 
+// SchemaVersionKey is the parquet key-value metadata holding the schema version of the record type.
+const SchemaVersionKey = "data2parquet.schema.version"
+
 type Result struct {
 	Key    string
 	Error  error
@@ -43,27 +53,68 @@ type Converter struct {
 	rowGroupSize    int64
 	recordType      string
 	jsonSchemaPath  string
-	jsonSchemaData  string
+	fileSchema      *dynamicSchema
+	logSchema       int
+	inferSchema     bool
+	sampleSize      int
+	schemas         map[string]*inferNode
+	schemasMu       sync.Mutex
+	registry        registry.Registry
+	keySchemas      map[string]*dynamicSchema
+	keySchemasMu    sync.Mutex
+	cacheTTL        time.Duration
 	np              int64
 }
 
-func New(cfg *config.Config) *Converter {
+// dynamicSchema is the parquet schema of a batch of dynamic records. Schemas with a node are from JSON Schema
+// documents or inferred, records are converted to the node types and written as JSON.
+type dynamicSchema struct {
+	data      string
+	node      *inferNode
+	validator *JSONSchema
+	version   int
+	checked   time.Time
+}
+
+func New(ctx context.Context, cfg *config.Config) *Converter {
 	ret := &Converter{
 		config:          cfg,
 		compressionType: GetCompressionType(cfg.WriterCompressionType),
 		rowGroupSize:    cfg.WriterRowGroupSize,
 		recordType:      cfg.RecordType,
 		jsonSchemaPath:  cfg.JsonSchemaPath,
+		logSchema:       cfg.LogSchemaVersion,
+		inferSchema:     cfg.RecordType == config.RecordTypeDynamic && cfg.DynamicSchemaMode == config.DynamicSchemaModeInfer,
+		sampleSize:      cfg.DynamicSchemaSampleSize,
+		schemas:         make(map[string]*inferNode),
+		keySchemas:      make(map[string]*dynamicSchema),
+		cacheTTL:        time.Duration(cfg.SchemaRegistryCacheTTL) * time.Second,
 		np:              4,
 	}
 
-	if cfg.RecordType == config.RecordTypeDynamic && len(cfg.JsonSchemaPath) != 0 {
+	if _, ok := config.LogSchemaVersions[ret.logSchema]; !ok {
+		ret.logSchema = config.LogSchemaVersionCurrent
+	}
+
+	if cfg.RecordType == config.RecordTypeDynamic && len(cfg.DynamicSchemaMode) == 0 && len(cfg.JsonSchemaPath) == 0 {
+		ret.inferSchema = true
+	}
+
+	if cfg.RecordType == config.RecordTypeDynamic && !ret.inferSchema && len(cfg.JsonSchemaPath) != 0 {
 		err := ret.loadJsonSchema()
 
 		if err != nil {
 			slog.Error("Error loading json schema", "error", err, "module", "converter", "function", "New")
 		} else {
-			slog.Info("Json schema loaded", "module", "converter", "function", "New", "path", cfg.JsonSchemaPath, "schema", ret.jsonSchemaData)
+			slog.Info("Json schema loaded", "module", "converter", "function", "New", "path", cfg.JsonSchemaPath, "schema", ret.fileSchema.data)
+		}
+	}
+
+	if cfg.RecordType == config.RecordTypeDynamic && len(cfg.SchemaRegistryType) > 0 {
+		ret.registry = registry.New(ctx, cfg)
+
+		if ret.registry == nil {
+			slog.Error("Error creating schema registry, using DynamicSchemaMode for all keys", "type", cfg.SchemaRegistryType, "module", "converter", "function", "New")
 		}
 	}
 
@@ -82,30 +133,127 @@ func (c *Converter) loadJsonSchema() error {
 		return err
 	}
 
-	c.jsonSchemaData = string(data)
-	slog.Debug("Json schema loaded", "module", "converter", "function", "loadJsonSchema", "path", c.jsonSchemaPath, "schema", c.jsonSchemaData)
+	if IsJSONSchema(data) {
+		c.fileSchema, err = newDynamicSchema(data)
+
+		if err != nil {
+			slog.Error("Error loading json schema", "error", err, "module", "converter", "function", "loadJsonSchema", "path", c.jsonSchemaPath)
+		}
+
+		return err
+	}
+
+	c.fileSchema = &dynamicSchema{data: string(data)}
+	slog.Debug("Json schema loaded", "module", "converter", "function", "loadJsonSchema", "path", c.jsonSchemaPath, "schema", c.fileSchema.data)
 
 	return nil
 }
 
-func (c *Converter) createParquetWriter(w io.Writer) (*writer.ParquetWriter, error) {
+// newDynamicSchema translates a JSON Schema document to the parquet schema, records are validated with it
+// on Validate and converted to its types on Write.
+func newDynamicSchema(data []byte) (*dynamicSchema, error) {
+	validator, err := ParseJSONSchema(data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := validator.ParquetNode()
+
+	if err != nil {
+		return nil, err
+	}
+
+	root := node.schemaItem("parquet_go_root")
+	root.Tag = "name=parquet_go_root, repetitiontype=REQUIRED"
+
+	jsonSchema, err := json.Marshal(root)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &dynamicSchema{data: string(jsonSchema), node: node, validator: validator}, nil
+}
+
+// Validate checks a dynamic record against the JSON Schema of its key on the registry, or of JsonSchemaPath, other
+// records are always valid.
+func (c *Converter) Validate(record domain.Record) error {
+	if c.recordType != config.RecordTypeDynamic {
+		return nil
+	}
+
+	schema := c.keySchema(record.Key())
+
+	if schema == nil {
+		schema = c.fileSchema
+	}
+
+	if schema == nil || schema.validator == nil {
+		return nil
+	}
+
+	return schema.validator.Validate(record.GetData())
+}
+
+// batchSchema returns the schema of dynamic records of a key, from the registry, inferred from the batch or from
+// JsonSchemaPath, in this order.
+func (c *Converter) batchSchema(key string, data []domain.Record) (*dynamicSchema, error) {
+	if schema := c.keySchema(key); schema != nil {
+		return schema, nil
+	}
+
+	if c.inferSchema {
+		node, jsonSchema, err := c.inferBatchSchema(key, data)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &dynamicSchema{data: jsonSchema, node: node}, nil
+	}
+
+	if c.fileSchema == nil {
+		return nil, fmt.Errorf("no schema found for dynamic records of key %s", key)
+	}
+
+	return c.fileSchema, nil
+}
+
+func (c *Converter) createParquetWriter(w io.Writer, schema *dynamicSchema) (*writer.ParquetWriter, error) {
 	var pw *writer.ParquetWriter
 	var err error
 
 	if c.config.RecordType == config.RecordTypeDynamic {
-		pw, err = writer.NewParquetWriterFromWriter(w, c.jsonSchemaData, c.np)
+		pw, err = writer.NewParquetWriterFromWriter(w, schema.data, c.np)
+	} else if c.config.RecordType == config.RecordTypeLog {
+		pw, err = writer.NewParquetWriterFromWriter(w, domain.LogSchema(c.logSchema), c.np)
 	} else {
 		pw, err = writer.NewParquetWriterFromWriter(w, domain.NewObj(c.config.RecordType), c.np)
 	}
 
 	if err != nil {
-		slog.Error("Error creating parquet writer", "error", err, "module", "converter", "function", "createParquetWriter", "recordType", c.config.RecordType, "jsonSchemaData", c.jsonSchemaData)
+		slog.Error("Error creating parquet writer", "error", err, "module", "converter", "function", "createParquetWriter", "recordType", c.config.RecordType)
 		return nil, err
 	}
 
+	if schema != nil && schema.node != nil {
+		pw.MarshalFunc = marshal.MarshalJSON
+	}
+
 	pw.RowGroupSize = c.rowGroupSize
 	pw.CompressionType = c.compressionType
 
+	if c.config.RecordType == config.RecordTypeLog {
+		version := fmt.Sprint(c.logSchema)
+		pw.Footer.KeyValueMetadata = append(pw.Footer.KeyValueMetadata, &parquet.KeyValue{Key: SchemaVersionKey, Value: &version})
+	}
+
+	if schema != nil && schema.version > 0 {
+		version := fmt.Sprint(schema.version)
+		pw.Footer.KeyValueMetadata = append(pw.Footer.KeyValueMetadata, &parquet.KeyValue{Key: SchemaVersionKey, Value: &version})
+	}
+
 	return pw, err
 }
 
@@ -121,34 +269,16 @@ func (c *Converter) Write(key string, data []domain.Record, w io.Writer) []*Resu
 		return ret
 	}
 
-	pw, err := c.createParquetWriter(w)
-	if err != nil {
-		slog.Error("Error creating parquet writer", "error", err, "module", "writer", "function", "writeToFile", "key", key)
-		ret = append(ret, &Result{Key: key, Error: err})
-		return ret
-	}
-
-	defer pw.PFile.Close()
+	stream := c.NewStream(key, w)
 
-	for _, record := range data {
-		if err = pw.Write(record); err != nil {
-			slog.Error("Error writing parquet file", "error", err, "module", "writer", "function", "writeToFile", "key", key, "record", record.ToJson())
+	// The whole batch is the sample of inferred schemas.
+	stream.pending = data
 
-			ret = append(ret, &Result{Key: key, Error: err, Record: record})
-		}
+	if stream.open() != nil {
+		slog.Error("Error writing parquet file", "error", stream.Err(), "module", "writer", "function", "writeToFile", "key", key)
 	}
 
-	slog.Debug("Stopping parquet writer", "module", "writer", "function", "writeToFile", "key", key)
-	err = pw.WriteStop()
-
-	if err != nil {
-		slog.Error("Error to try stop parquet writer", "error", err, "module", "writer", "function", "writeToFile", "key", key)
-		ret = append(ret, &Result{Error: err})
-		return ret
-	}
-
-	slog.Debug("Parquet file written", "key", key, "module", "writer", "function", "writeToFile")
-	return ret
+	return stream.Close()
 }
 
 func (w *Result) IsError() bool {
@@ -0,0 +1,496 @@
+package converter
+
+import (
+	"data2parquet/pkg/domain"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/xitongsys/parquet-go/common"
+	"github.com/xitongsys/parquet-go/schema"
+)
+
+type inferKind int
+
+const (
+	inferNull inferKind = iota
+	inferBool
+	inferInt
+	inferDouble
+	inferString
+	inferList
+	inferObject
+	inferTimestamp
+	inferDate
+)
+
+// inferNode is the type inferred for a value of dynamic records, objects keep a node for each field, by parquet
+// internal name, and lists a node for their elements. Nodes are never changed after created, so cached schemas
+// can be shared between flushes.
+type inferNode struct {
+	kind     inferKind
+	name     string
+	optional bool
+	elem     *inferNode
+	fields   map[string]*inferNode
+}
+
+// inferBatchSchema samples the batch and widens the cached schema of the key with it. The returned node is final, fields
+// that only had nulls, empty objects and empty lists are written as strings.
+func (c *Converter) inferBatchSchema(key string, data []domain.Record) (*inferNode, string, error) {
+	var batch *inferNode
+
+	for _, record := range sampleRecords(data, c.sampleSize) {
+		batch = mergeNodes(batch, inferValue("", record.GetData()))
+	}
+
+	cacheKey := domain.NewRecordInfoFromKey(c.recordType, key).Key()
+
+	c.schemasMu.Lock()
+	node := mergeNodes(c.schemas[cacheKey], batch)
+	c.schemas[cacheKey] = node
+	c.schemasMu.Unlock()
+
+	if node == nil || node.kind != inferObject {
+		return nil, "", fmt.Errorf("no object found to infer the schema of key %s", key)
+	}
+
+	node = finalNode(node)
+
+	if len(node.fields) == 0 {
+		return nil, "", fmt.Errorf("no fields found to infer the schema of key %s", key)
+	}
+
+	root := node.schemaItem("parquet_go_root")
+	root.Tag = "name=parquet_go_root, repetitiontype=REQUIRED"
+
+	jsonSchema, err := json.Marshal(root)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	slog.Debug("Schema inferred", "module", "converter", "function", "inferBatchSchema", "key", key, "schema", string(jsonSchema))
+
+	return node, string(jsonSchema), nil
+}
+
+func sampleRecords(data []domain.Record, size int) []domain.Record {
+	if size < 1 || len(data) <= size {
+		return data
+	}
+
+	ret := make([]domain.Record, 0, size)
+	step := float64(len(data)) / float64(size)
+
+	for i := 0; i < size; i++ {
+		ret = append(ret, data[int(float64(i)*step)])
+	}
+
+	return ret
+}
+
+// columnName keeps names from breaking the parquet tag format, that uses `,` and `=` as separators.
+func columnName(name string) string {
+	return strings.NewReplacer(",", "_", "=", "_").Replace(strings.TrimSpace(name))
+}
+
+func inferValue(name string, value interface{}) *inferNode {
+	ret := &inferNode{name: name}
+
+	switch v := value.(type) {
+	case nil:
+		ret.kind = inferNull
+		ret.optional = true
+	case bool:
+		ret.kind = inferBool
+	case string:
+		ret.kind = inferString
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			ret.kind = inferInt
+		} else {
+			ret.kind = inferDouble
+		}
+	case float32, float64:
+		if _, ok := toInt64(v); ok {
+			ret.kind = inferInt
+		} else {
+			ret.kind = inferDouble
+		}
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		if _, ok := toInt64(v); ok {
+			ret.kind = inferInt
+		} else {
+			ret.kind = inferDouble
+		}
+	default:
+		rv := reflect.ValueOf(value)
+
+		switch {
+		case rv.Kind() == reflect.Map:
+			ret.kind = inferObject
+			ret.fields = make(map[string]*inferNode)
+
+			iter := rv.MapRange()
+			for iter.Next() {
+				col := columnName(fmt.Sprint(iter.Key().Interface()))
+
+				if len(col) == 0 {
+					continue
+				}
+
+				in := common.StringToVariableName(col)
+				ret.fields[in] = mergeNodes(ret.fields[in], inferValue(col, iter.Value().Interface()))
+			}
+		case (rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array) && rv.Type().Elem().Kind() != reflect.Uint8:
+			ret.kind = inferList
+
+			for i := 0; i < rv.Len(); i++ {
+				ret.elem = mergeNodes(ret.elem, inferValue("element", rv.Index(i).Interface()))
+			}
+		default:
+			ret.kind = inferString
+		}
+	}
+
+	return ret
+}
+
+// mergeNodes widens two types into one that holds both: null takes the other type as optional, int and double become
+// double, lists and objects are merged by element and field, fields missing on one side become optional and any
+// other conflict becomes string.
+func mergeNodes(a *inferNode, b *inferNode) *inferNode {
+	if a == nil {
+		return b
+	}
+
+	if b == nil {
+		return a
+	}
+
+	if a.kind == inferNull {
+		return b.withOptional(a.name, true)
+	}
+
+	if b.kind == inferNull {
+		return a.withOptional(a.name, true)
+	}
+
+	ret := &inferNode{kind: a.kind, name: a.name, optional: a.optional || b.optional}
+
+	switch {
+	case a.kind == inferList && b.kind == inferList:
+		ret.elem = mergeNodes(a.elem, b.elem)
+	case a.kind == inferObject && b.kind == inferObject:
+		ret.fields = make(map[string]*inferNode)
+
+		for in, f := range a.fields {
+			if o, ok := b.fields[in]; ok {
+				ret.fields[in] = mergeNodes(f, o)
+			} else {
+				ret.fields[in] = f.withOptional(f.name, true)
+			}
+		}
+
+		for in, f := range b.fields {
+			if _, ok := a.fields[in]; !ok {
+				ret.fields[in] = f.withOptional(f.name, true)
+			}
+		}
+	case a.kind == b.kind:
+	case a.isNumber() && b.isNumber():
+		ret.kind = inferDouble
+	default:
+		ret.kind = inferString
+	}
+
+	return ret
+}
+
+func finalNode(n *inferNode) *inferNode {
+	ret := n.withOptional(n.name, n.optional)
+
+	switch n.kind {
+	case inferNull:
+		ret.kind = inferString
+	case inferList:
+		if n.elem == nil {
+			ret.elem = &inferNode{kind: inferString, name: "element", optional: true}
+		} else {
+			ret.elem = finalNode(n.elem).withOptional("element", true)
+		}
+	case inferObject:
+		if len(n.fields) == 0 {
+			ret.kind = inferString
+			break
+		}
+
+		ret.fields = make(map[string]*inferNode)
+
+		for in, f := range n.fields {
+			ret.fields[in] = finalNode(f)
+		}
+	}
+
+	return ret
+}
+
+func (n *inferNode) withOptional(name string, optional bool) *inferNode {
+	ret := *n
+	ret.name = name
+	ret.optional = optional
+	return &ret
+}
+
+func (n *inferNode) isNumber() bool {
+	return n.kind == inferInt || n.kind == inferDouble
+}
+
+func (n *inferNode) schemaItem(name string) *schema.JSONSchemaItemType {
+	repetition := "REQUIRED"
+	if n.optional {
+		repetition = "OPTIONAL"
+	}
+
+	ret := schema.NewJSONSchemaItem()
+
+	switch n.kind {
+	case inferBool:
+		ret.Tag = fmt.Sprintf("name=%s, type=BOOLEAN, repetitiontype=%s", name, repetition)
+	case inferInt:
+		ret.Tag = fmt.Sprintf("name=%s, type=INT64, repetitiontype=%s", name, repetition)
+	case inferDouble:
+		ret.Tag = fmt.Sprintf("name=%s, type=DOUBLE, repetitiontype=%s", name, repetition)
+	case inferTimestamp:
+		ret.Tag = fmt.Sprintf("name=%s, type=INT64, convertedtype=TIMESTAMP_MICROS, logicaltype=TIMESTAMP, logicaltype.isadjustedtoutc=true, logicaltype.unit=MICROS, repetitiontype=%s", name, repetition)
+	case inferDate:
+		ret.Tag = fmt.Sprintf("name=%s, type=INT32, convertedtype=DATE, repetitiontype=%s", name, repetition)
+	case inferList:
+		ret.Tag = fmt.Sprintf("name=%s, type=LIST, repetitiontype=%s", name, repetition)
+		ret.Fields = []*schema.JSONSchemaItemType{n.elem.schemaItem("element")}
+	case inferObject:
+		ret.Tag = fmt.Sprintf("name=%s, repetitiontype=%s", name, repetition)
+
+		for _, in := range n.fieldNames() {
+			f := n.fields[in]
+			ret.Fields = append(ret.Fields, f.schemaItem(f.name))
+		}
+	default:
+		ret.Tag = fmt.Sprintf("name=%s, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=%s", name, repetition)
+	}
+
+	return ret
+}
+
+func (n *inferNode) fieldNames() []string {
+	ret := make([]string, 0, len(n.fields))
+
+	for in := range n.fields {
+		ret = append(ret, in)
+	}
+
+	sort.Strings(ret)
+
+	return ret
+}
+
+// conformRecord converts the record data to the inferred or JSON Schema types and returns it as JSON for the parquet
+// JSON writer. Records out of the sample may not fit an inferred schema, they return an error to be sent to the DLQ.
+func conformRecord(record domain.Record, node *inferNode) (string, error) {
+	value, err := conformValue("", record.GetData(), node)
+
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(value)
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+func conformValue(path string, value interface{}, node *inferNode) (interface{}, error) {
+	if value == nil {
+		if !node.optional {
+			return nil, fmt.Errorf("field %s is required by the schema", path)
+		}
+
+		return nil, nil
+	}
+
+	rv := reflect.ValueOf(value)
+
+	switch node.kind {
+	case inferBool:
+		if v, ok := value.(bool); ok {
+			return v, nil
+		}
+	case inferInt:
+		if v, ok := toInt64(value); ok {
+			return v, nil
+		}
+	case inferDouble:
+		if v, ok := toFloat64(value); ok {
+			return v, nil
+		}
+	case inferTimestamp:
+		if tm, err := time.Parse(time.RFC3339Nano, fmt.Sprint(value)); err == nil {
+			return tm.UnixMicro(), nil
+		}
+	case inferDate:
+		if tm, err := time.Parse(time.DateOnly, fmt.Sprint(value)); err == nil {
+			return tm.Unix() / 86400, nil
+		}
+	case inferList:
+		if (rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array) && rv.Type().Elem().Kind() != reflect.Uint8 {
+			ret := make([]interface{}, 0, rv.Len())
+
+			for i := 0; i < rv.Len(); i++ {
+				v, err := conformValue(fmt.Sprintf("%s[%d]", path, i), rv.Index(i).Interface(), node.elem)
+
+				if err != nil {
+					return nil, err
+				}
+
+				// The parquet JSON marshal does not support null list elements
+				if v != nil {
+					ret = append(ret, v)
+				}
+			}
+
+			return ret, nil
+		}
+	case inferObject:
+		if rv.Kind() == reflect.Map {
+			ret := make(map[string]interface{})
+
+			iter := rv.MapRange()
+			for iter.Next() {
+				col := columnName(fmt.Sprint(iter.Key().Interface()))
+				f, ok := node.fields[common.StringToVariableName(col)]
+
+				if len(col) == 0 || !ok {
+					continue
+				}
+
+				v, err := conformValue(path+"."+f.name, iter.Value().Interface(), f)
+
+				if err != nil {
+					return nil, err
+				}
+
+				ret[f.name] = v
+			}
+
+			for _, f := range node.fields {
+				if _, ok := ret[f.name]; !ok && !f.optional {
+					return nil, fmt.Errorf("field %s.%s is required by the schema", path, f.name)
+				}
+			}
+
+			return ret, nil
+		}
+	default:
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case []byte:
+			return string(v), nil
+		}
+
+		if rv.Kind() == reflect.Map || rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+			data, err := json.Marshal(value)
+
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %w", path, err)
+			}
+
+			return string(data), nil
+		}
+
+		return fmt.Sprint(value), nil
+	}
+
+	return nil, fmt.Errorf("field %s value %v does not match the schema", path, value)
+}
+
+func toInt64(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint:
+		return int64(v), uint64(v) <= math.MaxInt64
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint64:
+		return int64(v), v <= math.MaxInt64
+	case json.Number:
+		ret, err := v.Int64()
+		return ret, err == nil
+	case float32:
+		return toInt64(float64(v))
+	case float64:
+		if v != math.Trunc(v) || v < math.MinInt64 || v >= math.MaxInt64 {
+			return 0, false
+		}
+
+		return int64(v), true
+	}
+
+	return 0, false
+}
+
+func toFloat64(value interface{}) (float64, bool) {
+	var ret float64
+
+	switch v := value.(type) {
+	case float32:
+		ret = float64(v)
+	case float64:
+		ret = v
+	case json.Number:
+		f, err := v.Float64()
+
+		if err != nil {
+			return 0, false
+		}
+
+		ret = f
+	default:
+		if i, ok := toInt64(value); ok {
+			return float64(i), true
+		}
+
+		if u, ok := value.(uint64); ok {
+			return float64(u), true
+		}
+
+		if u, ok := value.(uint); ok {
+			return float64(u), true
+		}
+
+		return 0, false
+	}
+
+	return ret, !math.IsNaN(ret) && !math.IsInf(ret, 0)
+}
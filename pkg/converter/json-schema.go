@@ -0,0 +1,731 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/xitongsys/parquet-go/common"
+)
+
+// JSONSchema is a JSON Schema (draft 2020-12) document, used as the schema of dynamic records. It is translated to a
+// parquet schema and validates records before they enter the buffer. Supported keywords are type, enum, const,
+// format, properties, required, additionalProperties, items, prefixItems, the number, string and array limits,
+// allOf, anyOf, oneOf, not and local $ref to $defs or definitions.
+type JSONSchema struct {
+	always *bool
+
+	Ref                  string                 `json:"$ref,omitempty"`
+	Defs                 map[string]*JSONSchema `json:"$defs,omitempty"`
+	Definitions          map[string]*JSONSchema `json:"definitions,omitempty"`
+	Type                 schemaTypes            `json:"type,omitempty"`
+	Enum                 []interface{}          `json:"enum,omitempty"`
+	Const                json.RawMessage        `json:"const,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *JSONSchema            `json:"additionalProperties,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+	PrefixItems          []*JSONSchema          `json:"prefixItems,omitempty"`
+	Minimum              *float64               `json:"minimum,omitempty"`
+	Maximum              *float64               `json:"maximum,omitempty"`
+	ExclusiveMinimum     *float64               `json:"exclusiveMinimum,omitempty"`
+	ExclusiveMaximum     *float64               `json:"exclusiveMaximum,omitempty"`
+	MultipleOf           *float64               `json:"multipleOf,omitempty"`
+	MinLength            *int                   `json:"minLength,omitempty"`
+	MaxLength            *int                   `json:"maxLength,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+	MinItems             *int                   `json:"minItems,omitempty"`
+	MaxItems             *int                   `json:"maxItems,omitempty"`
+	UniqueItems          bool                   `json:"uniqueItems,omitempty"`
+	AllOf                []*JSONSchema          `json:"allOf,omitempty"`
+	AnyOf                []*JSONSchema          `json:"anyOf,omitempty"`
+	OneOf                []*JSONSchema          `json:"oneOf,omitempty"`
+	Not                  *JSONSchema            `json:"not,omitempty"`
+
+	ref     *JSONSchema
+	pattern *regexp.Regexp
+	constV  interface{}
+}
+
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var one string
+
+	if err := json.Unmarshal(data, &one); err == nil {
+		*t = schemaTypes{one}
+		return nil
+	}
+
+	var many []string
+
+	if err := json.Unmarshal(data, &many); err != nil {
+		return fmt.Errorf("type must be a string or an array of strings: %w", err)
+	}
+
+	*t = many
+	return nil
+}
+
+// UnmarshalJSON also accepts the boolean schemas `true` (anything is valid) and `false` (nothing is valid).
+func (s *JSONSchema) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+
+	if string(data) == "true" || string(data) == "false" {
+		always := string(data) == "true"
+		*s = JSONSchema{always: &always}
+		return nil
+	}
+
+	type plain JSONSchema
+	return json.Unmarshal(data, (*plain)(s))
+}
+
+var jsonSchemaTypes = map[string]int{
+	"null":    1,
+	"boolean": 2,
+	"integer": 3,
+	"number":  4,
+	"string":  5,
+	"array":   6,
+	"object":  7,
+}
+
+// IsJSONSchema tells a JSON Schema document from the xitongsys schema format, that has a `Tag` on its root.
+func IsJSONSchema(data []byte) bool {
+	root := make(map[string]interface{})
+
+	if err := json.Unmarshal(data, &root); err != nil {
+		return false
+	}
+
+	_, tag := root["Tag"]
+	return !tag
+}
+
+func ParseJSONSchema(data []byte) (*JSONSchema, error) {
+	ret := &JSONSchema{}
+
+	if err := json.Unmarshal(data, ret); err != nil {
+		return nil, fmt.Errorf("error parsing json schema: %w", err)
+	}
+
+	if err := ret.prepare(ret, "#"); err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+// prepare resolves references, compiles patterns and checks keyword values, once for the whole document.
+func (s *JSONSchema) prepare(root *JSONSchema, path string) error {
+	if s == nil || s.always != nil {
+		return nil
+	}
+
+	for _, t := range s.Type {
+		if _, ok := jsonSchemaTypes[t]; !ok {
+			return fmt.Errorf("%s: unknown type %q", path, t)
+		}
+	}
+
+	if len(s.Ref) > 0 {
+		ref, err := root.resolve(s.Ref)
+
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		s.ref = ref
+	}
+
+	if len(s.Pattern) > 0 {
+		pattern, err := regexp.Compile(s.Pattern)
+
+		if err != nil {
+			return fmt.Errorf("%s: invalid pattern: %w", path, err)
+		}
+
+		s.pattern = pattern
+	}
+
+	if len(s.Const) > 0 {
+		if err := json.Unmarshal(s.Const, &s.constV); err != nil {
+			return fmt.Errorf("%s: invalid const: %w", path, err)
+		}
+	}
+
+	children := map[string]*JSONSchema{
+		"additionalProperties": s.AdditionalProperties,
+		"items":                s.Items,
+		"not":                  s.Not,
+	}
+
+	for name, child := range s.Defs {
+		children["$defs/"+name] = child
+	}
+
+	for name, child := range s.Definitions {
+		children["definitions/"+name] = child
+	}
+
+	for name, child := range s.Properties {
+		children["properties/"+name] = child
+	}
+
+	for keyword, list := range map[string][]*JSONSchema{"prefixItems": s.PrefixItems, "allOf": s.AllOf, "anyOf": s.AnyOf, "oneOf": s.OneOf} {
+		for i, child := range list {
+			children[fmt.Sprintf("%s/%d", keyword, i)] = child
+		}
+	}
+
+	for name, child := range children {
+		if err := child.prepare(root, path+"/"+name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *JSONSchema) resolve(ref string) (*JSONSchema, error) {
+	if ref == "#" {
+		return s, nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(ref, "#/"), "/")
+
+	if !strings.HasPrefix(ref, "#/") || len(parts) != 2 {
+		return nil, fmt.Errorf("only local references to $defs or definitions are supported, got %q", ref)
+	}
+
+	name := strings.ReplaceAll(strings.ReplaceAll(parts[1], "~1", "/"), "~0", "~")
+	var ret *JSONSchema
+
+	switch parts[0] {
+	case "$defs":
+		ret = s.Defs[name]
+	case "definitions":
+		ret = s.Definitions[name]
+	}
+
+	if ret == nil {
+		return nil, fmt.Errorf("reference %q not found", ref)
+	}
+
+	return ret, nil
+}
+
+// Validate checks a record value against the schema, returning all violations found as one error.
+func (s *JSONSchema) Validate(value interface{}) error {
+	errs := s.validate("", value, make([]string, 0))
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("%s", strings.Join(errs, "; "))
+}
+
+func (s *JSONSchema) validate(path string, value interface{}, errs []string) []string {
+	if s == nil {
+		return errs
+	}
+
+	if s.always != nil {
+		if !*s.always {
+			errs = append(errs, fmt.Sprintf("%s: no value is allowed", pathName(path)))
+		}
+
+		return errs
+	}
+
+	if s.ref != nil {
+		errs = s.ref.validate(path, value, errs)
+	}
+
+	kind := jsonKind(value)
+
+	if len(s.Type) > 0 && !s.allowsKind(kind) {
+		return append(errs, fmt.Sprintf("%s: expected %s, got %s", pathName(path), strings.Join(s.Type, " or "), kind))
+	}
+
+	if len(s.Enum) > 0 {
+		found := false
+
+		for _, e := range s.Enum {
+			if jsonEqual(e, value) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			errs = append(errs, fmt.Sprintf("%s: value %v is not one of the enum values", pathName(path), value))
+		}
+	}
+
+	if len(s.Const) > 0 && !jsonEqual(s.constV, value) {
+		errs = append(errs, fmt.Sprintf("%s: value %v is not the const value", pathName(path), value))
+	}
+
+	switch kind {
+	case "integer", "number":
+		errs = s.validateNumber(path, value, errs)
+	case "string":
+		if b, ok := value.([]byte); ok {
+			value = string(b)
+		}
+
+		errs = s.validateString(path, fmt.Sprint(value), errs)
+	case "array":
+		errs = s.validateArray(path, value, errs)
+	case "object":
+		errs = s.validateObject(path, value, errs)
+	}
+
+	for _, sub := range s.AllOf {
+		errs = sub.validate(path, value, errs)
+	}
+
+	if len(s.AnyOf) > 0 {
+		valid := 0
+
+		for _, sub := range s.AnyOf {
+			if len(sub.validate(path, value, nil)) == 0 {
+				valid++
+				break
+			}
+		}
+
+		if valid == 0 {
+			errs = append(errs, fmt.Sprintf("%s: value does not match any schema of anyOf", pathName(path)))
+		}
+	}
+
+	if len(s.OneOf) > 0 {
+		valid := 0
+
+		for _, sub := range s.OneOf {
+			if len(sub.validate(path, value, nil)) == 0 {
+				valid++
+			}
+		}
+
+		if valid != 1 {
+			errs = append(errs, fmt.Sprintf("%s: value matches %d schemas of oneOf, expected exactly one", pathName(path), valid))
+		}
+	}
+
+	if s.Not != nil && len(s.Not.validate(path, value, nil)) == 0 {
+		errs = append(errs, fmt.Sprintf("%s: value must not match the schema of not", pathName(path)))
+	}
+
+	return errs
+}
+
+func (s *JSONSchema) allowsKind(kind string) bool {
+	for _, t := range s.Type {
+		if t == kind || (t == "number" && kind == "integer") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *JSONSchema) validateNumber(path string, value interface{}, errs []string) []string {
+	n, _ := toFloat64(value)
+
+	if s.Minimum != nil && n < *s.Minimum {
+		errs = append(errs, fmt.Sprintf("%s: %v is less than the minimum %v", pathName(path), n, *s.Minimum))
+	}
+
+	if s.Maximum != nil && n > *s.Maximum {
+		errs = append(errs, fmt.Sprintf("%s: %v is greater than the maximum %v", pathName(path), n, *s.Maximum))
+	}
+
+	if s.ExclusiveMinimum != nil && n <= *s.ExclusiveMinimum {
+		errs = append(errs, fmt.Sprintf("%s: %v must be greater than %v", pathName(path), n, *s.ExclusiveMinimum))
+	}
+
+	if s.ExclusiveMaximum != nil && n >= *s.ExclusiveMaximum {
+		errs = append(errs, fmt.Sprintf("%s: %v must be less than %v", pathName(path), n, *s.ExclusiveMaximum))
+	}
+
+	if s.MultipleOf != nil && *s.MultipleOf > 0 {
+		if q := n / *s.MultipleOf; math.Abs(q-math.Round(q)) > 1e-9 {
+			errs = append(errs, fmt.Sprintf("%s: %v is not a multiple of %v", pathName(path), n, *s.MultipleOf))
+		}
+	}
+
+	return errs
+}
+
+func (s *JSONSchema) validateString(path string, value string, errs []string) []string {
+	length := utf8.RuneCountInString(value)
+
+	if s.MinLength != nil && length < *s.MinLength {
+		errs = append(errs, fmt.Sprintf("%s: length %d is less than %d", pathName(path), length, *s.MinLength))
+	}
+
+	if s.MaxLength != nil && length > *s.MaxLength {
+		errs = append(errs, fmt.Sprintf("%s: length %d is greater than %d", pathName(path), length, *s.MaxLength))
+	}
+
+	if s.pattern != nil && !s.pattern.MatchString(value) {
+		errs = append(errs, fmt.Sprintf("%s: value does not match the pattern %s", pathName(path), s.Pattern))
+	}
+
+	if len(s.Format) > 0 && !validFormat(s.Format, value) {
+		errs = append(errs, fmt.Sprintf("%s: value %q is not a valid %s", pathName(path), value, s.Format))
+	}
+
+	return errs
+}
+
+func (s *JSONSchema) validateArray(path string, value interface{}, errs []string) []string {
+	rv := reflect.ValueOf(value)
+	length := rv.Len()
+
+	if s.MinItems != nil && length < *s.MinItems {
+		errs = append(errs, fmt.Sprintf("%s: %d items are less than %d", pathName(path), length, *s.MinItems))
+	}
+
+	if s.MaxItems != nil && length > *s.MaxItems {
+		errs = append(errs, fmt.Sprintf("%s: %d items are more than %d", pathName(path), length, *s.MaxItems))
+	}
+
+	for i := 0; i < length; i++ {
+		item := rv.Index(i).Interface()
+
+		if i < len(s.PrefixItems) {
+			errs = s.PrefixItems[i].validate(fmt.Sprintf("%s/%d", path, i), item, errs)
+		} else {
+			errs = s.Items.validate(fmt.Sprintf("%s/%d", path, i), item, errs)
+		}
+
+		if !s.UniqueItems {
+			continue
+		}
+
+		for j := 0; j < i; j++ {
+			if jsonEqual(rv.Index(j).Interface(), item) {
+				errs = append(errs, fmt.Sprintf("%s: items %d and %d are equal", pathName(path), j, i))
+				break
+			}
+		}
+	}
+
+	return errs
+}
+
+func (s *JSONSchema) validateObject(path string, value interface{}, errs []string) []string {
+	fields := make(map[string]interface{})
+
+	iter := reflect.ValueOf(value).MapRange()
+	for iter.Next() {
+		fields[fmt.Sprint(iter.Key().Interface())] = iter.Value().Interface()
+	}
+
+	for _, name := range s.Required {
+		if _, ok := fields[name]; !ok {
+			errs = append(errs, fmt.Sprintf("%s: required field %q is missing", pathName(path), name))
+		}
+	}
+
+	names := make([]string, 0, len(fields))
+
+	for name := range fields {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if prop, ok := s.Properties[name]; ok {
+			errs = prop.validate(path+"/"+name, fields[name], errs)
+		} else {
+			errs = s.AdditionalProperties.validate(path+"/"+name, fields[name], errs)
+		}
+	}
+
+	return errs
+}
+
+func pathName(path string) string {
+	if len(path) == 0 {
+		return "/"
+	}
+
+	return path
+}
+
+// jsonKind returns the JSON Schema type of a decoded value, numbers with no fraction are integers.
+func jsonKind(value interface{}) string {
+	if value == nil {
+		return "null"
+	}
+
+	switch value.(type) {
+	case bool:
+		return "boolean"
+	case string, []byte:
+		return "string"
+	}
+
+	if _, ok := toInt64(value); ok {
+		return "integer"
+	}
+
+	if _, ok := toFloat64(value); ok {
+		return "number"
+	}
+
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Map:
+		return "object"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	}
+
+	return "string"
+}
+
+func jsonEqual(a interface{}, b interface{}) bool {
+	an, aok := toFloat64(a)
+	bn, bok := toFloat64(b)
+
+	if aok || bok {
+		return aok && bok && an == bn
+	}
+
+	ad, err := json.Marshal(a)
+
+	if err != nil {
+		return false
+	}
+
+	bd, err := json.Marshal(b)
+
+	if err != nil {
+		return false
+	}
+
+	return bytes.Equal(ad, bd)
+}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// validFormat checks the formats that change the column type (date-time and date) and the common ones, unknown
+// formats are accepted as annotations.
+func validFormat(format string, value string) bool {
+	switch format {
+	case "date-time":
+		_, err := time.Parse(time.RFC3339Nano, value)
+		return err == nil
+	case "date":
+		_, err := time.Parse(time.DateOnly, value)
+		return err == nil
+	case "time":
+		if _, err := time.Parse("15:04:05Z07:00", value); err == nil {
+			return true
+		}
+
+		_, err := time.Parse("15:04:05.999999999Z07:00", value)
+		return err == nil
+	case "email":
+		_, err := mail.ParseAddress(value)
+		return err == nil
+	case "uuid":
+		return uuidPattern.MatchString(value)
+	case "ipv4":
+		ip := net.ParseIP(value)
+		return ip != nil && ip.To4() != nil && strings.Contains(value, ".")
+	case "ipv6":
+		ip := net.ParseIP(value)
+		return ip != nil && strings.Contains(value, ":")
+	case "uri":
+		u, err := url.Parse(value)
+		return err == nil && len(u.Scheme) > 0
+	}
+
+	return true
+}
+
+// ParquetNode translates the schema to the parquet type of a dynamic record, the root must be an object with
+// properties. Fields are optional unless required and not nullable, date-time strings are written as timestamps
+// and date strings as dates, objects without properties and values of many types are written as JSON text.
+func (s *JSONSchema) ParquetNode() (*inferNode, error) {
+	ret := s.parquetNode("", make(map[*JSONSchema]bool))
+
+	if ret.kind != inferObject || len(ret.fields) == 0 {
+		return nil, fmt.Errorf("json schema root must be an object with properties")
+	}
+
+	ret.optional = false
+
+	return ret, nil
+}
+
+func (s *JSONSchema) parquetNode(name string, visiting map[*JSONSchema]bool) *inferNode {
+	ret := &inferNode{kind: inferString, name: name, optional: true}
+
+	// Boolean and recursive schemas have no fixed shape
+	if s == nil || s.always != nil || visiting[s] {
+		return ret
+	}
+
+	visiting[s] = true
+	defer delete(visiting, s)
+
+	if s.ref != nil {
+		ret = s.ref.parquetNode(name, visiting)
+	}
+
+	types := s.Type
+	nullable := len(types) == 0 && s.ref == nil && len(s.Enum) == 0
+
+	if len(types) > 0 {
+		types = make(schemaTypes, 0, len(s.Type))
+
+		for _, t := range s.Type {
+			if t == "null" {
+				nullable = true
+			} else {
+				types = append(types, t)
+			}
+		}
+	}
+
+	switch {
+	case len(types) == 1:
+		ret = s.typeNode(name, types[0], visiting)
+	case len(types) == 0 && len(s.Properties) > 0:
+		ret = s.typeNode(name, "object", visiting)
+	case len(types) == 0 && s.Items != nil:
+		ret = s.typeNode(name, "array", visiting)
+	case len(types) == 0 && len(s.Enum) > 0:
+		var node *inferNode
+
+		for _, e := range s.Enum {
+			node = mergeNodes(node, inferValue(name, e))
+		}
+
+		ret = finalNode(node)
+		nullable = ret.optional
+	case len(types) > 1:
+		ret = &inferNode{kind: inferString, name: name}
+	}
+
+	// allOf adds fields to objects, anyOf and oneOf are widened to a type that holds all options
+	for _, sub := range s.AllOf {
+		ret = allOfNode(ret, sub.parquetNode(name, visiting))
+	}
+
+	if len(s.AnyOf)+len(s.OneOf) > 0 && len(s.Type) == 0 && len(s.Properties) == 0 {
+		var node *inferNode
+
+		for _, sub := range append(append([]*JSONSchema{}, s.AnyOf...), s.OneOf...) {
+			node = mergeNodes(node, sub.parquetNode(name, visiting))
+		}
+
+		ret = node
+	}
+
+	ret = ret.withOptional(name, ret.optional || nullable)
+
+	return ret
+}
+
+func (s *JSONSchema) typeNode(name string, tp string, visiting map[*JSONSchema]bool) *inferNode {
+	ret := &inferNode{name: name}
+
+	switch tp {
+	case "boolean":
+		ret.kind = inferBool
+	case "integer":
+		ret.kind = inferInt
+	case "number":
+		ret.kind = inferDouble
+	case "string":
+		switch s.Format {
+		case "date-time":
+			ret.kind = inferTimestamp
+		case "date":
+			ret.kind = inferDate
+		default:
+			ret.kind = inferString
+		}
+	case "array":
+		ret.kind = inferList
+		ret.elem = s.Items.parquetNode("element", visiting).withOptional("element", true)
+	case "object":
+		if len(s.Properties) == 0 {
+			ret.kind = inferString
+			break
+		}
+
+		ret.kind = inferObject
+		ret.fields = make(map[string]*inferNode)
+		required := make(map[string]bool)
+
+		for _, r := range s.Required {
+			required[r] = true
+		}
+
+		for prop, sub := range s.Properties {
+			col := columnName(prop)
+
+			if len(col) == 0 {
+				continue
+			}
+
+			field := sub.parquetNode(col, visiting)
+			ret.fields[common.StringToVariableName(col)] = field.withOptional(col, field.optional || !required[prop])
+		}
+	default:
+		ret.kind = inferString
+		ret.optional = true
+	}
+
+	return ret
+}
+
+func allOfNode(a *inferNode, b *inferNode) *inferNode {
+	if a.kind != inferObject || b.kind != inferObject {
+		if a.kind == inferString && a.optional {
+			return b
+		}
+
+		return a
+	}
+
+	ret := a.withOptional(a.name, a.optional && b.optional)
+	ret.fields = make(map[string]*inferNode)
+
+	for in, f := range a.fields {
+		ret.fields[in] = f
+	}
+
+	for in, f := range b.fields {
+		if o, ok := ret.fields[in]; ok {
+			ret.fields[in] = o.withOptional(o.name, o.optional && f.optional)
+		} else {
+			ret.fields[in] = f
+		}
+	}
+
+	return ret
+}
@@ -0,0 +1,161 @@
+package converter
+
+import (
+	"data2parquet/pkg/domain"
+	"data2parquet/pkg/registry"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var inferKindNames = map[inferKind]string{
+	inferNull:      "null",
+	inferBool:      "boolean",
+	inferInt:       "int64",
+	inferDouble:    "double",
+	inferString:    "string",
+	inferList:      "list",
+	inferObject:    "object",
+	inferTimestamp: "timestamp",
+	inferDate:      "date",
+}
+
+// keySchema returns the registry schema of a key, cached for SchemaRegistryCacheTTL. A new version is only used when
+// it is backward compatible with the version in use, or with the previous one on the registry, otherwise the
+// previous version is kept. It returns nil when the key has no schema on the registry.
+func (c *Converter) keySchema(key string) *dynamicSchema {
+	if c.registry == nil {
+		return nil
+	}
+
+	info := domain.NewRecordInfoFromKey(c.recordType, key)
+	subject := registry.Subject(info)
+
+	c.keySchemasMu.Lock()
+	defer c.keySchemasMu.Unlock()
+
+	current, found := c.keySchemas[subject]
+
+	if found && time.Since(current.checked) < c.cacheTTL {
+		return current.orNil()
+	}
+
+	latest, err := c.registry.Latest(info)
+
+	if errors.Is(err, registry.ErrNotFound) {
+		slog.Debug("No schema on registry for key", "subject", subject, "module", "converter", "function", "keySchema")
+		c.keySchemas[subject] = &dynamicSchema{checked: time.Now()}
+		return nil
+	}
+
+	if err != nil {
+		slog.Error("Error getting schema from registry, keeping the current one", "error", err, "subject", subject, "module", "converter", "function", "keySchema")
+
+		if found {
+			current.checked = time.Now()
+			return current.orNil()
+		}
+
+		return nil
+	}
+
+	if found && current.version == latest.Version {
+		current.checked = time.Now()
+		return current.orNil()
+	}
+
+	next, err := newDynamicSchema(latest.Data)
+
+	if err != nil {
+		slog.Error("Invalid schema on registry, keeping the current one", "error", err, "subject", subject, "version", latest.Version, "module", "converter", "function", "keySchema")
+		return c.keepSchema(subject, current)
+	}
+
+	next.version = latest.Version
+	next.checked = time.Now()
+
+	previous := current.orNil()
+
+	if previous == nil && latest.Version > 1 {
+		if schema, err := c.registry.Get(info, latest.Version-1); err == nil {
+			if previous, err = newDynamicSchema(schema.Data); err == nil {
+				previous.version = schema.Version
+			}
+		}
+	}
+
+	if previous != nil && previous.version < next.version {
+		err = checkCompatible("", previous.node, next.node)
+
+		if err != nil {
+			slog.Error("Schema version is not backward compatible, keeping the previous one", "error", err, "subject", subject, "version", next.version, "previous", previous.version, "module", "converter", "function", "keySchema")
+			return c.keepSchema(subject, previous)
+		}
+	}
+
+	slog.Info("Schema loaded from registry", "subject", subject, "version", next.version, "module", "converter", "function", "keySchema")
+	c.keySchemas[subject] = next
+
+	return next
+}
+
+func (c *Converter) keepSchema(subject string, schema *dynamicSchema) *dynamicSchema {
+	if schema == nil {
+		schema = &dynamicSchema{}
+	}
+
+	schema.checked = time.Now()
+	c.keySchemas[subject] = schema
+
+	return schema.orNil()
+}
+
+// orNil returns nil for the cache entries of keys without schema on the registry.
+func (s *dynamicSchema) orNil() *dynamicSchema {
+	if s == nil || s.node == nil {
+		return nil
+	}
+
+	return s
+}
+
+// checkCompatible allows a new schema version to add optional fields, remove optional fields, make required fields
+// optional and widen int64 to double. Other changes would break files already written with the previous version.
+func checkCompatible(path string, previous *inferNode, next *inferNode) error {
+	if previous.optional && !next.optional {
+		return fmt.Errorf("field %s became required", pathName(path))
+	}
+
+	if previous.kind != next.kind && !(previous.kind == inferInt && next.kind == inferDouble) {
+		return fmt.Errorf("field %s changed from %s to %s", pathName(path), inferKindNames[previous.kind], inferKindNames[next.kind])
+	}
+
+	switch next.kind {
+	case inferList:
+		return checkCompatible(path+"/element", previous.elem, next.elem)
+	case inferObject:
+		for in, f := range previous.fields {
+			n, ok := next.fields[in]
+
+			if !ok {
+				if !f.optional {
+					return fmt.Errorf("required field %s/%s was removed", path, f.name)
+				}
+
+				continue
+			}
+
+			if err := checkCompatible(path+"/"+f.name, f, n); err != nil {
+				return err
+			}
+		}
+
+		for in, f := range next.fields {
+			if _, ok := previous.fields[in]; !ok && !f.optional {
+				return fmt.Errorf("new field %s/%s must be optional", path, f.name)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,181 @@
+package converter
+
+import (
+	"data2parquet/pkg/config"
+	"data2parquet/pkg/domain"
+	"io"
+
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// Stream converts the records of a key as they are written, row groups go to the destination when they reach
+// WriterRowGroupSize, so a batch is never held in memory. Dynamic records with an inferred schema are held until
+// DynamicSchemaSampleSize records are written, the schema is inferred from them.
+type Stream struct {
+	c       *Converter
+	key     string
+	dest    *streamWriter
+	pw      *writer.ParquetWriter
+	schema  *dynamicSchema
+	pending []domain.Record
+	result  []*Result
+	err     error
+	rows    int
+}
+
+// streamWriter keeps the first error of the destination, records can not be written after it.
+type streamWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+
+	return n, err
+}
+
+func (c *Converter) NewStream(key string, w io.Writer) *Stream {
+	return &Stream{
+		c:      c,
+		key:    key,
+		dest:   &streamWriter{w: w},
+		result: make([]*Result, 0),
+	}
+}
+
+// Write converts a record, records that can not be converted are returned by Close. It returns an error when the
+// stream can not go on, as when the destination fails.
+func (s *Stream) Write(record domain.Record) error {
+	if s.err != nil {
+		return s.err
+	}
+
+	if s.pw == nil {
+		s.pending = append(s.pending, record)
+
+		if s.c.recordType == config.RecordTypeDynamic && s.c.inferSchema && len(s.pending) < s.c.sampleSize {
+			return nil
+		}
+
+		return s.open()
+	}
+
+	return s.write(record)
+}
+
+// open creates the parquet writer and writes the pending records, they are the sample of inferred schemas.
+func (s *Stream) open() error {
+	var err error
+
+	if s.c.recordType == config.RecordTypeDynamic {
+		s.schema, err = s.c.batchSchema(s.key, s.pending)
+
+		if err != nil {
+			slog.Error("Error getting dynamic schema", "error", err, "module", "converter", "function", "open", "key", s.key)
+			return s.fail(err)
+		}
+	}
+
+	s.pw, err = s.c.createParquetWriter(s.dest, s.schema)
+
+	if err != nil {
+		slog.Error("Error creating parquet writer", "error", err, "module", "converter", "function", "open", "key", s.key)
+		return s.fail(err)
+	}
+
+	pending := s.pending
+	s.pending = nil
+
+	for _, record := range pending {
+		if err = s.write(record); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Stream) write(record domain.Record) error {
+	var row interface{} = record
+	var err error
+
+	if s.schema != nil && s.schema.node != nil {
+		if row, err = conformRecord(record, s.schema.node); err != nil {
+			slog.Warn("Record does not match the schema", "error", err, "module", "converter", "function", "write", "key", s.key)
+			s.result = append(s.result, &Result{Key: s.key, Error: err, Record: record})
+			return nil
+		}
+	}
+
+	if err = s.pw.Write(row); err != nil {
+		if s.dest.err != nil {
+			slog.Error("Error writing parquet row group", "error", s.dest.err, "module", "converter", "function", "write", "key", s.key)
+			return s.fail(s.dest.err)
+		}
+
+		slog.Error("Error writing parquet file", "error", err, "module", "converter", "function", "write", "key", s.key, "record", record.ToJson())
+		s.result = append(s.result, &Result{Key: s.key, Error: err, Record: record})
+		return nil
+	}
+
+	s.rows++
+
+	return nil
+}
+
+func (s *Stream) fail(err error) error {
+	if s.err == nil {
+		s.err = err
+	}
+
+	return s.err
+}
+
+// Err returns the error that stopped the stream, the destination must not keep its data.
+func (s *Stream) Err() error {
+	return s.err
+}
+
+// DestinationErr returns the error of the destination when it stopped the stream, any other error of Err is a
+// conversion one.
+func (s *Stream) DestinationErr() error {
+	return s.dest.err
+}
+
+// Rows returns how many records were written to the file.
+func (s *Stream) Rows() int {
+	return s.rows
+}
+
+// Close writes the parquet footer and returns the records that could not be converted, with a result without
+// record when the stream failed.
+func (s *Stream) Close() []*Result {
+	if s.err == nil && s.pw == nil && len(s.pending) > 0 {
+		s.open()
+	}
+
+	if s.err == nil && s.pw != nil {
+		slog.Debug("Stopping parquet writer", "module", "converter", "function", "Close", "key", s.key, "rows", s.rows)
+
+		if err := s.pw.WriteStop(); err != nil {
+			slog.Error("Error to try stop parquet writer", "error", err, "module", "converter", "function", "Close", "key", s.key)
+			s.fail(err)
+		}
+
+		s.pw.PFile.Close()
+	}
+
+	if s.err != nil {
+		s.result = append(s.result, &Result{Key: s.key, Error: s.err})
+		return s.result
+	}
+
+	slog.Debug("Parquet file written", "key", s.key, "rows", s.rows, "module", "converter", "function", "Close")
+
+	return s.result
+}
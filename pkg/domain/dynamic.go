@@ -8,8 +8,9 @@ import (
 )
 
 type Dynamic struct {
-	Data map[string]interface{} `msg:"data" json:"data"`
-	Info *DynamicInfo           `msg:"info" json:"info,omitempty"`
+	Data  map[string]interface{} `msg:"data" json:"data"`
+	Info  *DynamicInfo           `msg:"info" json:"info,omitempty"`
+	Error string                 `msg:"error" json:"error,omitempty"`
 }
 
 func NewDynamic(data map[string]interface{}) Record {
@@ -23,30 +24,14 @@ func NewDynamic(data map[string]interface{}) Record {
 	return ret
 }
 
+// UpdateInfo builds the key of the record from the DynamicKeyFields.
 func (d *Dynamic) UpdateInfo() {
-	d.Info = &DynamicInfo{}
+	fields := dynamicKeyFields()
+	d.Info = &DynamicInfo{Values: make([]string, 0, len(fields))}
 
-	d.Info.DynamicService = "dynamic_service"
-	if v, ok := d.Data["service"]; ok {
-		d.Info.DynamicService = fmt.Sprintf("%s", v)
+	for _, f := range fields {
+		d.Info.Values = append(d.Info.Values, f.Value(d.Data))
 	}
-
-	d.Info.DynamicDomain = "dynamic_domain"
-	if v, ok := d.Data["domain"]; ok {
-		d.Info.DynamicDomain = fmt.Sprintf("%s", v)
-	}
-
-	d.Info.DynamicCapability = "dynamic_capability"
-	if v, ok := d.Data["capability"]; ok {
-		d.Info.DynamicCapability = fmt.Sprintf("%s", v)
-	}
-
-	d.Info.DynamicApplication = "dynamic_application"
-	if v, ok := d.Data["application"]; ok {
-		d.Info.DynamicApplication = fmt.Sprintf("%s", v)
-	}
-
-	d.Info.makeKey()
 }
 
 func (d *Dynamic) GetData() map[string]interface{} {
@@ -64,6 +49,10 @@ func (d *Dynamic) GetInfo() RecordInfo {
 }
 
 func (d *Dynamic) Key() string {
+	if d.Info == nil || len(d.Info.Values) == 0 {
+		d.UpdateInfo()
+	}
+
 	return d.Info.Key()
 }
 
@@ -8,27 +8,26 @@ import (
 	"data2parquet/pkg/config"
 )
 
+// DynamicInfo holds the values of the DynamicKeyFields of a dynamic record. Its keys start with the record type, as
+// `dynamic:<value>:<value>`, and the first four values are its capability, domain, service and application.
 type DynamicInfo struct {
-	DynamicService     string `msg:"service" json:"service,omitempty"`
-	DynamicDomain      string `msg:"domain" json:"domain,omitempty"`
-	DynamicCapability  string `msg:"capability" json:"capability,omitempty"`
-	DynamicApplication string `msg:"application" json:"application,omitempty"`
-	key                string
+	Values    []string `msg:"values" json:"values,omitempty"`
+	partition *time.Time
+	fields    map[string]string
 }
 
 func NewDynamicInfoFromKey(key string) RecordInfo {
+	key, partition, fields := parsePartition(key, strings.Split(key, KeySeparator), 2)
 	values := strings.Split(key, KeySeparator)
 
-	for len(values) < 4 {
-		values = append(values, "unkown")
+	if len(values) > 0 && values[0] == config.RecordTypeDynamic {
+		values = values[1:]
 	}
 
 	ret := &DynamicInfo{
-		DynamicCapability:  values[0],
-		DynamicDomain:      values[1],
-		DynamicService:     values[2],
-		DynamicApplication: values[3],
-		key:                key,
+		Values:    values,
+		partition: partition,
+		fields:    fields,
 	}
 
 	return ret
@@ -38,34 +37,47 @@ func (i *DynamicInfo) RecordType() string {
 	return config.RecordTypeDynamic
 }
 
+func (i *DynamicInfo) value(index int) string {
+	if index >= len(i.Values) {
+		return "unknown"
+	}
+
+	return i.Values[index]
+}
+
 func (i *DynamicInfo) Capability() string {
-	return i.DynamicCapability
+	return i.value(0)
 }
 
 func (i *DynamicInfo) Domain() string {
-	return i.DynamicDomain
+	return i.value(1)
 }
 
 func (i *DynamicInfo) Service() string {
-	return i.DynamicService
+	return i.value(2)
 }
 
 func (i *DynamicInfo) Application() string {
-	return i.DynamicApplication
+	return i.value(3)
 }
 
 func (i *DynamicInfo) Key() string {
-	return fmt.Sprintf("%s%s%s%s%s%s%s", i.Capability(), KeySeparator, i.Domain(), KeySeparator, i.Service(), KeySeparator, i.Application())
+	return config.RecordTypeDynamic + KeySeparator + strings.Join(i.Values, KeySeparator)
 }
 
 func (i *DynamicInfo) Target(id string, hash string) string {
-	tm := time.Now()
+	tm := i.Partition()
 	year, month, day := tm.Date()
 	hour, _, _ := tm.Clock()
 
-	return fmt.Sprintf("%s/year=%04d/month=%02d/day=%02d/hour=%02d/%s-%s-%s.parquet", i.Capability(), year, month, day, hour, id, i.Key(), hash)
+	return fmt.Sprintf("%s/year=%04d/month=%02d/day=%02d/hour=%02d/%s-%s-%s.parquet", i.Capability(), year, month, day, hour, id, strings.Join(i.Values, KeySeparator), hash)
+}
+
+// Partition returns the event hour of a partition key, or the current time for plain record keys.
+func (i *DynamicInfo) Partition() time.Time {
+	return partitionTime(i.partition)
 }
 
-func (i *DynamicInfo) makeKey() {
-	i.key = fmt.Sprintf("%s%s%s%s%s%s%s", i.Capability(), KeySeparator, i.Domain(), KeySeparator, i.Service(), KeySeparator, i.Application())
+func (i *DynamicInfo) Fields() map[string]string {
+	return i.fields
 }
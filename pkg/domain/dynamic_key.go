@@ -0,0 +1,290 @@
+package domain
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"data2parquet/pkg/config"
+)
+
+// KeyField is a JSONPath like expression of a field used on the key of dynamic records, as `$.resource.service`,
+// `labels['app.name']` or `tags[0]`, with an optional default value after `|` for missing or empty fields.
+type KeyField struct {
+	Expr    string
+	Default string
+	path    []keyStep
+}
+
+type keyStep struct {
+	name  string
+	index int
+	list  bool
+}
+
+var dynamicKey = struct {
+	sync.Mutex
+	exprs  string
+	fields []*KeyField
+}{}
+
+// ParseKeyFields parses a comma separated list of key field expressions.
+func ParseKeyFields(exprs string) ([]*KeyField, error) {
+	ret := make([]*KeyField, 0)
+
+	for _, expr := range splitOutside(exprs, ',') {
+		field, err := ParseKeyField(expr)
+
+		if err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, field)
+	}
+
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("no key fields in %q", exprs)
+	}
+
+	return ret, nil
+}
+
+func ParseKeyField(expr string) (*KeyField, error) {
+	expr = strings.TrimSpace(expr)
+	ret := &KeyField{Expr: expr, Default: "unknown"}
+
+	parts := splitOutside(expr, '|')
+	path := strings.TrimSpace(parts[0])
+
+	if len(parts) > 1 {
+		ret.Default = strings.TrimSpace(strings.Join(parts[1:], "|"))
+	}
+
+	if strings.HasPrefix(path, "$") {
+		path = path[1:]
+	} else if len(path) > 0 && path[0] != '[' {
+		path = "." + path
+	}
+
+	for len(path) > 0 {
+		var step keyStep
+		var err error
+
+		switch path[0] {
+		case '.':
+			end := strings.IndexAny(path[1:], ".[")
+			if end < 0 {
+				end = len(path) - 1
+			}
+
+			step.name = path[1 : end+1]
+			path = path[end+1:]
+
+			if len(step.name) == 0 {
+				return nil, fmt.Errorf("empty field name on key field %q", expr)
+			}
+		case '[':
+			step, path, err = parseBracket(path)
+
+			if err != nil {
+				return nil, fmt.Errorf("%w on key field %q", err, expr)
+			}
+		default:
+			return nil, fmt.Errorf("unexpected %q on key field %q", path[0], expr)
+		}
+
+		ret.path = append(ret.path, step)
+	}
+
+	if len(ret.path) == 0 {
+		return nil, fmt.Errorf("key field %q has no path", expr)
+	}
+
+	return ret, nil
+}
+
+// parseBracket reads a `['name']`, `["name"]` or `[index]` step, returning the rest of the path.
+func parseBracket(path string) (keyStep, string, error) {
+	if len(path) > 1 && (path[1] == '\'' || path[1] == '"') {
+		quote := path[1]
+		sb := strings.Builder{}
+
+		for i := 2; i < len(path); i++ {
+			switch {
+			case path[i] == '\\' && i+1 < len(path):
+				i++
+				sb.WriteByte(path[i])
+			case path[i] == quote:
+				if i+1 >= len(path) || path[i+1] != ']' {
+					return keyStep{}, "", fmt.Errorf("missing ] after quoted name")
+				}
+				return keyStep{name: sb.String()}, path[i+2:], nil
+			default:
+				sb.WriteByte(path[i])
+			}
+		}
+
+		return keyStep{}, "", fmt.Errorf("unterminated quoted name")
+	}
+
+	end := strings.IndexByte(path, ']')
+
+	if end < 0 {
+		return keyStep{}, "", fmt.Errorf("missing ]")
+	}
+
+	index, err := strconv.Atoi(strings.TrimSpace(path[1:end]))
+
+	if err != nil {
+		return keyStep{}, "", fmt.Errorf("invalid index %q", path[1:end])
+	}
+
+	return keyStep{index: index, list: true}, path[end+1:], nil
+}
+
+// splitOutside splits s on sep, except inside brackets and quotes.
+func splitOutside(s string, sep byte) []string {
+	ret := make([]string, 0)
+	depth := 0
+	var quote byte
+	start := 0
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case c == sep && depth <= 0:
+			ret = append(ret, s[start:i])
+			start = i + 1
+		}
+	}
+
+	ret = append(ret, s[start:])
+
+	if sep == ',' {
+		items := ret[:0]
+		for _, item := range ret {
+			if len(strings.TrimSpace(item)) > 0 {
+				items = append(items, item)
+			}
+		}
+		ret = items
+	}
+
+	return ret
+}
+
+// Value returns the field value as a key part, or the default value when it is missing or empty.
+func (f *KeyField) Value(data map[string]interface{}) string {
+	var cur interface{} = data
+
+	for _, step := range f.path {
+		cur = step.get(cur)
+
+		if cur == nil {
+			break
+		}
+	}
+
+	ret := keyValue(cur)
+
+	if len(ret) == 0 {
+		ret = f.Default
+	}
+
+	return strings.ReplaceAll(ret, KeySeparator, "_")
+}
+
+func (s keyStep) get(value interface{}) interface{} {
+	if s.list {
+		list, ok := value.([]interface{})
+
+		if !ok {
+			return nil
+		}
+
+		index := s.index
+		if index < 0 {
+			index += len(list)
+		}
+
+		if index < 0 || index >= len(list) {
+			return nil
+		}
+
+		return list[index]
+	}
+
+	switch m := value.(type) {
+	case map[string]interface{}:
+		return m[s.name]
+	case map[interface{}]interface{}:
+		if v, ok := m[s.name]; ok {
+			return v
+		}
+		for k, v := range m {
+			if keyValue(k) == s.name {
+				return v
+			}
+		}
+	}
+
+	return nil
+}
+
+func keyValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case *string:
+		if v == nil {
+			return ""
+		}
+		return *v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// dynamicKeyFields returns the parsed DynamicKeyFields, invalid expressions are logged and the default key is used.
+func dynamicKeyFields() []*KeyField {
+	dynamicKey.Lock()
+	defer dynamicKey.Unlock()
+
+	if dynamicKey.fields != nil && dynamicKey.exprs == config.DynamicKeyFields {
+		return dynamicKey.fields
+	}
+
+	fields, err := ParseKeyFields(config.DynamicKeyFields)
+
+	if err != nil {
+		slog.Error("Error parsing dynamic key fields, using default", "error", err, "fields", config.DynamicKeyFields, "module", "domain", "function", "dynamicKeyFields")
+		fields, _ = ParseKeyFields(config.DefaultDynamicKeyFields)
+	}
+
+	dynamicKey.exprs = config.DynamicKeyFields
+	dynamicKey.fields = fields
+
+	return fields
+}
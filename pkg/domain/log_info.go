@@ -14,10 +14,13 @@ type LogInfo struct {
 	BusinessService    string `msg:"business-service" json:"business-service,omitempty"`
 	ApplicationService string `msg:"application-service" json:"application-service,omitempty"`
 	key                string
+	partition          *time.Time
+	fields             map[string]string
 }
 
 func NewLogInfoFromKey(key string) RecordInfo {
 	values := strings.Split(key, KeySeparator)
+	key, partition, fields := parsePartition(key, values, 4)
 
 	for len(values) < 4 {
 		values = append(values, "unkown")
@@ -29,6 +32,8 @@ func NewLogInfoFromKey(key string) RecordInfo {
 		BusinessService:    values[2],
 		ApplicationService: values[3],
 		key:                key,
+		partition:          partition,
+		fields:             fields,
 	}
 
 	return ret
@@ -59,13 +64,22 @@ func (i *LogInfo) Key() string {
 }
 
 func (i *LogInfo) Target(id string, hash string) string {
-	tm := time.Now()
+	tm := i.Partition()
 	year, month, day := tm.Date()
 	hour, _, _ := tm.Clock()
 
 	return fmt.Sprintf("capability=%s/year=%04d/month=%02d/day=%02d/hour=%02d/%s-%s%s.parquet", i.Capability(), year, month, day, hour, id, i.Key(), hash)
 }
 
+// Partition returns the event hour of a partition key, or the current time for plain record keys.
+func (i *LogInfo) Partition() time.Time {
+	return partitionTime(i.partition)
+}
+
+func (i *LogInfo) Fields() map[string]string {
+	return i.fields
+}
+
 func (i *LogInfo) makeKey() {
 	i.key = fmt.Sprintf("%s%s%s%s%s%s%s", i.Capability(), KeySeparator, i.Domain(), KeySeparator, i.Service(), KeySeparator, i.Application())
 }
@@ -0,0 +1,175 @@
+package domain
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	msgp "github.com/vmihailenco/msgpack/v5"
+
+	"data2parquet/pkg/config"
+)
+
+// LogLegacy is the previous generation of the log schema (see etc/log-schema.json), with snake_case columns,
+// every value as text and business process and step in place of service and application.
+type LogLegacy struct {
+	info               *LogLegacyInfo `json:"-"`
+	Time               string         `json:"time" parquet:"name=time, type=BYTE_ARRAY, convertedtype=UTF8" msg:"time"`
+	Level              string         `json:"level" parquet:"name=level, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY" msg:"level"`
+	Logger             string         `json:"logger" parquet:"name=logger, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY" msg:"logger"`
+	ThreadName         string         `json:"thread_name" parquet:"name=thread_name, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY" msg:"thread_name"`
+	Message            string         `json:"message" parquet:"name=message, type=BYTE_ARRAY, convertedtype=UTF8" msg:"message"`
+	BusinessCapability string         `json:"business_capability" parquet:"name=business_capability, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY" msg:"business_capability"`
+	BusinessDomain     string         `json:"business_domain" parquet:"name=business_domain, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY" msg:"business_domain"`
+	BusinessProcess    string         `json:"business_process" parquet:"name=business_process, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY" msg:"business_process"`
+	BusinessStep       string         `json:"business_step" parquet:"name=business_step, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY" msg:"business_step"`
+	CorrelationId      string         `json:"correlation_id" parquet:"name=correlation_id, type=BYTE_ARRAY, convertedtype=UTF8" msg:"correlation_id"`
+}
+
+func NewLogLegacy(data map[string]interface{}) Record {
+	ret := &LogLegacy{
+		Level: LevelInfo,
+	}
+
+	ret.Decode(data)
+
+	return ret
+}
+
+func (l *LogLegacy) UpdateInfo() {
+	ret := &LogLegacyInfo{
+		BusinessCapability: l.BusinessCapability,
+		BusinessDomain:     l.BusinessDomain,
+		BusinessProcess:    l.BusinessProcess,
+		BusinessStep:       l.BusinessStep,
+	}
+
+	ret.makeKey()
+	l.info = ret
+}
+
+func (l *LogLegacy) GetData() map[string]interface{} {
+	ret := make(map[string]interface{})
+
+	ret["time"] = l.Time
+	ret["level"] = l.Level
+	ret["logger"] = l.Logger
+	ret["thread_name"] = l.ThreadName
+	ret["message"] = l.Message
+	ret["business_capability"] = l.BusinessCapability
+	ret["business_domain"] = l.BusinessDomain
+	ret["business_process"] = l.BusinessProcess
+	ret["business_step"] = l.BusinessStep
+	ret["correlation_id"] = l.CorrelationId
+
+	return ret
+}
+
+// Decode accepts the legacy snake_case names and the current dash ones, other fields are not part of the legacy schema.
+func (l *LogLegacy) Decode(data map[string]interface{}) {
+	for k, v := range data {
+		key := strings.ReplaceAll(strings.ToLower(fmt.Sprintf("%v", k)), "_", "-")
+
+		if len(key) == 0 {
+			continue
+		}
+
+		if _, ignore := config.IgnoredFields[key]; ignore {
+			continue
+		}
+
+		if _, ignore := config.MaskFields[key]; ignore {
+			v = "*"
+		}
+
+		switch key {
+		case "time", "timestamp", "when":
+			l.Time = *GetStringP(v)
+		case "level", "lvl":
+			l.Level = *GetStringP(v)
+		case "logger", "logger-name":
+			l.Logger = *GetStringP(v)
+		case "thread-name", "thread":
+			l.ThreadName = *GetStringP(v)
+		case "message", "msg", "log":
+			l.Message = *GetStringP(v)
+		case "business-capability":
+			l.BusinessCapability = *GetStringP(v)
+		case "business-domain":
+			l.BusinessDomain = *GetStringP(v)
+		case "business-process", "business-service":
+			l.BusinessProcess = *GetStringP(v)
+		case "business-step", "application-service":
+			l.BusinessStep = *GetStringP(v)
+		case "correlation-id":
+			l.CorrelationId = *GetStringP(v)
+		default:
+			slog.Debug("Field is not part of the legacy log schema, skipping", "field", key)
+		}
+	}
+
+	l.UpdateInfo()
+}
+
+func (l *LogLegacy) GetInfo() RecordInfo {
+	if l.info == nil {
+		l.UpdateInfo()
+	}
+	return l.info
+}
+
+func (l *LogLegacy) Key() string {
+	return l.GetInfo().Key()
+}
+
+func (l *LogLegacy) ToString() string {
+	return fmt.Sprintf("%+v", l)
+}
+
+func (l *LogLegacy) ToJson() string {
+	data, err := json.Marshal(l)
+
+	if err != nil {
+		slog.Error("Error marshalling JSON", "error", err)
+		return ""
+	}
+
+	return string(data)
+}
+
+func (l *LogLegacy) FromJson(data string) error {
+	err := json.Unmarshal([]byte(data), l)
+
+	if err != nil {
+		slog.Error("Error unmarshalling JSON", "error", err)
+		return err
+	}
+
+	l.UpdateInfo()
+
+	return nil
+}
+
+func (l *LogLegacy) ToMsgPack() []byte {
+	data, err := msgp.Marshal(l)
+
+	if err != nil {
+		slog.Error("Error marshalling MsgPack", "error", err)
+		return nil
+	}
+
+	return data
+}
+
+func (l *LogLegacy) FromMsgPack(data []byte) error {
+	err := msgp.Unmarshal(data, l)
+
+	if err != nil {
+		slog.Error("Error unmarshalling MsgPack", "error", err)
+		return err
+	}
+
+	l.UpdateInfo()
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"data2parquet/pkg/config"
+)
+
+type LogLegacyInfo struct {
+	BusinessCapability string `msg:"business_capability" json:"business_capability,omitempty"`
+	BusinessDomain     string `msg:"business_domain" json:"business_domain,omitempty"`
+	BusinessProcess    string `msg:"business_process" json:"business_process,omitempty"`
+	BusinessStep       string `msg:"business_step" json:"business_step,omitempty"`
+	key                string
+	partition          *time.Time
+	fields             map[string]string
+}
+
+func NewLogLegacyInfoFromKey(key string) RecordInfo {
+	values := strings.Split(key, KeySeparator)
+	key, partition, fields := parsePartition(key, values, 4)
+
+	for len(values) < 4 {
+		values = append(values, "unkown")
+	}
+
+	ret := &LogLegacyInfo{
+		BusinessCapability: values[0],
+		BusinessDomain:     values[1],
+		BusinessProcess:    values[2],
+		BusinessStep:       values[3],
+		key:                key,
+		partition:          partition,
+		fields:             fields,
+	}
+
+	return ret
+}
+
+func (i *LogLegacyInfo) RecordType() string {
+	return config.RecordTypeLogLegacy
+}
+
+func (i *LogLegacyInfo) Capability() string {
+	return i.BusinessCapability
+}
+
+func (i *LogLegacyInfo) Domain() string {
+	return i.BusinessDomain
+}
+
+// Service returns the business process, the legacy schema has no service field.
+func (i *LogLegacyInfo) Service() string {
+	return i.BusinessProcess
+}
+
+// Application returns the business step, the legacy schema has no application field.
+func (i *LogLegacyInfo) Application() string {
+	return i.BusinessStep
+}
+
+func (i *LogLegacyInfo) Key() string {
+	return i.key
+}
+
+// Target keeps legacy files under their own root, so both schemas can be written to the same bucket during migrations.
+func (i *LogLegacyInfo) Target(id string, hash string) string {
+	tm := i.Partition()
+	year, month, day := tm.Date()
+	hour, _, _ := tm.Clock()
+
+	return fmt.Sprintf("%s/capability=%s/year=%04d/month=%02d/day=%02d/hour=%02d/%s-%s%s.parquet", i.RecordType(), i.Capability(), year, month, day, hour, id, i.Key(), hash)
+}
+
+func (i *LogLegacyInfo) Partition() time.Time {
+	return partitionTime(i.partition)
+}
+
+func (i *LogLegacyInfo) Fields() map[string]string {
+	return i.fields
+}
+
+func (i *LogLegacyInfo) makeKey() {
+	i.key = fmt.Sprintf("%s%s%s%s%s%s%s", i.Capability(), KeySeparator, i.Domain(), KeySeparator, i.Service(), KeySeparator, i.Application())
+}
@@ -0,0 +1,97 @@
+package domain
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"data2parquet/pkg/config"
+)
+
+// Log fields with a `version` tag only belong to that schema version, so the same column name can change its type
+// between versions. LogSchema builds the parquet schema object of a version, keeping only its fields, records are
+// written as *Log because the parquet writer ignores struct fields that are not on the schema.
+var logSchemas = make(map[int]interface{})
+var logSchemasMu sync.Mutex
+
+func LogSchema(version int) interface{} {
+	if _, ok := config.LogSchemaVersions[version]; !ok {
+		version = config.LogSchemaVersionCurrent
+	}
+
+	logSchemasMu.Lock()
+	defer logSchemasMu.Unlock()
+
+	if ret, ok := logSchemas[version]; ok {
+		return ret
+	}
+
+	tp := reflect.TypeOf(Log{})
+	fields := make([]reflect.StructField, 0, tp.NumField())
+
+	for i := 0; i < tp.NumField(); i++ {
+		f := tp.Field(i)
+
+		if !f.IsExported() || len(f.Tag.Get("parquet")) == 0 {
+			continue
+		}
+
+		if v, ok := f.Tag.Lookup("version"); ok && v != fmt.Sprint(version) {
+			continue
+		}
+
+		fields = append(fields, f)
+	}
+
+	ret := reflect.New(reflect.StructOf(fields)).Interface()
+	logSchemas[version] = ret
+
+	return ret
+}
+
+// ParseDuration reads a duration as nanoseconds, from Go duration strings as `1.2s` or numbers in milliseconds.
+func ParseDuration(v *string) *int64 {
+	if v == nil {
+		return nil
+	}
+
+	val := strings.TrimSpace(*v)
+
+	if len(val) == 0 {
+		return nil
+	}
+
+	if ms, err := strconv.ParseFloat(val, 64); err == nil {
+		ret := int64(math.Round(ms * float64(time.Millisecond)))
+		return &ret
+	}
+
+	d, err := time.ParseDuration(val)
+
+	if err != nil {
+		slog.Debug("Error parsing duration", "duration", val, "error", err)
+		return nil
+	}
+
+	ret := int64(d)
+	return &ret
+}
+
+func ParseHTTPStatus(v *string) *int32 {
+	if v == nil {
+		return nil
+	}
+
+	val, err := strconv.ParseFloat(strings.TrimSpace(*v), 64)
+
+	if err != nil || val < 0 || val > math.MaxInt32 {
+		return nil
+	}
+
+	ret := int32(val)
+	return &ret
+}
@@ -0,0 +1,110 @@
+package domain_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"data2parquet/pkg/config"
+	"data2parquet/pkg/domain"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := map[string]*int64{
+		"1500":   int64P(int64(1500 * time.Millisecond)),
+		"0.25":   int64P(int64(250 * time.Microsecond)),
+		"1.5s":   int64P(int64(1500 * time.Millisecond)),
+		"2m3s":   int64P(int64(2*time.Minute + 3*time.Second)),
+		" 10ms ": int64P(int64(10 * time.Millisecond)),
+		"":       nil,
+		"soon":   nil,
+	}
+
+	for value, want := range tests {
+		v := value
+		got := domain.ParseDuration(&v)
+
+		if (got == nil) != (want == nil) || (got != nil && *got != *want) {
+			t.Errorf("ParseDuration(%q) = %v, expected %v", value, deref(got), deref(want))
+		}
+	}
+
+	if domain.ParseDuration(nil) != nil {
+		t.Error("ParseDuration(nil) must be nil")
+	}
+}
+
+func TestParseHTTPStatus(t *testing.T) {
+	tests := map[string]*int32{
+		"200":   int32P(200),
+		" 404 ": int32P(404),
+		"503.0": int32P(503),
+		"-1":    nil,
+		"OK":    nil,
+		"":      nil,
+	}
+
+	for value, want := range tests {
+		v := value
+		got := domain.ParseHTTPStatus(&v)
+
+		if (got == nil) != (want == nil) || (got != nil && *got != *want) {
+			t.Errorf("ParseHTTPStatus(%q) = %v, expected %v", value, deref(got), deref(want))
+		}
+	}
+}
+
+func TestLogSchema(t *testing.T) {
+	expected := map[int]map[string]string{
+		config.LogSchemaVersionLegacy: {"duration": "BYTE_ARRAY", "http-response": "BYTE_ARRAY"},
+		config.LogSchemaVersionTyped:  {"duration": "INT64", "http-response": "INT32"},
+	}
+
+	for version, types := range expected {
+		schema := reflect.TypeOf(domain.LogSchema(version)).Elem()
+		columns := make(map[string]int)
+
+		for i := 0; i < schema.NumField(); i++ {
+			tag := schema.Field(i).Tag.Get("parquet")
+			name := strings.TrimPrefix(strings.Split(tag, ",")[0], "name=")
+			columns[name]++
+
+			if want, ok := types[name]; ok && !strings.Contains(tag, "type="+want+",") {
+				t.Errorf("Column %s of schema version %d is %q, expected type %s", name, version, tag, want)
+			}
+		}
+
+		for name, count := range columns {
+			if count > 1 {
+				t.Errorf("Column %s is %d times on schema version %d", name, count, version)
+			}
+		}
+
+		for name := range types {
+			if columns[name] != 1 {
+				t.Errorf("Column %s is missing on schema version %d", name, version)
+			}
+		}
+	}
+
+	if domain.LogSchema(99) != domain.LogSchema(config.LogSchemaVersionCurrent) {
+		t.Error("Unknown schema versions must use the current one")
+	}
+}
+
+func int64P(v int64) *int64 {
+	return &v
+}
+
+func int32P(v int32) *int32 {
+	return &v
+}
+
+func deref[T any](v *T) any {
+	if v == nil {
+		return nil
+	}
+
+	return *v
+}
@@ -4,7 +4,11 @@ import (
 	"crypto/md5"
 	"encoding/hex"
 	"fmt"
+	"math"
 	"math/rand"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -18,6 +22,9 @@ var slog = logger.GetLogger()
 
 const KeySeparator = ":"
 
+// PartitionTimeFormat is the layout of the event hour appended to a record key by PartitionKey.
+const PartitionTimeFormat = "2006-01-02T15"
+
 type Record interface {
 	GetInfo() RecordInfo
 	Decode(data map[string]interface{})
@@ -37,15 +44,171 @@ type RecordInfo interface {
 	Service() string
 	Domain() string
 	Capability() string
+	Application() string
 	Target(id string, hash string) string
+	Partition() time.Time
+	Fields() map[string]string
 }
 
 func NewRecordInfoFromKey(recordType string, key string) RecordInfo {
-	if strings.Contains(key, config.RecordTypeDynamic) {
+	switch strings.ToLower(recordType) {
+	case config.RecordTypeLogLegacy:
+		return NewLogLegacyInfoFromKey(key)
+	case config.RecordTypeDynamic:
 		return NewDynamicInfoFromKey(key)
+	default:
+		return NewLogInfoFromKey(key)
+	}
+}
+
+// PartitionKey appends the event hour and the values of path template fields to a record key,
+// writers use them to build the target path of the partition.
+func PartitionKey(key string, tm time.Time, fields map[string]string) string {
+	ret := key + KeySeparator + tm.Format(PartitionTimeFormat)
+
+	if len(fields) > 0 {
+		values := url.Values{}
+		for k, v := range fields {
+			values.Set(k, v)
+		}
+		ret += KeySeparator + values.Encode()
 	}
 
-	return NewLogInfoFromKey(key)
+	return ret
+}
+
+// parsePartition splits a key created by PartitionKey, returning the record key, its event hour and field values.
+// Record keys have at least size values, keys without a valid event hour are returned as they are, with a nil partition.
+func parsePartition(key string, values []string, size int) (string, *time.Time, map[string]string) {
+	fields := make(map[string]string)
+
+	if len(values) > size+1 {
+		_, err := time.Parse(PartitionTimeFormat, values[len(values)-2])
+		query, qerr := url.ParseQuery(values[len(values)-1])
+
+		if err == nil && qerr == nil && len(query) > 0 {
+			for k := range query {
+				fields[k] = query.Get(k)
+			}
+			values = values[:len(values)-1]
+		}
+	}
+
+	if len(values) < size+1 {
+		return key, nil, fields
+	}
+
+	tm, err := time.Parse(PartitionTimeFormat, values[len(values)-1])
+
+	if err != nil {
+		return key, nil, fields
+	}
+
+	return strings.Join(values[:len(values)-1], KeySeparator), &tm, fields
+}
+
+func partitionTime(partition *time.Time) time.Time {
+	if partition == nil {
+		return time.Now().UTC()
+	}
+
+	return *partition
+}
+
+func PartitionLocation(tz string) *time.Location {
+	switch strings.ToLower(tz) {
+	case "", "utc":
+		return time.UTC
+	case "local":
+		return time.Local
+	}
+
+	loc, err := time.LoadLocation(tz)
+
+	if err != nil {
+		slog.Error("Error loading partition timezone, using UTC", "error", err, "timezone", tz)
+		return time.UTC
+	}
+
+	return loc
+}
+
+// RecordTime returns the event time of a record, dynamic records read it from field. The fallback is returned when
+// the record has no time or it can not be parsed.
+func RecordTime(record Record, field string, fallback time.Time) time.Time {
+	switch r := record.(type) {
+	case *Log:
+		return parseRecordTime(r.Time, fallback)
+	case *LogLegacy:
+		return parseRecordTime(r.Time, fallback)
+	case *Dynamic:
+		if len(field) == 0 {
+			field = "time"
+		}
+		return parseRecordTime(r.Data[field], fallback)
+	default:
+		return fallback
+	}
+}
+
+// RecordField returns the value of a record field as text, log records also look for it on extra fields.
+func RecordField(record Record, name string) string {
+	v, ok := record.GetData()[name]
+
+	if !ok {
+		if l, isLog := record.(*Log); isLog {
+			return l.ExtraFields[name]
+		}
+		return ""
+	}
+
+	switch t := v.(type) {
+	case *string:
+		if t == nil {
+			return ""
+		}
+		return *t
+	case *bool:
+		if t == nil {
+			return ""
+		}
+		return fmt.Sprint(*t)
+	}
+
+	return *GetStringP(v)
+}
+
+// RecordPartition returns the partition key of a record, by its event hour on loc and the values of fields. Records
+// without a valid time go to the hour of fallback.
+func RecordPartition(key string, record Record, timeField string, loc *time.Location, fields []string, fallback time.Time) string {
+	values := make(map[string]string, len(fields))
+	for _, f := range fields {
+		values[f] = RecordField(record, f)
+	}
+
+	return PartitionKey(key, RecordTime(record, timeField, fallback).In(loc), values)
+}
+
+// SplitPartitions groups records of the same key by event hour on loc and by the values of fields, keeping their order.
+// It returns the partition keys sorted, and the records of each one.
+func SplitPartitions(key string, data []Record, timeField string, loc *time.Location, fields []string) ([]string, map[string][]Record) {
+	parts := make(map[string][]Record)
+	keys := make([]string, 0)
+	now := time.Now()
+
+	for _, record := range data {
+		pkey := RecordPartition(key, record, timeField, loc, fields, now)
+
+		if _, ok := parts[pkey]; !ok {
+			keys = append(keys, pkey)
+		}
+
+		parts[pkey] = append(parts[pkey], record)
+	}
+
+	sort.Strings(keys)
+
+	return keys, parts
 }
 
 func NewRecord(recordType string, data map[string]interface{}) Record {
@@ -53,6 +216,8 @@ func NewRecord(recordType string, data map[string]interface{}) Record {
 	switch strings.ToLower(recordType) {
 	case config.RecordTypeDynamic:
 		ret = NewDynamic(data)
+	case config.RecordTypeLogLegacy:
+		ret = NewLogLegacy(data)
 	default:
 		ret = NewLog(data)
 	}
@@ -64,6 +229,8 @@ func NewObj(t string) Record {
 	switch t {
 	case config.RecordTypeDynamic:
 		return &Dynamic{}
+	case config.RecordTypeLogLegacy:
+		return &LogLegacy{}
 	default:
 		return &Log{}
 	}
@@ -104,14 +271,53 @@ func GetStringP(s interface{}) *string {
 	return &ret
 }
 
+// TryParseRecordTime parses a record time from text layouts or from epoch numbers, in seconds, milliseconds,
+// microseconds or nanoseconds. The current time is returned when the value can not be parsed.
 func TryParseRecordTime(v any) time.Time {
-	ret := time.Now()
+	return parseRecordTime(v, time.Now())
+}
+
+func parseRecordTime(v any, fallback time.Time) time.Time {
+	ret := fallback
 
 	if v == nil {
 		return ret
 	}
 
-	val := fmt.Sprint(v)
+	switch n := v.(type) {
+	case int:
+		return timeFromEpoch(int64(n))
+	case int32:
+		return timeFromEpoch(int64(n))
+	case int64:
+		return timeFromEpoch(n)
+	case uint32:
+		return timeFromEpoch(int64(n))
+	case uint64:
+		return timeFromEpoch(int64(n))
+	case float32:
+		return timeFromEpochFloat(float64(n))
+	case float64:
+		return timeFromEpochFloat(n)
+	}
+
+	val := strings.TrimSpace(fmt.Sprint(v))
+
+	if len(val) == 0 {
+		return ret
+	}
+
+	if n, err := strconv.ParseInt(val, 10, 64); err == nil {
+		return timeFromEpoch(n)
+	}
+
+	if tm, ok := timeFromEpochDecimal(val); ok {
+		return tm
+	}
+
+	if n, err := strconv.ParseFloat(val, 64); err == nil {
+		return timeFromEpochFloat(n)
+	}
 
 	parsed, err := time.Parse(time.RFC3339Nano, val)
 	if err == nil {
@@ -201,6 +407,70 @@ func TryParseRecordTime(v any) time.Time {
 	return ret
 }
 
+// epochScale guesses the unit of an epoch by its magnitude, returning how many units fit in a second.
+func epochScale(abs float64) int64 {
+	switch {
+	case abs < 1e11:
+		return 1
+	case abs < 1e14:
+		return 1e3
+	case abs < 1e17:
+		return 1e6
+	default:
+		return 1e9
+	}
+}
+
+func timeFromEpoch(n int64) time.Time {
+	return epochTime(n, epochScale(math.Abs(float64(n))), 0)
+}
+
+// timeFromEpochFloat keeps the integer part of the epoch out of float math, only its fraction is rounded.
+func timeFromEpochFloat(n float64) time.Time {
+	if n == math.Trunc(n) && math.Abs(n) < math.MaxInt64 {
+		return timeFromEpoch(int64(n))
+	}
+
+	scale := epochScale(math.Abs(n))
+	units := math.Floor(n)
+
+	return epochTime(int64(units), scale, int64(math.Round((n-units)*float64(1e9/scale))))
+}
+
+// timeFromEpochDecimal parses an epoch as `1700000000.123456` without float math, it returns false for other texts.
+func timeFromEpochDecimal(val string) (time.Time, bool) {
+	whole, fraction, found := strings.Cut(val, ".")
+
+	if !found || len(fraction) == 0 || strings.Trim(fraction, "0123456789") != "" {
+		return time.Time{}, false
+	}
+
+	units, err := strconv.ParseInt(whole, 10, 64)
+
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	scale := epochScale(math.Abs(float64(units)))
+	digits := len(strconv.FormatInt(1e9/scale, 10)) - 1
+	nsec := int64(0)
+
+	if digits > 0 {
+		nsec, _ = strconv.ParseInt((fraction + "000000000")[:digits], 10, 64)
+	}
+
+	if strings.HasPrefix(whole, "-") {
+		nsec = -nsec
+	}
+
+	return epochTime(units, scale, nsec), true
+}
+
+// epochTime returns the time of an epoch in units of 1/scale of a second plus nsec nanoseconds.
+func epochTime(units int64, scale int64, nsec int64) time.Time {
+	return time.Unix(units/scale, (units%scale)*(1e9/scale)+nsec).UTC()
+}
+
 func GetInt64(n any) int64 {
 	if n == nil {
 		return 0
@@ -0,0 +1,69 @@
+package domain_test
+
+import (
+	"testing"
+	"time"
+
+	"data2parquet/pkg/domain"
+)
+
+func TestTryParseRecordTime(t *testing.T) {
+	expected := time.Date(2023, 11, 14, 22, 13, 20, 123456789, time.UTC)
+
+	tests := []struct {
+		name  string
+		value any
+		want  time.Time
+	}{
+		{"seconds", int64(1700000000), expected.Truncate(time.Second)},
+		{"milliseconds", int64(1700000000123), expected.Truncate(time.Millisecond)},
+		{"microseconds", int64(1700000000123456), expected.Truncate(time.Microsecond)},
+		{"nanoseconds", int64(1700000000123456789), expected},
+		{"int", 1700000000, expected.Truncate(time.Second)},
+		{"negative seconds", int64(-1), time.Unix(-1, 0).UTC()},
+		{"float seconds", 1700000000.0, expected.Truncate(time.Second)},
+		{"float milliseconds", float64(1700000000123), expected.Truncate(time.Millisecond)},
+		{"float microseconds", float64(1700000000123456), expected.Truncate(time.Microsecond)},
+		{"float fraction", 1700000000.5, time.Unix(1700000000, 5e8).UTC()},
+		{"negative float", -1.5, time.Unix(-2, 5e8).UTC()},
+		{"text seconds", "1700000000", expected.Truncate(time.Second)},
+		{"text milliseconds", "1700000000123", expected.Truncate(time.Millisecond)},
+		{"text decimal seconds", "1700000000.123456789", expected},
+		{"text decimal milliseconds", "1700000000123.456", expected.Truncate(time.Microsecond)},
+		{"text decimal of a float", "1700000000123.000000", expected.Truncate(time.Millisecond)},
+		{"text negative decimal", "-1.5", time.Unix(-2, 5e8).UTC()},
+		{"text exponent", "1.7e9", time.Unix(1700000000, 0).UTC()},
+		{"rfc3339", "2023-11-14T22:13:20.123456789Z", expected},
+		{"rfc3339 offset", "2023-11-14T19:13:20.123-03:00", expected.Truncate(time.Millisecond)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := domain.TryParseRecordTime(tt.value); !got.Equal(tt.want) {
+				t.Errorf("TryParseRecordTime(%v) = %s, expected %s", tt.value, got.Format(time.RFC3339Nano), tt.want.Format(time.RFC3339Nano))
+			}
+		})
+	}
+}
+
+func TestRecordPartitionFallback(t *testing.T) {
+	fallback := time.Date(2024, 5, 1, 10, 59, 59, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		record domain.Record
+		want   string
+	}{
+		{"event time", domain.NewDynamic(map[string]any{"time": "2024-05-01T08:30:00Z"}), "key:2024-05-01T08"},
+		{"missing time", domain.NewDynamic(map[string]any{"msg": "no time"}), "key:2024-05-01T10"},
+		{"invalid time", domain.NewDynamic(map[string]any{"time": "not a time"}), "key:2024-05-01T10"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := domain.RecordPartition("key", tt.record, "time", time.UTC, nil, fallback); got != tt.want {
+				t.Errorf("RecordPartition = %s, expected %s", got, tt.want)
+			}
+		})
+	}
+}
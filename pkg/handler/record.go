@@ -5,7 +5,9 @@ import (
 	"data2parquet/pkg/config"
 	"data2parquet/pkg/domain"
 	"data2parquet/pkg/receiver"
+	"data2parquet/pkg/writer"
 	"encoding/json"
+	"errors"
 
 	"data2parquet/pkg/logger" //"log/slog"
 
@@ -68,6 +70,26 @@ func (h *LogHandler) Write(ctx *gin.Context) {
 
 	err = h.rcv.Write(record)
 
+	if errors.Is(err, receiver.ErrBackpressure) {
+		slog.Warn("Buffer is full, asking client to retry", "error", err, "module", "handler", "function", "Write")
+		ctx.JSON(http.StatusTooManyRequests, gin.H{
+			"error":     err.Error(),
+			"timestamp": time.Now().Unix(),
+			"elapsed":   time.Since(start).String(),
+		})
+		return
+	}
+
+	if errors.Is(err, receiver.ErrSchemaViolation) {
+		slog.Warn("Record does not match the schema", "error", err, "module", "handler", "function", "Write")
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":     err.Error(),
+			"timestamp": time.Now().Unix(),
+			"elapsed":   time.Since(start).String(),
+		})
+		return
+	}
+
 	if err != nil {
 		slog.Error("Error writing record", "error", err, "module", "handler", "function", "Write")
 		ctx.JSON(http.StatusInternalServerError, gin.H{
@@ -113,9 +135,41 @@ func (h *LogHandler) Healthcheck(ctx *gin.Context) {
 
 	slog.Debug("Healthcheck", "module", "handler", "function", "Healthcheck")
 
-	ctx.JSON(http.StatusOK, gin.H{
+	err := errors.New("receiver is not running")
+	var targets []writer.TargetStats
+
+	if h.rcv != nil {
+		err = h.rcv.Healthcheck()
+		targets = h.rcv.WriterTargets()
+	}
+
+	if err != nil {
+		slog.Warn("Healthcheck failed", "error", err, "module", "handler", "function", "Healthcheck")
+		ret := gin.H{
+			"status":    "error",
+			"error":     err.Error(),
+			"kind":      writer.ErrorKind(err),
+			"timestamp": time.Now().Unix(),
+			"elapsed":   time.Since(start).String(),
+		}
+
+		if targets != nil {
+			ret["targets"] = targets
+		}
+
+		ctx.JSON(http.StatusServiceUnavailable, ret)
+		return
+	}
+
+	ret := gin.H{
 		"status":    "ok",
 		"timestamp": time.Now().Unix(),
 		"elapsed":   time.Since(start).String(),
-	})
+	}
+
+	if targets != nil {
+		ret["targets"] = targets
+	}
+
+	ctx.JSON(http.StatusOK, ret)
 }
@@ -4,10 +4,13 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
+	"io"
 
 	"data2parquet/pkg/logger" //"log/slog"
 
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"data2parquet/pkg/buffer"
@@ -31,6 +34,10 @@ type Receiver struct {
 	interval      time.Duration
 	mu            *sync.RWMutex
 	update        chan *UpdateItem
+	location      *time.Location
+	fields        []string
+	pageSize      int
+	relieving     atomic.Bool
 }
 
 type BufferControl struct {
@@ -41,6 +48,7 @@ type BufferControl struct {
 type UpdateItem struct {
 	Key   string
 	Count int
+	Bytes int64
 }
 
 type FlushReason string
@@ -49,8 +57,16 @@ const (
 	FlushReasonSize     FlushReason = "buffer-size"
 	FlushReasonInterval FlushReason = "interval"
 	FlushReasonClose    FlushReason = "close"
+	FlushReasonBytes    FlushReason = "buffer-bytes"
+	FlushReasonMemory   FlushReason = "memory-pressure"
 )
 
+// ErrBackpressure is returned by Write when the buffer memory limit is reached and the record was not accepted.
+var ErrBackpressure = errors.New("buffer memory limit reached")
+
+// ErrSchemaViolation is returned by Write when a record does not match the JSON Schema, the record goes to the DLQ.
+var ErrSchemaViolation = errors.New("record does not match the schema")
+
 func NewReceiver(ctx context.Context, config *config.Config) *Receiver {
 	if ctx == nil {
 		ctx = context.Background()
@@ -64,10 +80,16 @@ func NewReceiver(ctx context.Context, config *config.Config) *Receiver {
 		last:          make(map[string]*time.Time),
 		ctx:           ctx,
 		recoveryCount: make(map[string]int),
-		converter:     converter.New(config),
+		converter:     converter.New(ctx, config),
 		interval:      time.Duration(config.FlushInterval) * time.Second,
 		mu:            &sync.RWMutex{},
 		update:        make(chan *UpdateItem, config.BufferSize),
+		location:      domain.PartitionLocation(config.PartitionTimezone),
+		pageSize:      config.BufferPageSize,
+	}
+
+	if ret.pageSize < 1 {
+		ret.pageSize = 1000
 	}
 
 	if ret.buffer == nil {
@@ -87,6 +109,24 @@ func NewReceiver(ctx context.Context, config *config.Config) *Receiver {
 		return nil
 	}
 
+	if len(config.WriterPathTemplate) > 0 {
+		template, err := writer.NewPathTemplate(config.WriterPathTemplate)
+
+		if err != nil {
+			slog.Error("Error parsing writer path template", "error", err)
+			return nil
+		}
+
+		ret.fields = template.Fields()
+	}
+
+	if len(config.DynamicKeyFields) > 0 {
+		if _, err := domain.ParseKeyFields(config.DynamicKeyFields); err != nil {
+			slog.Error("Error parsing dynamic key fields", "error", err)
+			return nil
+		}
+	}
+
 	err := ret.writer.Init()
 
 	if err != nil {
@@ -95,7 +135,7 @@ func NewReceiver(ctx context.Context, config *config.Config) *Receiver {
 	}
 
 	if !ret.writer.IsReady() {
-		slog.Error("Writer is not ready")
+		slog.Error("Writer is not ready", "error", ret.writer.Status())
 		return nil
 	}
 
@@ -177,6 +217,14 @@ func (r *Receiver) processUpdate() {
 			if bfSize > r.config.BufferSize {
 				err := r.flushKey(item.Key, FlushReasonSize)
 
+				if err != nil {
+					slog.Error("Error to flush key", "key", item.Key, "error", err)
+				}
+			}
+		} else if r.config.BufferFlushBytes > 0 && item.Bytes >= r.config.BufferFlushBytes {
+			if r.buffer.Size(item.Key) >= r.config.BufferFlushBytes {
+				err := r.flushKey(item.Key, FlushReasonBytes)
+
 				if err != nil {
 					slog.Error("Error to flush key", "key", item.Key, "error", err)
 				}
@@ -187,6 +235,20 @@ func (r *Receiver) processUpdate() {
 }
 
 func (r *Receiver) Write(record domain.Record) error {
+	if r.config.BufferMemoryLimit > 0 {
+		err := r.checkMemory()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	err := r.converter.Validate(record)
+
+	if err != nil {
+		return r.reject(record, err)
+	}
+
 	key := record.Key()
 	n, err := r.buffer.Push(key, record)
 
@@ -195,14 +257,109 @@ func (r *Receiver) Write(record domain.Record) error {
 		return err
 	}
 
-	r.update <- &UpdateItem{
+	item := &UpdateItem{
 		Key:   key,
 		Count: n,
 	}
 
+	if r.config.BufferFlushBytes > 0 {
+		item.Bytes = r.buffer.Size(key)
+	}
+
+	r.update <- item
+
 	return nil
 }
 
+// reject sends a record that violates the schema to the DLQ, with the validation error attached to it.
+func (r *Receiver) reject(record domain.Record, violation error) error {
+	if d, ok := record.(*domain.Dynamic); ok {
+		d.Error = violation.Error()
+	}
+
+	if r.config.UseDLQ {
+		slog.Warn("Record does not match the schema, push to DLQ", "error", violation, "key", record.Key(), "record", record.ToJson())
+		err := r.buffer.PushDLQ(record.Key(), record)
+
+		if err != nil {
+			slog.Error("Error pushing to DLQ Buffer", "error", err, "key", record.Key())
+		}
+	} else {
+		slog.Warn("DLQ is disabled, skipping record", "error", violation, "key", record.Key(), "record", record.ToJson())
+	}
+
+	return fmt.Errorf("%w: %v", ErrSchemaViolation, violation)
+}
+
+// checkMemory applies the backpressure policy when the buffered bytes reach BufferMemoryLimit: the largest key is
+// flushed and the write is rejected at once, or blocked until memory is released or BufferBackpressureTimeout expires.
+func (r *Receiver) checkMemory() error {
+	total := r.buffer.TotalSize()
+
+	if total < r.config.BufferMemoryLimit {
+		return nil
+	}
+
+	if r.config.BufferBackpressure != config.BufferBackpressureBlock {
+		slog.Warn("Buffer memory limit reached, rejecting record", "total", total, "limit", r.config.BufferMemoryLimit)
+		go r.relieveMemory()
+		return fmt.Errorf("%w: %d of %d bytes", ErrBackpressure, total, r.config.BufferMemoryLimit)
+	}
+
+	slog.Warn("Buffer memory limit reached, waiting for flush", "total", total, "limit", r.config.BufferMemoryLimit)
+	deadline := time.Now().Add(time.Duration(r.config.BufferBackpressureTimeout) * time.Second)
+
+	for total >= r.config.BufferMemoryLimit {
+		if time.Now().After(deadline) {
+			slog.Error("Timeout waiting for buffer memory, rejecting record", "total", total, "limit", r.config.BufferMemoryLimit)
+			return fmt.Errorf("%w: %d of %d bytes", ErrBackpressure, total, r.config.BufferMemoryLimit)
+		}
+
+		if !r.relieveMemory() {
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		total = r.buffer.TotalSize()
+	}
+
+	return nil
+}
+
+// relieveMemory flushes the key holding more bytes on buffer, it returns false when another flush is already running.
+func (r *Receiver) relieveMemory() bool {
+	if !r.relieving.CompareAndSwap(false, true) {
+		return false
+	}
+
+	defer r.relieving.Store(false)
+
+	key := ""
+	var largest int64
+
+	r.mu.RLock()
+	for k := range r.last {
+		size := r.buffer.Size(k)
+		if size > largest {
+			key = k
+			largest = size
+		}
+	}
+	r.mu.RUnlock()
+
+	if len(key) == 0 {
+		return false
+	}
+
+	err := r.flushKey(key, FlushReasonMemory)
+
+	if err != nil {
+		slog.Error("Error to flush key", "key", key, "error", err)
+		return false
+	}
+
+	return true
+}
+
 func (r *Receiver) flushKey(key string, reason FlushReason) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -228,76 +385,264 @@ func (r *Receiver) flushKey(key string, reason FlushReason) error {
 		return nil
 	}
 
-	data := r.buffer.Get(key)
-	size := len(data)
+	size := r.buffer.Take(key)
 
 	if reason == FlushReasonSize && size < r.config.BufferSize {
 		slog.Info("Skipping buffer flush, buffer size has not yet been reached", "reason", reason, "key", key, "size", size)
-		return nil
+		return r.buffer.Rollback(key)
 	}
 
 	if size == 0 {
 		slog.Info("Skipping buffer flush, no data to flush here", "reason", reason, "key", key, "size", size)
-		return nil
+		return r.buffer.Rollback(key)
 	}
 
 	slog.Info("Flushing key", "reason", reason, "key", key)
 
-	buf := new(bytes.Buffer)
-	result := r.converter.Write(key, data, buf)
+	parts := make(map[string]*partition)
+	pkeys := make([]string, 0)
+	// The partition of each in-flight record, by its position on the batch, records without a valid time go to the
+	// hour of the flush start. Requeue, DLQ and recovery read the records again, they must not compute it again.
+	owners := make([]string, size)
 
-	errCount := 0
+	for offset := 0; offset < size; offset += r.pageSize {
+		for i, record := range r.buffer.Page(key, offset, r.pageSize) {
+			pkey := domain.RecordPartition(key, record, r.config.DynamicTimeField, r.location, r.fields, start)
+			part, found := parts[pkey]
 
-	for _, item := range result {
-		if item.Error != nil {
-			errCount++
-			if r.config.UseDLQ {
-				slog.Error("Error converting data, push to DLQ", "error", item.Error, "key", key, "record", item.Record.ToJson())
-				err := r.buffer.PushDLQ(item.Key, item.Record)
+			if offset+i < size {
+				owners[offset+i] = pkey
+			}
 
-				if err != nil {
-					slog.Error("Error pushing to DLQ Buffer", "error", err, "key", key)
-				}
-			} else {
-				slog.Warn("DLQ is disabled, skipping record", "error", item.Error, "key", key, "record", item.Record.ToJson())
+			if !found {
+				part = r.openPartition(pkey)
+				parts[pkey] = part
+				pkeys = append(pkeys, pkey)
 			}
+
+			part.write(record)
 		}
 	}
 
-	err := r.writer.Write(key, buf)
+	var failed error
+	failedParts := make(map[string]bool)
 
-	if err != nil {
-		if !r.config.TryAutoRecover {
-			slog.Error("Error writing data, resend is disabled, discarding data", "error", err, "key", key, "lines", len(data))
-		} else {
-			slog.Error("Error writing data, pushing to recovery Buffer", "error", err, "key", key, "lines", len(data))
-			errWr := r.buffer.PushRecovery(key, buf)
-
-			if errWr != nil {
-				slog.Error("Error pushing to recovery buffer", "error", errWr, "key", key, "lines", len(data), "duration", time.Since(start))
-			}
+	for _, pkey := range pkeys {
+		resend, err := r.closePartition(key, parts[pkey], owners)
+
+		if err != nil {
+			slog.Error("Error writing partition, keeping data on buffer to retry on next flush", "error", err, "key", key, "partition", pkey, "lines", parts[pkey].count, "duration", time.Since(start))
+			failedParts[pkey] = true
 
-			if r.config.TryAutoRecover {
-				callResend = true
+			if failed == nil {
+				failed = err
 			}
+
+			continue
+		}
+
+		callResend = callResend || resend
+	}
+
+	if len(failedParts) == len(pkeys) {
+		r.rollback(key)
+		return failed
+	}
+
+	if failed != nil {
+		err := r.requeue(key, owners, failedParts)
+
+		if err != nil {
+			slog.Error("Error returning failed partitions to buffer, rolling back the whole batch", "error", err, "key", key)
+			r.rollback(key)
+			return failed
 		}
 	}
 
-	err = r.buffer.Clear(key, len(data))
+	err := r.buffer.Clear(key, size)
 
 	if err != nil {
-		slog.Error("Error clearing buffer", "error", err, "key", key, "lines", len(data))
+		slog.Error("Error clearing buffer", "error", err, "key", key, "lines", size)
 	}
 
-	slog.Info("Buffer flush process finished", "key", key, "total-duration", time.Since(start), "lines", len(data))
+	slog.Info("Buffer flush process finished", "key", key, "partitions", len(pkeys), "failed", len(failedParts), "total-duration", time.Since(start), "lines", size)
 
 	if callResend {
 		go r.TryResendData()
 	}
 
+	if failed != nil {
+		return failed
+	}
+
 	return err
 }
 
+// requeue pushes the records of the failed partitions back to the buffer, so the batch can be cleared without writing
+// again the partitions already written, and the failed ones are retried on the next flush.
+func (r *Receiver) requeue(key string, owners []string, failed map[string]bool) error {
+	count := 0
+
+	err := r.eachRecord(key, owners, failed, func(record domain.Record) error {
+		count++
+		_, err := r.buffer.Push(key, record)
+		return err
+	})
+
+	slog.Warn("Failed partitions returned to buffer", "key", key, "partitions", len(failed), "lines", count)
+
+	return err
+}
+
+// eachRecord reads the in-flight records of key again, page by page, calling fn for the ones of the partitions in
+// pkeys. The partition of each record is the one given by owners when it was flushed.
+func (r *Receiver) eachRecord(key string, owners []string, pkeys map[string]bool, fn func(record domain.Record) error) error {
+	for offset := 0; offset < len(owners); offset += r.pageSize {
+		for i, record := range r.buffer.Page(key, offset, r.pageSize) {
+			if offset+i >= len(owners) || !pkeys[owners[offset+i]] {
+				continue
+			}
+
+			if err := fn(record); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// partition streams the records of a partition key to the writer while they are converted, through a pipe, so
+// only the parquet row group being built is kept in memory.
+type partition struct {
+	pkey   string
+	stream *converter.Stream
+	pipe   *io.PipeWriter
+	done   chan error
+	count  int
+}
+
+func (r *Receiver) openPartition(pkey string) *partition {
+	reader, pipe := io.Pipe()
+
+	ret := &partition{
+		pkey:   pkey,
+		stream: r.converter.NewStream(pkey, pipe),
+		pipe:   pipe,
+		done:   make(chan error, 1),
+	}
+
+	go func() {
+		err := r.writer.Write(pkey, &partitionReader{PipeReader: reader, p: ret})
+		reader.CloseWithError(err)
+		ret.done <- err
+	}()
+
+	return ret
+}
+
+// partitionReader gives the writer the number of records of the file, it is complete when the data was read to the
+// end, as the stream is closed before the pipe.
+type partitionReader struct {
+	*io.PipeReader
+	p *partition
+}
+
+func (r *partitionReader) Records() int {
+	return r.p.stream.Rows()
+}
+
+func (p *partition) write(record domain.Record) {
+	p.count++
+	p.stream.Write(record)
+}
+
+// closePartition finishes the file of a partition, a failed conversion makes the writer drop it and the records of
+// the partition go to the DLQ. Records that could not be converted go to the DLQ when the file is written, or
+// converted again to the recovery buffer when the writer fails and TryAutoRecover is set.
+func (r *Receiver) closePartition(key string, p *partition, owners []string) (bool, error) {
+	result := p.stream.Close()
+	p.pipe.CloseWithError(p.stream.Err())
+	err := <-p.done
+
+	if p.stream.Err() != nil && p.stream.DestinationErr() == nil {
+		slog.Error("Error converting partition, push to DLQ", "error", p.stream.Err(), "key", p.pkey, "lines", p.count)
+
+		return false, r.eachRecord(key, owners, map[string]bool{p.pkey: true}, func(record domain.Record) error {
+			r.dlq(key, record, p.stream.Err())
+			return nil
+		})
+	}
+
+	if err != nil && !r.config.TryAutoRecover {
+		slog.Error("Error writing data, resend is disabled", "error", err, "key", p.pkey, "lines", p.count)
+		return false, err
+	}
+
+	r.pushDLQ(key, result)
+
+	if err == nil {
+		return false, nil
+	}
+
+	slog.Error("Error writing data, pushing to recovery Buffer", "error", err, "key", p.pkey, "lines", p.count)
+	buf := new(bytes.Buffer)
+	stream := r.converter.NewStream(p.pkey, buf)
+
+	r.eachRecord(key, owners, map[string]bool{p.pkey: true}, func(record domain.Record) error {
+		stream.Write(record)
+		return nil
+	})
+
+	stream.Close()
+
+	if stream.Err() != nil {
+		slog.Error("Error converting data to recovery buffer", "error", stream.Err(), "key", p.pkey, "lines", p.count)
+		return false, err
+	}
+
+	err = r.buffer.PushRecovery(p.pkey, buf)
+
+	if err != nil {
+		slog.Error("Error pushing to recovery buffer", "error", err, "key", p.pkey, "lines", p.count)
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (r *Receiver) pushDLQ(key string, result []*converter.Result) {
+	for _, item := range result {
+		if item.Error == nil || item.Record == nil {
+			continue
+		}
+
+		r.dlq(key, item.Record, item.Error)
+	}
+}
+
+func (r *Receiver) dlq(key string, record domain.Record, cause error) {
+	if !r.config.UseDLQ {
+		slog.Warn("DLQ is disabled, skipping record", "error", cause, "key", key, "record", record.ToJson())
+		return
+	}
+
+	slog.Error("Error converting data, push to DLQ", "error", cause, "key", key, "record", record.ToJson())
+	err := r.buffer.PushDLQ(key, record)
+
+	if err != nil {
+		slog.Error("Error pushing to DLQ Buffer", "error", err, "key", key)
+	}
+}
+
+func (r *Receiver) rollback(key string) {
+	err := r.buffer.Rollback(key)
+
+	if err != nil {
+		slog.Error("Error returning in-flight data to buffer", "error", err, "key", key)
+	}
+}
+
 func (r *Receiver) TryResendData() {
 	start := time.Now()
 
@@ -417,6 +762,15 @@ func (r *Receiver) Close() error {
 	return nil
 }
 
+// WriterTargets returns the accounting of the targets of a fan-out writer, nil for the other writers.
+func (r *Receiver) WriterTargets() []writer.TargetStats {
+	if f, ok := r.writer.(*writer.FanOut); ok {
+		return f.Targets()
+	}
+
+	return nil
+}
+
 func (r *Receiver) Healthcheck() error {
 	slog.Debug("Healthcheck", "running", r.running)
 	if !r.running {
@@ -428,6 +782,10 @@ func (r *Receiver) Healthcheck() error {
 	}
 
 	if !r.writer.IsReady() {
+		if err := r.writer.Status(); err != nil {
+			return fmt.Errorf("writer is not ready: %w", err)
+		}
+
 		return errors.New("writer is not ready")
 	}
 
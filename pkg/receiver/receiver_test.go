@@ -8,13 +8,19 @@ import (
 	"os"
 	"path/filepath"
 	"runtime/debug"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/oklog/ulid"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/common"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
 	"gopkg.in/loremipsum.v1"
 
 	"data2parquet/pkg/config"
+	"data2parquet/pkg/converter"
 	"data2parquet/pkg/domain"
 	"data2parquet/pkg/receiver"
 )
@@ -160,3 +166,894 @@ func getID() *string {
 	ret := ulid.MustNew(ulid.Timestamp(time.Now()), entropy).String()
 	return &ret
 }
+
+func TestReceiverEventTimePartitions(t *testing.T) {
+	cfg := PrepareConfig()
+	cfg.WriterFilePath = t.TempDir()
+	rec := receiver.NewReceiver(context.Background(), cfg)
+
+	if rec == nil {
+		t.Fatal("Receiver is nil")
+	}
+
+	data := generateData(20)
+
+	for i, d := range data {
+		line := d.(*domain.Log)
+		line.Time = "2024-01-02T10:30:00Z"
+
+		if i%2 == 1 {
+			line.Time = "2024-01-02T08:15:00-03:00"
+		}
+
+		err := rec.Write(line)
+
+		if err != nil {
+			t.Error("Error writing data")
+		}
+	}
+
+	time.Sleep(1 * time.Second)
+
+	err := rec.Flush()
+
+	if err != nil {
+		t.Error("Error flushing data")
+	}
+
+	err = rec.Close()
+
+	if err != nil {
+		t.Error("Error closing receiver")
+	}
+
+	for _, hour := range []string{"10", "11"} {
+		outputdir := filepath.Join(cfg.WriterFilePath, "capability=business_capability", "year=2024", "month=01", "day=02", "hour="+hour)
+		files, err := os.ReadDir(outputdir)
+
+		if err != nil || len(files) != 1 {
+			t.Errorf("Expected one file on partition %s, got %d (%v)", outputdir, len(files), err)
+		}
+	}
+}
+
+func TestReceiverPartialFlush(t *testing.T) {
+	cfg := PrepareConfig()
+	cfg.WriterFilePath = t.TempDir()
+	cfg.BufferType = config.BufferTypeDisk
+	cfg.DiskPath = t.TempDir()
+	cfg.FlushInterval = 3600
+	cfg.TryAutoRecover = true
+	rec := receiver.NewReceiver(context.Background(), cfg)
+
+	if rec == nil {
+		t.Fatal("Receiver is nil")
+	}
+
+	day := filepath.Join(cfg.WriterFilePath, "capability=business_capability", "year=2024", "month=01", "day=02")
+	blocked := filepath.Join(day, "hour=11")
+
+	// A file in place of the directory of a partition fails its write, and the recovery buffer can't take it
+	if err := os.MkdirAll(day, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(blocked, []byte{}, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.RemoveAll(filepath.Join(cfg.DiskPath, "recovery")); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, d := range generateData(20) {
+		line := d.(*domain.Log)
+		line.Time = "2024-01-02T10:30:00Z"
+
+		if i%2 == 1 {
+			line.Time = "2024-01-02T11:30:00Z"
+		}
+
+		if err := rec.Write(line); err != nil {
+			t.Error("Error writing data")
+		}
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if err := rec.Flush(); err == nil {
+		t.Error("Flush must fail when a partition can't be written")
+	}
+
+	if err := os.Remove(blocked); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(cfg.DiskPath, "recovery"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rec.Flush(); err != nil {
+		t.Errorf("Error flushing data after the partition is fixed: %v", err)
+	}
+
+	rec.Close()
+
+	for _, hour := range []string{"10", "11"} {
+		outputdir := filepath.Join(day, "hour="+hour)
+		files, err := os.ReadDir(outputdir)
+
+		if err != nil || len(files) != 1 {
+			t.Errorf("Expected one file on partition %s, got %d (%v)", outputdir, len(files), err)
+			continue
+		}
+
+		fr, err := local.NewLocalFileReader(filepath.Join(outputdir, files[0].Name()))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pr, err := reader.NewParquetReader(fr, nil, 1)
+
+		if err != nil {
+			t.Fatalf("Invalid parquet file %s: %v", files[0].Name(), err)
+		}
+
+		if pr.GetNumRows() != 10 {
+			t.Errorf("Partition %s has %d rows, expected 10", outputdir, pr.GetNumRows())
+		}
+
+		pr.ReadStop()
+		fr.Close()
+	}
+}
+
+// partitionRows returns the number of files of a partition directory and their rows.
+func partitionRows(t *testing.T, dir string) (int, int64) {
+	files, err := os.ReadDir(dir)
+
+	if err != nil {
+		return 0, 0
+	}
+
+	rows := int64(0)
+
+	for _, file := range files {
+		fr, err := local.NewLocalFileReader(filepath.Join(dir, file.Name()))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pr, err := reader.NewParquetReader(fr, nil, 1)
+
+		if err != nil {
+			t.Fatalf("Invalid parquet file %s: %v", file.Name(), err)
+		}
+
+		rows += pr.GetNumRows()
+		pr.ReadStop()
+		fr.Close()
+	}
+
+	return len(files), rows
+}
+
+func TestReceiverFailedWriteWithoutRecover(t *testing.T) {
+	cfg := PrepareConfig()
+	cfg.WriterFilePath = t.TempDir()
+	cfg.FlushInterval = 3600
+	cfg.TryAutoRecover = false
+	rec := receiver.NewReceiver(context.Background(), cfg)
+
+	if rec == nil {
+		t.Fatal("Receiver is nil")
+	}
+
+	day := filepath.Join(cfg.WriterFilePath, "capability=business_capability", "year=2024", "month=01", "day=02")
+	blocked := filepath.Join(day, "hour=11")
+
+	// A file in place of the directory of a partition fails its write
+	if err := os.MkdirAll(day, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(blocked, []byte{}, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, d := range generateData(20) {
+		line := d.(*domain.Log)
+		line.Time = "2024-01-02T10:30:00Z"
+
+		if i%2 == 1 {
+			line.Time = "2024-01-02T11:30:00Z"
+		}
+
+		if err := rec.Write(line); err != nil {
+			t.Error("Error writing data")
+		}
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if err := rec.Flush(); err == nil {
+		t.Error("Flush must fail when a partition can't be written")
+	}
+
+	if files, rows := partitionRows(t, filepath.Join(day, "hour=10")); files != 1 || rows != 10 {
+		t.Errorf("Expected the partition that could be written, got %d files with %d rows", files, rows)
+	}
+
+	// The records of the failed partition are kept on the buffer, and written by the next flush
+	if err := os.Remove(blocked); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rec.Flush(); err != nil {
+		t.Errorf("Error flushing data after the partition is fixed: %v", err)
+	}
+
+	rec.Close()
+
+	for _, hour := range []string{"10", "11"} {
+		if files, rows := partitionRows(t, filepath.Join(day, "hour="+hour)); files != 1 || rows != 10 {
+			t.Errorf("Expected every record of hour %s written once, got %d files with %d rows", hour, files, rows)
+		}
+	}
+}
+
+func TestReceiverConversionFailureDLQ(t *testing.T) {
+	cfg := PrepareConfig()
+	cfg.RecordType = config.RecordTypeDynamic
+	cfg.WriterFilePath = t.TempDir()
+	cfg.BufferType = config.BufferTypeDisk
+	cfg.DiskPath = t.TempDir()
+	cfg.FlushInterval = 3600
+	cfg.UseDLQ = true
+	cfg.DynamicSchemaMode = config.DynamicSchemaModeFile
+	cfg.JsonSchemaPath = filepath.Join(t.TempDir(), "missing.json")
+	rec := receiver.NewReceiver(context.Background(), cfg)
+
+	if rec == nil {
+		t.Fatal("Receiver is nil")
+	}
+
+	// Without the schema file the partition can't be converted
+	for i := 0; i < 10; i++ {
+		err := rec.Write(domain.NewRecord(cfg.RecordType, map[string]interface{}{
+			"time":       "2024-01-02T10:30:00Z",
+			"capability": "dlq_capability",
+			"count":      i,
+		}))
+
+		if err != nil {
+			t.Errorf("Error writing record: %v", err)
+		}
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if err := rec.Flush(); err != nil {
+		t.Errorf("A partition that can't be converted must go to the DLQ, got %v", err)
+	}
+
+	files, err := os.ReadDir(filepath.Join(cfg.DiskPath, "dlq"))
+
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected one DLQ file, got %d (%v)", len(files), err)
+	}
+
+	if info, err := files[0].Info(); err != nil || info.Size() == 0 {
+		t.Errorf("DLQ file is empty (%v)", err)
+	}
+
+	if err := rec.Flush(); err != nil {
+		t.Errorf("Error flushing data: %v", err)
+	}
+
+	rec.Close()
+
+	written := 0
+
+	filepath.Walk(cfg.WriterFilePath, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			written++
+		}
+		return nil
+	})
+
+	if written != 0 {
+		t.Errorf("Expected no files written, got %d", written)
+	}
+
+	dlq, _ := os.ReadFile(filepath.Join(cfg.DiskPath, "dlq", files[0].Name()))
+
+	if count := strings.Count(string(dlq), "count"); count != 10 {
+		t.Errorf("Expected 10 records on the DLQ, got %d", count)
+	}
+}
+
+func TestReceiverPathTemplate(t *testing.T) {
+	cfg := PrepareConfig()
+	cfg.WriterPathTemplate = "{nope}/{id}.parquet"
+
+	if receiver.NewReceiver(context.Background(), cfg) != nil {
+		t.Fatal("Receiver must not start with an invalid path template")
+	}
+
+	cfg.WriterFilePath = t.TempDir()
+	cfg.WriterPathTemplate = "env={field:env}/domain={domain}/dt={date}/{id}-{records}.parquet"
+	rec := receiver.NewReceiver(context.Background(), cfg)
+
+	if rec == nil {
+		t.Fatal("Receiver is nil")
+	}
+
+	data := generateData(20)
+
+	for i, d := range data {
+		line := d.(*domain.Log)
+		line.Time = "2024-01-02T10:30:00Z"
+		line.ExtraFields = map[string]string{"env": "prod"}
+
+		if i%2 == 1 {
+			line.ExtraFields["env"] = "dev"
+		}
+
+		err := rec.Write(line)
+
+		if err != nil {
+			t.Error("Error writing data")
+		}
+	}
+
+	time.Sleep(1 * time.Second)
+
+	err := rec.Flush()
+
+	if err != nil {
+		t.Error("Error flushing data")
+	}
+
+	err = rec.Close()
+
+	if err != nil {
+		t.Error("Error closing receiver")
+	}
+
+	for _, env := range []string{"prod", "dev"} {
+		outputdir := filepath.Join(cfg.WriterFilePath, "env="+env, "domain=business_domain", "dt=2024-01-02")
+		files, err := os.ReadDir(outputdir)
+
+		if err != nil || len(files) != 1 {
+			t.Errorf("Expected one file on %s, got %d (%v)", outputdir, len(files), err)
+			continue
+		}
+
+		if !strings.HasSuffix(files[0].Name(), "-10.parquet") {
+			t.Errorf("Expected the number of records on the file name, got %s", files[0].Name())
+		}
+	}
+}
+
+func TestReceiverLogSchemaVersions(t *testing.T) {
+	expected := map[int]map[string]parquet.Type{
+		config.LogSchemaVersionLegacy: {"duration": parquet.Type_BYTE_ARRAY, "http-response": parquet.Type_BYTE_ARRAY},
+		config.LogSchemaVersionTyped:  {"duration": parquet.Type_INT64, "http-response": parquet.Type_INT32},
+	}
+
+	for version, types := range expected {
+		cfg := PrepareConfig()
+		cfg.WriterFilePath = t.TempDir()
+		cfg.LogSchemaVersion = version
+		rec := receiver.NewReceiver(context.Background(), cfg)
+
+		if rec == nil {
+			t.Fatal("Receiver is nil")
+		}
+
+		duration := "1.5s"
+		status := "404"
+
+		for _, d := range generateData(10) {
+			line := d.(*domain.Log)
+			line.Time = "2024-01-02T10:30:00Z"
+			line.Duration = &duration
+			line.HTTPResponse = &status
+
+			if err := rec.Write(line); err != nil {
+				t.Error("Error writing data")
+			}
+		}
+
+		time.Sleep(100 * time.Millisecond)
+
+		if err := rec.Flush(); err != nil {
+			t.Error("Error flushing data")
+		}
+
+		rec.Close()
+
+		outputdir := filepath.Join(cfg.WriterFilePath, "capability=business_capability", "year=2024", "month=01", "day=02", "hour=10")
+		files, err := os.ReadDir(outputdir)
+
+		if err != nil || len(files) != 1 {
+			t.Fatalf("Expected one file on %s, got %d (%v)", outputdir, len(files), err)
+		}
+
+		fr, err := local.NewLocalFileReader(filepath.Join(outputdir, files[0].Name()))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pr, err := reader.NewParquetColumnReader(fr, 1)
+
+		if err != nil {
+			t.Fatalf("Invalid parquet file %s: %v", files[0].Name(), err)
+		}
+
+		for _, element := range pr.Footer.Schema {
+			if want, ok := types[element.Name]; ok && element.GetType() != want {
+				t.Errorf("Column %s is %s on schema version %d, expected %s", element.Name, element.GetType(), version, want)
+			}
+		}
+
+		found := false
+
+		for _, kv := range pr.Footer.KeyValueMetadata {
+			if kv.Key == converter.SchemaVersionKey && kv.GetValue() == fmt.Sprint(version) {
+				found = true
+			}
+		}
+
+		if !found {
+			t.Errorf("Schema version %d is not on the file metadata", version)
+		}
+
+		values, _, _, err := pr.ReadColumnByPath(common.ReformPathStr("parquet_go_root.duration"), 10)
+
+		if err != nil || len(values) != 10 {
+			t.Fatalf("Error reading duration column: %v", err)
+		}
+
+		if version == config.LogSchemaVersionTyped && values[0] != int64(1500*time.Millisecond) {
+			t.Errorf("Duration is %v, expected %d nanoseconds", values[0], int64(1500*time.Millisecond))
+		}
+
+		if version == config.LogSchemaVersionLegacy && values[0] != duration {
+			t.Errorf("Duration is %v, expected %s", values[0], duration)
+		}
+
+		pr.ReadStop()
+		fr.Close()
+	}
+}
+
+func TestReceiverLogLegacy(t *testing.T) {
+	cfg := PrepareConfig()
+	cfg.RecordType = config.RecordTypeLogLegacy
+	cfg.WriterFilePath = t.TempDir()
+	rec := receiver.NewReceiver(context.Background(), cfg)
+
+	if rec == nil {
+		t.Fatal("Receiver is nil")
+	}
+
+	for i := 0; i < 10; i++ {
+		record := domain.NewRecord(cfg.RecordType, map[string]interface{}{
+			"time":                "2024-01-02T10:30:00Z",
+			"level":               "INFO",
+			"message":             fmt.Sprintf("legacy message %d", i),
+			"business_capability": "business_capability",
+			"business_domain":     "business_domain",
+			"business_process":    "business_process",
+			"business_step":       "business_step",
+			"correlation_id":      *getID(),
+		})
+
+		if _, ok := record.(*domain.LogLegacy); !ok {
+			t.Fatal("Record is not a legacy log")
+		}
+
+		err := rec.Write(record)
+
+		if err != nil {
+			t.Error("Error writing data")
+		}
+	}
+
+	time.Sleep(1 * time.Second)
+
+	err := rec.Flush()
+
+	if err != nil {
+		t.Error("Error flushing data")
+	}
+
+	err = rec.Close()
+
+	if err != nil {
+		t.Error("Error closing receiver")
+	}
+
+	outputdir := filepath.Join(cfg.WriterFilePath, config.RecordTypeLogLegacy, "capability=business_capability", "year=2024", "month=01", "day=02", "hour=10")
+	files, err := os.ReadDir(outputdir)
+
+	if err != nil || len(files) != 1 {
+		t.Errorf("Expected one file on %s, got %d (%v)", outputdir, len(files), err)
+	}
+}
+
+func TestReceiverDynamicInferSchema(t *testing.T) {
+	cfg := PrepareConfig()
+	cfg.RecordType = config.RecordTypeDynamic
+	cfg.DynamicSchemaMode = config.DynamicSchemaModeInfer
+	cfg.WriterFilePath = t.TempDir()
+	rec := receiver.NewReceiver(context.Background(), cfg)
+
+	if rec == nil {
+		t.Fatal("Receiver is nil")
+	}
+
+	for i := 0; i < 10; i++ {
+		data := map[string]interface{}{
+			"time":       "2024-01-02T10:30:00Z",
+			"capability": "dynamic_capability",
+			"message":    fmt.Sprintf("dynamic message %d", i),
+			"count":      i,
+			"ratio":      float64(i) / 3,
+			"tags":       []interface{}{"a", "b"},
+			"request":    map[string]interface{}{"method": "GET", "status": 200},
+		}
+
+		if i%2 == 0 {
+			data["extra"] = true
+		}
+
+		err := rec.Write(domain.NewRecord(cfg.RecordType, data))
+
+		if err != nil {
+			t.Error("Error writing data")
+		}
+	}
+
+	time.Sleep(1 * time.Second)
+
+	err := rec.Flush()
+
+	if err != nil {
+		t.Error("Error flushing data")
+	}
+
+	err = rec.Close()
+
+	if err != nil {
+		t.Error("Error closing receiver")
+	}
+
+	outputdir := filepath.Join(cfg.WriterFilePath, "dynamic_capability", "year=2024", "month=01", "day=02", "hour=10")
+	files, err := os.ReadDir(outputdir)
+
+	if err != nil || len(files) != 1 {
+		t.Errorf("Expected one file on %s, got %d (%v)", outputdir, len(files), err)
+	}
+}
+
+func TestReceiverJSONSchema(t *testing.T) {
+	cfg := PrepareConfig()
+	cfg.RecordType = config.RecordTypeDynamic
+	cfg.UseDLQ = true
+	cfg.WriterFilePath = t.TempDir()
+	cfg.JsonSchemaPath = filepath.Join(t.TempDir(), "schema.json")
+
+	schema := `{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"type": "object",
+		"required": ["time", "capability", "count"],
+		"properties": {
+			"time": {"type": "string", "format": "date-time"},
+			"capability": {"type": "string"},
+			"count": {"type": "integer", "minimum": 0},
+			"tags": {"type": "array", "items": {"type": "string"}}
+		}
+	}`
+
+	err := os.WriteFile(cfg.JsonSchemaPath, []byte(schema), 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := receiver.NewReceiver(context.Background(), cfg)
+
+	if rec == nil {
+		t.Fatal("Receiver is nil")
+	}
+
+	for i := 0; i < 10; i++ {
+		err := rec.Write(domain.NewRecord(cfg.RecordType, map[string]interface{}{
+			"time":       "2024-01-02T10:30:00Z",
+			"capability": "dynamic_capability",
+			"count":      i,
+			"tags":       []interface{}{"a", "b"},
+		}))
+
+		if err != nil {
+			t.Errorf("Error writing valid record: %v", err)
+		}
+	}
+
+	err = rec.Write(domain.NewRecord(cfg.RecordType, map[string]interface{}{
+		"time":       "yesterday",
+		"capability": "dynamic_capability",
+		"count":      -1,
+	}))
+
+	if !errors.Is(err, receiver.ErrSchemaViolation) {
+		t.Errorf("Expected schema violation, got %v", err)
+	}
+
+	time.Sleep(1 * time.Second)
+
+	err = rec.Flush()
+
+	if err != nil {
+		t.Error("Error flushing data")
+	}
+
+	err = rec.Close()
+
+	if err != nil {
+		t.Error("Error closing receiver")
+	}
+
+	outputdir := filepath.Join(cfg.WriterFilePath, "dynamic_capability", "year=2024", "month=01", "day=02", "hour=10")
+	files, err := os.ReadDir(outputdir)
+
+	if err != nil || len(files) != 1 {
+		t.Errorf("Expected one file on %s, got %d (%v)", outputdir, len(files), err)
+	}
+}
+
+func TestReceiverSchemaRegistry(t *testing.T) {
+	cfg := PrepareConfig()
+	cfg.RecordType = config.RecordTypeDynamic
+	cfg.SchemaRegistryType = config.SchemaRegistryTypeLocal
+	cfg.SchemaRegistryPath = t.TempDir()
+	cfg.SchemaRegistryCacheTTL = 60
+	cfg.WriterFilePath = t.TempDir()
+
+	dir := filepath.Join(cfg.SchemaRegistryPath, "registry_capability", "registry_domain", "registry_service", "registry_application")
+	versions := map[string]string{
+		"v1.json": `{"type": "object", "required": ["time", "count"], "properties": {"time": {"type": "string", "format": "date-time"}, "count": {"type": "integer"}}}`,
+		"v2.json": `{"type": "object", "required": ["time", "count"], "properties": {"time": {"type": "string", "format": "date-time"}, "count": {"type": "integer"}, "ratio": {"type": "number"}}}`,
+	}
+
+	err := os.MkdirAll(dir, os.ModePerm)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, schema := range versions {
+		err = os.WriteFile(filepath.Join(dir, name), []byte(schema), 0644)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := receiver.NewReceiver(context.Background(), cfg)
+
+	if rec == nil {
+		t.Fatal("Receiver is nil")
+	}
+
+	for i := 0; i < 10; i++ {
+		for _, capability := range []string{"registry_capability", "other_capability"} {
+			err := rec.Write(domain.NewRecord(cfg.RecordType, map[string]interface{}{
+				"time":        "2024-01-02T10:30:00Z",
+				"capability":  capability,
+				"domain":      "registry_domain",
+				"service":     "registry_service",
+				"application": "registry_application",
+				"count":       i,
+				"ratio":       float64(i) / 3,
+			}))
+
+			if err != nil {
+				t.Errorf("Error writing record: %v", err)
+			}
+		}
+	}
+
+	err = rec.Write(domain.NewRecord(cfg.RecordType, map[string]interface{}{
+		"time":        "2024-01-02T10:30:00Z",
+		"capability":  "registry_capability",
+		"domain":      "registry_domain",
+		"service":     "registry_service",
+		"application": "registry_application",
+		"count":       "ten",
+	}))
+
+	if !errors.Is(err, receiver.ErrSchemaViolation) {
+		t.Errorf("Expected schema violation, got %v", err)
+	}
+
+	time.Sleep(1 * time.Second)
+
+	err = rec.Flush()
+
+	if err != nil {
+		t.Error("Error flushing data")
+	}
+
+	err = rec.Close()
+
+	if err != nil {
+		t.Error("Error closing receiver")
+	}
+
+	for _, capability := range []string{"registry_capability", "other_capability"} {
+		outputdir := filepath.Join(cfg.WriterFilePath, capability, "year=2024", "month=01", "day=02", "hour=10")
+		files, err := os.ReadDir(outputdir)
+
+		if err != nil || len(files) != 1 {
+			t.Errorf("Expected one file on %s, got %d (%v)", outputdir, len(files), err)
+		}
+	}
+}
+
+func TestReceiverDynamicKeyFields(t *testing.T) {
+	cfg := PrepareConfig()
+	cfg.RecordType = config.RecordTypeDynamic
+	cfg.DynamicKeyFields = "$.resource.team|no_team, resource.labels['app.name'], $.tags[0]|untagged"
+	cfg.WriterFilePath = t.TempDir()
+	cfg.SetDefaults()
+
+	t.Cleanup(func() {
+		config.DynamicKeyFields = config.DefaultDynamicKeyFields
+	})
+
+	rec := receiver.NewReceiver(context.Background(), cfg)
+
+	if rec == nil {
+		t.Fatal("Receiver is nil")
+	}
+
+	records := []map[string]interface{}{
+		{"time": "2024-01-02T10:30:00Z", "resource": map[string]interface{}{"team": "payments", "labels": map[string]interface{}{"app.name": "checkout"}}, "tags": []interface{}{"blue"}},
+		{"time": "2024-01-02T10:40:00Z", "resource": map[string]interface{}{"labels": map[string]interface{}{"app.name": "checkout"}}},
+	}
+
+	expected := []string{"dynamic:payments:checkout:blue", "dynamic:no_team:checkout:untagged"}
+
+	for i, data := range records {
+		record := domain.NewRecord(cfg.RecordType, data)
+
+		if record.Key() != expected[i] {
+			t.Errorf("Expected key %s, got %s", expected[i], record.Key())
+		}
+
+		info := domain.NewRecordInfoFromKey(cfg.RecordType, domain.PartitionKey(record.Key(), time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC), nil))
+
+		if info.Key() != expected[i] || info.RecordType() != config.RecordTypeDynamic || info.Domain() != "checkout" || info.Partition().Hour() != 10 {
+			t.Errorf("Unexpected info from key %s: %s, %s, %s, %v", expected[i], info.Key(), info.RecordType(), info.Domain(), info.Partition())
+		}
+
+		err := rec.Write(record)
+
+		if err != nil {
+			t.Errorf("Error writing record: %v", err)
+		}
+	}
+
+	time.Sleep(1 * time.Second)
+
+	err := rec.Flush()
+
+	if err != nil {
+		t.Error("Error flushing data")
+	}
+
+	err = rec.Close()
+
+	if err != nil {
+		t.Error("Error closing receiver")
+	}
+
+	for _, team := range []string{"payments", "no_team"} {
+		outputdir := filepath.Join(cfg.WriterFilePath, team, "year=2024", "month=01", "day=02", "hour=10")
+		files, err := os.ReadDir(outputdir)
+
+		if err != nil || len(files) != 1 {
+			t.Errorf("Expected one file on %s, got %d (%v)", outputdir, len(files), err)
+		}
+	}
+
+	cfg.DynamicKeyFields = "resource[team"
+
+	if receiver.NewReceiver(context.Background(), cfg) != nil {
+		t.Error("Expected nil receiver for an invalid key field")
+	}
+}
+
+func TestReceiverStreamingFlush(t *testing.T) {
+	cfg := PrepareConfig()
+	cfg.WriterFilePath = t.TempDir()
+	cfg.BufferSize = 500
+	cfg.BufferPageSize = 30
+	cfg.UseHash = true
+	rec := receiver.NewReceiver(context.Background(), cfg)
+
+	if rec == nil {
+		t.Fatal("Receiver is nil")
+	}
+
+	data := generateData(500)
+
+	for i, d := range data {
+		line := d.(*domain.Log)
+		line.Time = "2024-01-02T10:30:00Z"
+
+		if i%2 == 1 {
+			line.Time = "2024-01-02T11:15:00Z"
+		}
+
+		err := rec.Write(line)
+
+		if err != nil {
+			t.Error("Error writing data")
+		}
+	}
+
+	time.Sleep(1 * time.Second)
+
+	err := rec.Flush()
+
+	if err != nil {
+		t.Error("Error flushing data")
+	}
+
+	err = rec.Close()
+
+	if err != nil {
+		t.Error("Error closing receiver")
+	}
+
+	for _, hour := range []string{"10", "11"} {
+		outputdir := filepath.Join(cfg.WriterFilePath, "capability=business_capability", "year=2024", "month=01", "day=02", "hour="+hour)
+		files, err := os.ReadDir(outputdir)
+
+		if err != nil || len(files) == 0 {
+			t.Fatalf("Expected files on partition %s (%v)", outputdir, err)
+		}
+
+		rows := int64(0)
+
+		for _, file := range files {
+			fr, err := local.NewLocalFileReader(filepath.Join(outputdir, file.Name()))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pr, err := reader.NewParquetReader(fr, nil, 1)
+
+			if err != nil {
+				t.Fatalf("Invalid parquet file %s: %v", file.Name(), err)
+			}
+
+			rows += pr.GetNumRows()
+			pr.ReadStop()
+			fr.Close()
+		}
+
+		if rows != 250 {
+			t.Errorf("Expected 250 rows on partition %s, got %d", outputdir, rows)
+		}
+	}
+}
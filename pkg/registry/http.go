@@ -0,0 +1,110 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"data2parquet/pkg/config"
+	"data2parquet/pkg/domain"
+)
+
+// HTTP reads schemas from a registry service with a Confluent like API, answering
+// `GET {url}/subjects/{subject}/versions/{version|latest}` with `{"subject": "...", "version": 1, "schema": "..."}`.
+type HTTP struct {
+	config *config.Config
+	ctx    context.Context
+	url    string
+	client *http.Client
+}
+
+type httpSchema struct {
+	Subject string `json:"subject"`
+	Version int    `json:"version"`
+	Schema  string `json:"schema"`
+}
+
+func NewHTTP(ctx context.Context, config *config.Config) Registry {
+	ret := &HTTP{
+		config: config,
+		ctx:    ctx,
+		url:    strings.TrimRight(config.SchemaRegistryURL, "/"),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+
+	if len(ret.url) == 0 {
+		slog.Error("Schema registry URL is empty", "module", "registry.http", "function", "NewHTTP")
+		return nil
+	}
+
+	return ret
+}
+
+func (h *HTTP) Latest(info domain.RecordInfo) (*Schema, error) {
+	return h.fetch(info, "latest")
+}
+
+func (h *HTTP) Get(info domain.RecordInfo, version int) (*Schema, error) {
+	return h.fetch(info, fmt.Sprint(version))
+}
+
+func (h *HTTP) fetch(info domain.RecordInfo, version string) (*Schema, error) {
+	subject := Subject(info)
+	target := fmt.Sprintf("%s/subjects/%s/versions/%s", h.url, url.PathEscape(subject), version)
+
+	req, err := http.NewRequestWithContext(h.ctx, http.MethodGet, target, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := h.client.Do(req)
+
+	if err != nil {
+		slog.Error("Error requesting schema", "error", err, "url", target, "module", "registry.http", "function", "fetch")
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+
+	body, err := io.ReadAll(resp.Body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		slog.Error("Unexpected schema registry response", "status", resp.StatusCode, "body", string(body), "url", target, "module", "registry.http", "function", "fetch")
+		return nil, fmt.Errorf("schema registry returned %d for %s", resp.StatusCode, target)
+	}
+
+	ret := &httpSchema{}
+
+	if err := json.Unmarshal(body, ret); err != nil {
+		return nil, fmt.Errorf("invalid schema registry response for %s: %w", target, err)
+	}
+
+	if ret.Version < 1 || len(ret.Schema) == 0 {
+		return nil, fmt.Errorf("schema registry response for %s has no version or schema", target)
+	}
+
+	return &Schema{Subject: subject, Version: ret.Version, Data: []byte(ret.Schema)}, nil
+}
+
+func (h *HTTP) Close() error {
+	h.client.CloseIdleConnections()
+	return nil
+}
+
+func (h *HTTP) IsReady() bool {
+	return true
+}
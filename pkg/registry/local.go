@@ -0,0 +1,110 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"data2parquet/pkg/config"
+	"data2parquet/pkg/domain"
+)
+
+// Local reads schemas from a directory with one folder for each key and one file for each version, as
+// `capability/domain/service/application/v1.json`.
+type Local struct {
+	config *config.Config
+	ctx    context.Context
+	path   string
+}
+
+func NewLocal(ctx context.Context, config *config.Config) Registry {
+	ret := &Local{
+		config: config,
+		ctx:    ctx,
+		path:   config.SchemaRegistryPath,
+	}
+
+	info, err := os.Stat(ret.path)
+
+	if err != nil || !info.IsDir() {
+		slog.Error("Schema registry path is not a directory", "error", err, "path", ret.path, "module", "registry.local", "function", "NewLocal")
+		return nil
+	}
+
+	return ret
+}
+
+func (l *Local) dir(info domain.RecordInfo) string {
+	parts := []string{l.path}
+
+	for _, part := range []string{info.Capability(), info.Domain(), info.Service(), info.Application()} {
+		parts = append(parts, strings.NewReplacer("/", "_", "\\", "_", "..", "_").Replace(part))
+	}
+
+	return filepath.Join(parts...)
+}
+
+func (l *Local) Latest(info domain.RecordInfo) (*Schema, error) {
+	entries, err := os.ReadDir(l.dir(info))
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+
+	if err != nil {
+		slog.Error("Error reading schema registry directory", "error", err, "path", l.dir(info), "module", "registry.local", "function", "Latest")
+		return nil, err
+	}
+
+	latest := 0
+
+	for _, entry := range entries {
+		var version int
+
+		if entry.IsDir() {
+			continue
+		}
+
+		if _, err := fmt.Sscanf(entry.Name(), "v%d.json", &version); err != nil || entry.Name() != fmt.Sprintf("v%d.json", version) {
+			continue
+		}
+
+		if version > latest {
+			latest = version
+		}
+	}
+
+	if latest == 0 {
+		return nil, ErrNotFound
+	}
+
+	return l.Get(info, latest)
+}
+
+func (l *Local) Get(info domain.RecordInfo, version int) (*Schema, error) {
+	file := filepath.Join(l.dir(info), fmt.Sprintf("v%d.json", version))
+	data, err := os.ReadFile(file)
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+
+	if err != nil {
+		slog.Error("Error reading schema file", "error", err, "file", file, "module", "registry.local", "function", "Get")
+		return nil, err
+	}
+
+	return &Schema{Subject: Subject(info), Version: version, Data: data}, nil
+}
+
+func (l *Local) Close() error {
+	return nil
+}
+
+func (l *Local) IsReady() bool {
+	return true
+}
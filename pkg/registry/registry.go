@@ -0,0 +1,51 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"data2parquet/pkg/config"
+	"data2parquet/pkg/domain"
+	"data2parquet/pkg/logger" // "log/slog"
+)
+
+var slog = logger.GetLogger()
+
+// ErrNotFound is returned when the registry has no schema, or no such version, for a key.
+var ErrNotFound = errors.New("schema not found")
+
+// Schema is a version of the JSON Schema document published for the records of a key.
+type Schema struct {
+	Subject string
+	Version int
+	Data    []byte
+}
+
+// Registry finds the versioned schemas of dynamic records, by capability, domain, service and application.
+type Registry interface {
+	Latest(info domain.RecordInfo) (*Schema, error)
+	Get(info domain.RecordInfo, version int) (*Schema, error)
+	Close() error
+	IsReady() bool
+}
+
+func New(ctx context.Context, cfg *config.Config) Registry {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	switch cfg.SchemaRegistryType {
+	case config.SchemaRegistryTypeLocal:
+		return NewLocal(ctx, cfg)
+	case config.SchemaRegistryTypeHTTP:
+		return NewHTTP(ctx, cfg)
+	default:
+		return nil
+	}
+}
+
+// Subject is the name of the schemas of a key, the record key without its partition.
+func Subject(info domain.RecordInfo) string {
+	return strings.Join([]string{info.Capability(), info.Domain(), info.Service(), info.Application()}, domain.KeySeparator)
+}
@@ -0,0 +1,634 @@
+package writer
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// Avro object container files, as used by Iceberg manifests and manifest lists. Records are
+// map[string]interface{}, arrays []interface{}, ints and longs int64 and bytes and fixed []byte.
+
+var avroMagic = []byte{'O', 'b', 'j', 1}
+
+type avroSchema struct {
+	Type    string
+	Name    string
+	Fields  []*avroField
+	Items   *avroSchema
+	Values  *avroSchema
+	Union   []*avroSchema
+	Size    int
+	Symbols []string
+}
+
+type avroField struct {
+	Name    string
+	FieldID int
+	Type    *avroSchema
+}
+
+func parseAvroSchema(data string) (*avroSchema, error) {
+	var value interface{}
+
+	if err := json.Unmarshal([]byte(data), &value); err != nil {
+		return nil, fmt.Errorf("invalid avro schema: %w", err)
+	}
+
+	return parseAvroType(value, make(map[string]*avroSchema), "")
+}
+
+func parseAvroType(value interface{}, named map[string]*avroSchema, namespace string) (*avroSchema, error) {
+	switch v := value.(type) {
+	case string:
+		switch v {
+		case "null", "boolean", "int", "long", "float", "double", "bytes", "string":
+			return &avroSchema{Type: v}, nil
+		}
+
+		if ret, ok := named[v]; ok {
+			return ret, nil
+		}
+
+		if ret, ok := named[namespace+"."+v]; ok {
+			return ret, nil
+		}
+
+		return nil, fmt.Errorf("unknown avro type %q", v)
+	case []interface{}:
+		ret := &avroSchema{Type: "union"}
+
+		for _, branch := range v {
+			t, err := parseAvroType(branch, named, namespace)
+
+			if err != nil {
+				return nil, err
+			}
+
+			ret.Union = append(ret.Union, t)
+		}
+
+		return ret, nil
+	case map[string]interface{}:
+		kind, _ := v["type"].(string)
+		ret := &avroSchema{Type: kind}
+		ret.Name, _ = v["name"].(string)
+
+		if ns, ok := v["namespace"].(string); ok {
+			namespace = ns
+		}
+
+		switch kind {
+		case "record", "error":
+			ret.Type = "record"
+			named[ret.Name] = ret
+			named[namespace+"."+ret.Name] = ret
+			fields, _ := v["fields"].([]interface{})
+
+			for _, f := range fields {
+				field, _ := f.(map[string]interface{})
+				name, _ := field["name"].(string)
+				t, err := parseAvroType(field["type"], named, namespace)
+
+				if err != nil {
+					return nil, fmt.Errorf("field %s: %w", name, err)
+				}
+
+				id := -1
+
+				if n, ok := field["field-id"].(float64); ok {
+					id = int(n)
+				}
+
+				ret.Fields = append(ret.Fields, &avroField{Name: name, FieldID: id, Type: t})
+			}
+		case "array":
+			items, err := parseAvroType(v["items"], named, namespace)
+
+			if err != nil {
+				return nil, err
+			}
+
+			ret.Items = items
+		case "map":
+			values, err := parseAvroType(v["values"], named, namespace)
+
+			if err != nil {
+				return nil, err
+			}
+
+			ret.Values = values
+		case "fixed":
+			size, _ := v["size"].(float64)
+			ret.Size = int(size)
+			named[ret.Name] = ret
+			named[namespace+"."+ret.Name] = ret
+		case "enum":
+			symbols, _ := v["symbols"].([]interface{})
+
+			for _, s := range symbols {
+				symbol, _ := s.(string)
+				ret.Symbols = append(ret.Symbols, symbol)
+			}
+
+			named[ret.Name] = ret
+			named[namespace+"."+ret.Name] = ret
+		default:
+			// Primitives with attributes, as {"type": "long", "logicalType": "timestamp-micros"}.
+			return parseAvroType(kind, named, namespace)
+		}
+
+		return ret, nil
+	}
+
+	return nil, fmt.Errorf("invalid avro type %v", value)
+}
+
+// writeAvro encodes records in an object container file, schema is kept as the writer schema with its attributes.
+func writeAvro(schema string, meta map[string]string, records []interface{}) ([]byte, error) {
+	parsed, err := parseAvroSchema(schema)
+
+	if err != nil {
+		return nil, err
+	}
+
+	block := &bytes.Buffer{}
+
+	for _, record := range records {
+		if err = encodeAvro(block, parsed, record); err != nil {
+			return nil, err
+		}
+	}
+
+	sync := make([]byte, 16)
+
+	if _, err = rand.Read(sync); err != nil {
+		return nil, err
+	}
+
+	ret := &bytes.Buffer{}
+	ret.Write(avroMagic)
+
+	header := map[string]interface{}{"avro.schema": []byte(schema), "avro.codec": []byte("null")}
+
+	for k, v := range meta {
+		header[k] = []byte(v)
+	}
+
+	if err = encodeAvro(ret, &avroSchema{Type: "map", Values: &avroSchema{Type: "bytes"}}, header); err != nil {
+		return nil, err
+	}
+
+	ret.Write(sync)
+
+	if len(records) > 0 {
+		writeAvroLong(ret, int64(len(records)))
+		writeAvroLong(ret, int64(block.Len()))
+		ret.Write(block.Bytes())
+		ret.Write(sync)
+	}
+
+	return ret.Bytes(), nil
+}
+
+// readAvro decodes an object container file written with the null or deflate codecs.
+func readAvro(data []byte) (*avroSchema, map[string][]byte, []interface{}, error) {
+	if !bytes.HasPrefix(data, avroMagic) {
+		return nil, nil, nil, errors.New("not an avro object container file")
+	}
+
+	r := bytes.NewReader(data[len(avroMagic):])
+	header, err := decodeAvro(r, &avroSchema{Type: "map", Values: &avroSchema{Type: "bytes"}})
+
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid avro header: %w", err)
+	}
+
+	meta := make(map[string][]byte)
+
+	for k, v := range header.(map[string]interface{}) {
+		meta[k] = v.([]byte)
+	}
+
+	schema, err := parseAvroSchema(string(meta["avro.schema"]))
+
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	codec := string(meta["avro.codec"])
+
+	if codec != "" && codec != "null" && codec != "deflate" {
+		return nil, nil, nil, fmt.Errorf("unsupported avro codec %s", codec)
+	}
+
+	sync := make([]byte, 16)
+
+	if _, err = io.ReadFull(r, sync); err != nil {
+		return nil, nil, nil, err
+	}
+
+	records := make([]interface{}, 0)
+
+	for r.Len() > 0 {
+		count, err := readAvroLong(r)
+
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		size, err := readAvroLong(r)
+
+		if err != nil || size < 0 || size > int64(r.Len()) {
+			return nil, nil, nil, errors.New("invalid avro block")
+		}
+
+		block := make([]byte, size)
+		io.ReadFull(r, block)
+
+		if codec == "deflate" {
+			if block, err = io.ReadAll(flate.NewReader(bytes.NewReader(block))); err != nil {
+				return nil, nil, nil, fmt.Errorf("invalid deflate avro block: %w", err)
+			}
+		}
+
+		br := bytes.NewReader(block)
+
+		for i := int64(0); i < count; i++ {
+			record, err := decodeAvro(br, schema)
+
+			if err != nil {
+				return nil, nil, nil, err
+			}
+
+			records = append(records, record)
+		}
+
+		marker := make([]byte, 16)
+
+		if _, err = io.ReadFull(r, marker); err != nil || !bytes.Equal(marker, sync) {
+			return nil, nil, nil, errors.New("invalid avro sync marker")
+		}
+	}
+
+	return schema, meta, records, nil
+}
+
+func encodeAvro(w *bytes.Buffer, schema *avroSchema, value interface{}) error {
+	switch schema.Type {
+	case "null":
+		return nil
+	case "boolean":
+		if b, _ := value.(bool); b {
+			w.WriteByte(1)
+		} else {
+			w.WriteByte(0)
+		}
+	case "int", "long":
+		n, ok := avroInt(value)
+
+		if !ok {
+			return fmt.Errorf("invalid avro %s %v", schema.Type, value)
+		}
+
+		writeAvroLong(w, n)
+	case "float":
+		f, _ := value.(float64)
+		binary.Write(w, binary.LittleEndian, math.Float32bits(float32(f)))
+	case "double":
+		f, _ := value.(float64)
+		binary.Write(w, binary.LittleEndian, math.Float64bits(f))
+	case "bytes":
+		b, _ := value.([]byte)
+		writeAvroLong(w, int64(len(b)))
+		w.Write(b)
+	case "string":
+		s, _ := value.(string)
+		writeAvroLong(w, int64(len(s)))
+		w.WriteString(s)
+	case "fixed":
+		b, _ := value.([]byte)
+
+		if len(b) != schema.Size {
+			return fmt.Errorf("invalid avro fixed of %d bytes, got %d", schema.Size, len(b))
+		}
+
+		w.Write(b)
+	case "enum":
+		s, _ := value.(string)
+
+		for i, symbol := range schema.Symbols {
+			if symbol == s {
+				writeAvroLong(w, int64(i))
+				return nil
+			}
+		}
+
+		return fmt.Errorf("invalid avro enum %q", s)
+	case "record":
+		record, _ := value.(map[string]interface{})
+
+		for _, field := range schema.Fields {
+			if err := encodeAvro(w, field.Type, record[field.Name]); err != nil {
+				return fmt.Errorf("%s: %w", field.Name, err)
+			}
+		}
+	case "array":
+		items, _ := value.([]interface{})
+
+		if len(items) > 0 {
+			writeAvroLong(w, int64(len(items)))
+
+			for _, item := range items {
+				if err := encodeAvro(w, schema.Items, item); err != nil {
+					return err
+				}
+			}
+		}
+
+		w.WriteByte(0)
+	case "map":
+		values, _ := value.(map[string]interface{})
+		keys := make([]string, 0, len(values))
+
+		for k := range values {
+			keys = append(keys, k)
+		}
+
+		sort.Strings(keys)
+
+		if len(keys) > 0 {
+			writeAvroLong(w, int64(len(keys)))
+
+			for _, k := range keys {
+				encodeAvro(w, &avroSchema{Type: "string"}, k)
+
+				if err := encodeAvro(w, schema.Values, values[k]); err != nil {
+					return err
+				}
+			}
+		}
+
+		w.WriteByte(0)
+	case "union":
+		for i, branch := range schema.Union {
+			if (value == nil) == (branch.Type == "null") {
+				writeAvroLong(w, int64(i))
+				return encodeAvro(w, branch, value)
+			}
+		}
+
+		return fmt.Errorf("no avro union branch for %v", value)
+	default:
+		return fmt.Errorf("unsupported avro type %s", schema.Type)
+	}
+
+	return nil
+}
+
+func avroInt(value interface{}) (int64, bool) {
+	switch n := value.(type) {
+	case int:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	}
+
+	return 0, false
+}
+
+func decodeAvro(r *bytes.Reader, schema *avroSchema) (interface{}, error) {
+	switch schema.Type {
+	case "null":
+		return nil, nil
+	case "boolean":
+		b, err := r.ReadByte()
+		return b == 1, err
+	case "int", "long":
+		return readAvroLong(r)
+	case "float":
+		var bits uint32
+		err := binary.Read(r, binary.LittleEndian, &bits)
+		return float64(math.Float32frombits(bits)), err
+	case "double":
+		var bits uint64
+		err := binary.Read(r, binary.LittleEndian, &bits)
+		return math.Float64frombits(bits), err
+	case "bytes", "string":
+		size, err := readAvroLong(r)
+
+		if err != nil || size < 0 || size > int64(r.Len()) {
+			return nil, errors.New("invalid avro bytes")
+		}
+
+		b := make([]byte, size)
+		io.ReadFull(r, b)
+
+		if schema.Type == "string" {
+			return string(b), nil
+		}
+
+		return b, nil
+	case "fixed":
+		b := make([]byte, schema.Size)
+		_, err := io.ReadFull(r, b)
+		return b, err
+	case "enum":
+		i, err := readAvroLong(r)
+
+		if err != nil || i < 0 || int(i) >= len(schema.Symbols) {
+			return nil, errors.New("invalid avro enum")
+		}
+
+		return schema.Symbols[i], nil
+	case "record":
+		ret := make(map[string]interface{}, len(schema.Fields))
+
+		for _, field := range schema.Fields {
+			value, err := decodeAvro(r, field.Type)
+
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", field.Name, err)
+			}
+
+			ret[field.Name] = value
+		}
+
+		return ret, nil
+	case "array", "map":
+		items := make([]interface{}, 0)
+		values := make(map[string]interface{})
+
+		for {
+			count, err := readAvroLong(r)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if count == 0 {
+				break
+			}
+
+			// Negative counts are followed by the size of the block in bytes.
+			if count < 0 {
+				count = -count
+
+				if _, err = readAvroLong(r); err != nil {
+					return nil, err
+				}
+			}
+
+			for i := int64(0); i < count; i++ {
+				if schema.Type == "array" {
+					item, err := decodeAvro(r, schema.Items)
+
+					if err != nil {
+						return nil, err
+					}
+
+					items = append(items, item)
+					continue
+				}
+
+				key, err := decodeAvro(r, &avroSchema{Type: "string"})
+
+				if err != nil {
+					return nil, err
+				}
+
+				if values[key.(string)], err = decodeAvro(r, schema.Values); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		if schema.Type == "array" {
+			return items, nil
+		}
+
+		return values, nil
+	case "union":
+		i, err := readAvroLong(r)
+
+		if err != nil || i < 0 || int(i) >= len(schema.Union) {
+			return nil, errors.New("invalid avro union")
+		}
+
+		return decodeAvro(r, schema.Union[i])
+	}
+
+	return nil, fmt.Errorf("unsupported avro type %s", schema.Type)
+}
+
+// convertAvro converts a value read with the from schema to the to schema, matching record fields by their Iceberg
+// field id or, without ids, by name. Missing fields are null, or zero when they are not optional.
+func convertAvro(from *avroSchema, to *avroSchema, value interface{}) interface{} {
+	if to.Type == "union" {
+		if value == nil {
+			return nil
+		}
+
+		for _, branch := range to.Union {
+			if branch.Type != "null" {
+				return convertAvro(unwrapAvro(from), branch, value)
+			}
+		}
+	}
+
+	switch to.Type {
+	case "record":
+		record, _ := value.(map[string]interface{})
+		from = unwrapAvro(from)
+		ret := make(map[string]interface{}, len(to.Fields))
+
+		for _, field := range to.Fields {
+			var source *avroField
+
+			for _, f := range from.Fields {
+				if field.FieldID >= 0 && f.FieldID == field.FieldID {
+					source = f
+					break
+				}
+
+				if source == nil && f.Name == field.Name {
+					source = f
+				}
+			}
+
+			if source == nil || record[source.Name] == nil {
+				ret[field.Name] = zeroAvro(field.Type)
+				continue
+			}
+
+			ret[field.Name] = convertAvro(source.Type, field.Type, record[source.Name])
+		}
+
+		return ret
+	case "array":
+		items, _ := value.([]interface{})
+		from = unwrapAvro(from)
+		ret := make([]interface{}, 0, len(items))
+
+		for _, item := range items {
+			ret = append(ret, convertAvro(from.Items, to.Items, item))
+		}
+
+		return ret
+	}
+
+	return value
+}
+
+func unwrapAvro(schema *avroSchema) *avroSchema {
+	if schema.Type != "union" {
+		return schema
+	}
+
+	for _, branch := range schema.Union {
+		if branch.Type != "null" {
+			return branch
+		}
+	}
+
+	return schema
+}
+
+func zeroAvro(schema *avroSchema) interface{} {
+	switch schema.Type {
+	case "boolean":
+		return false
+	case "int", "long":
+		return int64(0)
+	case "float", "double":
+		return float64(0)
+	case "string":
+		return ""
+	case "bytes":
+		return []byte{}
+	case "array":
+		return []interface{}{}
+	case "record":
+		return convertAvro(schema, schema, map[string]interface{}{})
+	}
+
+	return nil
+}
+
+func writeAvroLong(w *bytes.Buffer, n int64) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	w.Write(buf[:binary.PutVarint(buf, n)])
+}
+
+func readAvroLong(r *bytes.Reader) (int64, error) {
+	return binary.ReadVarint(r)
+}
@@ -3,26 +3,84 @@ package writer
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	awsConfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
 
 	"data2parquet/pkg/config"
 	"data2parquet/pkg/domain"
 )
 
+// s3MinPartSize is the minimum size S3 accepts on all parts of a multipart upload but the last.
+const s3MinPartSize = 5 << 20
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
 type S3 struct {
-	config *config.Config
-	client *s3.Client
-	ctx    context.Context
+	config       *config.Config
+	client       *s3.Client
+	ctx          context.Context
+	template     *PathTemplate
+	partSize     int64
+	threshold    int64
+	concurrency  int
+	checksum     string
+	tags         []objectAttribute
+	meta         []objectAttribute
+	storageClass types.StorageClass
+	encryption   types.ServerSideEncryption
+	kmsKeyID     *string
+	sseCustomer  sseCustomerKey
+	table        table
+	tablePath    string
+	status       writerStatus
+	parts        sync.Pool
+}
+
+// s3Object is a file being written, with the attributes rendered for it.
+type s3Object struct {
+	key      string
+	tagging  *string
+	metadata map[string]string
+}
+
+// objectAttribute is a tag or metadata entry, its value is a template rendered for each object.
+type objectAttribute struct {
+	name  string
+	value *PathTemplate
+}
+
+type sseCustomerKey struct {
+	algorithm *string
+	key       *string
+	md5       *string
 }
 
 func NewS3(ctx context.Context, config *config.Config) Writer {
@@ -31,8 +89,52 @@ func NewS3(ctx context.Context, config *config.Config) Writer {
 	}
 
 	ret := &S3{
-		config: config,
-		ctx:    ctx,
+		config:      config,
+		ctx:         ctx,
+		partSize:    config.S3PartSize,
+		threshold:   config.S3MultipartThreshold,
+		concurrency: config.S3UploadConcurrency,
+		checksum:    config.S3ChecksumAlgorithm,
+	}
+
+	if ret.partSize < s3MinPartSize {
+		ret.partSize = 8 << 20
+	}
+
+	if ret.threshold < 1 {
+		ret.threshold = 16 << 20
+	}
+
+	if ret.concurrency < 1 {
+		ret.concurrency = 4
+	}
+
+	// Part buffers are reused between parts and files, each write only allocates the ones in flight at the same time.
+	ret.parts.New = func() any {
+		part := make([]byte, ret.partSize)
+		return &part
+	}
+
+	if err := ret.setObjectOptions(); err != nil {
+		slog.Error("Invalid S3 object options", "error", err, "module", "writer.s3", "function", "NewS3")
+		return nil
+	}
+
+	if len(config.WriterPathTemplate) > 0 {
+		template, err := NewPathTemplate(config.WriterPathTemplate)
+
+		if err != nil {
+			slog.Error("Invalid writer path template", "error", err, "module", "writer.s3", "function", "NewS3")
+			return nil
+		}
+
+		ret.template = template
+	}
+
+	store := &s3Store{s3: ret, prefix: strings.Trim(config.WriterTablePath, "/")}
+
+	if ret.table = newTable(config, store); ret.table != nil {
+		ret.tablePath = store.prefix
 	}
 
 	slog.Info("Creating S3 writer")
@@ -40,6 +142,133 @@ func NewS3(ctx context.Context, config *config.Config) Writer {
 	return ret
 }
 
+// setObjectOptions validates and prepares the encryption, storage class, tags and metadata set on each object.
+func (s *S3) setObjectOptions() error {
+	var err error
+
+	if s.tags, err = parseAttributes(s.config.S3Tags); err != nil {
+		return fmt.Errorf("invalid S3Tags: %w", err)
+	}
+
+	if len(s.tags) > 10 {
+		return fmt.Errorf("invalid S3Tags: S3 accepts up to 10 tags, got %d", len(s.tags))
+	}
+
+	if s.meta, err = parseAttributes(s.config.S3Metadata); err != nil {
+		return fmt.Errorf("invalid S3Metadata: %w", err)
+	}
+
+	if len(s.config.S3StorageClass) > 0 {
+		s.storageClass = types.StorageClass(strings.ToUpper(s.config.S3StorageClass))
+		valid := false
+
+		for _, class := range s.storageClass.Values() {
+			valid = valid || class == s.storageClass
+		}
+
+		if !valid {
+			return fmt.Errorf("invalid S3StorageClass %q", s.config.S3StorageClass)
+		}
+	}
+
+	switch strings.ToLower(s.config.S3ServerSideEncryption) {
+	case "", config.S3ServerSideEncryptionNone:
+	case config.S3ServerSideEncryptionS3:
+		s.encryption = types.ServerSideEncryptionAes256
+	case config.S3ServerSideEncryptionKMS:
+		s.encryption = types.ServerSideEncryptionAwsKms
+
+		if len(s.config.S3KMSKeyID) > 0 {
+			s.kmsKeyID = aws.String(s.config.S3KMSKeyID)
+		}
+	case config.S3ServerSideEncryptionCustomer:
+		key, err := base64.StdEncoding.DecodeString(s.config.S3SSECustomerKey)
+
+		if err != nil || len(key) != 32 {
+			return errors.New("S3ServerSideEncryption is sse-c but S3SSECustomerKey is not a base64 encoded 256 bits key")
+		}
+
+		sum := md5.Sum(key)
+		s.sseCustomer = sseCustomerKey{
+			algorithm: aws.String("AES256"),
+			key:       aws.String(s.config.S3SSECustomerKey),
+			md5:       aws.String(base64.StdEncoding.EncodeToString(sum[:])),
+		}
+	default:
+		return fmt.Errorf("invalid S3ServerSideEncryption %q, use none, sse-s3, sse-kms or sse-c", s.config.S3ServerSideEncryption)
+	}
+
+	return nil
+}
+
+// parseAttributes parses a comma separated list of `name=value` templates.
+func parseAttributes(value string) ([]objectAttribute, error) {
+	ret := make([]objectAttribute, 0)
+
+	for _, item := range strings.Split(value, ",") {
+		if len(strings.TrimSpace(item)) == 0 {
+			continue
+		}
+
+		name, text, found := strings.Cut(item, "=")
+		name = strings.TrimSpace(name)
+
+		if !found || len(name) == 0 {
+			return nil, fmt.Errorf("%q must be name=value", item)
+		}
+
+		template, err := NewValueTemplate(strings.TrimSpace(text))
+
+		if err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, objectAttribute{name: name, value: template})
+	}
+
+	return ret, nil
+}
+
+// attributesNeedComplete reports if tags or metadata use the hash or the records of the file.
+func (s *S3) attributesNeedComplete() bool {
+	for _, attr := range append(s.tags, s.meta...) {
+		if attr.value.NeedsComplete() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// tagging returns the tags of an object as the URL encoded query S3 expects.
+func (s *S3) tagging(info domain.RecordInfo, id string, hash string, records int) *string {
+	if len(s.tags) == 0 {
+		return nil
+	}
+
+	values := url.Values{}
+
+	for _, tag := range s.tags {
+		values.Set(tag.name, tag.value.Render(info, id, hash, records))
+	}
+
+	return aws.String(values.Encode())
+}
+
+func (s *S3) metadata(info domain.RecordInfo, id string, hash string, records int) map[string]string {
+	if len(s.meta) == 0 {
+		return nil
+	}
+
+	ret := make(map[string]string)
+
+	for _, meta := range s.meta {
+		ret[meta.name] = meta.value.Render(info, id, hash, records)
+	}
+
+	return ret
+}
+
 func (s *S3) Init() error {
 	endpoints := map[string]string{
 		"S3":  s.config.S3Endpoint,
@@ -60,6 +289,14 @@ func (s *S3) Init() error {
 			}
 			return aws.Endpoint{}, &aws.EndpointNotFoundError{}
 		})),
+		awsConfig.WithRetryer(func() aws.Retryer {
+			return retry.NewStandard(func(o *retry.StandardOptions) {
+				o.MaxAttempts = s.config.S3MaxAttempts
+				if s.config.S3RetryMaxBackoff > 0 {
+					o.MaxBackoff = time.Duration(s.config.S3RetryMaxBackoff) * time.Millisecond
+				}
+			})
+		}),
 	)
 
 	if err != nil {
@@ -67,16 +304,17 @@ func (s *S3) Init() error {
 		return err
 	}
 
-	session := filepath.Base(s.config.S3RoleARN)
-	slog.Info("Assuming role", "roleArn", s.config.S3RoleARN, "sessionName", session)
+	cfg.Credentials, err = s.credentials(cfg)
 
-	stsClient := sts.NewFromConfig(cfg)
+	if err != nil {
+		slog.Error("Invalid S3 credentials configuration", "error", err, "module", "writer.s3", "function", "Init", "mode", s.credentialMode())
+		return err
+	}
 
-	cfg.Credentials = aws.NewCredentialsCache(
-		stscreds.NewAssumeRoleProvider(stsClient, s.config.S3RoleARN, func(aro *stscreds.AssumeRoleOptions) {
-			aro.RoleSessionName = session
-		}),
-	)
+	if _, err = cfg.Credentials.Retrieve(s.ctx); err != nil {
+		slog.Error("Error getting S3 credentials", "error", err, "module", "writer.s3", "function", "Init", "mode", s.credentialMode())
+		return fmt.Errorf("error getting S3 credentials with %s mode: %w", s.credentialMode(), err)
+	}
 
 	slog.Debug("Get credentials, trying to create a S3 client")
 
@@ -91,10 +329,11 @@ func (s *S3) Init() error {
 
 	slog.Debug("S3 client created, checking bucket")
 
-	err = s.CheckBucket()
+	err = s.check()
+	s.setStatus(err)
 
 	if err != nil {
-		slog.Error("Error checking S3 bucket", "error", err, "module", "writer.s3", "function", "Init")
+		slog.Error("S3 writer is not ready", "error", err, "kind", ErrorKind(err), "module", "writer.s3", "function", "Init")
 		return err
 	}
 
@@ -103,79 +342,643 @@ func (s *S3) Init() error {
 	return nil
 }
 
+// check checks the bucket and the write permissions, at init and when the writer is not ready.
+func (s *S3) check() error {
+	if err := s.CheckBucket(); err != nil {
+		return err
+	}
+
+	return s.probe()
+}
+
+func (s *S3) credentialMode() string {
+	if len(s.config.S3CredentialMode) > 0 {
+		return s.config.S3CredentialMode
+	}
+
+	if len(s.config.S3RoleARN) > 0 {
+		return config.S3CredentialModeAssumeRole
+	}
+
+	return config.S3CredentialModeDefaultChain
+}
+
+// credentials returns the provider of the configured S3CredentialMode, cfg has the default chain credentials.
+func (s *S3) credentials(cfg aws.Config) (aws.CredentialsProvider, error) {
+	mode := s.credentialMode()
+	duration := time.Duration(s.config.S3SessionDuration) * time.Second
+
+	switch mode {
+	case config.S3CredentialModeStatic:
+		if len(s.config.S3AccessKeyID) == 0 || len(s.config.S3SecretAccessKey) == 0 {
+			return nil, errors.New("S3CredentialMode is static but S3AccessKeyID or S3SecretAccessKey is empty")
+		}
+
+		slog.Info("Using static credentials", "accessKeyId", s.config.S3AccessKeyID)
+
+		return credentials.NewStaticCredentialsProvider(s.config.S3AccessKeyID, s.config.S3SecretAccessKey, s.config.S3SessionToken), nil
+	case config.S3CredentialModeDefaultChain:
+		slog.Info("Using default credentials chain")
+
+		return cfg.Credentials, nil
+	case config.S3CredentialModeAssumeRole:
+		if len(s.config.S3RoleARN) == 0 {
+			return nil, errors.New("S3CredentialMode is assume-role but S3RoleARN is empty")
+		}
+
+		session := filepath.Base(s.config.S3RoleARN)
+		slog.Info("Assuming role", "roleArn", s.config.S3RoleARN, "sessionName", session, "externalId", s.config.S3ExternalID)
+
+		return aws.NewCredentialsCache(
+			stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), s.config.S3RoleARN, func(aro *stscreds.AssumeRoleOptions) {
+				aro.RoleSessionName = session
+
+				if len(s.config.S3ExternalID) > 0 {
+					aro.ExternalID = aws.String(s.config.S3ExternalID)
+				}
+
+				if duration > 0 {
+					aro.Duration = duration
+				}
+			}),
+		), nil
+	case config.S3CredentialModeWebIdentity:
+		role := s.config.S3RoleARN
+		if len(role) == 0 {
+			role = os.Getenv("AWS_ROLE_ARN")
+		}
+
+		token := s.config.S3WebIdentityTokenFile
+		if len(token) == 0 {
+			token = os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+		}
+
+		if len(role) == 0 {
+			return nil, errors.New("S3CredentialMode is web-identity but S3RoleARN and AWS_ROLE_ARN are empty")
+		}
+
+		if len(token) == 0 {
+			return nil, errors.New("S3CredentialMode is web-identity but S3WebIdentityTokenFile and AWS_WEB_IDENTITY_TOKEN_FILE are empty")
+		}
+
+		if _, err := os.Stat(token); err != nil {
+			return nil, fmt.Errorf("S3CredentialMode is web-identity but the token file can not be read: %w", err)
+		}
+
+		session := filepath.Base(role)
+		slog.Info("Assuming role with web identity", "roleArn", role, "sessionName", session, "tokenFile", token)
+
+		return aws.NewCredentialsCache(
+			stscreds.NewWebIdentityRoleProvider(sts.NewFromConfig(cfg), role, stscreds.IdentityTokenFile(token), func(o *stscreds.WebIdentityRoleOptions) {
+				o.RoleSessionName = session
+
+				if duration > 0 {
+					o.Duration = duration
+				}
+			}),
+		), nil
+	}
+
+	return nil, fmt.Errorf("invalid S3CredentialMode %q, use static, default-chain, assume-role or web-identity", mode)
+}
+
+// CheckBucket checks the bucket exists and can be accessed, creating it only when it does not exist and
+// S3CreateBucket is set.
 func (s *S3) CheckBucket() error {
 	_, err := s.client.HeadBucket(s.ctx, &s3.HeadBucketInput{
 		Bucket: aws.String(s.config.S3BuketName),
 	})
 
-	if err != nil {
-		var apiError smithy.APIError
-		if errors.As(err, &apiError) {
-			switch apiError.(type) {
-			case *types.NotFound:
-				slog.Info("S3 bucket not found", "bucket", s.config.S3BuketName, "region", s.config.S3Region)
-			default:
-				slog.Debug("AWS SDK return on checking S3 bucket", "return", err, "bucket", s.config.S3BuketName)
-			}
-		}
-	} else {
+	if err == nil {
 		slog.Info("S3 bucket already exists", "bucket", s.config.S3BuketName, "region", s.config.S3Region)
 		return nil
 	}
 
-	ret, err := s.client.CreateBucket(s.ctx, &s3.CreateBucketInput{
+	err = s.classify("s3://"+s.config.S3BuketName, err)
+
+	if ErrorKind(err) != ErrorKindNotFound || !s.config.S3CreateBucket {
+		slog.Error("Error checking S3 bucket", "error", err, "kind", ErrorKind(err), "module", "writer.s3", "function", "CheckBucket", "bucket", s.config.S3BuketName, "region", s.config.S3Region)
+		return err
+	}
+
+	input := &s3.CreateBucketInput{
 		Bucket: aws.String(s.config.S3BuketName),
-		CreateBucketConfiguration: &types.CreateBucketConfiguration{
+	}
+
+	// us-east-1 is the default location and it is rejected as a location constraint.
+	if len(s.config.S3Region) > 0 && s.config.S3Region != "us-east-1" {
+		input.CreateBucketConfiguration = &types.CreateBucketConfiguration{
 			LocationConstraint: types.BucketLocationConstraint(s.config.S3Region),
-		},
-	})
+		}
+	}
 
-	if ret != nil {
-		slog.Info("S3 bucket created", "module", "writer.s3", "function", "CheckBucket", "bucket", s.config.S3BuketName, "region", s.config.S3Region)
-		return nil
+	_, err = s.client.CreateBucket(s.ctx, input)
+
+	if err != nil {
+		err = s.classify("s3://"+s.config.S3BuketName, err)
+		slog.Error("Error creating S3 bucket", "error", err, "kind", ErrorKind(err), "module", "writer.s3", "function", "CheckBucket", "bucket", s.config.S3BuketName, "region", s.config.S3Region)
+		return err
+	}
+
+	slog.Info("S3 bucket created", "module", "writer.s3", "function", "CheckBucket", "bucket", s.config.S3BuketName, "region", s.config.S3Region)
+
+	return nil
+}
+
+// probe writes and deletes a sentinel object under S3ProbePrefix with the options of data files, so missing
+// permissions are found on start instead of on the first flush.
+func (s *S3) probe() error {
+	prefix := strings.Trim(s.config.S3ProbePrefix, "/")
+
+	if len(prefix) == 0 {
+		prefix = "_data2parquet"
+	}
+
+	info := domain.NewRecordInfoFromKey(s.config.RecordType, "")
+	id := domain.MakeID()
+	object := &s3Object{
+		key:      prefix + "/.probe-" + id,
+		tagging:  s.tagging(info, id, "", 0),
+		metadata: s.metadata(info, id, "", 0),
 	}
+	target := "s3://" + s.config.S3BuketName + "/" + object.key
+
+	if err := s.putObject(object, []byte(id)); err != nil {
+		return s.classify(target, err)
+	}
+
+	_, err := s.client.DeleteObject(s.ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.config.S3BuketName),
+		Key:    aws.String(object.key),
+	})
 
 	if err != nil {
-		slog.Warn("Warning to check S3 bucket", "error", err, "module", "writer.s3", "function", "CheckBucket", "bucket", s.config.S3BuketName, "region", s.config.S3Region)
+		return s.classify(target, err)
+	}
+
+	slog.Info("S3 write permissions checked", "module", "writer.s3", "function", "probe", "bucket", s.config.S3BuketName, "file", object.key)
+
+	return nil
+}
+
+// classify returns err as a writer Error with its kind and a hint of how to fix it.
+func (s *S3) classify(target string, err error) error {
+	var werr *Error
+
+	if errors.As(err, &werr) {
+		return err
+	}
+
+	ret := &Error{Kind: ErrorKindUnknown, Target: target, Err: err}
+
+	var respErr *awshttp.ResponseError
+	var sendErr *smithyhttp.RequestSendError
+	var apiErr smithy.APIError
+
+	switch {
+	case errors.As(err, &sendErr), errors.Is(err, context.DeadlineExceeded):
+		ret.Kind = ErrorKindNetwork
+	case errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotFound:
+		ret.Kind = ErrorKindNotFound
+	case errors.As(err, &respErr) && (respErr.HTTPStatusCode() == http.StatusForbidden || respErr.HTTPStatusCode() == http.StatusUnauthorized):
+		ret.Kind = ErrorKindForbidden
+	case errors.As(err, &apiErr):
+		switch apiErr.ErrorCode() {
+		case "NoSuchBucket", "NotFound":
+			ret.Kind = ErrorKindNotFound
+		case "AccessDenied", "Forbidden", "InvalidAccessKeyId", "SignatureDoesNotMatch", "AllAccessDisabled", "ExpiredToken":
+			ret.Kind = ErrorKindForbidden
+		}
+	}
+
+	switch ret.Kind {
+	case ErrorKindNotFound:
+		ret.Hint = fmt.Sprintf("bucket %s does not exist on %s, create it or set S3CreateBucket", s.config.S3BuketName, s.config.S3Region)
+	case ErrorKindForbidden:
+		ret.Hint = fmt.Sprintf("check the %s credentials and that they allow s3:ListBucket on the bucket and s3:PutObject and s3:DeleteObject on its objects", s.credentialMode())
+	case ErrorKindNetwork:
+		ret.Hint = "check S3Endpoint, S3Region and the network access to S3"
 	}
 
-	return err
+	return ret
+}
+
+func (s *S3) setStatus(err error) {
+	s.status.set(err)
 }
 
-func (s *S3) Write(key string, buf *bytes.Buffer) error {
+func (s *S3) Write(key string, data io.Reader) error {
 	start := time.Now()
-	recInfo := domain.NewRecordInfoFromKey(s.config.RecordType, key)
+	hash := ""
+	records := 0
+	var spool *os.File
+
+	// Tables need the footer of the file to commit it, so it is kept after the upload.
+	if needsComplete(s.template, s.config.UseHash) || s.attributesNeedComplete() || s.table != nil {
+		var sum string
+		var err error
+
+		if spool, sum, err = spoolFile(data); err != nil {
+			slog.Error("Error spooling data to hash it", "error", err, "module", "writer.s3", "function", "Write", "key", key)
+			return err
+		}
+
+		defer spool.Close()
+		defer os.Remove(spool.Name())
+
+		records = recordCount(data)
+		data = spool
+		hash = sum
+	}
+
+	info := domain.NewRecordInfoFromKey(s.config.RecordType, key)
 	id := domain.MakeID()
-	var hash = ""
-	if s.config.UseHash {
-		hash = "-" + domain.GetMD5Sum(buf.Bytes())
-	}
-	s3Key := recInfo.Target(id, hash)
-
-	_, err := s.client.PutObject(
-		s.ctx,
-		&s3.PutObjectInput{
-			Bucket: aws.String(s.config.S3BuketName),
-			Key:    aws.String(s3Key),
-			Body:   bytes.NewReader(buf.Bytes()),
-		},
-	)
+	object := &s3Object{
+		key:      path.Join(s.tablePath, targetPath(s.template, info, id, hash, records)),
+		tagging:  s.tagging(info, id, hash, records),
+		metadata: s.metadata(info, id, hash, records),
+	}
+
+	size, err := s.upload(object, data)
+
+	if err != nil {
+		err = s.classify("s3://"+s.config.S3BuketName+"/"+object.key, err)
+		slog.Error("Error writing to S3", "error", err, "kind", ErrorKind(err), "module", "writer.s3", "function", "Write", "key", key)
+
+		if ErrorKind(err) != ErrorKindUnknown {
+			s.setStatus(err)
+		}
+
+		return err
+	}
+
+	s.setStatus(nil)
+
+	if s.table != nil {
+		if err = s.commitTable(object, spool, size, info); err != nil {
+			slog.Error("Error committing file to table, removing it", "error", err, "module", "writer.s3", "function", "Write", "key", key, "file", object.key, "format", s.config.WriterTableFormat)
+			s.client.DeleteObject(s.ctx, &s3.DeleteObjectInput{Bucket: aws.String(s.config.S3BuketName), Key: aws.String(object.key)})
+			return err
+		}
+	}
+
+	slog.Info("S3 written", "file", object.key, "duration", time.Since(start), "file-size", size, "bucket", s.config.S3BuketName)
+
+	return nil
+}
+
+func (s *S3) commitTable(object *s3Object, spool *os.File, size int64, info domain.RecordInfo) error {
+	name := strings.TrimPrefix(strings.TrimPrefix(object.key, s.tablePath), "/")
+	file, err := newTableFile(name, spool, size, info)
+
+	if err != nil {
+		return err
+	}
+
+	return s.table.Commit(file)
+}
+
+// upload sends files smaller than S3MultipartThreshold with PutObject, and bigger files with a multipart upload as
+// they are read. The head of the file grows as it is read, small files don't allocate the whole threshold.
+func (s *S3) upload(object *s3Object, data io.Reader) (int64, error) {
+	head := new(bytes.Buffer)
+	n, err := io.Copy(head, io.LimitReader(data, s.threshold))
+
+	if err != nil {
+		return 0, err
+	}
+
+	if n < s.threshold {
+		return n, s.putObject(object, head.Bytes())
+	}
+
+	return s.multipart(object, io.MultiReader(head, data))
+}
+
+func (s *S3) putObject(object *s3Object, data []byte, optFns ...func(*s3.Options)) error {
+	input := &s3.PutObjectInput{
+		Bucket:               aws.String(s.config.S3BuketName),
+		Key:                  aws.String(object.key),
+		Body:                 bytes.NewReader(data),
+		ContentLength:        aws.Int64(int64(len(data))),
+		Metadata:             object.metadata,
+		Tagging:              object.tagging,
+		StorageClass:         s.storageClass,
+		ServerSideEncryption: s.encryption,
+		SSEKMSKeyId:          s.kmsKeyID,
+		SSECustomerAlgorithm: s.sseCustomer.algorithm,
+		SSECustomerKey:       s.sseCustomer.key,
+		SSECustomerKeyMD5:    s.sseCustomer.md5,
+	}
+
+	sum := s.sum(data)
+
+	if sum != nil {
+		input.ChecksumAlgorithm = s.algorithm()
+		input.ChecksumCRC32C, input.ChecksumSHA256 = s.checksumFields(sum)
+	}
+
+	ret, err := s.client.PutObject(s.ctx, input, optFns...)
 
 	if err != nil {
-		slog.Error("Error writing to S3", "error", err, "module", "writer.s3", "function", "Write", "key", key)
 		return err
 	}
 
-	slog.Info("S3 written", "file", s3Key, "duration", time.Since(start), "file-size", buf.Len(), "bucket", s.config.S3BuketName)
+	if sum != nil {
+		return verifyChecksum(object.key, base64.StdEncoding.EncodeToString(sum), ret.ChecksumCRC32C, ret.ChecksumSHA256)
+	}
+
+	return nil
+}
+
+// multipart reads the data in parts of S3PartSize, sending up to S3UploadConcurrency of them at the same time. The
+// upload is aborted on any failure, so no partial object or orphan part is kept.
+func (s *S3) multipart(object *s3Object, data io.Reader) (int64, error) {
+	input := &s3.CreateMultipartUploadInput{
+		Bucket:               aws.String(s.config.S3BuketName),
+		Key:                  aws.String(object.key),
+		Metadata:             object.metadata,
+		Tagging:              object.tagging,
+		StorageClass:         s.storageClass,
+		ServerSideEncryption: s.encryption,
+		SSEKMSKeyId:          s.kmsKeyID,
+		SSECustomerAlgorithm: s.sseCustomer.algorithm,
+		SSECustomerKey:       s.sseCustomer.key,
+		SSECustomerKeyMD5:    s.sseCustomer.md5,
+	}
+
+	if s.hasChecksum() {
+		input.ChecksumAlgorithm = s.algorithm()
+	}
+
+	upload, err := s.client.CreateMultipartUpload(s.ctx, input)
+
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+
+	parts := make([]types.CompletedPart, 0)
+	sums := make(map[int32][]byte)
+	slots := make(chan struct{}, s.concurrency)
+	wg := sync.WaitGroup{}
+	mu := sync.Mutex{}
+	size := int64(0)
+	var failed error
+
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if failed == nil {
+			failed = err
+			cancel()
+		}
+	}
+
+	for number := int32(1); ctx.Err() == nil; number++ {
+		part := s.parts.Get().(*[]byte)
+		n, err := io.ReadFull(data, *part)
+
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			s.parts.Put(part)
+			fail(err)
+			break
+		}
+
+		if n == 0 {
+			s.parts.Put(part)
+			break
+		}
+
+		size += int64(n)
+		slots <- struct{}{}
+		wg.Add(1)
+
+		go func(number int32, part *[]byte, body []byte) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			defer s.parts.Put(part)
+
+			completed, sum, err := s.uploadPart(ctx, object, upload.UploadId, number, body)
+
+			if err != nil {
+				slog.Warn("Error uploading part", "error", err, "module", "writer.s3", "function", "multipart", "file", object.key, "part", number)
+				fail(err)
+				return
+			}
+
+			mu.Lock()
+			parts = append(parts, completed)
+			sums[number] = sum
+			mu.Unlock()
+		}(number, part, (*part)[:n])
+
+		if err != nil {
+			break
+		}
+	}
+
+	wg.Wait()
+
+	if failed == nil && ctx.Err() != nil {
+		failed = ctx.Err()
+	}
+
+	if failed != nil {
+		s.abort(object, upload.UploadId)
+		return size, failed
+	}
+
+	sort.Slice(parts, func(i, j int) bool { return *parts[i].PartNumber < *parts[j].PartNumber })
+
+	ret, err := s.client.CompleteMultipartUpload(s.ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:               aws.String(s.config.S3BuketName),
+		Key:                  aws.String(object.key),
+		UploadId:             upload.UploadId,
+		MultipartUpload:      &types.CompletedMultipartUpload{Parts: parts},
+		SSECustomerAlgorithm: s.sseCustomer.algorithm,
+		SSECustomerKey:       s.sseCustomer.key,
+		SSECustomerKeyMD5:    s.sseCustomer.md5,
+	})
+
+	if err != nil {
+		s.abort(object, upload.UploadId)
+		return size, err
+	}
+
+	if s.hasChecksum() {
+		return size, verifyChecksum(object.key, s.composite(parts, sums), ret.ChecksumCRC32C, ret.ChecksumSHA256)
+	}
+
+	return size, nil
+}
+
+func (s *S3) uploadPart(ctx context.Context, object *s3Object, uploadId *string, number int32, body []byte) (types.CompletedPart, []byte, error) {
+	input := &s3.UploadPartInput{
+		Bucket:               aws.String(s.config.S3BuketName),
+		Key:                  aws.String(object.key),
+		UploadId:             uploadId,
+		PartNumber:           aws.Int32(number),
+		Body:                 bytes.NewReader(body),
+		ContentLength:        aws.Int64(int64(len(body))),
+		SSECustomerAlgorithm: s.sseCustomer.algorithm,
+		SSECustomerKey:       s.sseCustomer.key,
+		SSECustomerKeyMD5:    s.sseCustomer.md5,
+	}
+
+	sum := s.sum(body)
+
+	if sum != nil {
+		input.ChecksumAlgorithm = s.algorithm()
+		input.ChecksumCRC32C, input.ChecksumSHA256 = s.checksumFields(sum)
+	}
+
+	ret, err := s.client.UploadPart(ctx, input)
+
+	if err != nil {
+		return types.CompletedPart{}, nil, err
+	}
+
+	completed := types.CompletedPart{ETag: ret.ETag, PartNumber: aws.Int32(number)}
+	completed.ChecksumCRC32C, completed.ChecksumSHA256 = s.checksumFields(sum)
+
+	return completed, sum, nil
+}
+
+func (s *S3) hasChecksum() bool {
+	return s.checksum == config.S3ChecksumCRC32C || s.checksum == config.S3ChecksumSHA256
+}
+
+func (s *S3) algorithm() types.ChecksumAlgorithm {
+	if s.checksum == config.S3ChecksumSHA256 {
+		return types.ChecksumAlgorithmSha256
+	}
+
+	return types.ChecksumAlgorithmCrc32c
+}
+
+func (s *S3) newHash() hash.Hash {
+	switch s.checksum {
+	case config.S3ChecksumCRC32C:
+		return crc32.New(crc32c)
+	case config.S3ChecksumSHA256:
+		return sha256.New()
+	}
 
 	return nil
 }
 
+// sum returns the raw checksum of data, or nil when checksums are disabled.
+func (s *S3) sum(data []byte) []byte {
+	h := s.newHash()
+
+	if h == nil {
+		return nil
+	}
+
+	h.Write(data)
+
+	return h.Sum(nil)
+}
+
+// checksumFields returns the base64 checksum on the field of the configured algorithm.
+func (s *S3) checksumFields(sum []byte) (crc *string, sha *string) {
+	if sum == nil {
+		return nil, nil
+	}
+
+	value := aws.String(base64.StdEncoding.EncodeToString(sum))
+
+	if s.checksum == config.S3ChecksumSHA256 {
+		return nil, value
+	}
+
+	return value, nil
+}
+
+// composite returns the full object checksum S3 computes for multipart uploads, the checksum of the part checksums
+// followed by the number of parts.
+func (s *S3) composite(parts []types.CompletedPart, sums map[int32][]byte) string {
+	h := s.newHash()
+
+	for _, part := range parts {
+		h.Write(sums[*part.PartNumber])
+	}
+
+	return fmt.Sprintf("%s-%d", base64.StdEncoding.EncodeToString(h.Sum(nil)), len(parts))
+}
+
+// verifyChecksum compares the expected checksum with the one returned by S3, when it returns one.
+func verifyChecksum(s3Key string, expected string, crc *string, sha *string) error {
+	got := aws.ToString(crc)
+
+	if len(got) == 0 {
+		got = aws.ToString(sha)
+	}
+
+	if len(got) == 0 || got == expected {
+		return nil
+	}
+
+	slog.Error("S3 object checksum mismatch", "module", "writer.s3", "function", "verifyChecksum", "file", s3Key, "expected", expected, "got", got)
+
+	return fmt.Errorf("checksum mismatch on %s, expected %s got %s", s3Key, expected, got)
+}
+
+func (s *S3) abort(object *s3Object, uploadId *string) {
+	_, err := s.client.AbortMultipartUpload(s.ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.config.S3BuketName),
+		Key:      aws.String(object.key),
+		UploadId: uploadId,
+	})
+
+	if err != nil {
+		slog.Error("Error aborting multipart upload", "error", err, "module", "writer.s3", "function", "abort", "file", object.key)
+	}
+}
+
+// spoolFile copies data to a temporary file while computing its MD5, returning the file ready to be read.
+func spoolFile(data io.Reader) (*os.File, string, error) {
+	tmp, err := os.CreateTemp("", "data2parquet-*.parquet")
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	hash := md5.New()
+	_, err = io.Copy(io.MultiWriter(tmp, hash), data)
+
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, "", err
+	}
+
+	return tmp, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
 func (s *S3) Close() error {
 	slog.Debug("Closing AWS-S3 writer")
 	return nil
 }
 
 func (s *S3) IsReady() bool {
-	return s.client != nil
+	return s.Status() == nil
+}
+
+// Status returns the classified error of the last bucket check or write, a successful write or a new check after
+// WriterStatusInterval makes it ready again.
+func (s *S3) Status() error {
+	if s.client == nil {
+		return errors.New("S3 client is not initialized")
+	}
+
+	return s.status.get(time.Duration(s.config.WriterStatusInterval)*time.Millisecond, s.check)
 }
@@ -0,0 +1,375 @@
+package writer
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"data2parquet/pkg/config"
+	"data2parquet/pkg/domain"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+)
+
+const azureMaxAttempts = 3
+
+// azureMinBlockSize is the smallest block staged by the SDK.
+const azureMinBlockSize = 1 << 20
+
+var azureAccessTiers = map[string]blob.AccessTier{
+	config.AzureAccessTierHot:     blob.AccessTierHot,
+	config.AzureAccessTierCool:    blob.AccessTierCool,
+	config.AzureAccessTierCold:    blob.AccessTierCold,
+	config.AzureAccessTierArchive: blob.AccessTierArchive,
+}
+
+type AzureBlob struct {
+	config      *config.Config
+	client      *container.Client
+	ctx         context.Context
+	endpoint    string
+	template    *PathTemplate
+	prefix      string
+	blockSize   int64
+	concurrency int
+	tier        *blob.AccessTier
+	status      writerStatus
+}
+
+func NewAzureBlob(ctx context.Context, config *config.Config) Writer {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if len(config.AzureAccountName) == 0 || len(config.AzureContainerName) == 0 {
+		slog.Error("Azure account or container name is empty", "module", "writer.azure", "function", "NewAzureBlob", "account", config.AzureAccountName, "container", config.AzureContainerName)
+		return nil
+	}
+
+	ret := &AzureBlob{
+		config:      config,
+		ctx:         ctx,
+		prefix:      strings.Trim(config.AzurePrefix, "/"),
+		blockSize:   config.AzureBlockSize,
+		concurrency: config.AzureUploadConcurrency,
+	}
+
+	if ret.blockSize < 1 {
+		ret.blockSize = 8 << 20
+	}
+
+	if ret.blockSize < azureMinBlockSize {
+		slog.Warn("Azure block size is less than 1M, setting to 1M", "size", ret.blockSize, "module", "writer.azure", "function", "NewAzureBlob")
+		ret.blockSize = azureMinBlockSize
+	}
+
+	if ret.concurrency < 1 {
+		ret.concurrency = 4
+	}
+
+	if len(config.AzureAccessTier) > 0 {
+		tier, ok := azureAccessTiers[strings.ToLower(config.AzureAccessTier)]
+
+		if !ok {
+			slog.Error("Invalid Azure access tier, use hot, cool, cold or archive", "tier", config.AzureAccessTier, "module", "writer.azure", "function", "NewAzureBlob")
+			return nil
+		}
+
+		ret.tier = &tier
+	}
+
+	if len(config.WriterPathTemplate) > 0 {
+		template, err := NewPathTemplate(config.WriterPathTemplate)
+
+		if err != nil {
+			slog.Error("Invalid writer path template", "error", err, "module", "writer.azure", "function", "NewAzureBlob")
+			return nil
+		}
+
+		ret.template = template
+	}
+
+	slog.Info("Creating Azure Blob writer")
+
+	return ret
+}
+
+func (a *AzureBlob) Init() error {
+	a.endpoint = strings.TrimSuffix(a.config.AzureEndpoint, "/")
+
+	if len(a.endpoint) == 0 {
+		a.endpoint = "https://" + a.config.AzureAccountName + ".blob.core.windows.net"
+	}
+
+	slog.Debug("Initializing Azure Blob writer", "config", a.config.ToJSON(), "endpoint", a.endpoint)
+
+	options := &azblob.ClientOptions{}
+	options.Retry = policy.RetryOptions{MaxRetries: azureMaxAttempts - 1, RetryDelay: backoff(1), MaxRetryDelay: backoff(azureMaxAttempts)}
+	// Bearer tokens are only sent over HTTPS, but to local emulators as Azurite.
+	options.InsecureAllowCredentialWithHTTP = strings.HasPrefix(a.endpoint, "http://")
+
+	var client *azblob.Client
+	var err error
+
+	mode := a.authMode()
+
+	switch mode {
+	case config.AzureAuthModeSharedKey:
+		credential, kerr := azblob.NewSharedKeyCredential(a.config.AzureAccountName, a.config.AzureAccountKey)
+
+		if kerr != nil || len(a.config.AzureAccountKey) == 0 {
+			slog.Error("Invalid Azure shared key", "error", kerr, "module", "writer.azure", "function", "Init")
+			return errors.New("AzureAuthMode is shared-key but AzureAccountKey is empty or not base64 encoded")
+		}
+
+		client, err = azblob.NewClientWithSharedKeyCredential(a.endpoint, credential, options)
+	case config.AzureAuthModeSAS:
+		sas := strings.TrimPrefix(a.config.AzureSASToken, "?")
+
+		if len(sas) == 0 {
+			slog.Error("Azure SAS token is empty", "module", "writer.azure", "function", "Init")
+			return errors.New("AzureAuthMode is sas but AzureSASToken is empty")
+		}
+
+		client, err = azblob.NewClientWithNoCredential(a.endpoint+"?"+sas, options)
+	case config.AzureAuthModeManagedIdentity:
+		identity := &azidentity.ManagedIdentityCredentialOptions{}
+
+		if len(a.config.AzureClientID) > 0 {
+			identity.ID = azidentity.ClientID(a.config.AzureClientID)
+		}
+
+		credential, cerr := azidentity.NewManagedIdentityCredential(identity)
+
+		if cerr != nil {
+			slog.Error("Error creating Azure managed identity credential", "error", cerr, "module", "writer.azure", "function", "Init")
+			return cerr
+		}
+
+		client, err = azblob.NewClient(a.endpoint, credential, options)
+	default:
+		slog.Error("Invalid Azure auth mode", "mode", mode, "module", "writer.azure", "function", "Init")
+		return fmt.Errorf("invalid AzureAuthMode %q, use shared-key, sas or managed-identity", mode)
+	}
+
+	if err != nil {
+		slog.Error("Error creating Azure Blob client", "error", err, "module", "writer.azure", "function", "Init", "mode", mode)
+		return err
+	}
+
+	a.client = client.ServiceClient().NewContainerClient(a.config.AzureContainerName)
+
+	err = a.CheckContainer()
+	a.setStatus(err)
+
+	if err != nil {
+		slog.Error("Azure Blob writer is not ready", "error", err, "kind", ErrorKind(err), "module", "writer.azure", "function", "Init")
+		return err
+	}
+
+	slog.Info("Azure Blob writer initialized", "mode", mode)
+
+	return nil
+}
+
+func (a *AzureBlob) authMode() string {
+	if len(a.config.AzureAuthMode) > 0 {
+		return strings.ToLower(a.config.AzureAuthMode)
+	}
+
+	switch {
+	case len(a.config.AzureAccountKey) > 0:
+		return config.AzureAuthModeSharedKey
+	case len(a.config.AzureSASToken) > 0:
+		return config.AzureAuthModeSAS
+	}
+
+	return config.AzureAuthModeManagedIdentity
+}
+
+// CheckContainer lists at most one blob of the prefix, checking the container exists and the credentials are accepted.
+func (a *AzureBlob) CheckContainer() error {
+	options := &container.ListBlobsFlatOptions{MaxResults: &[]int32{1}[0]}
+
+	if len(a.prefix) > 0 {
+		prefix := a.prefix + "/"
+		options.Prefix = &prefix
+	}
+
+	_, err := a.client.NewListBlobsFlatPager(options).NextPage(a.ctx)
+
+	if err != nil {
+		err = a.classify(a.client.URL(), err)
+		slog.Error("Error checking Azure container", "error", err, "kind", ErrorKind(err), "module", "writer.azure", "function", "CheckContainer", "container", a.config.AzureContainerName)
+		return err
+	}
+
+	slog.Info("Azure container checked", "container", a.config.AzureContainerName, "prefix", a.prefix)
+
+	return nil
+}
+
+// classify returns err as a writer Error with its kind and a hint of how to fix it.
+func (a *AzureBlob) classify(target string, err error) error {
+	var werr *Error
+
+	if errors.As(err, &werr) {
+		return err
+	}
+
+	ret := &Error{Kind: ErrorKindUnknown, Target: target, Err: err}
+
+	var rerr *azcore.ResponseError
+	var aerr *azidentity.AuthenticationFailedError
+	var uerr *url.Error
+
+	switch {
+	case errors.As(err, &rerr) && rerr.StatusCode == http.StatusNotFound:
+		ret.Kind = ErrorKindNotFound
+		ret.Hint = fmt.Sprintf("container %s does not exist on account %s, create it before starting the writer", a.config.AzureContainerName, a.config.AzureAccountName)
+	case errors.As(err, &rerr) && (rerr.StatusCode == http.StatusForbidden || rerr.StatusCode == http.StatusUnauthorized), errors.As(err, &aerr):
+		ret.Kind = ErrorKindForbidden
+		ret.Hint = fmt.Sprintf("check the %s credentials and that they allow to list, create and write blobs on the container", a.authMode())
+	case errors.As(err, &uerr), errors.Is(err, context.DeadlineExceeded):
+		ret.Kind = ErrorKindNetwork
+		ret.Hint = "check AzureEndpoint, AzureAccountName and the network access to the storage account"
+	}
+
+	return ret
+}
+
+func (a *AzureBlob) setStatus(err error) {
+	a.status.set(err)
+}
+
+func (a *AzureBlob) Write(key string, data io.Reader) error {
+	start := time.Now()
+	hash := ""
+	records := 0
+
+	if needsComplete(a.template, a.config.UseHash) {
+		spool, sum, err := spoolFile(data)
+
+		if err != nil {
+			slog.Error("Error spooling data to hash it", "error", err, "module", "writer.azure", "function", "Write", "key", key)
+			return err
+		}
+
+		defer spool.Close()
+		defer os.Remove(spool.Name())
+
+		records = recordCount(data)
+		data = spool
+		hash = sum
+	}
+
+	info := domain.NewRecordInfoFromKey(a.config.RecordType, key)
+	name := targetPath(a.template, info, domain.MakeID(), hash, records)
+
+	if len(a.prefix) > 0 {
+		name = a.prefix + "/" + name
+	}
+
+	size, err := a.upload(name, data)
+
+	if err != nil {
+		err = a.classify(a.client.NewBlobClient(name).URL(), err)
+		slog.Error("Error writing to Azure Blob", "error", err, "kind", ErrorKind(err), "module", "writer.azure", "function", "Write", "key", key)
+
+		if ErrorKind(err) != ErrorKindUnknown {
+			a.setStatus(err)
+		}
+
+		return err
+	}
+
+	a.setStatus(nil)
+
+	slog.Info("Azure Blob written", "file", name, "duration", time.Since(start), "file-size", size, "container", a.config.AzureContainerName, "tier", a.config.AzureAccessTier)
+
+	return nil
+}
+
+// upload sends files up to AzureBlockSize with Put Blob and its MD5, and bigger files as blocks staged while they are
+// read, AzureUploadConcurrency at the same time, with their CRC64, and committed with a block list. Blocks of a failed
+// upload are never committed, Azure discards them.
+func (a *AzureBlob) upload(name string, data io.Reader) (int64, error) {
+	client := a.client.NewBlockBlobClient(name)
+	headers := &blob.HTTPHeaders{BlobContentType: &[]string{"application/octet-stream"}[0]}
+	head := make([]byte, a.blockSize)
+	n, err := io.ReadFull(data, head)
+
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		sum := md5.Sum(head[:n])
+
+		_, err = client.Upload(a.ctx, streaming.NopCloser(bytes.NewReader(head[:n])), &blockblob.UploadOptions{
+			HTTPHeaders:             headers,
+			Tier:                    a.tier,
+			TransactionalValidation: blob.TransferValidationTypeMD5(sum[:]),
+		})
+
+		return int64(n), err
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	counter := &countingReader{r: io.MultiReader(bytes.NewReader(head), data)}
+
+	_, err = client.UploadStream(a.ctx, counter, &blockblob.UploadStreamOptions{
+		BlockSize:               a.blockSize,
+		Concurrency:             a.concurrency,
+		HTTPHeaders:             headers,
+		AccessTier:              a.tier,
+		TransactionalValidation: blob.TransferValidationTypeComputeCRC64(),
+	})
+
+	return counter.n, err
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (a *AzureBlob) Close() error {
+	slog.Debug("Closing Azure Blob writer")
+	return nil
+}
+
+func (a *AzureBlob) IsReady() bool {
+	return a.Status() == nil
+}
+
+// Status returns the classified error of the last container check or write, a successful write or a new check after
+// WriterStatusInterval makes it ready again.
+func (a *AzureBlob) Status() error {
+	if a.client == nil {
+		return errors.New("Azure Blob client is not initialized")
+	}
+
+	return a.status.get(time.Duration(a.config.WriterStatusInterval)*time.Millisecond, a.CheckContainer)
+}
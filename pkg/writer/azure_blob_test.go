@@ -0,0 +1,404 @@
+package writer_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"data2parquet/pkg/config"
+	"data2parquet/pkg/writer"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"hash/crc64"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// azuriteKey is the well known key of the Azurite devstoreaccount1 account.
+const azuriteKey = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+
+// azureCRC64 is the CRC64 polynomial of the blob service.
+var azureCRC64 = crc64.MakeTable(0x9A6C9329AC4BC9B5)
+
+// fakeAzure is an in-process stand-in of the Azurite blob service, with path style URLs as
+// `/devstoreaccount1/container/blob`. It checks the shared key signature, the SAS token or the managed identity token,
+// and the MD5 or CRC64 of blobs and blocks, and serves managed identity tokens at /msi.
+type fakeAzure struct {
+	fakeStorage
+	blocks    map[string][]byte
+	tiers     map[string]string
+	auth      string
+	staged    int
+	failBlock int
+	tokens    int
+}
+
+func newFakeAzure(auth string) *fakeAzure {
+	return &fakeAzure{
+		blocks: make(map[string][]byte),
+		tiers:  make(map[string]string),
+		auth:   auth,
+	}
+}
+
+func (f *fakeAzure) serve(t *testing.T, closed bool) string {
+	return f.fakeStorage.serve(t, closed, f.handle)
+}
+
+func (f *fakeAzure) handle(w http.ResponseWriter, r *http.Request, body []byte) {
+	query := r.URL.Query()
+
+	if r.URL.Path == "/msi" {
+		if r.Header.Get("X-IDENTITY-HEADER") != "identity-secret" || strings.TrimSuffix(query.Get("resource"), "/") != "https://storage.azure.com" {
+			azureError(w, http.StatusBadRequest, "InvalidRequest")
+			return
+		}
+
+		f.tokens++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"azure-token","expires_on":"4102444800","resource":"https://storage.azure.com/","token_type":"Bearer"}`)
+		return
+	}
+
+	authorized := false
+
+	switch f.auth {
+	case "shared-key":
+		authorized = r.Header.Get("Authorization") == azureSignature(r, azuriteKey)
+	case "sas":
+		authorized = query.Get("sig") == "signature" && query.Get("sp") == "clw"
+	case "bearer":
+		authorized = r.Header.Get("Authorization") == "Bearer azure-token"
+	}
+
+	if !authorized {
+		azureError(w, http.StatusForbidden, "AuthenticationFailed")
+		return
+	}
+
+	if f.fail != 0 {
+		azureError(w, f.fail, "ContainerNotFound")
+		return
+	}
+
+	name, found := strings.CutPrefix(r.URL.Path, "/devstoreaccount1/container")
+
+	if !found {
+		azureError(w, http.StatusNotFound, "ContainerNotFound")
+		return
+	}
+
+	name = strings.TrimPrefix(name, "/")
+
+	if !azureChecksum(r, body) {
+		azureError(w, http.StatusBadRequest, "Md5Mismatch")
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && query.Get("comp") == "list":
+		fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><EnumerationResults><Blobs /></EnumerationResults>`)
+	case r.Method == http.MethodPut && query.Get("comp") == "block":
+		if f.failBlock != 0 {
+			azureError(w, f.failBlock, "ServerBusy")
+			return
+		}
+
+		f.staged++
+		f.blocks[name+"/"+query.Get("blockid")] = body
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && query.Get("comp") == "blocklist":
+		list := struct {
+			Latest []string `xml:"Latest"`
+		}{}
+		xml.Unmarshal(body, &list)
+
+		data := make([]byte, 0)
+		for _, id := range list.Latest {
+			block, ok := f.blocks[name+"/"+id]
+			if !ok {
+				azureError(w, http.StatusBadRequest, "InvalidBlockList")
+				return
+			}
+			data = append(data, block...)
+		}
+
+		f.objects[name] = data
+		f.tiers[name] = r.Header.Get("x-ms-access-tier")
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && r.Header.Get("x-ms-blob-type") == "BlockBlob":
+		f.objects[name] = body
+		f.tiers[name] = r.Header.Get("x-ms-access-tier")
+		w.WriteHeader(http.StatusCreated)
+	default:
+		azureError(w, http.StatusBadRequest, "InvalidQueryParameterValue")
+	}
+}
+
+// azureChecksum checks the body against its Content-MD5 or x-ms-content-crc64, writes must have one of them.
+func azureChecksum(r *http.Request, body []byte) bool {
+	if r.Method == http.MethodGet {
+		return true
+	}
+
+	if value := r.Header.Get("x-ms-content-crc64"); len(value) > 0 {
+		sum := make([]byte, 8)
+		binary.LittleEndian.PutUint64(sum, crc64.Checksum(body, azureCRC64))
+		return value == base64.StdEncoding.EncodeToString(sum)
+	}
+
+	return r.Header.Get("Content-MD5") == azureMD5(body) || r.URL.Query().Get("comp") == "blocklist"
+}
+
+// azureSignature computes the SharedKey authorization of a request as the blob service does.
+func azureSignature(r *http.Request, key string) string {
+	length := ""
+	if r.ContentLength > 0 {
+		length = strconv.FormatInt(r.ContentLength, 10)
+	}
+
+	lines := []string{r.Method, r.Header.Get("Content-Encoding"), r.Header.Get("Content-Language"), length,
+		r.Header.Get("Content-MD5"), r.Header.Get("Content-Type"), r.Header.Get("Date"), r.Header.Get("If-Modified-Since"),
+		r.Header.Get("If-Match"), r.Header.Get("If-None-Match"), r.Header.Get("If-Unmodified-Since"), r.Header.Get("Range")}
+
+	headers := make([]string, 0)
+	for name, values := range r.Header {
+		if strings.HasPrefix(strings.ToLower(name), "x-ms-") {
+			headers = append(headers, strings.ToLower(name)+":"+values[0])
+		}
+	}
+	sort.Strings(headers)
+
+	resource := "/devstoreaccount1" + r.URL.EscapedPath()
+	query := r.URL.Query()
+	names := make([]string, 0)
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		resource += "\n" + name + ":" + strings.Join(query[name], ",")
+	}
+
+	secret, _ := base64.StdEncoding.DecodeString(key)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strings.Join(lines, "\n") + "\n" + strings.Join(headers, "\n") + "\n" + resource))
+
+	return "SharedKey devstoreaccount1:" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func azureMD5(data []byte) string {
+	sum := md5.Sum(data)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func azureError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("x-ms-error-code", code)
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?><Error><Code>%s</Code><Message>%s
+RequestId:00000000-0000-0000-0000-000000000000</Message></Error>`, code, http.StatusText(status))
+}
+
+func newAzureBlob(endpoint string, settings map[string]string) (writer.Writer, error) {
+	cfg := &config.Config{}
+	values := map[string]string{
+		"RecordType":         config.RecordTypeLog,
+		"WriterType":         config.WriterTypeAzureBlob,
+		"AzureAccountName":   "devstoreaccount1",
+		"AzureContainerName": "container",
+		"AzureEndpoint":      endpoint + "/devstoreaccount1",
+		"AzureBlockSize":     strconv.Itoa(1 << 20),
+	}
+
+	for k, v := range settings {
+		values[k] = v
+	}
+
+	if err := cfg.Set(values); err != nil {
+		return nil, err
+	}
+
+	cfg.SetDefaults()
+
+	w := writer.New(context.Background(), cfg)
+
+	return w, w.Init()
+}
+
+func prepareAzureBlob(t *testing.T, fake *fakeAzure, settings map[string]string) writer.Writer {
+	values := map[string]string{"AzureAccountKey": azuriteKey}
+	for k, v := range settings {
+		values[k] = v
+	}
+
+	w, err := newAzureBlob(fake.serve(t, false), values)
+
+	if err != nil {
+		t.Fatalf("Error initializing Azure Blob writer: %s", err)
+	}
+
+	return w
+}
+
+func TestAzureBlobUpload(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		blocks int
+	}{
+		{name: "put blob", size: 100 << 10},
+		{name: "staged blocks", size: 3*(1<<20) + 100, blocks: 4},
+		{name: "block aligned", size: 2 * (1 << 20), blocks: 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := newFakeAzure("shared-key")
+			w := prepareAzureBlob(t, fake, map[string]string{
+				"AzurePrefix":        "lake",
+				"AzureAccessTier":    "Cool",
+				"WriterPathTemplate": "{service}/{id}-{records}.parquet",
+			})
+			data := randomData(test.size)
+
+			if err := w.Write("capability:domain:service:application", &countedReader{bytes.NewReader(data), 10}); err != nil {
+				t.Fatalf("Error writing to Azure Blob: %s", err)
+			}
+
+			if len(fake.objects) != 1 {
+				t.Fatalf("Expected one blob, got %d", len(fake.objects))
+			}
+
+			for name, blob := range fake.objects {
+				if !strings.HasPrefix(name, "lake/service/") || !strings.HasSuffix(name, "-10.parquet") {
+					t.Errorf("Expected the blob name from the template under the prefix, got %s", name)
+				}
+
+				if !bytes.Equal(blob, data) {
+					t.Errorf("Expected the blob to have the written data")
+				}
+
+				if fake.tiers[name] != "Cool" {
+					t.Errorf("Expected the blob on the Cool tier, got %q", fake.tiers[name])
+				}
+			}
+
+			if fake.staged != test.blocks {
+				t.Errorf("Expected %d staged blocks, got %d", test.blocks, fake.staged)
+			}
+		})
+	}
+}
+
+func TestAzureBlobStageFailure(t *testing.T) {
+	fake := newFakeAzure("shared-key")
+	w := prepareAzureBlob(t, fake, nil)
+	fake.failBlock = http.StatusServiceUnavailable
+
+	err := w.Write("capability:domain:service:application", bytes.NewReader(randomData(3*(1<<20))))
+
+	if err == nil || !strings.Contains(err.Error(), "ServerBusy") {
+		t.Fatalf("Expected the staging error, got %v", err)
+	}
+
+	if len(fake.objects) != 0 {
+		t.Errorf("Expected no blob to be committed, got %d", len(fake.objects))
+	}
+
+	fake.failBlock = 0
+
+	if err = w.Write("capability:domain:service:application", &failingReader{bytes.NewReader(randomData(3 * (1 << 20)))}); err == nil {
+		t.Fatalf("Expected the read error")
+	}
+
+	if len(fake.objects) != 0 {
+		t.Errorf("Expected no blob to be committed after a read error, got %d", len(fake.objects))
+	}
+}
+
+func TestAzureBlobAuth(t *testing.T) {
+	tests := []struct {
+		name     string
+		auth     string
+		settings map[string]string
+		kind     string
+		err      string
+	}{
+		{name: "shared key", auth: "shared-key", settings: map[string]string{"AzureAccountKey": azuriteKey}},
+		{name: "wrong shared key", auth: "shared-key", settings: map[string]string{"AzureAccountKey": base64.StdEncoding.EncodeToString([]byte("wrong"))}, kind: writer.ErrorKindForbidden},
+		{name: "sas", auth: "sas", settings: map[string]string{"AzureSASToken": "?sv=2021-12-02&sp=clw&sig=signature"}},
+		{name: "sas without token", auth: "sas", settings: map[string]string{"AzureAuthMode": "sas"}, err: "AzureSASToken is empty"},
+		{name: "managed identity", auth: "bearer"},
+		{name: "invalid mode", auth: "bearer", settings: map[string]string{"AzureAuthMode": "oauth"}, err: "invalid AzureAuthMode"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := newFakeAzure(test.auth)
+			endpoint := fake.serve(t, false)
+
+			t.Setenv("IDENTITY_ENDPOINT", endpoint+"/msi")
+			t.Setenv("IDENTITY_HEADER", "identity-secret")
+
+			w, err := newAzureBlob(endpoint, test.settings)
+
+			if len(test.err) > 0 {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("Expected an error with %q, got %v", test.err, err)
+				}
+				return
+			}
+
+			if writer.ErrorKind(err) != test.kind && (err != nil || len(test.kind) > 0) {
+				t.Fatalf("Expected %q error, got %v", test.kind, err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if err = w.Write("capability:domain:service:application", bytes.NewReader(randomData(1024))); err != nil {
+				t.Fatalf("Error writing to Azure Blob: %s", err)
+			}
+
+			if len(fake.objects) != 1 || (test.auth == "bearer") != (fake.tokens == 1) {
+				t.Errorf("Expected one blob written with %s auth, got %d blobs and %d tokens", test.auth, len(fake.objects), fake.tokens)
+			}
+		})
+	}
+}
+
+func TestAzureBlobCheckContainer(t *testing.T) {
+	tests := []struct {
+		name      string
+		container int
+		kind      string
+	}{
+		{name: "ready"},
+		{name: "not found", container: http.StatusNotFound, kind: writer.ErrorKindNotFound},
+		{name: "network", kind: writer.ErrorKindNetwork},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := newFakeAzure("shared-key")
+			fake.fail = test.container
+
+			w, err := newAzureBlob(fake.serve(t, test.kind == writer.ErrorKindNetwork), map[string]string{"AzureAccountKey": azuriteKey})
+
+			if writer.ErrorKind(err) != test.kind && (err != nil || len(test.kind) > 0) {
+				t.Fatalf("Expected %q error, got %v", test.kind, err)
+			}
+
+			if w.IsReady() != (err == nil) || writer.ErrorKind(w.Status()) != writer.ErrorKind(err) {
+				t.Errorf("Expected the writer status to be %v, got %v", err, w.Status())
+			}
+		})
+	}
+}
@@ -0,0 +1,487 @@
+package writer
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/schema"
+)
+
+// deltaMaxStringBound is the length of string bounds in file statistics, as Delta keeps prefixes of long strings.
+const deltaMaxStringBound = 32
+
+// deltaField is a field of the Spark schema of a Delta table.
+type deltaField struct {
+	Name     string          `json:"name"`
+	Type     *deltaType      `json:"type"`
+	Nullable bool            `json:"nullable"`
+	Metadata json.RawMessage `json:"metadata"`
+}
+
+// deltaType is a primitive type, as `long` or `decimal(9,2)`, or a struct, array or map.
+type deltaType struct {
+	Kind              string
+	Primitive         string
+	Fields            []*deltaField
+	ElementType       *deltaType
+	ContainsNull      bool
+	KeyType           *deltaType
+	ValueType         *deltaType
+	ValueContainsNull bool
+}
+
+type deltaStructJSON struct {
+	Type   string        `json:"type"`
+	Fields []*deltaField `json:"fields"`
+}
+
+type deltaArrayJSON struct {
+	Type         string     `json:"type"`
+	ElementType  *deltaType `json:"elementType"`
+	ContainsNull bool       `json:"containsNull"`
+}
+
+type deltaMapJSON struct {
+	Type              string     `json:"type"`
+	KeyType           *deltaType `json:"keyType"`
+	ValueType         *deltaType `json:"valueType"`
+	ValueContainsNull bool       `json:"valueContainsNull"`
+}
+
+func (t *deltaType) MarshalJSON() ([]byte, error) {
+	switch t.Kind {
+	case "struct":
+		return json.Marshal(deltaStructJSON{Type: t.Kind, Fields: t.Fields})
+	case "array":
+		return json.Marshal(deltaArrayJSON{Type: t.Kind, ElementType: t.ElementType, ContainsNull: t.ContainsNull})
+	case "map":
+		return json.Marshal(deltaMapJSON{Type: t.Kind, KeyType: t.KeyType, ValueType: t.ValueType, ValueContainsNull: t.ValueContainsNull})
+	}
+
+	return json.Marshal(t.Primitive)
+}
+
+func (t *deltaType) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &t.Primitive)
+	}
+
+	kind := struct {
+		Type string `json:"type"`
+	}{}
+
+	if err := json.Unmarshal(data, &kind); err != nil {
+		return err
+	}
+
+	t.Kind = kind.Type
+
+	switch kind.Type {
+	case "struct":
+		v := deltaStructJSON{}
+		err := json.Unmarshal(data, &v)
+		t.Fields = v.Fields
+		return err
+	case "array":
+		v := deltaArrayJSON{}
+		err := json.Unmarshal(data, &v)
+		t.ElementType, t.ContainsNull = v.ElementType, v.ContainsNull
+		return err
+	case "map":
+		v := deltaMapJSON{}
+		err := json.Unmarshal(data, &v)
+		t.KeyType, t.ValueType, t.ValueContainsNull = v.KeyType, v.ValueType, v.ValueContainsNull
+		return err
+	}
+
+	return fmt.Errorf("unknown delta type %s", kind.Type)
+}
+
+func (t *deltaType) String() string {
+	data, _ := json.Marshal(t)
+	return string(data)
+}
+
+// deltaTypeOf returns the Spark type of a parquet node. Fields are nullable, as Spark writes them, so files with
+// optional and required columns can be appended to the same table.
+func deltaTypeOf(node *parquetNode, element bool) *deltaType {
+	var ret *deltaType
+
+	switch {
+	case len(node.children) == 0:
+		ret = &deltaType{Primitive: deltaPrimitive(node.element)}
+	case isParquetList(node) && node.listElement() != nil:
+		ret = &deltaType{Kind: "array", ElementType: deltaTypeOf(node.listElement(), true), ContainsNull: true}
+	case isParquetMap(node) && len(node.children[0].children) == 2:
+		key, value := node.mapEntry()
+		ret = &deltaType{Kind: "map", KeyType: deltaTypeOf(key, false), ValueType: deltaTypeOf(value, false), ValueContainsNull: true}
+	default:
+		ret = &deltaType{Kind: "struct"}
+
+		for _, child := range node.children {
+			ret.Fields = append(ret.Fields, newDeltaField(child.element.Name, deltaTypeOf(child, false)))
+		}
+	}
+
+	if node.repeated() && !element {
+		return &deltaType{Kind: "array", ElementType: ret, ContainsNull: true}
+	}
+
+	return ret
+}
+
+func newDeltaField(name string, t *deltaType) *deltaField {
+	return &deltaField{Name: name, Type: t, Nullable: true, Metadata: json.RawMessage("{}")}
+}
+
+// deltaPrimitive maps the Iceberg primitive of a parquet column to the type Spark reads it as.
+func deltaPrimitive(e *parquet.SchemaElement) string {
+	if e.ConvertedType != nil && *e.ConvertedType == parquet.ConvertedType_UINT_64 {
+		return "decimal(20,0)"
+	}
+
+	primitive := icebergPrimitive(e)
+
+	switch {
+	case primitive == "int":
+		return "integer"
+	case primitive == "time":
+		return "long"
+	case primitive == "timestamptz":
+		return "timestamp"
+	case primitive == "uuid", strings.HasPrefix(primitive, "fixed"):
+		return "binary"
+	case strings.HasPrefix(primitive, "decimal"):
+		return strings.ReplaceAll(primitive, " ", "")
+	}
+
+	return primitive
+}
+
+// mergeDeltaType returns the table type with the columns of a file. New columns are added at the end of their struct,
+// names are matched ignoring case as Delta does, and types can't change.
+func mergeDeltaType(current *deltaType, file *deltaType) (*deltaType, error) {
+	if current.Kind != file.Kind || current.Primitive != file.Primitive {
+		return nil, fmt.Errorf("type %s can't be changed to %s", current, file)
+	}
+
+	ret := *current
+
+	switch current.Kind {
+	case "struct":
+		ret.Fields = append([]*deltaField{}, current.Fields...)
+
+		for _, f := range file.Fields {
+			found := false
+
+			for i, c := range ret.Fields {
+				if !strings.EqualFold(c.Name, f.Name) {
+					continue
+				}
+
+				t, err := mergeDeltaType(c.Type, f.Type)
+
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", f.Name, err)
+				}
+
+				copied := *c
+				copied.Type = t
+				ret.Fields[i] = &copied
+				found = true
+			}
+
+			if !found {
+				ret.Fields = append(ret.Fields, newDeltaField(f.Name, f.Type))
+			}
+		}
+	case "array":
+		element, err := mergeDeltaType(current.ElementType, file.ElementType)
+
+		if err != nil {
+			return nil, fmt.Errorf("element: %w", err)
+		}
+
+		ret.ElementType = element
+	case "map":
+		key, err := mergeDeltaType(current.KeyType, file.KeyType)
+
+		if err != nil {
+			return nil, fmt.Errorf("key: %w", err)
+		}
+
+		value, err := mergeDeltaType(current.ValueType, file.ValueType)
+
+		if err != nil {
+			return nil, fmt.Errorf("value: %w", err)
+		}
+
+		ret.KeyType, ret.ValueType = key, value
+	}
+
+	return &ret, nil
+}
+
+// deltaFileStats are the statistics of an `add` action, with the values of nested columns in nested objects.
+type deltaFileStats struct {
+	NumRecords int64                  `json:"numRecords"`
+	MinValues  map[string]interface{} `json:"minValues"`
+	MaxValues  map[string]interface{} `json:"maxValues"`
+	NullCount  map[string]interface{} `json:"nullCount"`
+}
+
+// newDeltaStats collects the statistics of the first indexed leaf columns of a file, or all of them when indexed is
+// negative. Partition columns and columns in arrays and maps have no statistics.
+func newDeltaStats(stats *parquetStats, partitions []string, indexed int) *deltaFileStats {
+	ret := &deltaFileStats{
+		NumRecords: stats.rows,
+		MinValues:  make(map[string]interface{}),
+		MaxValues:  make(map[string]interface{}),
+		NullCount:  make(map[string]interface{}),
+	}
+
+	count := 0
+	children := make([]*parquetNode, 0, len(stats.root.children))
+
+	for _, child := range stats.root.children {
+		if !containsFold(partitions, child.element.Name) {
+			children = append(children, child)
+		}
+	}
+
+	collectDeltaStats(stats, children, ret.MinValues, ret.MaxValues, ret.NullCount, indexed, &count)
+
+	return ret
+}
+
+func collectDeltaStats(stats *parquetStats, nodes []*parquetNode, min, max, nulls map[string]interface{}, indexed int, count *int) {
+	for _, node := range nodes {
+		if indexed >= 0 && *count >= indexed {
+			return
+		}
+
+		name := node.element.Name
+
+		switch {
+		case node.repeated() || isParquetList(node) || isParquetMap(node):
+		case len(node.children) > 0:
+			nestedMin, nestedMax, nestedNulls := make(map[string]interface{}), make(map[string]interface{}), make(map[string]interface{})
+			collectDeltaStats(stats, node.children, nestedMin, nestedMax, nestedNulls, indexed, count)
+
+			if len(nestedMin) > 0 {
+				min[name] = nestedMin
+			}
+
+			if len(nestedMax) > 0 {
+				max[name] = nestedMax
+			}
+
+			if len(nestedNulls) > 0 {
+				nulls[name] = nestedNulls
+			}
+		default:
+			*count++
+			column := stats.columns[strings.Join(node.path, ".")]
+
+			if column == nil {
+				continue
+			}
+
+			if column.counts {
+				nulls[name] = column.nulls
+			}
+
+			if column.bounds && column.min != nil {
+				if lower, upper := deltaBounds(node.element, column.min, column.max); lower != nil {
+					min[name] = lower
+
+					if upper != nil {
+						max[name] = upper
+					}
+				}
+			}
+		}
+	}
+}
+
+// deltaBounds converts plain encoded parquet bounds to the JSON values of Delta statistics, nil when the type has no
+// bounds in this writer. Timestamps are rounded to milliseconds, down for the lower bound and up for the upper bound,
+// and long strings only have a lower bound.
+func deltaBounds(e *parquet.SchemaElement, min []byte, max []byte) (interface{}, interface{}) {
+	primitive := icebergPrimitive(e)
+
+	if e.ConvertedType != nil {
+		switch *e.ConvertedType {
+		case parquet.ConvertedType_UINT_8, parquet.ConvertedType_UINT_16, parquet.ConvertedType_UINT_32, parquet.ConvertedType_UINT_64, parquet.ConvertedType_DECIMAL:
+			return nil, nil
+		}
+	}
+
+	switch e.GetType() {
+	case parquet.Type_INT32:
+		if len(min) != 4 || len(max) != 4 || strings.HasPrefix(primitive, "decimal") {
+			return nil, nil
+		}
+
+		lower, upper := int32(binary.LittleEndian.Uint32(min)), int32(binary.LittleEndian.Uint32(max))
+
+		if primitive == "date" {
+			return time.Unix(int64(lower)*86400, 0).UTC().Format("2006-01-02"), time.Unix(int64(upper)*86400, 0).UTC().Format("2006-01-02")
+		}
+
+		return lower, upper
+	case parquet.Type_INT64:
+		if len(min) != 8 || len(max) != 8 || strings.HasPrefix(primitive, "decimal") {
+			return nil, nil
+		}
+
+		lower, upper := int64(binary.LittleEndian.Uint64(min)), int64(binary.LittleEndian.Uint64(max))
+
+		if strings.HasPrefix(primitive, "timestamp") {
+			unit := int64(time.Microsecond)
+
+			if isMillis(e) {
+				unit = int64(time.Millisecond)
+			}
+
+			return deltaTimestamp(lower*unit, false), deltaTimestamp(upper*unit, true)
+		}
+
+		return lower, upper
+	case parquet.Type_FLOAT:
+		if len(min) != 4 || len(max) != 4 {
+			return nil, nil
+		}
+
+		lower, upper := float64(math.Float32frombits(binary.LittleEndian.Uint32(min))), float64(math.Float32frombits(binary.LittleEndian.Uint32(max)))
+
+		return finiteBounds(lower, upper)
+	case parquet.Type_DOUBLE:
+		if len(min) != 8 || len(max) != 8 {
+			return nil, nil
+		}
+
+		return finiteBounds(math.Float64frombits(binary.LittleEndian.Uint64(min)), math.Float64frombits(binary.LittleEndian.Uint64(max)))
+	case parquet.Type_BYTE_ARRAY:
+		if primitive != "string" || !utf8.Valid(min) || !utf8.Valid(max) {
+			return nil, nil
+		}
+
+		lower := []rune(string(min))
+
+		if len(lower) > deltaMaxStringBound {
+			lower = lower[:deltaMaxStringBound]
+		}
+
+		if utf8.RuneCount(max) > deltaMaxStringBound {
+			return string(lower), nil
+		}
+
+		return string(lower), string(max)
+	}
+
+	return nil, nil
+}
+
+func deltaTimestamp(nanos int64, up bool) string {
+	tm := time.Unix(0, nanos).UTC()
+	truncated := tm.Truncate(time.Millisecond)
+
+	if up && truncated.Before(tm) {
+		truncated = truncated.Add(time.Millisecond)
+	}
+
+	return truncated.Format("2006-01-02T15:04:05.000Z07:00")
+}
+
+func finiteBounds(lower float64, upper float64) (interface{}, interface{}) {
+	if math.IsNaN(lower) || math.IsNaN(upper) || math.IsInf(lower, 0) || math.IsInf(upper, 0) {
+		return nil, nil
+	}
+
+	return lower, upper
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// deltaCheckpointSchema is the schema of the actions of a checkpoint, as the JSON schema of the parquet writer.
+func deltaCheckpointSchema() *schema.JSONSchemaItemType {
+	str := func(name string, repetition string) *schema.JSONSchemaItemType {
+		return &schema.JSONSchemaItemType{Tag: fmt.Sprintf("name=%s, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=%s", name, repetition)}
+	}
+	long := func(name string, repetition string) *schema.JSONSchemaItemType {
+		return &schema.JSONSchemaItemType{Tag: fmt.Sprintf("name=%s, type=INT64, repetitiontype=%s", name, repetition)}
+	}
+	boolean := func(name string, repetition string) *schema.JSONSchemaItemType {
+		return &schema.JSONSchemaItemType{Tag: fmt.Sprintf("name=%s, type=BOOLEAN, repetitiontype=%s", name, repetition)}
+	}
+	group := func(name string, fields ...*schema.JSONSchemaItemType) *schema.JSONSchemaItemType {
+		return &schema.JSONSchemaItemType{Tag: fmt.Sprintf("name=%s, repetitiontype=OPTIONAL", name), Fields: fields}
+	}
+	stringMap := func(name string) *schema.JSONSchemaItemType {
+		return &schema.JSONSchemaItemType{Tag: fmt.Sprintf("name=%s, type=MAP, repetitiontype=OPTIONAL", name), Fields: []*schema.JSONSchemaItemType{str("key", "REQUIRED"), str("value", "OPTIONAL")}}
+	}
+	list := func(name string) *schema.JSONSchemaItemType {
+		return &schema.JSONSchemaItemType{Tag: fmt.Sprintf("name=%s, type=LIST, repetitiontype=OPTIONAL", name), Fields: []*schema.JSONSchemaItemType{str("element", "REQUIRED")}}
+	}
+
+	format := group("format", str("provider", "REQUIRED"), stringMap("options"))
+	format.Tag = "name=format, repetitiontype=REQUIRED"
+
+	return &schema.JSONSchemaItemType{Tag: "name=parquet_go_root, repetitiontype=REQUIRED", Fields: []*schema.JSONSchemaItemType{
+		group("txn", str("appId", "REQUIRED"), long("version", "REQUIRED"), long("lastUpdated", "OPTIONAL")),
+		group("add", str("path", "REQUIRED"), stringMap("partitionValues"), long("size", "REQUIRED"), long("modificationTime", "REQUIRED"), boolean("dataChange", "REQUIRED"), str("stats", "OPTIONAL")),
+		group("remove", str("path", "REQUIRED"), long("deletionTimestamp", "OPTIONAL"), boolean("dataChange", "REQUIRED"), boolean("extendedFileMetadata", "OPTIONAL"), stringMap("partitionValues"), long("size", "OPTIONAL")),
+		group("metaData", str("id", "REQUIRED"), str("name", "OPTIONAL"), str("description", "OPTIONAL"), format, str("schemaString", "REQUIRED"), list("partitionColumns"), stringMap("configuration"), long("createdTime", "OPTIONAL")),
+		group("protocol", &schema.JSONSchemaItemType{Tag: "name=minReaderVersion, type=INT32, repetitiontype=REQUIRED"}, &schema.JSONSchemaItemType{Tag: "name=minWriterVersion, type=INT32, repetitiontype=REQUIRED"}, list("readerFeatures"), list("writerFeatures")),
+	}}
+}
+
+// pruneSchema keeps the columns of a JSON schema that a parquet file has, so checkpoints written by other engines,
+// with other columns, can be read. Lists and maps are kept when the file has any of their columns, path is the path
+// of the item, empty for the root.
+func pruneSchema(item *schema.JSONSchemaItemType, path string, columns map[string]*columnStats) *schema.JSONSchemaItemType {
+	if len(path) > 0 && (strings.Contains(item.Tag, "type=MAP") || strings.Contains(item.Tag, "type=LIST") || len(item.Fields) == 0) {
+		for column := range columns {
+			if column == path || strings.HasPrefix(column, path+".") {
+				return item
+			}
+		}
+
+		return nil
+	}
+
+	ret := &schema.JSONSchemaItemType{Tag: item.Tag}
+
+	for _, field := range item.Fields {
+		name := strings.TrimPrefix(strings.SplitN(field.Tag, ",", 2)[0], "name=")
+
+		if len(path) > 0 {
+			name = path + "." + name
+		}
+
+		if pruned := pruneSchema(field, name, columns); pruned != nil {
+			ret.Fields = append(ret.Fields, pruned)
+		}
+	}
+
+	if len(ret.Fields) == 0 {
+		return nil
+	}
+
+	return ret
+}
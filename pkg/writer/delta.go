@@ -0,0 +1,664 @@
+package writer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"data2parquet/pkg/config"
+
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/marshal"
+	"github.com/xitongsys/parquet-go/reader"
+	pwriter "github.com/xitongsys/parquet-go/writer"
+)
+
+const deltaLastCheckpoint = "_delta_log/_last_checkpoint"
+const deltaCheckpointInterval = 10
+const deltaIndexedColumns = 32
+const deltaTombstoneRetention = 7 * 24 * time.Hour
+
+// deltaWriterFeatures are the table features of writer version 7 this writer supports, as it only appends files.
+var deltaWriterFeatures = map[string]bool{
+	"appendOnly":   true,
+	"invariants":   true,
+	"timestampNtz": true,
+}
+
+// deltaAction is a line of a commit or a row of a checkpoint, with a single action set.
+type deltaAction struct {
+	Txn        *deltaTxn              `json:"txn,omitempty"`
+	Add        *deltaAdd              `json:"add,omitempty"`
+	Remove     *deltaRemove           `json:"remove,omitempty"`
+	MetaData   *deltaMetaData         `json:"metaData,omitempty"`
+	Protocol   *deltaProtocol         `json:"protocol,omitempty"`
+	CommitInfo map[string]interface{} `json:"commitInfo,omitempty"`
+}
+
+type deltaTxn struct {
+	AppID       string `json:"appId"`
+	Version     int64  `json:"version"`
+	LastUpdated *int64 `json:"lastUpdated,omitempty"`
+}
+
+type deltaAdd struct {
+	Path             string             `json:"path"`
+	PartitionValues  map[string]*string `json:"partitionValues"`
+	Size             int64              `json:"size"`
+	ModificationTime int64              `json:"modificationTime"`
+	DataChange       bool               `json:"dataChange"`
+	Stats            string             `json:"stats,omitempty"`
+}
+
+type deltaRemove struct {
+	Path                 string             `json:"path"`
+	DeletionTimestamp    *int64             `json:"deletionTimestamp,omitempty"`
+	DataChange           bool               `json:"dataChange"`
+	ExtendedFileMetadata *bool              `json:"extendedFileMetadata,omitempty"`
+	PartitionValues      map[string]*string `json:"partitionValues,omitempty"`
+	Size                 *int64             `json:"size,omitempty"`
+}
+
+type deltaMetaData struct {
+	ID               string            `json:"id"`
+	Name             *string           `json:"name,omitempty"`
+	Description      *string           `json:"description,omitempty"`
+	Format           deltaFormat       `json:"format"`
+	SchemaString     string            `json:"schemaString"`
+	PartitionColumns []string          `json:"partitionColumns"`
+	Configuration    map[string]string `json:"configuration"`
+	CreatedTime      *int64            `json:"createdTime,omitempty"`
+}
+
+type deltaFormat struct {
+	Provider string            `json:"provider"`
+	Options  map[string]string `json:"options"`
+}
+
+type deltaProtocol struct {
+	MinReaderVersion int      `json:"minReaderVersion"`
+	MinWriterVersion int      `json:"minWriterVersion"`
+	ReaderFeatures   []string `json:"readerFeatures,omitempty"`
+	WriterFeatures   []string `json:"writerFeatures,omitempty"`
+}
+
+// deltaState is the table at a version, from the last checkpoint and the commits after it.
+type deltaState struct {
+	version  int64
+	protocol *deltaProtocol
+	metaData *deltaMetaData
+	txns     map[string]*deltaTxn
+	files    map[string]*deltaAdd
+	removes  map[string]*deltaRemove
+}
+
+// deltaTable appends each file to a Delta Lake table, with a commit in `_delta_log` for each one. Commits are created
+// with put-if-absent, so when other writers commit a version first the commit is retried on the next one, and every
+// `delta.checkpointInterval` versions (10 by default) a checkpoint of the table is written. The state of the table is
+// kept between commits and only the new commits are read.
+type deltaTable struct {
+	config *config.Config
+	store  tableStore
+	mu     sync.Mutex
+	state  *deltaState
+}
+
+func newDeltaTable(cfg *config.Config, store tableStore) *deltaTable {
+	return &deltaTable{config: cfg, store: store}
+}
+
+// Commit adds the file to the table, with its partition values from its RecordInfo and its column statistics. The
+// schema of the table gets the new columns of the file.
+func (t *deltaTable) Commit(file *tableFile) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	start := time.Now()
+	version := int64(0)
+
+	err := commitTable(t.config, "delta", func(attempt int) error {
+		var err error
+		version, err = t.commit(file)
+		return err
+	})
+
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Delta table committed", "module", "writer.delta", "function", "Commit", "file", file.path, "version", version, "records", file.records, "duration", time.Since(start))
+
+	if t.checkpointDue(version) {
+		if err = t.checkpoint(); err != nil {
+			slog.Warn("Error writing delta checkpoint", "error", err, "module", "writer.delta", "function", "Commit", "version", version)
+		}
+	}
+
+	return nil
+}
+
+func (t *deltaTable) commit(file *tableFile) (int64, error) {
+	if err := t.update(); err != nil {
+		t.state = nil
+		return 0, err
+	}
+
+	state := t.state
+	now := time.Now().UnixMilli()
+	actions := make([]*deltaAction, 0, 4)
+	partitions := tablePartitions(file.info)
+	meta := state.metaData
+
+	if state.protocol != nil {
+		if err := state.protocol.validate(); err != nil {
+			return 0, err
+		}
+	}
+
+	fileType := deltaTypeOf(file.stats.root, false)
+
+	if meta == nil {
+		names := make([]string, 0, len(partitions))
+
+		for _, partition := range partitions {
+			names = append(names, partition.name)
+
+			if !deltaHasField(fileType, partition.name) {
+				primitive := "string"
+
+				if partition.integer {
+					primitive = "integer"
+				}
+
+				fileType.Fields = append(fileType.Fields, newDeltaField(partition.name, &deltaType{Primitive: primitive}))
+			}
+		}
+
+		schemaString, err := json.Marshal(fileType)
+
+		if err != nil {
+			return 0, err
+		}
+
+		meta = &deltaMetaData{
+			ID:               newUUID(),
+			Format:           deltaFormat{Provider: "parquet", Options: map[string]string{}},
+			SchemaString:     string(schemaString),
+			PartitionColumns: names,
+			Configuration:    map[string]string{},
+			CreatedTime:      &now,
+		}
+
+		actions = append(actions, &deltaAction{Protocol: &deltaProtocol{MinReaderVersion: 1, MinWriterVersion: 2}}, &deltaAction{MetaData: meta})
+	} else {
+		current := &deltaType{}
+
+		if err := json.Unmarshal([]byte(meta.SchemaString), current); err != nil {
+			return 0, fmt.Errorf("invalid delta table schema: %w", err)
+		}
+
+		merged, err := mergeDeltaType(current, fileType)
+
+		if err != nil {
+			return 0, fmt.Errorf("error evolving the schema of the delta table with %s: %w", file.path, err)
+		}
+
+		before, _ := json.Marshal(current)
+
+		if schemaString, _ := json.Marshal(merged); string(schemaString) != string(before) {
+			copied := *meta
+			copied.SchemaString = string(schemaString)
+			meta = &copied
+			actions = append(actions, &deltaAction{MetaData: meta})
+		}
+	}
+
+	partitionValues := make(map[string]*string)
+
+	for _, column := range meta.PartitionColumns {
+		for _, partition := range partitions {
+			if strings.EqualFold(partition.name, column) {
+				value := fmt.Sprint(partition.value)
+				partitionValues[column] = &value
+			}
+		}
+
+		if _, ok := partitionValues[column]; !ok {
+			return 0, fmt.Errorf("delta table is partitioned by %s, files only have the partition values of %s", column, deltaPartitionNames(partitions))
+		}
+	}
+
+	indexed := deltaIndexedColumns
+
+	if value, err := strconv.Atoi(meta.Configuration["delta.dataSkippingNumIndexedCols"]); err == nil {
+		indexed = value
+	}
+
+	stats, err := json.Marshal(newDeltaStats(file.stats, meta.PartitionColumns, indexed))
+
+	if err != nil {
+		return 0, err
+	}
+
+	actions = append(actions, &deltaAction{Add: &deltaAdd{
+		Path:             deltaPath(file.path),
+		PartitionValues:  partitionValues,
+		Size:             file.size,
+		ModificationTime: now,
+		DataChange:       true,
+		Stats:            string(stats),
+	}})
+
+	partitionBy, _ := json.Marshal(meta.PartitionColumns)
+	lines := make([][]byte, 0, len(actions)+1)
+	commitInfo := &deltaAction{CommitInfo: map[string]interface{}{
+		"timestamp":           now,
+		"operation":           "WRITE",
+		"operationParameters": map[string]string{"mode": "Append", "partitionBy": string(partitionBy)},
+		"isBlindAppend":       true,
+		"engineInfo":          "data2parquet",
+		"txnId":               newUUID(),
+	}}
+
+	for _, action := range append([]*deltaAction{commitInfo}, actions...) {
+		line, err := json.Marshal(action)
+
+		if err != nil {
+			return 0, err
+		}
+
+		lines = append(lines, line)
+	}
+
+	version := state.version + 1
+
+	if err = t.store.putIfAbsent(deltaVersionName(version), append(bytes.Join(lines, []byte("\n")), '\n')); err != nil {
+		return 0, err
+	}
+
+	for _, action := range actions {
+		state.apply(action)
+	}
+
+	state.version = version
+
+	return version, nil
+}
+
+// update reads the commits after the known version of the table, loading the last checkpoint the first time. Without
+// `_last_checkpoint` the log is read from version 0, as the log can't be listed on every storage.
+func (t *deltaTable) update() error {
+	if t.state == nil {
+		state := newDeltaState()
+		data, err := t.store.readObject(deltaLastCheckpoint)
+
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		if err == nil {
+			last := struct {
+				Version int64 `json:"version"`
+				Parts   int   `json:"parts"`
+			}{}
+
+			if err = json.Unmarshal(data, &last); err != nil {
+				return fmt.Errorf("invalid delta last checkpoint: %w", err)
+			}
+
+			if err = t.loadCheckpoint(state, last.Version, last.Parts); err != nil {
+				return err
+			}
+
+			state.version = last.Version
+		}
+
+		t.state = state
+	}
+
+	for {
+		name := deltaVersionName(t.state.version + 1)
+		data, err := t.store.readObject(name)
+
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		for _, line := range bytes.Split(data, []byte("\n")) {
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue
+			}
+
+			action := &deltaAction{}
+
+			if err = json.Unmarshal(line, action); err != nil {
+				return fmt.Errorf("invalid delta commit %s: %w", name, err)
+			}
+
+			t.state.apply(action)
+		}
+
+		t.state.version++
+	}
+}
+
+func (t *deltaTable) loadCheckpoint(state *deltaState, version int64, parts int) error {
+	names := []string{fmt.Sprintf("_delta_log/%020d.checkpoint.parquet", version)}
+
+	if parts > 0 {
+		names = names[:0]
+
+		for part := 1; part <= parts; part++ {
+			names = append(names, fmt.Sprintf("_delta_log/%020d.checkpoint.%010d.%010d.parquet", version, part, parts))
+		}
+	}
+
+	for _, name := range names {
+		data, err := t.store.readObject(name)
+
+		if err != nil {
+			return fmt.Errorf("error reading delta checkpoint %s: %w", name, err)
+		}
+
+		actions, err := readDeltaCheckpoint(data)
+
+		if err != nil {
+			return fmt.Errorf("invalid delta checkpoint %s: %w", name, err)
+		}
+
+		for _, action := range actions {
+			state.apply(action)
+		}
+	}
+
+	return nil
+}
+
+func (t *deltaTable) checkpointDue(version int64) bool {
+	interval := deltaCheckpointInterval
+
+	if t.state != nil && t.state.metaData != nil {
+		if value, err := strconv.Atoi(t.state.metaData.Configuration["delta.checkpointInterval"]); err == nil && value > 0 {
+			interval = value
+		}
+	}
+
+	return version > 0 && version%int64(interval) == 0
+}
+
+// checkpoint writes the state of the table at its version, with the tombstones of files removed in the retention of
+// vacuum, and points `_last_checkpoint` to it. Null partition values are left out of the maps of a checkpoint.
+func (t *deltaTable) checkpoint() error {
+	state := t.state
+	rows := make([]*deltaAction, 0, len(state.files)+len(state.txns)+2)
+
+	if state.protocol == nil || state.metaData == nil {
+		return errors.New("delta table without protocol or metadata")
+	}
+
+	rows = append(rows, &deltaAction{Protocol: state.protocol}, &deltaAction{MetaData: state.metaData})
+
+	for _, id := range sortedKeys(state.txns) {
+		rows = append(rows, &deltaAction{Txn: state.txns[id]})
+	}
+
+	for _, name := range sortedKeys(state.files) {
+		add := *state.files[name]
+		add.PartitionValues = withoutNulls(add.PartitionValues)
+		rows = append(rows, &deltaAction{Add: &add})
+	}
+
+	expired := time.Now().Add(-deltaTombstoneRetention).UnixMilli()
+
+	for _, name := range sortedKeys(state.removes) {
+		remove := *state.removes[name]
+
+		if remove.DeletionTimestamp != nil && *remove.DeletionTimestamp < expired {
+			continue
+		}
+
+		remove.PartitionValues = withoutNulls(remove.PartitionValues)
+		rows = append(rows, &deltaAction{Remove: &remove})
+	}
+
+	schemaJSON, err := json.Marshal(deltaCheckpointSchema())
+
+	if err != nil {
+		return err
+	}
+
+	buf := &bytes.Buffer{}
+	pw, err := pwriter.NewParquetWriterFromWriter(buf, string(schemaJSON), 1)
+
+	if err != nil {
+		return err
+	}
+
+	pw.MarshalFunc = marshal.MarshalJSON
+
+	for _, row := range rows {
+		data, err := json.Marshal(row)
+
+		if err != nil {
+			return err
+		}
+
+		if err = pw.Write(string(data)); err != nil {
+			return err
+		}
+	}
+
+	if err = pw.WriteStop(); err != nil {
+		return err
+	}
+
+	if err = t.store.writeObject(fmt.Sprintf("_delta_log/%020d.checkpoint.parquet", state.version), buf.Bytes()); err != nil {
+		return err
+	}
+
+	last, _ := json.Marshal(map[string]int64{"version": state.version, "size": int64(len(rows))})
+
+	if err = t.store.writeObject(deltaLastCheckpoint, last); err != nil {
+		return err
+	}
+
+	slog.Info("Delta checkpoint written", "module", "writer.delta", "function", "checkpoint", "version", state.version, "files", len(state.files))
+
+	return nil
+}
+
+// readDeltaCheckpoint reads the actions of a checkpoint, with the columns of the checkpoint schema it has.
+func readDeltaCheckpoint(data []byte) ([]*deltaAction, error) {
+	stats, err := readParquetStats(bytes.NewReader(data), int64(len(data)))
+
+	if err != nil {
+		return nil, err
+	}
+
+	pruned := pruneSchema(deltaCheckpointSchema(), "", stats.columns)
+
+	if pruned == nil {
+		return nil, errors.New("checkpoint without actions")
+	}
+
+	schemaJSON, err := json.Marshal(pruned)
+
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := buffer.NewBufferFile(data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pr, err := reader.NewParquetReader(file, string(schemaJSON), 1)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer pr.ReadStop()
+
+	rows, err := pr.ReadByNumber(int(pr.GetNumRows()))
+
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]*deltaAction, 0, len(rows))
+
+	// Rows have the fields of the schema with exported names, they are matched to the actions ignoring case.
+	for _, row := range rows {
+		data, err := json.Marshal(row)
+
+		if err != nil {
+			return nil, err
+		}
+
+		action := &deltaAction{}
+
+		if err = json.Unmarshal(data, action); err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, action)
+	}
+
+	return ret, nil
+}
+
+func newDeltaState() *deltaState {
+	return &deltaState{
+		version: -1,
+		txns:    make(map[string]*deltaTxn),
+		files:   make(map[string]*deltaAdd),
+		removes: make(map[string]*deltaRemove),
+	}
+}
+
+func (s *deltaState) apply(action *deltaAction) {
+	switch {
+	case action.Protocol != nil:
+		s.protocol = action.Protocol
+	case action.MetaData != nil:
+		meta := action.MetaData
+
+		if meta.PartitionColumns == nil {
+			meta.PartitionColumns = []string{}
+		}
+
+		if meta.Configuration == nil {
+			meta.Configuration = map[string]string{}
+		}
+
+		if meta.Format.Options == nil {
+			meta.Format.Options = map[string]string{}
+		}
+
+		s.metaData = meta
+	case action.Txn != nil:
+		s.txns[action.Txn.AppID] = action.Txn
+	case action.Add != nil:
+		s.files[action.Add.Path] = action.Add
+		delete(s.removes, action.Add.Path)
+	case action.Remove != nil:
+		s.removes[action.Remove.Path] = action.Remove
+		delete(s.files, action.Remove.Path)
+	}
+}
+
+// validate checks the table can be appended by this writer, with writer version 2 or the features it supports.
+func (p *deltaProtocol) validate() error {
+	if p.MinReaderVersion > 3 {
+		return fmt.Errorf("delta table has reader version %d, only versions up to 3 are supported", p.MinReaderVersion)
+	}
+
+	if p.MinWriterVersion == 7 {
+		for _, feature := range p.WriterFeatures {
+			if !deltaWriterFeatures[feature] {
+				return fmt.Errorf("delta table has writer feature %s, which is not supported", feature)
+			}
+		}
+
+		return nil
+	}
+
+	if p.MinWriterVersion > 2 {
+		return fmt.Errorf("delta table has writer version %d, only version 2 is supported", p.MinWriterVersion)
+	}
+
+	return nil
+}
+
+func deltaVersionName(version int64) string {
+	return fmt.Sprintf("_delta_log/%020d.json", version)
+}
+
+func deltaPartitionNames(partitions []tablePartition) string {
+	names := make([]string, 0, len(partitions))
+
+	for _, partition := range partitions {
+		names = append(names, partition.name)
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// deltaPath is the relative URI of a data file, colons are escaped so names with record keys are not read as schemes.
+func deltaPath(name string) string {
+	parts := strings.Split(name, "/")
+
+	for i, part := range parts {
+		parts[i] = strings.ReplaceAll(url.PathEscape(part), ":", "%3A")
+	}
+
+	return strings.Join(parts, "/")
+}
+
+func deltaHasField(t *deltaType, name string) bool {
+	for _, f := range t.Fields {
+		if strings.EqualFold(f.Name, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func withoutNulls(values map[string]*string) map[string]*string {
+	ret := make(map[string]*string)
+
+	for k, v := range values {
+		if v != nil {
+			ret[k] = v
+		}
+	}
+
+	return ret
+}
+
+func sortedKeys[T any](values map[string]T) []string {
+	ret := make([]string, 0, len(values))
+
+	for k := range values {
+		ret = append(ret, k)
+	}
+
+	sort.Strings(ret)
+
+	return ret
+}
@@ -0,0 +1,393 @@
+package writer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"data2parquet/pkg/config"
+)
+
+// FanOut writes each file to all the writers of WriterTargets. A failure of a `required` target fails the write, a
+// failure of a `best-effort` target is only logged and accounted.
+type FanOut struct {
+	config    *config.Config
+	ctx       context.Context
+	targets   []*fanOutTarget
+	mu        sync.Mutex
+	delivered map[string]map[string]bool
+	pending   []string
+}
+
+// fanOutMaxPending is the number of failed writes whose delivered targets are kept for their replay.
+const fanOutMaxPending = 1024
+
+type fanOutTarget struct {
+	name        string
+	policy      string
+	maxAttempts int
+	writer      Writer
+	mu          sync.Mutex
+	initialized bool
+	stats       TargetStats
+}
+
+// TargetStats is the accounting of a fan-out target, Retries counts the attempts after the first one of each file and
+// Recoveries the successful writes after a failure.
+type TargetStats struct {
+	Name                string `json:"name"`
+	Policy              string `json:"policy"`
+	Ready               bool   `json:"ready"`
+	Writes              int    `json:"writes"`
+	Failures            int    `json:"failures"`
+	Retries             int    `json:"retries"`
+	Recoveries          int    `json:"recoveries"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	LastError           string `json:"last_error,omitempty"`
+}
+
+// spooledFile is the data of a file for one attempt on a target, keeping the number of records of the original data.
+type spooledFile struct {
+	*os.File
+	records int
+}
+
+func (s *spooledFile) Records() int {
+	return s.records
+}
+
+func NewFanOut(ctx context.Context, cfg *config.Config) Writer {
+	targets, err := cfg.GetWriterTargets()
+
+	if err != nil {
+		slog.Error("Invalid writer targets", "error", err, "module", "writer.fan-out", "function", "NewFanOut")
+		return nil
+	}
+
+	if len(targets) == 0 {
+		slog.Error("Fan-out writer without WriterTargets", "module", "writer.fan-out", "function", "NewFanOut")
+		return nil
+	}
+
+	ret := &FanOut{
+		config:    cfg,
+		ctx:       ctx,
+		targets:   make([]*fanOutTarget, 0, len(targets)),
+		delivered: make(map[string]map[string]bool),
+		pending:   make([]string, 0),
+	}
+
+	for _, target := range targets {
+		c := *cfg
+		c.WriterTargets = ""
+		c.WriterType = config.WriterTypeFile
+
+		if err := c.Set(target.Settings); err != nil {
+			slog.Error("Invalid writer target settings", "error", err, "module", "writer.fan-out", "function", "NewFanOut", "target", target.Name)
+			return nil
+		}
+
+		if c.WriterType == config.WriterTypeFanOut {
+			slog.Error("Writer target must set a WriterType other than fan-out", "module", "writer.fan-out", "function", "NewFanOut", "target", target.Name)
+			return nil
+		}
+
+		c.SetDefaults()
+		w := New(ctx, &c)
+
+		if w == nil {
+			slog.Error("Error creating writer target", "module", "writer.fan-out", "function", "NewFanOut", "target", target.Name, "type", c.WriterType)
+			return nil
+		}
+
+		ret.targets = append(ret.targets, &fanOutTarget{
+			name:        target.Name,
+			policy:      target.Policy,
+			maxAttempts: target.MaxAttempts,
+			writer:      w,
+			stats:       TargetStats{Name: target.Name, Policy: target.Policy},
+		})
+	}
+
+	return ret
+}
+
+// Init initializes all the targets, it fails when a required target fails. A best-effort target that fails is
+// initialized again before its next write.
+func (f *FanOut) Init() error {
+	errs := make([]error, 0)
+
+	for _, t := range f.targets {
+		err := t.init()
+
+		if err == nil {
+			continue
+		}
+
+		if t.policy == config.WriterTargetPolicyRequired {
+			errs = append(errs, fmt.Errorf("writer target %s: %w", t.name, err))
+			continue
+		}
+
+		slog.Warn("Error initializing best-effort writer target", "error", err, "module", "writer.fan-out", "function", "Init", "target", t.name)
+	}
+
+	return errors.Join(errs...)
+}
+
+// Write spools the data once and writes it to all the targets in parallel, each one tried up to its max attempts.
+// When a required target fails, the targets that stored the file are kept by key and MD5 of the data, so the replay
+// of the same file skips them instead of writing it twice.
+func (f *FanOut) Write(key string, data io.Reader) error {
+	start := time.Now()
+	spool, sum, err := spoolFile(data)
+
+	if err != nil {
+		slog.Error("Error spooling data", "error", err, "module", "writer.fan-out", "function", "Write", "key", key)
+		return err
+	}
+
+	spool.Close()
+	defer os.Remove(spool.Name())
+
+	id := key + ":" + sum
+	delivered := f.deliveredTargets(id)
+	records := recordCount(data)
+	errs := make([]error, len(f.targets))
+	written := make([]bool, len(f.targets))
+	wg := sync.WaitGroup{}
+
+	for i, t := range f.targets {
+		if delivered[t.name] {
+			slog.Debug("File already written to writer target, skipping it", "module", "writer.fan-out", "function", "Write", "key", key, "target", t.name)
+			written[i] = true
+			continue
+		}
+
+		wg.Add(1)
+
+		go func(i int, t *fanOutTarget) {
+			defer wg.Done()
+
+			err := f.writeTarget(t, key, spool.Name(), records)
+			written[i] = err == nil
+
+			if err != nil && t.policy == config.WriterTargetPolicyRequired {
+				errs[i] = fmt.Errorf("writer target %s: %w", t.name, err)
+			}
+		}(i, t)
+	}
+
+	wg.Wait()
+
+	if err = errors.Join(errs...); err != nil {
+		f.keepDelivered(id, written)
+		return err
+	}
+
+	f.keepDelivered(id, nil)
+
+	slog.Info("File written to writer targets", "key", key, "targets", len(f.targets), "duration", time.Since(start))
+
+	return nil
+}
+
+// deliveredTargets returns the names of the targets that already stored the file id in a failed write.
+func (f *FanOut) deliveredTargets(id string) map[string]bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ret := make(map[string]bool)
+
+	for name := range f.delivered[id] {
+		ret[name] = true
+	}
+
+	return ret
+}
+
+// keepDelivered keeps the targets written for the file id, forgetting it when written is nil. The oldest file is
+// forgotten past fanOutMaxPending, its replay is written again to all the targets.
+func (f *FanOut) keepDelivered(id string, written []bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if written == nil {
+		if _, found := f.delivered[id]; found {
+			delete(f.delivered, id)
+
+			for i, pending := range f.pending {
+				if pending == id {
+					f.pending = append(f.pending[:i], f.pending[i+1:]...)
+					break
+				}
+			}
+		}
+
+		return
+	}
+
+	if _, found := f.delivered[id]; !found {
+		if len(f.pending) >= fanOutMaxPending {
+			delete(f.delivered, f.pending[0])
+			f.pending = f.pending[1:]
+		}
+
+		f.delivered[id] = make(map[string]bool)
+		f.pending = append(f.pending, id)
+	}
+
+	for i, t := range f.targets {
+		if written[i] {
+			f.delivered[id][t.name] = true
+		}
+	}
+}
+
+func (f *FanOut) writeTarget(t *fanOutTarget, key string, path string, records int) error {
+	err := t.init()
+
+	for attempt := 1; err == nil; attempt++ {
+		if err = t.write(key, path, records); err == nil || attempt >= t.maxAttempts {
+			break
+		}
+
+		slog.Warn("Error writing to writer target, retrying", "error", err, "module", "writer.fan-out", "function", "writeTarget", "key", key, "target", t.name, "attempt", attempt)
+		t.account(func(stats *TargetStats) { stats.Retries++ })
+
+		select {
+		case <-f.ctx.Done():
+			err = f.ctx.Err()
+		case <-time.After(backoff(attempt)):
+			err = nil
+		}
+	}
+
+	t.account(func(stats *TargetStats) {
+		if err != nil {
+			stats.Failures++
+			stats.ConsecutiveFailures++
+			stats.LastError = err.Error()
+			return
+		}
+
+		stats.Writes++
+
+		if stats.ConsecutiveFailures > 0 {
+			slog.Info("Writer target recovered", "module", "writer.fan-out", "function", "writeTarget", "key", key, "target", t.name, "failures", stats.ConsecutiveFailures)
+			stats.Recoveries++
+			stats.ConsecutiveFailures = 0
+		}
+	})
+
+	if err != nil {
+		slog.Error("Error writing to writer target", "error", err, "module", "writer.fan-out", "function", "writeTarget", "key", key, "target", t.name, "policy", t.policy)
+	}
+
+	return err
+}
+
+// init initializes the writer of the target once, an error is kept as its last error.
+func (t *fanOutTarget) init() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.initialized {
+		return nil
+	}
+
+	if err := t.writer.Init(); err != nil {
+		t.stats.LastError = err.Error()
+		return err
+	}
+
+	t.initialized = true
+
+	return nil
+}
+
+func (t *fanOutTarget) write(key string, path string, records int) error {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	return t.writer.Write(key, &spooledFile{File: file, records: records})
+}
+
+func (t *fanOutTarget) account(update func(stats *TargetStats)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	update(&t.stats)
+}
+
+// Targets returns the accounting of each target, in the order of WriterTargets.
+func (f *FanOut) Targets() []TargetStats {
+	ret := make([]TargetStats, 0, len(f.targets))
+
+	for _, t := range f.targets {
+		t.mu.Lock()
+		stats := t.stats
+		t.mu.Unlock()
+
+		stats.Ready = t.status() == nil
+		ret = append(ret, stats)
+	}
+
+	return ret
+}
+
+func (t *fanOutTarget) status() error {
+	t.mu.Lock()
+	initialized := t.initialized
+	t.mu.Unlock()
+
+	if !initialized {
+		return errors.New("writer is not initialized")
+	}
+
+	return t.writer.Status()
+}
+
+func (f *FanOut) Close() error {
+	slog.Debug("Closing fan-out writer")
+	errs := make([]error, 0)
+
+	for _, t := range f.targets {
+		if err := t.writer.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("writer target %s: %w", t.name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (f *FanOut) IsReady() bool {
+	return f.Status() == nil
+}
+
+// Status joins the errors of the required targets that are not ready, best-effort targets never make the writer not
+// ready, their health is reported by Targets.
+func (f *FanOut) Status() error {
+	errs := make([]error, 0)
+
+	for _, t := range f.targets {
+		if t.policy != config.WriterTargetPolicyRequired {
+			continue
+		}
+
+		if err := t.status(); err != nil {
+			errs = append(errs, fmt.Errorf("writer target %s: %w", t.name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -1,26 +1,130 @@
 package writer
 
 import (
-	"bytes"
 	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"data2parquet/pkg/config"
 	"data2parquet/pkg/domain"
 )
 
+const successMarker = "_SUCCESS"
+const manifestMarker = "_manifest.json"
+
+// File writes each parquet file to a hidden temporary file in its directory, syncs it and renames it to the final
+// name, so readers of WriterFilePath never see a partial file.
 type File struct {
-	config *config.Config
-	ctx    context.Context
+	config   *config.Config
+	ctx      context.Context
+	template *PathTemplate
+	fileMode os.FileMode
+	dirMode  os.FileMode
+	marker   string
+	root     string
+	table    table
+	mu       sync.Mutex
+}
+
+// manifest is the `_manifest.json` of a partition directory, with the files written to it.
+type manifest struct {
+	Files []manifestFile `json:"files"`
+}
+
+type manifestFile struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	Records   int       `json:"records"`
+	MD5       string    `json:"md5"`
+	WrittenAt time.Time `json:"written_at"`
 }
 
 func NewFile(ctx context.Context, config *config.Config) Writer {
-	return &File{
-		config: config,
-		ctx:    ctx,
+	ret := &File{
+		config:   config,
+		ctx:      ctx,
+		fileMode: 0644,
+		dirMode:  0755,
+		marker:   strings.ToLower(config.WriterFileMarker),
+		root:     config.WriterFilePath,
+	}
+
+	if err := ret.setOptions(); err != nil {
+		slog.Error("Invalid file writer options", "error", err, "module", "writer.file", "function", "NewFile")
+		return nil
+	}
+
+	if len(config.WriterPathTemplate) > 0 {
+		template, err := NewPathTemplate(config.WriterPathTemplate)
+
+		if err != nil {
+			slog.Error("Invalid writer path template", "error", err, "module", "writer.file", "function", "NewFile")
+			return nil
+		}
+
+		ret.template = template
+	}
+
+	// Tables keep data files and metadata under WriterTablePath, their locations are absolute URIs.
+	root, err := filepath.Abs(filepath.Join(config.WriterFilePath, config.WriterTablePath))
+
+	if err != nil {
+		slog.Error("Invalid writer table path", "error", err, "module", "writer.file", "function", "NewFile")
+		return nil
+	}
+
+	if ret.table = newTable(config, &fileStore{file: ret, root: root}); ret.table != nil {
+		ret.root = root
+	}
+
+	return ret
+}
+
+// setOptions validates the permissions and the marker of the written files.
+func (f *File) setOptions() error {
+	var err error
+
+	if f.fileMode, err = parseMode(f.config.WriterFileMode, f.fileMode); err != nil {
+		return fmt.Errorf("invalid WriterFileMode: %w", err)
+	}
+
+	if f.dirMode, err = parseMode(f.config.WriterDirMode, f.dirMode); err != nil {
+		return fmt.Errorf("invalid WriterDirMode: %w", err)
+	}
+
+	if len(f.marker) == 0 {
+		f.marker = config.WriterFileMarkerNone
+	}
+
+	if _, ok := config.WriterFileMarkers[f.marker]; !ok {
+		return fmt.Errorf("invalid WriterFileMarker %q, use none, success or manifest", f.marker)
+	}
+
+	return nil
+}
+
+func parseMode(value string, def os.FileMode) (os.FileMode, error) {
+	if len(value) == 0 {
+		return def, nil
+	}
+
+	mode, err := strconv.ParseUint(value, 8, 32)
+
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("%q is not octal permissions as 0644", value)
 	}
+
+	return os.FileMode(mode), nil
 }
 
 func (f *File) Init() error {
@@ -28,45 +132,248 @@ func (f *File) Init() error {
 	return nil
 }
 
-func (f *File) Write(key string, buf *bytes.Buffer) error {
+// Write copies the data to a hidden temporary file, in the final directory or, when the name needs the hash or the
+// number of records, in WriterFilePath. The file is synced and renamed, then its directory is synced and, with
+// WriterTableFormat, it is committed to the table.
+func (f *File) Write(key string, data io.Reader) error {
 	start := time.Now()
-
-	recInfo := domain.NewRecordInfoFromKey(f.config.RecordType, key)
+	complete := needsComplete(f.template, f.config.UseHash)
+	info := domain.NewRecordInfoFromKey(f.config.RecordType, key)
 	id := domain.MakeID()
-	var hash = ""
-	if f.config.UseHash {
-		hash = "-" + domain.GetMD5Sum(buf.Bytes())
+	filePath := ""
+	dir := f.root
+	pattern := ".data2parquet-*.tmp"
+
+	if !complete {
+		filePath = filepath.Join(f.root, targetPath(f.template, info, id, "", 0))
+		dir = filepath.Dir(filePath)
+		pattern = "." + filepath.Base(filePath) + "-*.tmp"
+	}
+
+	if err := f.mkdirAll(dir); err != nil {
+		slog.Error("Error creating directory", "error", err, "module", "writer.file", "function", "Write", "key", key, "path", dir)
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, pattern)
+
+	if err != nil {
+		slog.Error("Error creating temporary file", "error", err, "module", "writer.file", "function", "Write", "key", key, "path", dir)
+		return err
+	}
+
+	hash := md5.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), data)
+
+	if err = f.closeTemp(tmp, err); err != nil {
+		slog.Error("Error writing to temporary file, removing it", "error", err, "module", "writer.file", "function", "Write", "key", key, "file", tmp.Name())
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+	records := recordCount(data)
+
+	if complete {
+		filePath = filepath.Join(f.root, targetPath(f.template, info, id, sum, records))
+
+		if err = f.mkdirAll(filepath.Dir(filePath)); err != nil {
+			slog.Error("Error creating directory", "error", err, "module", "writer.file", "function", "Write", "key", key, "file", filePath)
+			os.Remove(tmp.Name())
+			return err
+		}
+	}
+
+	if err = f.commit(tmp.Name(), filePath); err != nil {
+		slog.Error("Error moving temporary file", "error", err, "module", "writer.file", "function", "Write", "key", key, "file", filePath)
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if f.table != nil {
+		if err = f.commitTable(filePath, size, info); err != nil {
+			slog.Error("Error committing file to table, removing it", "error", err, "module", "writer.file", "function", "Write", "key", key, "file", filePath, "format", f.config.WriterTableFormat)
+			os.Remove(filePath)
+			return err
+		}
+	}
+
+	// The data file is already committed, returning an error here would make the caller write it again.
+	if err = f.mark(filePath, manifestFile{Name: filepath.Base(filePath), Size: size, Records: records, MD5: sum, WrittenAt: time.Now().UTC()}); err != nil {
+		slog.Error("Error writing partition marker, data file is kept", "error", err, "module", "writer.file", "function", "Write", "key", key, "file", filePath, "marker", f.marker)
+	}
+
+	slog.Info("File written", "key", key, "file", filePath, "duration", time.Since(start), "file-size", size)
+
+	return nil
+}
+
+func (f *File) commitTable(filePath string, size int64, info domain.RecordInfo) error {
+	data, err := os.Open(filePath)
+
+	if err != nil {
+		return err
 	}
-	filePath := f.config.WriterFilePath + "/" + recInfo.Target(id, hash)
 
-	err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm)
+	defer data.Close()
+
+	name, err := filepath.Rel(f.root, filePath)
 
 	if err != nil {
-		slog.Error("Error creating directory", "error", err, "key", key, "file", filePath)
 		return err
 	}
 
-	file, err := os.Create(filePath)
+	file, err := newTableFile(filepath.ToSlash(name), data, size, info)
 
 	if err != nil {
-		slog.Error("Error creating file", "error", err, "key", key, "file", filePath)
 		return err
 	}
 
-	defer file.Close()
+	return f.table.Commit(file)
+}
+
+// closeTemp sets WriterFileMode on a temporary file and syncs it before closing, err is the error writing it.
+func (f *File) closeTemp(tmp *os.File, err error) error {
+	if err == nil {
+		err = tmp.Chmod(f.fileMode)
+	}
+
+	if err == nil {
+		err = tmp.Sync()
+	}
+
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// commit renames the temporary file to its final name and syncs the directories, so the rename survives a crash.
+func (f *File) commit(tmp string, filePath string) error {
+	if err := os.Rename(tmp, filePath); err != nil {
+		return err
+	}
+
+	if err := syncDir(filepath.Dir(filePath)); err != nil {
+		return err
+	}
+
+	if filepath.Dir(tmp) != filepath.Dir(filePath) {
+		return syncDir(filepath.Dir(tmp))
+	}
+
+	return nil
+}
+
+// mark updates the marker of the partition directory of filePath, `_SUCCESS` or `_manifest.json`, written
+// atomically as the data files.
+func (f *File) mark(filePath string, file manifestFile) error {
+	dir := filepath.Dir(filePath)
+
+	switch f.marker {
+	case config.WriterFileMarkerSuccess:
+		return f.writeAtomic(filepath.Join(dir, successMarker), []byte{})
+	case config.WriterFileMarkerManifest:
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		path := filepath.Join(dir, manifestMarker)
+		current := &manifest{Files: make([]manifestFile, 0)}
+		data, err := os.ReadFile(path)
+
+		if err == nil {
+			err = json.Unmarshal(data, current)
+		}
+
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("error reading manifest %s: %w", path, err)
+		}
+
+		current.Files = append(current.Files, file)
+
+		if data, err = json.MarshalIndent(current, "", "  "); err != nil {
+			return err
+		}
+
+		return f.writeAtomic(path, data)
+	}
+
+	return nil
+}
 
-	l, err := file.ReadFrom(buf)
+func (f *File) writeAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*.tmp")
 
 	if err != nil {
-		slog.Error("Error writing to file", "error", err, "key", key, "file", filePath)
 		return err
 	}
 
-	slog.Info("File written", "key", key, "file", filePath, "duration", time.Since(start), "file-size", l)
+	_, err = tmp.Write(data)
+
+	if err = f.closeTemp(tmp, err); err == nil {
+		err = f.commit(tmp.Name(), path)
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
 
 	return err
 }
 
+// mkdirAll creates the missing directories of path with WriterDirMode, regardless of the umask, syncing the parent
+// of each one created.
+func (f *File) mkdirAll(path string) error {
+	info, err := os.Stat(path)
+
+	if err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", path)
+		}
+
+		return nil
+	}
+
+	if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	parent := filepath.Dir(path)
+
+	if parent != path {
+		if err = f.mkdirAll(parent); err != nil {
+			return err
+		}
+	}
+
+	if err = os.Mkdir(path, f.dirMode); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return nil
+		}
+
+		return err
+	}
+
+	if err = os.Chmod(path, f.dirMode); err != nil {
+		return err
+	}
+
+	return syncDir(parent)
+}
+
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+
+	if err != nil {
+		return err
+	}
+
+	defer dir.Close()
+
+	return dir.Sync()
+}
+
 func (f *File) Close() error {
 	slog.Debug("Closing file writer")
 	return nil
@@ -75,3 +382,7 @@ func (f *File) Close() error {
 func (f *File) IsReady() bool {
 	return true
 }
+
+func (f *File) Status() error {
+	return nil
+}
@@ -0,0 +1,307 @@
+package writer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"data2parquet/pkg/config"
+	"data2parquet/pkg/domain"
+
+	"cloud.google.com/go/storage"
+	"github.com/googleapis/gax-go/v2"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+// gcsChunkAlign is the size resumable upload chunks must be a multiple of, but the last one.
+const gcsChunkAlign = 256 << 10
+
+const gcsMaxAttempts = 3
+
+type GCS struct {
+	config    *config.Config
+	client    *storage.Client
+	bucket    *storage.BucketHandle
+	ctx       context.Context
+	template  *PathTemplate
+	prefix    string
+	chunkSize int64
+	status    writerStatus
+}
+
+func NewGCS(ctx context.Context, config *config.Config) Writer {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if len(config.GCSBucketName) == 0 {
+		slog.Error("GCS bucket name is empty", "module", "writer.gcs", "function", "NewGCS")
+		return nil
+	}
+
+	ret := &GCS{
+		config:    config,
+		ctx:       ctx,
+		prefix:    strings.Trim(config.GCSPrefix, "/"),
+		chunkSize: config.GCSChunkSize / gcsChunkAlign * gcsChunkAlign,
+	}
+
+	if ret.chunkSize < gcsChunkAlign {
+		ret.chunkSize = 16 << 20
+	}
+
+	if len(config.WriterPathTemplate) > 0 {
+		template, err := NewPathTemplate(config.WriterPathTemplate)
+
+		if err != nil {
+			slog.Error("Invalid writer path template", "error", err, "module", "writer.gcs", "function", "NewGCS")
+			return nil
+		}
+
+		ret.template = template
+	}
+
+	slog.Info("Creating GCS writer")
+
+	return ret
+}
+
+func (g *GCS) Init() error {
+	opts := make([]option.ClientOption, 0)
+
+	if len(g.config.GCSEndpoint) > 0 {
+		endpoint := g.config.GCSEndpoint
+
+		if !strings.Contains(endpoint, "://") {
+			endpoint = "http://" + endpoint
+		}
+
+		opts = append(opts, option.WithEndpoint(strings.TrimSuffix(endpoint, "/")+"/storage/v1/"))
+	}
+
+	if len(g.config.GCSCredentialsFile) > 0 {
+		opts = append(opts, option.WithCredentialsFile(g.config.GCSCredentialsFile))
+	}
+
+	slog.Debug("Initializing GCS writer", "config", g.config.ToJSON(), "emulator", os.Getenv("STORAGE_EMULATOR_HOST"))
+
+	// Like the other Google SDKs, STORAGE_EMULATOR_HOST is used without credentials.
+	client, err := storage.NewClient(g.ctx, opts...)
+
+	if err != nil {
+		slog.Error("Invalid GCS credentials configuration", "error", err, "module", "writer.gcs", "function", "Init")
+		return err
+	}
+
+	g.client = client
+	g.bucket = client.Bucket(g.config.GCSBucketName)
+
+	err = g.CheckBucket()
+	g.setStatus(err)
+
+	if err != nil {
+		slog.Error("GCS writer is not ready", "error", err, "kind", ErrorKind(err), "module", "writer.gcs", "function", "Init")
+		return err
+	}
+
+	slog.Info("GCS writer initialized")
+
+	return nil
+}
+
+// CheckBucket lists at most one object of the prefix, so it only needs the object permissions used to write.
+func (g *GCS) CheckBucket() error {
+	query := &storage.Query{}
+
+	if len(g.prefix) > 0 {
+		query.Prefix = g.prefix + "/"
+	}
+
+	if err := query.SetAttrSelection([]string{"Name"}); err != nil {
+		return err
+	}
+
+	objects := g.bucket.Retryer(storage.WithMaxAttempts(gcsMaxAttempts), storage.WithBackoff(gcsBackoff())).Objects(g.ctx, query)
+	objects.PageInfo().MaxSize = 1
+
+	_, err := objects.Next()
+
+	if err != nil && err != iterator.Done {
+		err = g.classify("gs://"+g.config.GCSBucketName, err)
+		slog.Error("Error checking GCS bucket", "error", err, "kind", ErrorKind(err), "module", "writer.gcs", "function", "CheckBucket", "bucket", g.config.GCSBucketName)
+		return err
+	}
+
+	slog.Info("GCS bucket checked", "bucket", g.config.GCSBucketName, "prefix", g.prefix)
+
+	return nil
+}
+
+// classify returns err as a writer Error with its kind and a hint of how to fix it.
+func (g *GCS) classify(target string, err error) error {
+	var werr *Error
+
+	if errors.As(err, &werr) {
+		return err
+	}
+
+	ret := &Error{Kind: ErrorKindUnknown, Target: target, Err: err}
+
+	var gerr *googleapi.Error
+	var rerr *oauth2.RetrieveError
+	var uerr *url.Error
+
+	switch {
+	case errors.Is(err, storage.ErrBucketNotExist), errors.As(err, &gerr) && gerr.Code == http.StatusNotFound:
+		ret.Kind = ErrorKindNotFound
+		ret.Hint = fmt.Sprintf("bucket %s does not exist, create it before starting the writer", g.config.GCSBucketName)
+	case errors.As(err, &gerr) && (gerr.Code == http.StatusForbidden || gerr.Code == http.StatusUnauthorized), errors.As(err, &rerr):
+		ret.Kind = ErrorKindForbidden
+		ret.Hint = "check the GCS credentials and that they allow storage.objects.list and storage.objects.create on the bucket"
+	case errors.As(err, &uerr), errors.Is(err, context.DeadlineExceeded):
+		ret.Kind = ErrorKindNetwork
+		ret.Hint = "check GCSEndpoint and the network access to GCS"
+	}
+
+	return ret
+}
+
+func (g *GCS) setStatus(err error) {
+	g.status.set(err)
+}
+
+func (g *GCS) Write(key string, data io.Reader) error {
+	start := time.Now()
+	hash := ""
+	records := 0
+
+	if needsComplete(g.template, g.config.UseHash) {
+		spool, sum, err := spoolFile(data)
+
+		if err != nil {
+			slog.Error("Error spooling data to hash it", "error", err, "module", "writer.gcs", "function", "Write", "key", key)
+			return err
+		}
+
+		defer spool.Close()
+		defer os.Remove(spool.Name())
+
+		records = recordCount(data)
+		data = spool
+		hash = sum
+	}
+
+	info := domain.NewRecordInfoFromKey(g.config.RecordType, key)
+	name := targetPath(g.template, info, domain.MakeID(), hash, records)
+
+	if len(g.prefix) > 0 {
+		name = g.prefix + "/" + name
+	}
+
+	size, err := g.upload(name, data)
+
+	if err != nil {
+		err = g.classify("gs://"+g.config.GCSBucketName+"/"+name, err)
+		slog.Error("Error writing to GCS", "error", err, "kind", ErrorKind(err), "module", "writer.gcs", "function", "Write", "key", key)
+
+		if ErrorKind(err) != ErrorKindUnknown {
+			g.setStatus(err)
+		}
+
+		return err
+	}
+
+	g.setStatus(nil)
+
+	slog.Info("GCS written", "file", name, "duration", time.Since(start), "file-size", size, "bucket", g.config.GCSBucketName)
+
+	return nil
+}
+
+// upload sends files up to GCSChunkSize in a single request, and bigger files with a resumable upload as they are
+// read. The CRC32C computed while sending is checked against the one of the stored object, and the upload is
+// cancelled when the data can not be read, so no object is created.
+func (g *GCS) upload(name string, data io.Reader) (int64, error) {
+	ctx, cancel := context.WithCancel(g.ctx)
+	defer cancel()
+
+	failures := 0
+
+	// Names are unique, so uploads are retried as idempotent requests, up to gcsMaxAttempts failures of the upload.
+	object := g.bucket.Object(name).Retryer(storage.WithPolicy(storage.RetryAlways), storage.WithBackoff(gcsBackoff()), storage.WithErrorFunc(func(err error) bool {
+		if !storage.ShouldRetry(err) {
+			return false
+		}
+
+		failures++
+
+		return failures < gcsMaxAttempts
+	}))
+
+	w := object.NewWriter(ctx)
+	w.ChunkSize = int(g.chunkSize)
+	w.ContentType = "application/octet-stream"
+
+	sum := crc32.New(crc32c)
+	size, err := io.Copy(w, io.TeeReader(data, sum))
+
+	if err != nil {
+		cancel()
+		w.Close()
+		return size, err
+	}
+
+	if err = w.Close(); err != nil {
+		return size, err
+	}
+
+	attrs := w.Attrs()
+
+	if attrs.Size != size {
+		return size, fmt.Errorf("GCS object %s has %d bytes, %d were sent", name, attrs.Size, size)
+	}
+
+	if attrs.CRC32C != sum.Sum32() {
+		return size, fmt.Errorf("GCS object %s has checksum %08x, expected %08x", name, attrs.CRC32C, sum.Sum32())
+	}
+
+	return size, nil
+}
+
+func gcsBackoff() gax.Backoff {
+	return gax.Backoff{Initial: backoff(1), Max: backoff(gcsMaxAttempts)}
+}
+
+func (g *GCS) Close() error {
+	slog.Debug("Closing GCS writer")
+
+	if g.client != nil {
+		return g.client.Close()
+	}
+
+	return nil
+}
+
+func (g *GCS) IsReady() bool {
+	return g.Status() == nil
+}
+
+// Status returns the classified error of the last bucket check or write, a successful write or a new check after
+// WriterStatusInterval makes it ready again.
+func (g *GCS) Status() error {
+	if g.client == nil {
+		return errors.New("GCS client is not initialized")
+	}
+
+	return g.status.get(time.Duration(g.config.WriterStatusInterval)*time.Millisecond, g.CheckBucket)
+}
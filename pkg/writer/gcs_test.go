@@ -0,0 +1,450 @@
+package writer_test
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	crand "crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"data2parquet/pkg/config"
+	"data2parquet/pkg/writer"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeGCS is an in-process stand-in of the GCS JSON API and the OAuth2 token endpoint used by the writer, it can
+// fail resumable upload chunks after storing half of them, like an interrupted request.
+type fakeGCS struct {
+	fakeStorage
+	sessions   map[string][]byte
+	names      map[string]string
+	failChunks map[int]int
+	chunks     int
+	nextID     int
+	key        *rsa.PublicKey
+	claims     map[string]interface{}
+	tokens     int
+	auth       bool
+}
+
+func newFakeGCS() *fakeGCS {
+	return &fakeGCS{
+		sessions:   make(map[string][]byte),
+		names:      make(map[string]string),
+		failChunks: make(map[int]int),
+	}
+}
+
+func (f *fakeGCS) serve(t *testing.T, closed bool) string {
+	return f.fakeStorage.serve(t, closed, f.handle)
+}
+
+func (f *fakeGCS) handle(w http.ResponseWriter, r *http.Request, body []byte) {
+	if r.URL.Path == "/token" {
+		f.token(w, body)
+		return
+	}
+
+	if f.auth && r.Header.Get("Authorization") != "Bearer gcs-token" {
+		gcsError(w, http.StatusUnauthorized)
+		return
+	}
+
+	if f.fail != 0 {
+		gcsError(w, f.fail)
+		return
+	}
+
+	if !strings.HasPrefix(strings.TrimPrefix(r.URL.Path, "/upload"), "/storage/v1/b/bucket/o") {
+		gcsError(w, http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+
+	switch {
+	case len(query.Get("upload_id")) > 0:
+		f.chunk(w, r, query.Get("upload_id"), body)
+	case r.Method == http.MethodGet:
+		fmt.Fprint(w, `{"kind":"storage#objects"}`)
+	case r.Method == http.MethodPost && query.Get("uploadType") == "multipart":
+		name, data, err := gcsMultipart(r, body)
+
+		if err != nil {
+			gcsError(w, http.StatusBadRequest)
+			return
+		}
+
+		f.store(w, name, data)
+	case r.Method == http.MethodPost && query.Get("uploadType") == "resumable":
+		object := struct {
+			Name string `json:"name"`
+		}{}
+		json.Unmarshal(body, &object)
+
+		f.nextID++
+		id := strconv.Itoa(f.nextID)
+		f.sessions[id] = []byte{}
+		f.names[id] = object.Name
+		w.Header().Set("Location", "http://"+r.Host+r.URL.Path+"?uploadType=resumable&upload_id="+id)
+	default:
+		gcsError(w, http.StatusBadRequest)
+	}
+}
+
+// gcsMultipart returns the name of the object metadata and the media of a single request upload.
+func gcsMultipart(r *http.Request, body []byte) (string, []byte, error) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	parts := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	object := struct {
+		Name string `json:"name"`
+	}{}
+
+	metadata, err := parts.NextPart()
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	if err = json.NewDecoder(metadata).Decode(&object); err != nil {
+		return "", nil, err
+	}
+
+	media, err := parts.NextPart()
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	data, err := io.ReadAll(media)
+
+	return object.Name, data, err
+}
+
+func (f *fakeGCS) token(w http.ResponseWriter, body []byte) {
+	form, _ := url.ParseQuery(string(body))
+	parts := strings.Split(form.Get("assertion"), ".")
+
+	if form.Get("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" || len(parts) != 3 {
+		gcsError(w, http.StatusBadRequest)
+		return
+	}
+
+	signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+
+	if rsa.VerifyPKCS1v15(f.key, crypto.SHA256, sum[:], signature) != nil {
+		gcsError(w, http.StatusUnauthorized)
+		return
+	}
+
+	claims, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	json.Unmarshal(claims, &f.claims)
+	f.tokens++
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, `{"access_token":"gcs-token","expires_in":3600,"token_type":"Bearer"}`)
+}
+
+func (f *fakeGCS) chunk(w http.ResponseWriter, r *http.Request, id string, body []byte) {
+	data, ok := f.sessions[id]
+
+	if !ok {
+		gcsError(w, http.StatusNotFound)
+		return
+	}
+
+	var start, end int64
+	var total string
+
+	if _, err := fmt.Sscanf(r.Header.Get("Content-Range"), "bytes %d-%d/%s", &start, &end, &total); err != nil {
+		fmt.Sscanf(r.Header.Get("Content-Range"), "bytes */%s", &total)
+		start = int64(len(data))
+		body = nil
+	} else if start > int64(len(data)) || end-start+1 != int64(len(body)) {
+		gcsError(w, http.StatusBadRequest)
+		return
+	} else {
+		// Bytes already committed are ignored, like GCS does.
+		body = body[int64(len(data))-start:]
+	}
+
+	f.chunks++
+
+	if f.failChunks[f.chunks] != 0 {
+		f.sessions[id] = append(data, body[:len(body)/2]...)
+		gcsError(w, f.failChunks[f.chunks])
+		return
+	}
+
+	data = append(data, body...)
+	f.sessions[id] = data
+
+	if total != "*" && total == strconv.Itoa(len(data)) {
+		delete(f.sessions, id)
+		f.store(w, f.names[id], data)
+		return
+	}
+
+	if len(data) > 0 {
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(data)-1))
+	}
+
+	// Clients asking not to get 308, which is a redirect for HTTP libraries, get it in a header, like GCS does.
+	if r.Header.Get("X-GUploader-No-308") == "yes" {
+		w.Header().Set("X-HTTP-Status-Code-Override", "308")
+		return
+	}
+
+	w.WriteHeader(http.StatusPermanentRedirect)
+}
+
+func (f *fakeGCS) store(w http.ResponseWriter, name string, data []byte) {
+	f.objects[name] = data
+
+	sum := make([]byte, 4)
+	binary.BigEndian.PutUint32(sum, crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli)))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"bucket": "bucket",
+		"name":   name,
+		"size":   strconv.Itoa(len(data)),
+		"crc32c": base64.StdEncoding.EncodeToString(sum),
+	})
+}
+
+func gcsError(w http.ResponseWriter, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `{"error":{"code":%d,"message":"%s"}}`, status, http.StatusText(status))
+}
+
+func newGCS(settings map[string]string) (writer.Writer, error) {
+	cfg := &config.Config{}
+	values := map[string]string{
+		"RecordType":    config.RecordTypeLog,
+		"WriterType":    config.WriterTypeGCS,
+		"GCSBucketName": "bucket",
+		"GCSChunkSize":  strconv.Itoa(256 << 10),
+	}
+
+	for k, v := range settings {
+		values[k] = v
+	}
+
+	if err := cfg.Set(values); err != nil {
+		return nil, err
+	}
+
+	cfg.SetDefaults()
+
+	w := writer.New(context.Background(), cfg)
+
+	return w, w.Init()
+}
+
+func prepareGCS(t *testing.T, fake *fakeGCS, settings map[string]string) writer.Writer {
+	t.Setenv("STORAGE_EMULATOR_HOST", strings.TrimPrefix(fake.serve(t, false), "http://"))
+
+	w, err := newGCS(settings)
+
+	if err != nil {
+		t.Fatalf("Error initializing GCS writer: %s", err)
+	}
+
+	return w
+}
+
+func TestGCSUpload(t *testing.T) {
+	tests := []struct {
+		name     string
+		size     int
+		chunks   int
+		sessions int
+	}{
+		{name: "single request", size: 100 << 10},
+		{name: "resumable", size: 3*(256<<10) + 100, chunks: 4, sessions: 1},
+		{name: "chunk aligned", size: 2 * (256 << 10), chunks: 3, sessions: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := newFakeGCS()
+			w := prepareGCS(t, fake, map[string]string{
+				"GCSPrefix":          "/lake/",
+				"WriterPathTemplate": "{service}/{id}-{records}.parquet",
+			})
+			data := randomData(test.size)
+
+			if err := w.Write("capability:domain:service:application", &countedReader{bytes.NewReader(data), 10}); err != nil {
+				t.Fatalf("Error writing to GCS: %s", err)
+			}
+
+			if len(fake.objects) != 1 {
+				t.Fatalf("Expected one object, got %d", len(fake.objects))
+			}
+
+			for name, object := range fake.objects {
+				if !strings.HasPrefix(name, "lake/service/") || !strings.HasSuffix(name, "-10.parquet") {
+					t.Errorf("Expected the object name from the template under the prefix, got %s", name)
+				}
+
+				if !bytes.Equal(object, data) {
+					t.Errorf("Expected the object to have the written data")
+				}
+			}
+
+			if fake.chunks != test.chunks || fake.nextID != test.sessions {
+				t.Errorf("Expected %d resumable chunks, got %d in %d sessions", test.chunks, fake.chunks, fake.nextID)
+			}
+		})
+	}
+}
+
+func TestGCSResumableRetry(t *testing.T) {
+	fake := newFakeGCS()
+	fake.failChunks[2] = http.StatusServiceUnavailable
+	w := prepareGCS(t, fake, nil)
+	data := randomData(3 * (256 << 10))
+
+	if err := w.Write("log:capability:domain:service:application", bytes.NewReader(data)); err != nil {
+		t.Fatalf("Error writing to GCS: %s", err)
+	}
+
+	written := make([][]byte, 0)
+	for _, object := range fake.objects {
+		written = append(written, object)
+	}
+
+	if len(written) != 1 || !bytes.Equal(written[0], data) {
+		t.Fatalf("Expected the upload to resume from the committed offset, got %d objects", len(written))
+	}
+
+	fake = newFakeGCS()
+	w = prepareGCS(t, fake, nil)
+
+	for i := 1; i < 10; i++ {
+		fake.failChunks[i] = http.StatusInternalServerError
+	}
+
+	if err := w.Write("log:capability:domain:service:application", bytes.NewReader(data)); err == nil {
+		t.Fatalf("Expected the upload to fail")
+	}
+
+	if len(fake.objects) != 0 {
+		t.Errorf("Expected no objects, got %d", len(fake.objects))
+	}
+
+	fake = newFakeGCS()
+	w = prepareGCS(t, fake, nil)
+
+	if err := w.Write("log:capability:domain:service:application", &failingReader{bytes.NewReader(data)}); err == nil {
+		t.Fatalf("Expected the read error")
+	}
+
+	if len(fake.objects) != 0 {
+		t.Errorf("Expected the upload of a failed read to be cancelled, got %d objects", len(fake.objects))
+	}
+}
+
+func TestGCSCredentials(t *testing.T) {
+	key, err := rsa.GenerateKey(crand.Reader, 2048)
+
+	if err != nil {
+		t.Fatalf("Error generating key: %s", err)
+	}
+
+	fake := newFakeGCS()
+	fake.key = &key.PublicKey
+	fake.auth = true
+	endpoint := fake.serve(t, false)
+
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	account, _ := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"client_email":   "writer@project.iam.gserviceaccount.com",
+		"private_key_id": "key-id",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":      endpoint + "/token",
+	})
+
+	file := filepath.Join(t.TempDir(), "account.json")
+	os.WriteFile(file, account, 0600)
+
+	w, err := newGCS(map[string]string{"GCSEndpoint": endpoint, "GCSCredentialsFile": file})
+
+	if err != nil {
+		t.Fatalf("Error initializing GCS writer: %s", err)
+	}
+
+	if err = w.Write("log:capability:domain:service:application", bytes.NewReader(randomData(1024))); err != nil {
+		t.Fatalf("Error writing to GCS: %s", err)
+	}
+
+	if fake.tokens != 1 || fake.claims["iss"] != "writer@project.iam.gserviceaccount.com" || fake.claims["aud"] != endpoint+"/token" {
+		t.Errorf("Expected one token of the service account, got %d with %v", fake.tokens, fake.claims)
+	}
+
+	os.WriteFile(file, []byte(`{"type":"unknown"}`), 0600)
+
+	if _, err = newGCS(map[string]string{"GCSEndpoint": endpoint, "GCSCredentialsFile": file}); err == nil {
+		t.Errorf("Expected an error of the invalid credentials file")
+	}
+}
+
+func TestGCSCheckBucket(t *testing.T) {
+	tests := []struct {
+		name   string
+		bucket int
+		auth   bool
+		kind   string
+	}{
+		{name: "ready"},
+		{name: "not found", bucket: http.StatusNotFound, kind: writer.ErrorKindNotFound},
+		{name: "forbidden", bucket: http.StatusForbidden, kind: writer.ErrorKindForbidden},
+		{name: "unauthenticated", auth: true, kind: writer.ErrorKindForbidden},
+		{name: "network", kind: writer.ErrorKindNetwork},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := newFakeGCS()
+			fake.fail = test.bucket
+			fake.auth = test.auth
+
+			t.Setenv("STORAGE_EMULATOR_HOST", fake.serve(t, test.kind == writer.ErrorKindNetwork))
+
+			w, err := newGCS(nil)
+
+			if writer.ErrorKind(err) != test.kind && (err != nil || len(test.kind) > 0) {
+				t.Fatalf("Expected %q error, got %v", test.kind, err)
+			}
+
+			if w.IsReady() != (err == nil) || writer.ErrorKind(w.Status()) != writer.ErrorKind(err) {
+				t.Errorf("Expected the writer status to be %v, got %v", err, w.Status())
+			}
+		})
+	}
+}
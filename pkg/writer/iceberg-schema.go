@@ -0,0 +1,451 @@
+package writer
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/xitongsys/parquet-go/parquet"
+)
+
+type icebergSchema struct {
+	Type               string          `json:"type"`
+	SchemaID           int             `json:"schema-id"`
+	IdentifierFieldIDs []int           `json:"identifier-field-ids,omitempty"`
+	Fields             []*icebergField `json:"fields"`
+}
+
+type icebergField struct {
+	ID       int          `json:"id"`
+	Name     string       `json:"name"`
+	Required bool         `json:"required"`
+	Type     *icebergType `json:"type"`
+	Doc      string       `json:"doc,omitempty"`
+}
+
+// icebergType is a primitive type, as `long` or `decimal(9, 2)`, or a struct, list or map with the ids of their
+// fields.
+type icebergType struct {
+	Kind            string
+	Primitive       string
+	Fields          []*icebergField
+	ElementID       int
+	Element         *icebergType
+	ElementRequired bool
+	KeyID           int
+	Key             *icebergType
+	ValueID         int
+	Value           *icebergType
+	ValueRequired   bool
+}
+
+type icebergListJSON struct {
+	Type            string       `json:"type"`
+	ElementID       int          `json:"element-id"`
+	Element         *icebergType `json:"element"`
+	ElementRequired bool         `json:"element-required"`
+}
+
+type icebergMapJSON struct {
+	Type          string       `json:"type"`
+	KeyID         int          `json:"key-id"`
+	Key           *icebergType `json:"key"`
+	ValueID       int          `json:"value-id"`
+	Value         *icebergType `json:"value"`
+	ValueRequired bool         `json:"value-required"`
+}
+
+type icebergStructJSON struct {
+	Type   string          `json:"type"`
+	Fields []*icebergField `json:"fields"`
+}
+
+func (t *icebergType) MarshalJSON() ([]byte, error) {
+	switch t.Kind {
+	case "struct":
+		return json.Marshal(icebergStructJSON{Type: t.Kind, Fields: t.Fields})
+	case "list":
+		return json.Marshal(icebergListJSON{Type: t.Kind, ElementID: t.ElementID, Element: t.Element, ElementRequired: t.ElementRequired})
+	case "map":
+		return json.Marshal(icebergMapJSON{Type: t.Kind, KeyID: t.KeyID, Key: t.Key, ValueID: t.ValueID, Value: t.Value, ValueRequired: t.ValueRequired})
+	}
+
+	return json.Marshal(t.Primitive)
+}
+
+func (t *icebergType) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &t.Primitive)
+	}
+
+	kind := struct {
+		Type string `json:"type"`
+	}{}
+
+	if err := json.Unmarshal(data, &kind); err != nil {
+		return err
+	}
+
+	t.Kind = kind.Type
+
+	switch kind.Type {
+	case "struct":
+		v := icebergStructJSON{}
+		err := json.Unmarshal(data, &v)
+		t.Fields = v.Fields
+		return err
+	case "list":
+		v := icebergListJSON{}
+		err := json.Unmarshal(data, &v)
+		t.ElementID, t.Element, t.ElementRequired = v.ElementID, v.Element, v.ElementRequired
+		return err
+	case "map":
+		v := icebergMapJSON{}
+		err := json.Unmarshal(data, &v)
+		t.KeyID, t.Key, t.ValueID, t.Value, t.ValueRequired = v.KeyID, v.Key, v.ValueID, v.Value, v.ValueRequired
+		return err
+	}
+
+	return fmt.Errorf("unknown iceberg type %s", kind.Type)
+}
+
+func (t *icebergType) String() string {
+	data, _ := json.Marshal(t)
+	return string(data)
+}
+
+// icebergTypeOf returns the Iceberg type of a parquet node, without ids. Repeated nodes that are not the element of
+// a LIST are lists of required elements.
+func icebergTypeOf(node *parquetNode, element bool) *icebergType {
+	var ret *icebergType
+
+	switch {
+	case len(node.children) == 0:
+		ret = &icebergType{Primitive: icebergPrimitive(node.element)}
+	case isParquetList(node) && node.listElement() != nil:
+		item := node.listElement()
+		ret = &icebergType{Kind: "list", Element: icebergTypeOf(item, true), ElementRequired: item.required() || item.repeated()}
+	case isParquetMap(node) && len(node.children[0].children) == 2:
+		key, value := node.mapEntry()
+		ret = &icebergType{Kind: "map", Key: icebergTypeOf(key, false), Value: icebergTypeOf(value, false), ValueRequired: value.required()}
+	default:
+		ret = &icebergType{Kind: "struct"}
+
+		for _, child := range node.children {
+			ret.Fields = append(ret.Fields, &icebergField{Name: child.element.Name, Required: child.required(), Type: icebergTypeOf(child, false)})
+		}
+	}
+
+	if node.repeated() && !element {
+		return &icebergType{Kind: "list", Element: ret, ElementRequired: true}
+	}
+
+	return ret
+}
+
+func isParquetList(node *parquetNode) bool {
+	return node.converted(parquet.ConvertedType_LIST) || (node.element.LogicalType != nil && node.element.LogicalType.LIST != nil)
+}
+
+func isParquetMap(node *parquetNode) bool {
+	return node.converted(parquet.ConvertedType_MAP) || node.converted(parquet.ConvertedType_MAP_KEY_VALUE) || (node.element.LogicalType != nil && node.element.LogicalType.MAP != nil)
+}
+
+func icebergPrimitive(e *parquet.SchemaElement) string {
+	logical := e.LogicalType
+	converted := parquet.ConvertedType(-1)
+
+	if e.ConvertedType != nil {
+		converted = *e.ConvertedType
+	}
+
+	if converted == parquet.ConvertedType_DECIMAL || (logical != nil && logical.DECIMAL != nil) {
+		return fmt.Sprintf("decimal(%d, %d)", e.GetPrecision(), e.GetScale())
+	}
+
+	switch e.GetType() {
+	case parquet.Type_BOOLEAN:
+		return "boolean"
+	case parquet.Type_INT32:
+		switch {
+		case converted == parquet.ConvertedType_DATE || (logical != nil && logical.DATE != nil):
+			return "date"
+		case converted == parquet.ConvertedType_UINT_32:
+			return "long"
+		}
+
+		return "int"
+	case parquet.Type_INT64:
+		if ts := parquetTimestamp(e); ts != nil {
+			if ts.Unit != nil && ts.Unit.NANOS != nil {
+				return "long"
+			}
+
+			if ts.IsAdjustedToUTC {
+				return "timestamptz"
+			}
+
+			return "timestamp"
+		}
+
+		switch converted {
+		case parquet.ConvertedType_TIMESTAMP_MICROS, parquet.ConvertedType_TIMESTAMP_MILLIS:
+			return "timestamptz"
+		case parquet.ConvertedType_TIME_MICROS:
+			return "time"
+		}
+
+		return "long"
+	case parquet.Type_INT96:
+		return "timestamp"
+	case parquet.Type_FLOAT:
+		return "float"
+	case parquet.Type_DOUBLE:
+		return "double"
+	case parquet.Type_FIXED_LEN_BYTE_ARRAY:
+		if logical != nil && logical.UUID != nil {
+			return "uuid"
+		}
+
+		return fmt.Sprintf("fixed[%d]", e.GetTypeLength())
+	}
+
+	if converted == parquet.ConvertedType_UTF8 || converted == parquet.ConvertedType_ENUM || converted == parquet.ConvertedType_JSON || (logical != nil && (logical.STRING != nil || logical.ENUM != nil || logical.JSON != nil)) {
+		return "string"
+	}
+
+	return "binary"
+}
+
+// icebergBound converts a plain encoded parquet bound to the single value serialization of Iceberg, nil when the
+// type has no bounds in this writer.
+func icebergBound(e *parquet.SchemaElement, primitive string, value []byte) []byte {
+	switch {
+	case strings.HasPrefix(primitive, "decimal"), e.GetType() == parquet.Type_INT96, primitive == "long" && e.GetType() != parquet.Type_INT64:
+		return nil
+	case strings.HasPrefix(primitive, "timestamp") && isMillis(e):
+		if len(value) != 8 {
+			return nil
+		}
+
+		ret := make([]byte, 8)
+		binary.LittleEndian.PutUint64(ret, uint64(int64(binary.LittleEndian.Uint64(value))*1000))
+
+		return ret
+	}
+
+	return value
+}
+
+func parquetTimestamp(e *parquet.SchemaElement) *parquet.TimestampType {
+	if e.LogicalType == nil {
+		return nil
+	}
+
+	return e.LogicalType.TIMESTAMP
+}
+
+func isMillis(e *parquet.SchemaElement) bool {
+	if ts := parquetTimestamp(e); ts != nil {
+		return ts.Unit != nil && ts.Unit.MILLIS != nil
+	}
+
+	return e.ConvertedType != nil && *e.ConvertedType == parquet.ConvertedType_TIMESTAMP_MILLIS
+}
+
+// mergeIcebergType returns the table type with the columns of a file, keeping the ids of the table and assigning new
+// ids from next to new columns. New columns are optional, as older files don't have them. Types can only be promoted
+// as Iceberg allows, int to long and float to double.
+func mergeIcebergType(current *icebergType, file *icebergType, next *int) (*icebergType, error) {
+	if current == nil {
+		return assignIcebergIDs(file, next), nil
+	}
+
+	if current.Kind != file.Kind {
+		return nil, fmt.Errorf("type %s can't be changed to %s", current, file)
+	}
+
+	ret := *current
+
+	switch current.Kind {
+	case "":
+		switch {
+		case current.Primitive == file.Primitive, current.Primitive == "long" && file.Primitive == "int", current.Primitive == "double" && file.Primitive == "float":
+		case current.Primitive == "int" && file.Primitive == "long", current.Primitive == "float" && file.Primitive == "double":
+			ret.Primitive = file.Primitive
+		default:
+			return nil, fmt.Errorf("type %s can't be changed to %s", current.Primitive, file.Primitive)
+		}
+	case "struct":
+		ret.Fields = append([]*icebergField{}, current.Fields...)
+		added := make([]*icebergField, 0)
+
+		for _, f := range file.Fields {
+			var merged *icebergField
+
+			for i, c := range ret.Fields {
+				if c.Name == f.Name {
+					t, err := mergeIcebergType(c.Type, f.Type, next)
+
+					if err != nil {
+						return nil, fmt.Errorf("%s: %w", f.Name, err)
+					}
+
+					copied := *c
+					copied.Type = t
+					ret.Fields[i] = &copied
+					merged = &copied
+				}
+			}
+
+			if merged == nil {
+				*next++
+				added = append(added, &icebergField{ID: *next, Name: f.Name, Type: f.Type})
+			}
+		}
+
+		for _, f := range added {
+			f.Type = assignIcebergIDs(f.Type, next)
+			ret.Fields = append(ret.Fields, f)
+		}
+	case "list":
+		element, err := mergeIcebergType(current.Element, file.Element, next)
+
+		if err != nil {
+			return nil, fmt.Errorf("element: %w", err)
+		}
+
+		ret.Element = element
+		ret.ElementRequired = current.ElementRequired && file.ElementRequired
+	case "map":
+		key, err := mergeIcebergType(current.Key, file.Key, next)
+
+		if err != nil {
+			return nil, fmt.Errorf("key: %w", err)
+		}
+
+		value, err := mergeIcebergType(current.Value, file.Value, next)
+
+		if err != nil {
+			return nil, fmt.Errorf("value: %w", err)
+		}
+
+		ret.Key, ret.Value = key, value
+		ret.ValueRequired = current.ValueRequired && file.ValueRequired
+	}
+
+	return &ret, nil
+}
+
+// assignIcebergIDs returns a copy of a new type with ids from next, fields of a struct get their ids before the
+// fields of nested types, as Iceberg does.
+func assignIcebergIDs(t *icebergType, next *int) *icebergType {
+	ret := *t
+
+	switch t.Kind {
+	case "struct":
+		ret.Fields = make([]*icebergField, 0, len(t.Fields))
+
+		for _, f := range t.Fields {
+			*next++
+			ret.Fields = append(ret.Fields, &icebergField{ID: *next, Name: f.Name, Type: f.Type})
+		}
+
+		for _, f := range ret.Fields {
+			f.Type = assignIcebergIDs(f.Type, next)
+		}
+	case "list":
+		*next++
+		ret.ElementID = *next
+		ret.Element = assignIcebergIDs(t.Element, next)
+	case "map":
+		*next++
+		ret.KeyID = *next
+		*next++
+		ret.ValueID = *next
+		ret.Key = assignIcebergIDs(t.Key, next)
+		ret.Value = assignIcebergIDs(t.Value, next)
+	}
+
+	return &ret
+}
+
+// icebergColumn is the Iceberg field of a parquet leaf column, bounds are only kept for columns out of lists and
+// maps.
+type icebergColumn struct {
+	id        int
+	primitive string
+	bounds    bool
+}
+
+// icebergColumns maps the parquet leaf columns of a file to the fields of the table type, by name.
+func icebergColumns(node *parquetNode, t *icebergType, id int, nested bool, ret map[string]icebergColumn) {
+	if t == nil {
+		return
+	}
+
+	if t.Kind == "list" && node.repeated() && !isParquetList(node) {
+		t, id, nested = t.Element, t.ElementID, true
+	}
+
+	switch {
+	case len(node.children) == 0:
+		if t.Kind == "" {
+			ret[strings.Join(node.path, ".")] = icebergColumn{id: id, primitive: t.Primitive, bounds: !nested}
+		}
+	case t.Kind == "list":
+		if item := node.listElement(); item != nil {
+			icebergColumns(item, t.Element, t.ElementID, true, ret)
+		}
+	case t.Kind == "map":
+		if key, value := node.mapEntry(); key != nil {
+			icebergColumns(key, t.Key, t.KeyID, true, ret)
+			icebergColumns(value, t.Value, t.ValueID, true, ret)
+		}
+	case t.Kind == "struct":
+		for _, child := range node.children {
+			for _, f := range t.Fields {
+				if f.Name == child.element.Name {
+					icebergColumns(child, f.Type, f.ID, nested, ret)
+				}
+			}
+		}
+	}
+}
+
+// icebergNameMapping is the `schema.name-mapping.default` of the table, parquet files written by data2parquet have
+// no field ids and readers find their columns by name.
+type icebergNameMapping struct {
+	FieldID int                  `json:"field-id"`
+	Names   []string             `json:"names"`
+	Fields  []icebergNameMapping `json:"fields,omitempty"`
+}
+
+func icebergNameMappings(t *icebergType) []icebergNameMapping {
+	ret := make([]icebergNameMapping, 0)
+
+	switch t.Kind {
+	case "struct":
+		for _, f := range t.Fields {
+			ret = append(ret, icebergNameMapping{FieldID: f.ID, Names: []string{f.Name}, Fields: icebergNameMappings(f.Type)})
+		}
+	case "list":
+		ret = append(ret, icebergNameMapping{FieldID: t.ElementID, Names: []string{"element"}, Fields: icebergNameMappings(t.Element)})
+	case "map":
+		ret = append(ret, icebergNameMapping{FieldID: t.KeyID, Names: []string{"key"}, Fields: icebergNameMappings(t.Key)})
+		ret = append(ret, icebergNameMapping{FieldID: t.ValueID, Names: []string{"value"}, Fields: icebergNameMappings(t.Value)})
+	}
+
+	return ret
+}
+
+func icebergHasField(t *icebergType, name string) bool {
+	for _, f := range t.Fields {
+		if f.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
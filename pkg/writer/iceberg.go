@@ -0,0 +1,1110 @@
+package writer
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"data2parquet/pkg/config"
+	"data2parquet/pkg/domain"
+)
+
+const icebergVersionHint = "metadata/version-hint.text"
+const icebergNameMappingProperty = "schema.name-mapping.default"
+const icebergMetadataLogProperty = "write.metadata.previous-versions-max"
+const icebergMergeProperty = "commit.manifest-merge.enabled"
+const icebergMergeMinCountProperty = "commit.manifest.min-count-to-merge"
+
+// icebergManifestSchema is the v2 manifest_entry schema, the fields of the partition spec go in the partition record.
+const icebergManifestSchema = `{"type":"record","name":"manifest_entry","fields":[
+{"name":"status","type":"int","field-id":0},
+{"name":"snapshot_id","type":["null","long"],"default":null,"field-id":1},
+{"name":"sequence_number","type":["null","long"],"default":null,"field-id":3},
+{"name":"file_sequence_number","type":["null","long"],"default":null,"field-id":4},
+{"name":"data_file","type":{"type":"record","name":"r2","fields":[
+{"name":"content","type":"int","field-id":134},
+{"name":"file_path","type":"string","field-id":100},
+{"name":"file_format","type":"string","field-id":101},
+{"name":"partition","type":{"type":"record","name":"r102","fields":[%s]},"field-id":102},
+{"name":"record_count","type":"long","field-id":103},
+{"name":"file_size_in_bytes","type":"long","field-id":104},
+{"name":"column_sizes","type":["null",{"type":"array","items":{"type":"record","name":"k117_v118","fields":[{"name":"key","type":"int","field-id":117},{"name":"value","type":"long","field-id":118}]},"logicalType":"map"}],"default":null,"field-id":108},
+{"name":"value_counts","type":["null",{"type":"array","items":{"type":"record","name":"k119_v120","fields":[{"name":"key","type":"int","field-id":119},{"name":"value","type":"long","field-id":120}]},"logicalType":"map"}],"default":null,"field-id":109},
+{"name":"null_value_counts","type":["null",{"type":"array","items":{"type":"record","name":"k121_v122","fields":[{"name":"key","type":"int","field-id":121},{"name":"value","type":"long","field-id":122}]},"logicalType":"map"}],"default":null,"field-id":110},
+{"name":"nan_value_counts","type":["null",{"type":"array","items":{"type":"record","name":"k138_v139","fields":[{"name":"key","type":"int","field-id":138},{"name":"value","type":"long","field-id":139}]},"logicalType":"map"}],"default":null,"field-id":137},
+{"name":"lower_bounds","type":["null",{"type":"array","items":{"type":"record","name":"k126_v127","fields":[{"name":"key","type":"int","field-id":126},{"name":"value","type":"bytes","field-id":127}]},"logicalType":"map"}],"default":null,"field-id":125},
+{"name":"upper_bounds","type":["null",{"type":"array","items":{"type":"record","name":"k129_v130","fields":[{"name":"key","type":"int","field-id":129},{"name":"value","type":"bytes","field-id":130}]},"logicalType":"map"}],"default":null,"field-id":128},
+{"name":"key_metadata","type":["null","bytes"],"default":null,"field-id":131},
+{"name":"split_offsets","type":["null",{"type":"array","items":"long","element-id":133}],"default":null,"field-id":132},
+{"name":"equality_ids","type":["null",{"type":"array","items":"int","element-id":136}],"default":null,"field-id":135},
+{"name":"sort_order_id","type":["null","int"],"default":null,"field-id":140}
+]},"field-id":2}]}`
+
+// icebergManifestListSchema is the v2 manifest_file schema, manifests of other writers are converted to it by field id.
+const icebergManifestListSchema = `{"type":"record","name":"manifest_file","fields":[
+{"name":"manifest_path","type":"string","field-id":500},
+{"name":"manifest_length","type":"long","field-id":501},
+{"name":"partition_spec_id","type":"int","field-id":502},
+{"name":"content","type":"int","field-id":517},
+{"name":"sequence_number","type":"long","field-id":515},
+{"name":"min_sequence_number","type":"long","field-id":516},
+{"name":"added_snapshot_id","type":"long","field-id":503},
+{"name":"added_files_count","type":"int","field-id":504},
+{"name":"existing_files_count","type":"int","field-id":505},
+{"name":"deleted_files_count","type":"int","field-id":506},
+{"name":"added_rows_count","type":"long","field-id":512},
+{"name":"existing_rows_count","type":"long","field-id":513},
+{"name":"deleted_rows_count","type":"long","field-id":514},
+{"name":"partitions","type":["null",{"type":"array","items":{"type":"record","name":"r508","fields":[
+{"name":"contains_null","type":"boolean","field-id":509},
+{"name":"contains_nan","type":["null","boolean"],"default":null,"field-id":518},
+{"name":"lower_bound","type":["null","bytes"],"default":null,"field-id":510},
+{"name":"upper_bound","type":["null","bytes"],"default":null,"field-id":511}]},"element-id":508}],"default":null,"field-id":507},
+{"name":"key_metadata","type":["null","bytes"],"default":null,"field-id":519}]}`
+
+var icebergManifestList, _ = parseAvroSchema(icebergManifestListSchema)
+
+// icebergMetadata is the table metadata file of format version 2.
+type icebergMetadata struct {
+	FormatVersion       int                   `json:"format-version"`
+	TableUUID           string                `json:"table-uuid"`
+	Location            string                `json:"location"`
+	LastSequenceNumber  int64                 `json:"last-sequence-number"`
+	LastUpdatedMs       int64                 `json:"last-updated-ms"`
+	LastColumnID        int                   `json:"last-column-id"`
+	CurrentSchemaID     int                   `json:"current-schema-id"`
+	Schemas             []*icebergSchema      `json:"schemas"`
+	DefaultSpecID       int                   `json:"default-spec-id"`
+	PartitionSpecs      []icebergSpec         `json:"partition-specs"`
+	LastPartitionID     int                   `json:"last-partition-id"`
+	DefaultSortOrderID  int                   `json:"default-sort-order-id"`
+	SortOrders          []json.RawMessage     `json:"sort-orders"`
+	Properties          map[string]string     `json:"properties,omitempty"`
+	CurrentSnapshotID   int64                 `json:"current-snapshot-id"`
+	Refs                map[string]icebergRef `json:"refs,omitempty"`
+	Snapshots           []*icebergSnapshot    `json:"snapshots,omitempty"`
+	Statistics          json.RawMessage       `json:"statistics,omitempty"`
+	PartitionStatistics json.RawMessage       `json:"partition-statistics,omitempty"`
+	SnapshotLog         []icebergLogEntry     `json:"snapshot-log,omitempty"`
+	MetadataLog         []icebergLogEntry     `json:"metadata-log,omitempty"`
+}
+
+type icebergSpec struct {
+	SpecID int                     `json:"spec-id"`
+	Fields []icebergPartitionField `json:"fields"`
+}
+
+type icebergPartitionField struct {
+	Name      string `json:"name"`
+	Transform string `json:"transform"`
+	SourceID  int    `json:"source-id"`
+	FieldID   int    `json:"field-id"`
+}
+
+// icebergPartitionColumn is a field of the partition spec with the name and type of its source column.
+type icebergPartitionColumn struct {
+	icebergPartitionField
+	source    string
+	primitive string
+}
+
+type icebergRef struct {
+	SnapshotID         int64  `json:"snapshot-id"`
+	Type               string `json:"type"`
+	MinSnapshotsToKeep *int   `json:"min-snapshots-to-keep,omitempty"`
+	MaxSnapshotAgeMs   *int64 `json:"max-snapshot-age-ms,omitempty"`
+	MaxRefAgeMs        *int64 `json:"max-ref-age-ms,omitempty"`
+}
+
+type icebergSnapshot struct {
+	SequenceNumber   int64             `json:"sequence-number"`
+	SnapshotID       int64             `json:"snapshot-id"`
+	ParentSnapshotID *int64            `json:"parent-snapshot-id,omitempty"`
+	TimestampMs      int64             `json:"timestamp-ms"`
+	Summary          map[string]string `json:"summary"`
+	ManifestList     string            `json:"manifest-list"`
+	SchemaID         int               `json:"schema-id"`
+}
+
+type icebergLogEntry struct {
+	TimestampMs  int64  `json:"timestamp-ms"`
+	SnapshotID   int64  `json:"snapshot-id,omitempty"`
+	MetadataFile string `json:"metadata-file,omitempty"`
+}
+
+// icebergTable appends each file to an Apache Iceberg table with the layout of Hadoop tables: versions are
+// `metadata/v<N>.metadata.json` files and `metadata/version-hint.text` has the last one. A version is created with
+// put-if-absent, so when other writers commit first the commit is retried on their version.
+type icebergTable struct {
+	config *config.Config
+	store  tableStore
+	mu     sync.Mutex
+}
+
+func newIcebergTable(cfg *config.Config, store tableStore) *icebergTable {
+	return &icebergTable{config: cfg, store: store}
+}
+
+// Commit creates a snapshot appending the file, with a manifest of the file and a manifest list with the manifests of
+// the current snapshot, merged in one manifest past commit.manifest.min-count-to-merge manifests.
+func (t *icebergTable) Commit(file *tableFile) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	start := time.Now()
+	snapshotID := icebergSnapshotID()
+	version := 0
+
+	err := commitTable(t.config, "iceberg", func(attempt int) error {
+		var err error
+		version, err = t.commit(file, snapshotID, attempt)
+		return err
+	})
+
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Iceberg table committed", "module", "writer.iceberg", "function", "Commit", "file", file.path, "version", version, "snapshot", snapshotID, "records", file.records, "duration", time.Since(start))
+
+	return nil
+}
+
+func (t *icebergTable) commit(file *tableFile, snapshotID int64, attempt int) (int, error) {
+	version, meta, err := t.current()
+
+	if err != nil {
+		return 0, err
+	}
+
+	if meta == nil {
+		meta = t.create()
+	}
+
+	if err = meta.validate(); err != nil {
+		return 0, err
+	}
+
+	schema, err := meta.merge(file.stats)
+
+	if err != nil {
+		return 0, fmt.Errorf("error evolving the schema of the iceberg table with %s: %w", file.path, err)
+	}
+
+	columns, err := meta.partition(schema)
+
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now().UnixMilli()
+	sequence := meta.LastSequenceNumber + 1
+	commitID := newUUID()
+	manifestName := fmt.Sprintf("metadata/%s-m0.avro", commitID)
+	partition := icebergPartition(columns, file.info)
+	manifest, err := t.manifest(schema, columns, meta.DefaultSpecID, snapshotID, file, partition)
+
+	if err != nil {
+		return 0, err
+	}
+
+	parent := meta.snapshot()
+	list := []interface{}{map[string]interface{}{
+		"manifest_path":        t.store.location(manifestName),
+		"manifest_length":      int64(len(manifest)),
+		"partition_spec_id":    meta.DefaultSpecID,
+		"content":              0,
+		"sequence_number":      sequence,
+		"min_sequence_number":  sequence,
+		"added_snapshot_id":    snapshotID,
+		"added_files_count":    1,
+		"existing_files_count": 0,
+		"deleted_files_count":  0,
+		"added_rows_count":     file.records,
+		"existing_rows_count":  int64(0),
+		"deleted_rows_count":   int64(0),
+		"partitions":           icebergPartitionSummaries(columns, []map[string]interface{}{partition}),
+		"key_metadata":         nil,
+	}}
+
+	listMeta := map[string]string{
+		"snapshot-id":        strconv.FormatInt(snapshotID, 10),
+		"parent-snapshot-id": "null",
+		"sequence-number":    strconv.FormatInt(sequence, 10),
+		"format-version":     "2",
+	}
+
+	written := []string{manifestName}
+
+	if parent != nil {
+		manifests, err := t.manifests(parent)
+
+		if err == nil && meta.mergeDue(len(manifests)+1) {
+			mergedName := fmt.Sprintf("metadata/%s-m1.avro", commitID)
+
+			if manifests, err = t.mergeManifests(mergedName, meta, schema, columns, manifests, snapshotID, sequence); err == nil {
+				written = append(written, mergedName)
+			}
+		}
+
+		if err != nil {
+			return 0, err
+		}
+
+		list = append(list, manifests...)
+		listMeta["parent-snapshot-id"] = strconv.FormatInt(parent.SnapshotID, 10)
+	}
+
+	manifestList, err := writeAvro(icebergManifestListSchema, listMeta, list)
+
+	if err != nil {
+		t.remove(written)
+		return 0, err
+	}
+
+	listName := fmt.Sprintf("metadata/snap-%d-%d-%s.avro", snapshotID, attempt, newUUID())
+	written = append(written, listName)
+	var expired []*icebergSnapshot
+
+	if err = t.store.writeObject(manifestName, manifest); err == nil {
+		err = t.store.writeObject(listName, manifestList)
+	}
+
+	if err == nil {
+		expired = meta.append(&icebergSnapshot{
+			SequenceNumber: sequence,
+			SnapshotID:     snapshotID,
+			TimestampMs:    now,
+			Summary:        icebergSummary(parent, file),
+			ManifestList:   t.store.location(listName),
+			SchemaID:       schema.SchemaID,
+		}, parent, t.location(version))
+
+		var data []byte
+
+		if data, err = json.Marshal(meta); err == nil {
+			err = t.store.putIfAbsent(icebergVersionName(version+1), data)
+		}
+	}
+
+	if err != nil {
+		t.remove(written)
+		return 0, err
+	}
+
+	if err = t.store.writeObject(icebergVersionHint, []byte(strconv.Itoa(version+1))); err != nil {
+		slog.Warn("Error updating iceberg version hint", "error", err, "module", "writer.iceberg", "function", "commit", "version", version+1)
+	}
+
+	t.clean(meta, expired)
+
+	return version + 1, nil
+}
+
+func (t *icebergTable) remove(names []string) {
+	for _, name := range names {
+		t.store.removeObject(name)
+	}
+}
+
+func icebergVersionName(version int) string {
+	return fmt.Sprintf("metadata/v%d.metadata.json", version)
+}
+
+func (t *icebergTable) location(version int) string {
+	if version == 0 {
+		return ""
+	}
+
+	return t.store.location(icebergVersionName(version))
+}
+
+// current reads the last version of the table, from the version hint and then the versions after it, as the hint
+// is updated after each commit. Without versions the table does not exist and the metadata is nil.
+func (t *icebergTable) current() (int, *icebergMetadata, error) {
+	version := 0
+	hint, err := t.store.readObject(icebergVersionHint)
+
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return 0, nil, err
+	}
+
+	if err == nil {
+		if version, err = strconv.Atoi(strings.TrimSpace(string(hint))); err != nil || version < 0 {
+			version = 0
+		}
+	}
+
+	var data []byte
+
+	for {
+		next, err := t.store.readObject(icebergVersionName(version + 1))
+
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+
+		if err != nil {
+			return 0, nil, err
+		}
+
+		version, data = version+1, next
+	}
+
+	if version == 0 {
+		return 0, nil, nil
+	}
+
+	if data == nil {
+		if data, err = t.store.readObject(icebergVersionName(version)); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	meta := &icebergMetadata{}
+
+	if err = json.Unmarshal(data, meta); err != nil {
+		return 0, nil, fmt.Errorf("invalid iceberg metadata %s: %w", icebergVersionName(version), err)
+	}
+
+	return version, meta, nil
+}
+
+func (t *icebergTable) create() *icebergMetadata {
+	return &icebergMetadata{
+		FormatVersion:      2,
+		TableUUID:          newUUID(),
+		Location:           strings.TrimSuffix(t.store.location(""), "/"),
+		Schemas:            []*icebergSchema{{Type: "struct", Fields: []*icebergField{}}},
+		PartitionSpecs:     []icebergSpec{{Fields: []icebergPartitionField{}}},
+		LastPartitionID:    999,
+		SortOrders:         []json.RawMessage{json.RawMessage(`{"order-id":0,"fields":[]}`)},
+		Properties:         map[string]string{"write.format.default": "parquet"},
+		CurrentSnapshotID:  -1,
+		DefaultSortOrderID: 0,
+	}
+}
+
+// manifest writes the manifest of the file with its partition and column statistics, sequence numbers are inherited
+// from the manifest list as the commit can be retried on a new version.
+func (t *icebergTable) manifest(schema *icebergSchema, partitionColumns []icebergPartitionColumn, specID int, snapshotID int64, file *tableFile, partition map[string]interface{}) ([]byte, error) {
+	columns := make(map[string]icebergColumn)
+	root := &icebergType{Kind: "struct", Fields: schema.Fields}
+	icebergColumns(file.stats.root, root, 0, false, columns)
+
+	sizes := make(map[int]interface{})
+	values := make(map[int]interface{})
+	nulls := make(map[int]interface{})
+	lower := make(map[int]interface{})
+	upper := make(map[int]interface{})
+
+	for path, stats := range file.stats.columns {
+		column, ok := columns[path]
+
+		if !ok {
+			continue
+		}
+
+		sizes[column.id] = stats.size
+		values[column.id] = stats.values
+
+		if stats.counts {
+			nulls[column.id] = stats.nulls
+		}
+
+		if column.bounds && stats.bounds && stats.min != nil {
+			if min := icebergBound(stats.node.element, column.primitive, stats.min); min != nil {
+				lower[column.id] = min
+				upper[column.id] = icebergBound(stats.node.element, column.primitive, stats.max)
+			}
+		}
+	}
+
+	offsets := make([]interface{}, 0, len(file.stats.offsets))
+
+	for _, offset := range file.stats.offsets {
+		offsets = append(offsets, offset)
+	}
+
+	entry := map[string]interface{}{
+		"status":               1,
+		"snapshot_id":          snapshotID,
+		"sequence_number":      nil,
+		"file_sequence_number": nil,
+		"data_file": map[string]interface{}{
+			"content":            0,
+			"file_path":          t.store.location(file.path),
+			"file_format":        "PARQUET",
+			"partition":          partition,
+			"record_count":       file.records,
+			"file_size_in_bytes": file.size,
+			"column_sizes":       avroIntMap(sizes),
+			"value_counts":       avroIntMap(values),
+			"null_value_counts":  avroIntMap(nulls),
+			"nan_value_counts":   nil,
+			"lower_bounds":       avroIntMap(lower),
+			"upper_bounds":       avroIntMap(upper),
+			"key_metadata":       nil,
+			"split_offsets":      offsets,
+			"equality_ids":       nil,
+			"sort_order_id":      0,
+		},
+	}
+
+	return writeManifest(schema, partitionColumns, specID, []interface{}{entry})
+}
+
+func writeManifest(schema *icebergSchema, columns []icebergPartitionColumn, specID int, entries []interface{}) ([]byte, error) {
+	schemaJSON, err := json.Marshal(schema)
+
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make([]icebergPartitionField, 0, len(columns))
+
+	for _, column := range columns {
+		fields = append(fields, column.icebergPartitionField)
+	}
+
+	specJSON, err := json.Marshal(fields)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return writeAvro(icebergManifestSchemaOf(columns), map[string]string{
+		"schema":            string(schemaJSON),
+		"schema-id":         strconv.Itoa(schema.SchemaID),
+		"partition-spec":    string(specJSON),
+		"partition-spec-id": strconv.Itoa(specID),
+		"format-version":    "2",
+		"content":           "data",
+	}, entries)
+}
+
+// icebergManifestSchemaOf is the manifest_entry schema with the partition fields of the columns.
+func icebergManifestSchemaOf(columns []icebergPartitionColumn) string {
+	fields := make([]string, 0, len(columns))
+
+	for _, column := range columns {
+		fields = append(fields, fmt.Sprintf(`{"name":%q,"type":["null",%q],"default":null,"field-id":%d}`, column.Name, column.primitive, column.FieldID))
+	}
+
+	return fmt.Sprintf(icebergManifestSchema, strings.Join(fields, ","))
+}
+
+// manifests reads the manifest list of a snapshot, as the manifests of the new snapshot.
+func (t *icebergTable) manifests(snapshot *icebergSnapshot) ([]interface{}, error) {
+	name, err := t.relative(snapshot.ManifestList)
+
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := t.store.readObject(name)
+
+	if err != nil {
+		return nil, fmt.Errorf("error reading manifest list of snapshot %d: %w", snapshot.SnapshotID, err)
+	}
+
+	schema, _, records, err := readAvro(data)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest list %s: %w", snapshot.ManifestList, err)
+	}
+
+	ret := make([]interface{}, 0, len(records))
+
+	for _, record := range records {
+		ret = append(ret, convertAvro(schema, icebergManifestList, record))
+	}
+
+	return ret, nil
+}
+
+// relative returns the path in the table of a location, as the manifests and manifest lists of the metadata.
+func (t *icebergTable) relative(location string) (string, error) {
+	root := strings.TrimSuffix(t.store.location(""), "/") + "/"
+
+	if !strings.HasPrefix(location, root) {
+		return "", fmt.Errorf("%s is not in the table location %s", location, root)
+	}
+
+	return strings.TrimPrefix(location, root), nil
+}
+
+// mergeManifests rewrites the data manifests of the default spec as one manifest of existing files, with the
+// sequence numbers and snapshot ids they inherited, and returns the manifests of the list with it.
+func (t *icebergTable) mergeManifests(name string, meta *icebergMetadata, schema *icebergSchema, columns []icebergPartitionColumn, manifests []interface{}, snapshotID int64, sequence int64) ([]interface{}, error) {
+	entrySchema, err := parseAvroSchema(icebergManifestSchemaOf(columns))
+
+	if err != nil {
+		return nil, err
+	}
+
+	kept := make([]interface{}, 0)
+	entries := make([]interface{}, 0)
+	partitions := make([]map[string]interface{}, 0)
+	minSequence := sequence
+	rows := int64(0)
+
+	for _, m := range manifests {
+		manifest := m.(map[string]interface{})
+		specID, _ := avroInt(manifest["partition_spec_id"])
+		content, _ := avroInt(manifest["content"])
+
+		if content != 0 || int(specID) != meta.DefaultSpecID {
+			kept = append(kept, m)
+			continue
+		}
+
+		location, _ := manifest["manifest_path"].(string)
+		path, err := t.relative(location)
+
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := t.store.readObject(path)
+
+		if err != nil {
+			return nil, fmt.Errorf("error reading manifest %s: %w", location, err)
+		}
+
+		fileSchema, _, records, err := readAvro(data)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid manifest %s: %w", location, err)
+		}
+
+		for _, record := range records {
+			entry := convertAvro(fileSchema, entrySchema, record).(map[string]interface{})
+
+			if status, _ := avroInt(entry["status"]); status == 2 {
+				continue
+			}
+
+			for field, inherited := range map[string]string{"snapshot_id": "added_snapshot_id", "sequence_number": "sequence_number", "file_sequence_number": "sequence_number"} {
+				if entry[field] == nil {
+					entry[field] = manifest[inherited]
+				}
+			}
+
+			if n, ok := avroInt(entry["sequence_number"]); ok && n < minSequence {
+				minSequence = n
+			}
+
+			dataFile := entry["data_file"].(map[string]interface{})
+			count, _ := avroInt(dataFile["record_count"])
+			partition, _ := dataFile["partition"].(map[string]interface{})
+
+			entry["status"] = 0
+			rows += count
+			entries = append(entries, entry)
+			partitions = append(partitions, partition)
+		}
+	}
+
+	data, err := writeManifest(schema, columns, meta.DefaultSpecID, entries)
+
+	if err == nil {
+		err = t.store.writeObject(name, data)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Debug("Iceberg manifests merged", "module", "writer.iceberg", "function", "mergeManifests", "manifest", name, "manifests", len(manifests)-len(kept), "files", len(entries))
+
+	merged := map[string]interface{}{
+		"manifest_path":        t.store.location(name),
+		"manifest_length":      int64(len(data)),
+		"partition_spec_id":    meta.DefaultSpecID,
+		"content":              0,
+		"sequence_number":      sequence,
+		"min_sequence_number":  minSequence,
+		"added_snapshot_id":    snapshotID,
+		"added_files_count":    0,
+		"existing_files_count": len(entries),
+		"deleted_files_count":  0,
+		"added_rows_count":     int64(0),
+		"existing_rows_count":  rows,
+		"deleted_rows_count":   int64(0),
+		"partitions":           icebergPartitionSummaries(columns, partitions),
+		"key_metadata":         nil,
+	}
+
+	return append([]interface{}{merged}, kept...), nil
+}
+
+// clean removes the manifest lists of the expired snapshots and their manifests the oldest snapshot kept doesn't
+// have, as manifests only leave the lists of later snapshots when they are merged. Manifests are kept when the table
+// has refs other than the main branch.
+func (t *icebergTable) clean(meta *icebergMetadata, expired []*icebergSnapshot) {
+	if len(expired) == 0 {
+		return
+	}
+
+	var kept map[string]bool
+
+	if len(meta.Refs) == 1 && len(meta.Snapshots) > 0 {
+		if manifests, err := t.manifests(meta.Snapshots[0]); err == nil {
+			kept = make(map[string]bool)
+
+			for _, m := range manifests {
+				location, _ := m.(map[string]interface{})["manifest_path"].(string)
+				kept[location] = true
+			}
+		}
+	}
+
+	for _, snapshot := range expired {
+		names := make([]string, 0)
+
+		if manifests, err := t.manifests(snapshot); err == nil && kept != nil {
+			for _, m := range manifests {
+				location, _ := m.(map[string]interface{})["manifest_path"].(string)
+
+				if name, err := t.relative(location); err == nil && !kept[location] {
+					names = append(names, name)
+				}
+			}
+		}
+
+		if name, err := t.relative(snapshot.ManifestList); err == nil {
+			names = append(names, name)
+		}
+
+		for _, name := range names {
+			if err := t.store.removeObject(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+				slog.Warn("Error removing file of expired iceberg snapshot", "error", err, "module", "writer.iceberg", "function", "clean", "snapshot", snapshot.SnapshotID, "file", name)
+			}
+		}
+	}
+}
+
+// validate checks the table can be appended by this writer, with format version 2.
+func (m *icebergMetadata) validate() error {
+	if m.FormatVersion != 2 {
+		return fmt.Errorf("iceberg table has format version %d, only version 2 is supported", m.FormatVersion)
+	}
+
+	return nil
+}
+
+func (m *icebergMetadata) spec() icebergSpec {
+	for _, spec := range m.PartitionSpecs {
+		if spec.SpecID == m.DefaultSpecID {
+			return spec
+		}
+	}
+
+	return icebergSpec{SpecID: m.DefaultSpecID, Fields: []icebergPartitionField{}}
+}
+
+// partition returns the fields of the default partition spec with their source columns. A new table is partitioned
+// by the identity of the RecordInfo fields of its files, tables with snapshots keep their spec, that can only have
+// these fields.
+func (m *icebergMetadata) partition(schema *icebergSchema) ([]icebergPartitionColumn, error) {
+	spec := m.spec()
+
+	if len(spec.Fields) == 0 && len(m.Snapshots) == 0 {
+		for i, column := range tablePartitionColumns {
+			field := icebergPartitionField{Name: column.name, Transform: "identity", FieldID: 1000 + i}
+
+			for _, f := range schema.Fields {
+				if f.Name == column.name {
+					field.SourceID = f.ID
+				}
+			}
+
+			spec.Fields = append(spec.Fields, field)
+		}
+
+		m.PartitionSpecs = []icebergSpec{spec}
+		m.LastPartitionID = 999 + len(spec.Fields)
+	}
+
+	ret := make([]icebergPartitionColumn, 0, len(spec.Fields))
+
+	for _, field := range spec.Fields {
+		column := icebergPartitionColumn{icebergPartitionField: field}
+		var partition *tablePartition
+
+		for _, f := range schema.Fields {
+			if f.ID == field.SourceID && f.Type.Kind == "" {
+				column.source, column.primitive = f.Name, f.Type.Primitive
+			}
+		}
+
+		for i := range tablePartitionColumns {
+			if tablePartitionColumns[i].name == column.source {
+				partition = &tablePartitionColumns[i]
+			}
+		}
+
+		switch {
+		case field.Transform != "identity", partition == nil:
+		case partition.integer && (column.primitive == "int" || column.primitive == "long"), !partition.integer && column.primitive == "string":
+			ret = append(ret, column)
+			continue
+		}
+
+		return nil, fmt.Errorf("iceberg table is partitioned by %s(%d), only the identity of a string capability and int year, month, day and hour columns is supported", field.Transform, field.SourceID)
+	}
+
+	return ret, nil
+}
+
+// mergeDue is true when the manifests of a list are merged, with commit.manifest-merge.enabled and at least
+// commit.manifest.min-count-to-merge manifests (100 by default).
+func (m *icebergMetadata) mergeDue(manifests int) bool {
+	if enabled, err := strconv.ParseBool(m.Properties[icebergMergeProperty]); err == nil && !enabled {
+		return false
+	}
+
+	min := 100
+
+	if value, err := strconv.Atoi(m.Properties[icebergMergeMinCountProperty]); err == nil && value > 0 {
+		min = value
+	}
+
+	return manifests >= min
+}
+
+// previousVersionsMax is the number of previous metadata files and snapshots kept, write.metadata.previous-versions-max
+// or 100.
+func (m *icebergMetadata) previousVersionsMax() int {
+	if value, err := strconv.Atoi(m.Properties[icebergMetadataLogProperty]); err == nil && value > 0 {
+		return value
+	}
+
+	return 100
+}
+
+func (m *icebergMetadata) schema() *icebergSchema {
+	for _, schema := range m.Schemas {
+		if schema.SchemaID == m.CurrentSchemaID {
+			return schema
+		}
+	}
+
+	return &icebergSchema{Type: "struct", SchemaID: m.CurrentSchemaID, Fields: []*icebergField{}}
+}
+
+func (m *icebergMetadata) snapshot() *icebergSnapshot {
+	for _, snapshot := range m.Snapshots {
+		if snapshot.SnapshotID == m.CurrentSnapshotID {
+			return snapshot
+		}
+	}
+
+	return nil
+}
+
+// merge adds the new columns of a file to the table schema, as a new schema when the table has snapshots, and updates
+// the name mapping. It returns the current schema.
+func (m *icebergMetadata) merge(stats *parquetStats) (*icebergSchema, error) {
+	current := m.schema()
+	next := m.LastColumnID
+	fileType := icebergTypeOf(stats.root, false)
+
+	// The partition columns of a new table are added to its schema, their values are in the manifests.
+	if len(m.Snapshots) == 0 && len(m.spec().Fields) == 0 {
+		for _, column := range tablePartitionColumns {
+			if !icebergHasField(fileType, column.name) {
+				primitive := "string"
+
+				if column.integer {
+					primitive = "int"
+				}
+
+				fileType.Fields = append(fileType.Fields, &icebergField{Name: column.name, Type: &icebergType{Primitive: primitive}})
+			}
+		}
+	}
+
+	merged, err := mergeIcebergType(&icebergType{Kind: "struct", Fields: current.Fields}, fileType, &next)
+
+	if err != nil {
+		return nil, err
+	}
+
+	before, _ := json.Marshal(current.Fields)
+	after, _ := json.Marshal(merged.Fields)
+
+	if string(before) == string(after) {
+		return current, nil
+	}
+
+	schema := &icebergSchema{Type: "struct", SchemaID: current.SchemaID, Fields: merged.Fields}
+
+	if len(m.Snapshots) > 0 {
+		for _, s := range m.Schemas {
+			if s.SchemaID >= schema.SchemaID {
+				schema.SchemaID = s.SchemaID + 1
+			}
+		}
+
+		m.Schemas = append(m.Schemas, schema)
+	} else {
+		m.Schemas = []*icebergSchema{schema}
+	}
+
+	mapping, err := json.Marshal(icebergNameMappings(merged))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Properties == nil {
+		m.Properties = make(map[string]string)
+	}
+
+	m.CurrentSchemaID = schema.SchemaID
+	m.LastColumnID = next
+	m.Properties[icebergNameMappingProperty] = string(mapping)
+
+	return schema, nil
+}
+
+// append makes the snapshot the current one of the main branch, logging the previous metadata file, and expires the
+// oldest snapshots past write.metadata.previous-versions-max that no ref points to. It returns the expired snapshots.
+func (m *icebergMetadata) append(snapshot *icebergSnapshot, parent *icebergSnapshot, previous string) []*icebergSnapshot {
+	max := m.previousVersionsMax()
+
+	if parent != nil {
+		snapshot.ParentSnapshotID = &parent.SnapshotID
+	}
+
+	if len(previous) > 0 {
+		m.MetadataLog = append(m.MetadataLog, icebergLogEntry{TimestampMs: m.LastUpdatedMs, MetadataFile: previous})
+
+		if len(m.MetadataLog) > max {
+			m.MetadataLog = m.MetadataLog[len(m.MetadataLog)-max:]
+		}
+	}
+
+	if m.Refs == nil {
+		m.Refs = make(map[string]icebergRef)
+	}
+
+	main := m.Refs["main"]
+	main.SnapshotID, main.Type = snapshot.SnapshotID, "branch"
+	m.Refs["main"] = main
+
+	m.Snapshots = append(m.Snapshots, snapshot)
+	m.SnapshotLog = append(m.SnapshotLog, icebergLogEntry{TimestampMs: snapshot.TimestampMs, SnapshotID: snapshot.SnapshotID})
+	m.CurrentSnapshotID = snapshot.SnapshotID
+	m.LastSequenceNumber = snapshot.SequenceNumber
+	m.LastUpdatedMs = snapshot.TimestampMs
+
+	return m.expire(max)
+}
+
+func (m *icebergMetadata) expire(max int) []*icebergSnapshot {
+	expired := make([]*icebergSnapshot, 0)
+	extra := len(m.Snapshots) - max
+
+	if extra <= 0 {
+		return expired
+	}
+
+	referenced := make(map[int64]bool)
+
+	for _, ref := range m.Refs {
+		referenced[ref.SnapshotID] = true
+	}
+
+	kept := make([]*icebergSnapshot, 0, max)
+	ids := make(map[int64]bool)
+
+	for _, snapshot := range m.Snapshots {
+		if len(expired) < extra && !referenced[snapshot.SnapshotID] {
+			expired = append(expired, snapshot)
+			continue
+		}
+
+		kept = append(kept, snapshot)
+		ids[snapshot.SnapshotID] = true
+	}
+
+	log := make([]icebergLogEntry, 0, len(m.SnapshotLog))
+
+	for _, entry := range m.SnapshotLog {
+		if ids[entry.SnapshotID] {
+			log = append(log, entry)
+		}
+	}
+
+	m.Snapshots, m.SnapshotLog = kept, log
+
+	return expired
+}
+
+// icebergPartition returns the partition of a file, the values of its RecordInfo for the partition columns.
+func icebergPartition(columns []icebergPartitionColumn, info domain.RecordInfo) map[string]interface{} {
+	ret := make(map[string]interface{})
+
+	for _, column := range columns {
+		for _, partition := range tablePartitions(info) {
+			if partition.name != column.source {
+				continue
+			}
+
+			if n, ok := partition.value.(int); ok {
+				ret[column.Name] = int64(n)
+			} else {
+				ret[column.Name] = partition.value
+			}
+		}
+	}
+
+	return ret
+}
+
+// icebergPartitionSummaries returns the partition field summaries of a manifest list entry, for the partitions of
+// the files of the manifest.
+func icebergPartitionSummaries(columns []icebergPartitionColumn, partitions []map[string]interface{}) []interface{} {
+	ret := make([]interface{}, 0, len(columns))
+
+	for _, column := range columns {
+		nulls := false
+		var lower, upper interface{}
+
+		for _, partition := range partitions {
+			value := partition[column.Name]
+
+			switch {
+			case value == nil:
+				nulls = true
+				continue
+			case lower == nil:
+				lower, upper = value, value
+			case icebergLess(value, lower):
+				lower = value
+			case icebergLess(upper, value):
+				upper = value
+			}
+		}
+
+		ret = append(ret, map[string]interface{}{
+			"contains_null": nulls,
+			"contains_nan":  nil,
+			"lower_bound":   icebergPartitionBound(column.primitive, lower),
+			"upper_bound":   icebergPartitionBound(column.primitive, upper),
+		})
+	}
+
+	return ret
+}
+
+func icebergLess(a interface{}, b interface{}) bool {
+	if x, ok := avroInt(a); ok {
+		y, _ := avroInt(b)
+		return x < y
+	}
+
+	return fmt.Sprint(a) < fmt.Sprint(b)
+}
+
+// icebergPartitionBound is the single-value serialization of a partition value, little-endian ints and longs and
+// UTF-8 strings.
+func icebergPartitionBound(primitive string, value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+
+	n, ok := avroInt(value)
+
+	switch {
+	case !ok:
+		return []byte(fmt.Sprint(value))
+	case primitive == "int":
+		return binary.LittleEndian.AppendUint32(nil, uint32(int32(n)))
+	}
+
+	return binary.LittleEndian.AppendUint64(nil, uint64(n))
+}
+
+// icebergSummary is the summary of an append snapshot, totals are only kept when the parent has them.
+func icebergSummary(parent *icebergSnapshot, file *tableFile) map[string]string {
+	ret := map[string]string{
+		"operation":               "append",
+		"added-data-files":        "1",
+		"added-records":           strconv.FormatInt(file.records, 10),
+		"added-files-size":        strconv.FormatInt(file.size, 10),
+		"changed-partition-count": "1",
+	}
+
+	totals := map[string]int64{
+		"total-data-files":       1,
+		"total-records":          file.records,
+		"total-files-size":       file.size,
+		"total-delete-files":     0,
+		"total-position-deletes": 0,
+		"total-equality-deletes": 0,
+	}
+
+	for name, added := range totals {
+		value := int64(0)
+
+		if parent != nil {
+			total, err := strconv.ParseInt(parent.Summary[name], 10, 64)
+
+			if err != nil {
+				continue
+			}
+
+			value = total
+		}
+
+		ret[name] = strconv.FormatInt(value+added, 10)
+	}
+
+	return ret
+}
+
+// avroIntMap is an Iceberg map with int keys, written as an array of key and value records sorted by key.
+func avroIntMap(values map[int]interface{}) interface{} {
+	if len(values) == 0 {
+		return nil
+	}
+
+	keys := make([]int, 0, len(values))
+
+	for k := range values {
+		keys = append(keys, k)
+	}
+
+	sort.Ints(keys)
+	ret := make([]interface{}, 0, len(keys))
+
+	for _, k := range keys {
+		ret = append(ret, map[string]interface{}{"key": k, "value": values[k]})
+	}
+
+	return ret
+}
+
+func icebergSnapshotID() int64 {
+	n, err := rand.Int(rand.Reader, big.NewInt(math.MaxInt64))
+
+	if err != nil {
+		return time.Now().UnixNano()
+	}
+
+	return n.Int64() + 1
+}
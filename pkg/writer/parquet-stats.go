@@ -0,0 +1,278 @@
+package writer
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/xitongsys/parquet-go/parquet"
+)
+
+// parquetStats is the footer of a written parquet file, with its schema and the statistics of each column merged
+// across row groups, used by table formats to describe the file.
+type parquetStats struct {
+	rows    int64
+	root    *parquetNode
+	columns map[string]*columnStats
+	offsets []int64
+}
+
+// parquetNode is an element of the parquet schema with its children, path has the names from the root.
+type parquetNode struct {
+	element  *parquet.SchemaElement
+	children []*parquetNode
+	path     []string
+}
+
+// columnStats are the statistics of a leaf column, min and max are plain encoded and nil when a row group does not
+// have them.
+type columnStats struct {
+	node   *parquetNode
+	size   int64
+	values int64
+	nulls  int64
+	counts bool
+	min    []byte
+	max    []byte
+	bounds bool
+}
+
+func readParquetStats(r io.ReaderAt, size int64) (*parquetStats, error) {
+	tail := make([]byte, 8)
+
+	if size < 12 {
+		return nil, errors.New("file is too small to be parquet")
+	}
+
+	if _, err := r.ReadAt(tail, size-8); err != nil {
+		return nil, err
+	}
+
+	if string(tail[4:]) != "PAR1" {
+		return nil, errors.New("file is not parquet, magic not found")
+	}
+
+	length := int64(binary.LittleEndian.Uint32(tail))
+
+	if length > size-12 {
+		return nil, errors.New("invalid parquet footer length")
+	}
+
+	footer := make([]byte, length)
+
+	if _, err := r.ReadAt(footer, size-8-length); err != nil {
+		return nil, err
+	}
+
+	meta := parquet.NewFileMetaData()
+	protocol := thrift.NewTCompactProtocolConf(thrift.NewStreamTransportR(bytes.NewReader(footer)), &thrift.TConfiguration{})
+
+	if err := meta.Read(context.Background(), protocol); err != nil {
+		return nil, fmt.Errorf("invalid parquet footer: %w", err)
+	}
+
+	ret := &parquetStats{rows: meta.NumRows, columns: make(map[string]*columnStats)}
+	index := 0
+	ret.root = buildParquetTree(meta.Schema, &index, nil)
+
+	if ret.root == nil {
+		return nil, errors.New("parquet file without schema")
+	}
+
+	ret.root.walk(func(node *parquetNode) {
+		if len(node.children) == 0 && node.element.Type != nil {
+			ret.columns[strings.Join(node.path, ".")] = &columnStats{node: node, counts: true, bounds: true}
+		}
+	})
+
+	for _, group := range meta.RowGroups {
+		offset := int64(-1)
+
+		if group.FileOffset != nil {
+			offset = *group.FileOffset
+		}
+
+		for _, chunk := range group.Columns {
+			if chunk.MetaData == nil {
+				continue
+			}
+
+			if offset < 0 {
+				offset = chunk.MetaData.DataPageOffset
+
+				if chunk.MetaData.DictionaryPageOffset != nil && *chunk.MetaData.DictionaryPageOffset > 0 {
+					offset = *chunk.MetaData.DictionaryPageOffset
+				}
+			}
+
+			column, ok := ret.columns[strings.Join(chunk.MetaData.PathInSchema, ".")]
+
+			if ok {
+				column.add(chunk.MetaData)
+			}
+		}
+
+		if offset >= 0 {
+			ret.offsets = append(ret.offsets, offset)
+		}
+	}
+
+	return ret, nil
+}
+
+func buildParquetTree(schema []*parquet.SchemaElement, index *int, parent *parquetNode) *parquetNode {
+	if *index >= len(schema) {
+		return nil
+	}
+
+	node := &parquetNode{element: schema[*index]}
+	*index++
+
+	if parent != nil {
+		node.path = append(append([]string{}, parent.path...), node.element.Name)
+	}
+
+	for i := int32(0); i < node.element.GetNumChildren(); i++ {
+		if child := buildParquetTree(schema, index, node); child != nil {
+			node.children = append(node.children, child)
+		}
+	}
+
+	return node
+}
+
+func (n *parquetNode) walk(fn func(node *parquetNode)) {
+	fn(n)
+
+	for _, child := range n.children {
+		child.walk(fn)
+	}
+}
+
+func (n *parquetNode) repeated() bool {
+	return n.element.RepetitionType != nil && *n.element.RepetitionType == parquet.FieldRepetitionType_REPEATED
+}
+
+func (n *parquetNode) required() bool {
+	return n.element.RepetitionType != nil && *n.element.RepetitionType == parquet.FieldRepetitionType_REQUIRED
+}
+
+func (n *parquetNode) converted(t parquet.ConvertedType) bool {
+	return n.element.ConvertedType != nil && *n.element.ConvertedType == t
+}
+
+// listElement returns the element of a LIST group, with the standard three levels or the legacy two levels layout.
+func (n *parquetNode) listElement() *parquetNode {
+	if len(n.children) != 1 || !n.children[0].repeated() {
+		return nil
+	}
+
+	repeated := n.children[0]
+
+	if len(repeated.children) == 1 && repeated.element.Name != "array" && repeated.element.Name != n.element.Name+"_tuple" {
+		return repeated.children[0]
+	}
+
+	return repeated
+}
+
+// mapEntry returns the key and value of a MAP group, its repeated key_value group has both.
+func (n *parquetNode) mapEntry() (*parquetNode, *parquetNode) {
+	if len(n.children) != 1 || len(n.children[0].children) != 2 {
+		return nil, nil
+	}
+
+	return n.children[0].children[0], n.children[0].children[1]
+}
+
+func (c *columnStats) add(meta *parquet.ColumnMetaData) {
+	c.size += meta.TotalCompressedSize
+	c.values += meta.NumValues
+
+	stats := meta.Statistics
+
+	if stats == nil || stats.NullCount == nil {
+		c.counts = false
+	} else {
+		c.nulls += *stats.NullCount
+	}
+
+	if !c.bounds {
+		return
+	}
+
+	min, max := c.rowGroupBounds(stats)
+
+	// A row group with only nulls has no bounds and does not change them.
+	if min == nil && stats != nil && stats.NullCount != nil && *stats.NullCount == meta.NumValues {
+		return
+	}
+
+	if min == nil || max == nil {
+		c.bounds, c.min, c.max = false, nil, nil
+		return
+	}
+
+	if c.min == nil || compareParquet(meta.Type, min, c.min) < 0 {
+		c.min = min
+	}
+
+	if c.max == nil || compareParquet(meta.Type, max, c.max) > 0 {
+		c.max = max
+	}
+}
+
+// rowGroupBounds uses min_value and max_value, or the deprecated min and max for types whose sort order they got
+// right, as they were compared as signed values.
+func (c *columnStats) rowGroupBounds(stats *parquet.Statistics) ([]byte, []byte) {
+	if stats == nil {
+		return nil, nil
+	}
+
+	if stats.MinValue != nil && stats.MaxValue != nil {
+		return stats.MinValue, stats.MaxValue
+	}
+
+	switch *c.node.element.Type {
+	case parquet.Type_BOOLEAN, parquet.Type_INT32, parquet.Type_INT64, parquet.Type_FLOAT, parquet.Type_DOUBLE:
+		if c.node.converted(parquet.ConvertedType_UINT_8) || c.node.converted(parquet.ConvertedType_UINT_16) || c.node.converted(parquet.ConvertedType_UINT_32) || c.node.converted(parquet.ConvertedType_UINT_64) {
+			return nil, nil
+		}
+
+		return stats.Min, stats.Max
+	}
+
+	return nil, nil
+}
+
+func compareParquet(t parquet.Type, a []byte, b []byte) int {
+	switch {
+	case t == parquet.Type_INT32 && len(a) == 4 && len(b) == 4:
+		return compareOrdered(int32(binary.LittleEndian.Uint32(a)), int32(binary.LittleEndian.Uint32(b)))
+	case t == parquet.Type_INT64 && len(a) == 8 && len(b) == 8:
+		return compareOrdered(int64(binary.LittleEndian.Uint64(a)), int64(binary.LittleEndian.Uint64(b)))
+	case t == parquet.Type_FLOAT && len(a) == 4 && len(b) == 4:
+		return compareOrdered(math.Float32frombits(binary.LittleEndian.Uint32(a)), math.Float32frombits(binary.LittleEndian.Uint32(b)))
+	case t == parquet.Type_DOUBLE && len(a) == 8 && len(b) == 8:
+		return compareOrdered(math.Float64frombits(binary.LittleEndian.Uint64(a)), math.Float64frombits(binary.LittleEndian.Uint64(b)))
+	}
+
+	return bytes.Compare(a, b)
+}
+
+func compareOrdered[T int32 | int64 | float32 | float64](a T, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,276 @@
+package writer
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"data2parquet/pkg/domain"
+)
+
+const fieldPlaceholderPrefix = "field:"
+
+// PathTemplate builds the object path of a parquet file from `WriterPathTemplate`, a text with placeholders as
+// `env=prod/domain={domain}/service={service}/dt={date}/{id}.parquet`.
+// Record info placeholders: {record_type}, {key}, {capability}, {domain}, {service} and {application}.
+// Event time placeholders: {year}, {month}, {day}, {hour} and {date} (YYYY-MM-DD).
+// File placeholders: {id} (ULID), {hash} (MD5 of the file), {records} (number of records) and {host}.
+// Any record field can be used with {field:name}, records are split in one file for each value.
+type PathTemplate struct {
+	template string
+	parts    []templatePart
+	fields   []string
+	host     string
+}
+
+type templatePart struct {
+	text        string
+	placeholder string
+}
+
+var templatePlaceholders = map[string]int{
+	"record_type": 1,
+	"key":         2,
+	"capability":  3,
+	"domain":      4,
+	"service":     5,
+	"application": 6,
+	"year":        7,
+	"month":       8,
+	"day":         9,
+	"hour":        10,
+	"date":        11,
+	"id":          12,
+	"hash":        13,
+	"host":        14,
+	"records":     15,
+}
+
+func NewPathTemplate(template string) (*PathTemplate, error) {
+	ret, err := parseTemplate(strings.TrimLeft(strings.TrimSpace(template), "/"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ret.template) == 0 {
+		return nil, fmt.Errorf("path template is empty")
+	}
+
+	if !ret.uses("id") {
+		return nil, fmt.Errorf("path template %q must have the {id} placeholder to keep file names unique", template)
+	}
+
+	return ret, nil
+}
+
+// NewValueTemplate parses a template of an object attribute, as S3 tags and metadata, with the same placeholders of
+// path templates but {field:name}, as records are not split by it.
+func NewValueTemplate(template string) (*PathTemplate, error) {
+	ret, err := parseTemplate(template)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ret.fields) > 0 {
+		return nil, fmt.Errorf("template %q can not use field placeholders, they are only available on the path template", template)
+	}
+
+	return ret, nil
+}
+
+func parseTemplate(template string) (*PathTemplate, error) {
+	ret := &PathTemplate{
+		template: template,
+		parts:    make([]templatePart, 0),
+		fields:   make([]string, 0),
+	}
+
+	rest := ret.template
+
+	for len(rest) > 0 {
+		start := strings.IndexAny(rest, "{}")
+
+		if start < 0 {
+			ret.parts = append(ret.parts, templatePart{text: rest})
+			break
+		}
+
+		if rest[start] == '}' {
+			return nil, fmt.Errorf("template %q has an unexpected '}'", template)
+		}
+
+		end := strings.IndexAny(rest[start+1:], "{}")
+
+		if end < 0 || rest[start+1+end] != '}' {
+			return nil, fmt.Errorf("template %q has an unclosed '{'", template)
+		}
+
+		if start > 0 {
+			ret.parts = append(ret.parts, templatePart{text: rest[:start]})
+		}
+
+		name := strings.TrimSpace(rest[start+1 : start+1+end])
+
+		switch {
+		case strings.HasPrefix(name, fieldPlaceholderPrefix):
+			field := strings.TrimSpace(strings.TrimPrefix(name, fieldPlaceholderPrefix))
+
+			if len(field) == 0 {
+				return nil, fmt.Errorf("template %q has a field placeholder without name", template)
+			}
+
+			name = fieldPlaceholderPrefix + field
+			ret.addField(field)
+		case templatePlaceholders[name] > 0:
+		default:
+			return nil, fmt.Errorf("template %q has an unknown placeholder {%s}", template, name)
+		}
+
+		ret.parts = append(ret.parts, templatePart{placeholder: name})
+		rest = rest[start+end+2:]
+	}
+
+	host, err := os.Hostname()
+
+	if err != nil {
+		slog.Warn("Error getting hostname for path template", "error", err, "module", "writer", "function", "parseTemplate")
+		host = "unknown"
+	}
+
+	ret.host = host
+
+	return ret, nil
+}
+
+func (t *PathTemplate) addField(field string) {
+	for _, f := range t.fields {
+		if f == field {
+			return
+		}
+	}
+
+	t.fields = append(t.fields, field)
+}
+
+// Fields returns the record fields used by the template, records must be split by them before the write.
+func (t *PathTemplate) Fields() []string {
+	return t.fields
+}
+
+func (t *PathTemplate) uses(placeholder string) bool {
+	for _, part := range t.parts {
+		if part.placeholder == placeholder {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NeedsComplete reports if the template has the {hash} or {records} placeholders, the file must be complete to
+// render it.
+func (t *PathTemplate) NeedsComplete() bool {
+	return t.uses("hash") || t.uses("records")
+}
+
+func (t *PathTemplate) Render(info domain.RecordInfo, id string, hash string, records int) string {
+	tm := info.Partition()
+	fields := info.Fields()
+	sb := strings.Builder{}
+
+	for _, part := range t.parts {
+		if len(part.placeholder) == 0 {
+			sb.WriteString(part.text)
+			continue
+		}
+
+		switch part.placeholder {
+		case "record_type":
+			sb.WriteString(info.RecordType())
+		case "key":
+			sb.WriteString(info.Key())
+		case "capability":
+			sb.WriteString(info.Capability())
+		case "domain":
+			sb.WriteString(info.Domain())
+		case "service":
+			sb.WriteString(info.Service())
+		case "application":
+			sb.WriteString(info.Application())
+		case "year":
+			sb.WriteString(fmt.Sprintf("%04d", tm.Year()))
+		case "month":
+			sb.WriteString(fmt.Sprintf("%02d", tm.Month()))
+		case "day":
+			sb.WriteString(fmt.Sprintf("%02d", tm.Day()))
+		case "hour":
+			sb.WriteString(fmt.Sprintf("%02d", tm.Hour()))
+		case "date":
+			sb.WriteString(tm.Format("2006-01-02"))
+		case "id":
+			sb.WriteString(id)
+		case "hash":
+			sb.WriteString(hash)
+		case "host":
+			sb.WriteString(t.host)
+		case "records":
+			sb.WriteString(strconv.Itoa(records))
+		default:
+			sb.WriteString(pathSafe(fields[strings.TrimPrefix(part.placeholder, fieldPlaceholderPrefix)]))
+		}
+	}
+
+	return sb.String()
+}
+
+// pathSafe percent-encodes separators, `%` and control characters of field values, and values that are only dots,
+// so records can't create new path levels or write out of the writer root.
+func pathSafe(value string) string {
+	if len(value) == 0 {
+		return "unknown"
+	}
+
+	if strings.Trim(value, ".") == "" {
+		return strings.ReplaceAll(value, ".", "%2E")
+	}
+
+	sb := strings.Builder{}
+
+	for _, b := range []byte(value) {
+		if b < 0x20 || b == 0x7f || b == '/' || b == '\\' || b == '%' {
+			fmt.Fprintf(&sb, "%%%02X", b)
+			continue
+		}
+
+		sb.WriteByte(b)
+	}
+
+	return sb.String()
+}
+
+// needsComplete reports if the path of a file has its MD5 hash or its number of records, it must be written before
+// the path is known.
+func needsComplete(template *PathTemplate, useHash bool) bool {
+	if template != nil {
+		return template.NeedsComplete()
+	}
+
+	return useHash
+}
+
+// targetPath returns the path of a new parquet file, using the path template when it is set. The hash is the MD5 of
+// the file, or empty when needsComplete is false.
+func targetPath(template *PathTemplate, info domain.RecordInfo, id string, hash string, records int) string {
+	if template != nil {
+		return template.Render(info, id, hash, records)
+	}
+
+	if len(hash) > 0 {
+		hash = "-" + hash
+	}
+
+	return info.Target(id, hash)
+}
@@ -0,0 +1,157 @@
+package writer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// fileStore keeps the table in a local directory, objects are written atomically as data files and put-if-absent
+// links the synced temporary file to its name, failing when it exists.
+type fileStore struct {
+	file *File
+	root string
+}
+
+func (s *fileStore) location(name string) string {
+	return "file://" + filepath.ToSlash(filepath.Join(s.root, name))
+}
+
+func (s *fileStore) readObject(name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(s.root, name))
+}
+
+func (s *fileStore) writeObject(name string, data []byte) error {
+	target := filepath.Join(s.root, name)
+
+	if err := s.file.mkdirAll(filepath.Dir(target)); err != nil {
+		return err
+	}
+
+	return s.file.writeAtomic(target, data)
+}
+
+func (s *fileStore) putIfAbsent(name string, data []byte) error {
+	target := filepath.Join(s.root, name)
+
+	if err := s.file.mkdirAll(filepath.Dir(target)); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+"-*.tmp")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+
+	if err = s.file.closeTemp(tmp, err); err != nil {
+		return err
+	}
+
+	if err = os.Link(tmp.Name(), target); err != nil {
+		return err
+	}
+
+	return syncDir(filepath.Dir(target))
+}
+
+func (s *fileStore) removeObject(name string) error {
+	return os.Remove(filepath.Join(s.root, name))
+}
+
+// s3Store keeps the table under a prefix of the bucket, put-if-absent uses conditional writes (If-None-Match), so
+// concurrent commits are safe on S3 without a lock table.
+type s3Store struct {
+	s3     *S3
+	prefix string
+}
+
+func (s *s3Store) key(name string) string {
+	return path.Join(s.prefix, name)
+}
+
+func (s *s3Store) location(name string) string {
+	return "s3://" + s.s3.config.S3BuketName + "/" + s.key(name)
+}
+
+func (s *s3Store) readObject(name string) ([]byte, error) {
+	ret, err := s.s3.client.GetObject(s.s3.ctx, &s3.GetObjectInput{
+		Bucket:               aws.String(s.s3.config.S3BuketName),
+		Key:                  aws.String(s.key(name)),
+		SSECustomerAlgorithm: s.s3.sseCustomer.algorithm,
+		SSECustomerKey:       s.s3.sseCustomer.key,
+		SSECustomerKeyMD5:    s.s3.sseCustomer.md5,
+	})
+
+	if err != nil {
+		var apiErr smithy.APIError
+
+		if s3Status(err) == http.StatusNotFound || (errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchKey") {
+			return nil, fmt.Errorf("%s: %w", s.location(name), os.ErrNotExist)
+		}
+
+		return nil, s.s3.classify(s.location(name), err)
+	}
+
+	defer ret.Body.Close()
+
+	return io.ReadAll(ret.Body)
+}
+
+func (s *s3Store) writeObject(name string, data []byte) error {
+	if err := s.s3.putObject(&s3Object{key: s.key(name)}, data); err != nil {
+		return s.s3.classify(s.location(name), err)
+	}
+
+	return nil
+}
+
+func (s *s3Store) putIfAbsent(name string, data []byte) error {
+	err := s.s3.putObject(&s3Object{key: s.key(name)}, data, func(o *s3.Options) {
+		o.APIOptions = append(o.APIOptions, smithyhttp.AddHeaderValue("If-None-Match", "*"))
+	})
+
+	// 409 is returned when a conditional write of the same key is in progress.
+	if status := s3Status(err); status == http.StatusPreconditionFailed || status == http.StatusConflict {
+		return fmt.Errorf("%s: %w", s.location(name), os.ErrExist)
+	}
+
+	if err != nil {
+		return s.s3.classify(s.location(name), err)
+	}
+
+	return nil
+}
+
+func (s *s3Store) removeObject(name string) error {
+	_, err := s.s3.client.DeleteObject(s.s3.ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.s3.config.S3BuketName),
+		Key:    aws.String(s.key(name)),
+	})
+
+	return err
+}
+
+func s3Status(err error) int {
+	var respErr *awshttp.ResponseError
+
+	if errors.As(err, &respErr) {
+		return respErr.HTTPStatusCode()
+	}
+
+	return 0
+}
@@ -0,0 +1,137 @@
+package writer
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path"
+	"time"
+
+	"data2parquet/pkg/config"
+	"data2parquet/pkg/domain"
+)
+
+const maxCommitBackoff = 5 * time.Second
+
+// tableStore is where a table keeps its metadata, the directory of the file writer or the bucket of the S3 writer.
+// Paths are relative to the root of the table.
+type tableStore interface {
+	// location is the URI of a path, as the readers of the table find it.
+	location(name string) string
+	// readObject returns an error matching os.ErrNotExist when the object does not exist.
+	readObject(name string) ([]byte, error)
+	writeObject(name string, data []byte) error
+	// putIfAbsent writes an object only when it does not exist, returning an error matching os.ErrExist otherwise,
+	// so a single writer wins each commit.
+	putIfAbsent(name string, data []byte) error
+	removeObject(name string) error
+}
+
+// tableFile is a data file written under the root of the table, to be committed.
+type tableFile struct {
+	path    string
+	size    int64
+	records int64
+	info    domain.RecordInfo
+	stats   *parquetStats
+}
+
+// tablePartition is a partition column of the tables and its value for a file, the identity of a field of the
+// RecordInfo of the file.
+type tablePartition struct {
+	name    string
+	integer bool
+	value   interface{}
+}
+
+// tablePartitionColumns are the partition columns of the tables, the capability and the year, month, day and hour of
+// the partition time, as the directories of the default target path.
+var tablePartitionColumns = []tablePartition{
+	{name: "capability"},
+	{name: "year", integer: true},
+	{name: "month", integer: true},
+	{name: "day", integer: true},
+	{name: "hour", integer: true},
+}
+
+// tablePartitions returns the partition columns with the values of a file, strings and ints.
+func tablePartitions(info domain.RecordInfo) []tablePartition {
+	tm := info.Partition()
+	year, month, day := tm.Date()
+	values := []interface{}{info.Capability(), year, int(month), day, tm.Hour()}
+	ret := make([]tablePartition, 0, len(tablePartitionColumns))
+
+	for i, column := range tablePartitionColumns {
+		column.value = values[i]
+		ret = append(ret, column)
+	}
+
+	return ret
+}
+
+// table keeps a table format over the files written by a writer, each one is committed after it is written.
+type table interface {
+	Commit(file *tableFile) error
+}
+
+// newTable returns the table of WriterTableFormat, or nil when files are written as plain files.
+func newTable(cfg *config.Config, store tableStore) table {
+	switch cfg.WriterTableFormat {
+	case config.WriterTableFormatIceberg:
+		return newIcebergTable(cfg, store)
+	case config.WriterTableFormatDelta:
+		return newDeltaTable(cfg, store)
+	}
+
+	return nil
+}
+
+// newTableFile reads the footer of a written parquet file, to commit it to a table.
+func newTableFile(name string, data io.ReaderAt, size int64, info domain.RecordInfo) (*tableFile, error) {
+	stats, err := readParquetStats(data, size)
+
+	if err != nil {
+		return nil, fmt.Errorf("error reading parquet footer of %s: %w", name, err)
+	}
+
+	return &tableFile{path: path.Clean(name), size: size, records: stats.rows, info: info, stats: stats}, nil
+}
+
+// commitTable runs commit until it does not conflict with other writers, up to WriterTableCommitAttempts times with a
+// random backoff, so concurrent writers don't retry at the same time.
+func commitTable(cfg *config.Config, format string, commit func(attempt int) error) error {
+	var err error
+
+	for attempt := 1; attempt <= cfg.WriterTableCommitAttempts; attempt++ {
+		if err = commit(attempt); !errors.Is(err, os.ErrExist) {
+			return err
+		}
+
+		wait := backoff(attempt)
+
+		if wait <= 0 || wait > maxCommitBackoff {
+			wait = maxCommitBackoff
+		}
+
+		jitter, _ := rand.Int(rand.Reader, big.NewInt(int64(wait)))
+
+		slog.Warn("Table commit conflict, retrying", "module", "writer.table", "function", "commitTable", "format", format, "attempt", attempt, "wait", wait)
+		time.Sleep(wait/2 + time.Duration(jitter.Int64()))
+	}
+
+	return fmt.Errorf("%s commit failed after %d attempts: %w", format, cfg.WriterTableCommitAttempts, err)
+}
+
+// newUUID returns a random UUID, as the names of table metadata files.
+func newUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
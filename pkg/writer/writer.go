@@ -1,19 +1,118 @@
 package writer
 
 import (
-	"bytes"
 	"context"
 	"data2parquet/pkg/config"
 	"data2parquet/pkg/logger" // "log/slog"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
 )
 
 var slog = logger.GetLogger()
 
 type Writer interface {
 	Init() error
-	Write(key string, buf *bytes.Buffer) error
+	Write(key string, data io.Reader) error
 	Close() error
 	IsReady() bool
+	// Status returns the error that keeps the writer from being ready, nil when it is ready.
+	Status() error
+}
+
+const ErrorKindNotFound = "not-found"
+const ErrorKindForbidden = "forbidden"
+const ErrorKindNetwork = "network"
+const ErrorKindUnknown = "unknown"
+
+// Error is a writer failure classified by its kind, with a hint of how to fix it.
+type Error struct {
+	Kind   string
+	Target string
+	Hint   string
+	Err    error
+}
+
+func (e *Error) Error() string {
+	if len(e.Hint) == 0 {
+		return fmt.Sprintf("%s on %s: %v", e.Kind, e.Target, e.Err)
+	}
+
+	return fmt.Sprintf("%s on %s: %v (%s)", e.Kind, e.Target, e.Err, e.Hint)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ErrorKind returns the kind of a writer error, or ErrorKindUnknown when it is not classified.
+func ErrorKind(err error) string {
+	var werr *Error
+
+	if errors.As(err, &werr) {
+		return werr.Kind
+	}
+
+	return ErrorKindUnknown
+}
+
+// RecordCounter is implemented by the data given to Write when it knows how many records the file has, the count is
+// only complete when the data was read to the end.
+type RecordCounter interface {
+	Records() int
+}
+
+func recordCount(data io.Reader) int {
+	if counter, ok := data.(RecordCounter); ok {
+		return counter.Records()
+	}
+
+	return 0
+}
+
+// writerStatus is the readiness of an object storage writer. A failed status is checked again once the interval has
+// passed, so a write error does not keep the writer unready after its destination is back.
+type writerStatus struct {
+	mu       sync.Mutex
+	checking sync.Mutex
+	err      error
+	updated  time.Time
+}
+
+func (s *writerStatus) set(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.err = err
+	s.updated = time.Now()
+}
+
+// get returns the status, running check when it failed more than interval ago. A single check runs at a time, the
+// others return the failed status, and writes made while it runs are not overwritten by its result.
+func (s *writerStatus) get(interval time.Duration, check func() error) error {
+	s.mu.Lock()
+	err, updated := s.err, s.updated
+	s.mu.Unlock()
+
+	if err == nil || time.Since(updated) < interval || !s.checking.TryLock() {
+		return err
+	}
+
+	defer s.checking.Unlock()
+
+	err = check()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.updated.Equal(updated) {
+		s.err = err
+		s.updated = time.Now()
+	}
+
+	return s.err
 }
 
 func New(ctx context.Context, cfg *config.Config) Writer {
@@ -24,6 +123,12 @@ func New(ctx context.Context, cfg *config.Config) Writer {
 	switch cfg.WriterType {
 	case config.WriterTypeAWSS3:
 		return NewS3(ctx, cfg)
+	case config.WriterTypeGCS:
+		return NewGCS(ctx, cfg)
+	case config.WriterTypeAzureBlob:
+		return NewAzureBlob(ctx, cfg)
+	case config.WriterTypeFanOut:
+		return NewFanOut(ctx, cfg)
 	case config.WriterTypeFile:
 		return NewFile(ctx, cfg)
 
@@ -31,3 +136,19 @@ func New(ctx context.Context, cfg *config.Config) Writer {
 		return NewFile(ctx, cfg)
 	}
 }
+
+// maxBackoffAttempt is the last attempt whose wait grows, so the shift of backoff never overflows.
+const maxBackoffAttempt = 16
+
+// backoff returns the exponential wait before a retry, attempts after maxBackoffAttempt wait as that one.
+func backoff(attempt int) time.Duration {
+	if attempt < 0 {
+		attempt = 0
+	}
+
+	if attempt > maxBackoffAttempt {
+		attempt = maxBackoffAttempt
+	}
+
+	return time.Duration(100<<attempt) * time.Millisecond
+}
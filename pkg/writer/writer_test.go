@@ -0,0 +1,1799 @@
+package writer_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"data2parquet/pkg/config"
+	"data2parquet/pkg/domain"
+	"data2parquet/pkg/writer"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	pwriter "github.com/xitongsys/parquet-go/writer"
+)
+
+// fakeS3 is an in-process stand-in of the S3 API used by the writer, it validates checksums like S3 and can fail
+// parts on demand.
+type fakeS3 struct {
+	mu        sync.Mutex
+	objects   map[string][]byte
+	uploads   map[string]map[int][]byte
+	failParts map[int]int
+	aborted   int
+	checked   int
+	inflight  int
+	maxFlight int
+	nextID    int
+	accessKey string
+	sts       url.Values
+	headers   map[string]http.Header
+	parts     []http.Header
+	bucket    int
+	created   bool
+	deny      string
+	deleted   []string
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{
+		objects:   make(map[string][]byte),
+		uploads:   make(map[string]map[int][]byte),
+		failParts: make(map[int]int),
+		headers:   make(map[string]http.Header),
+	}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	query := r.URL.Query()
+
+	body, _ := io.ReadAll(r.Body)
+
+	if r.Method == http.MethodPost && len(parts[0]) == 0 {
+		f.assumeRole(w, body)
+		return
+	}
+
+	if auth := r.Header.Get("Authorization"); strings.Contains(auth, "Credential=") {
+		f.mu.Lock()
+		f.accessKey = strings.SplitN(strings.SplitN(auth, "Credential=", 2)[1], "/", 2)[0]
+		f.mu.Unlock()
+	}
+
+	if len(parts) < 2 {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		if r.Method == http.MethodPut {
+			f.created = true
+			f.bucket = 0
+		}
+
+		if f.bucket != 0 {
+			w.WriteHeader(f.bucket)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	key := parts[1]
+
+	if len(f.deny) > 0 && strings.HasPrefix(key, f.deny) {
+		s3Error(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.mu.Lock()
+		f.nextID++
+		id := fmt.Sprintf("upload-%d", f.nextID)
+		f.uploads[id] = make(map[int][]byte)
+		f.headers[key] = r.Header
+		f.mu.Unlock()
+
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>", parts[0], key, id)
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		f.uploadPart(w, r, query.Get("uploadId"), body)
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		f.complete(w, r, key, query.Get("uploadId"), body)
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		f.mu.Lock()
+		delete(f.uploads, query.Get("uploadId"))
+		f.aborted++
+		f.mu.Unlock()
+
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete:
+		f.mu.Lock()
+		delete(f.objects, key)
+		delete(f.headers, key)
+		f.deleted = append(f.deleted, key)
+		f.mu.Unlock()
+
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		sum, ok := checkSum(w, r, body)
+
+		if !ok {
+			return
+		}
+
+		f.mu.Lock()
+		if _, ok := f.objects[key]; ok && r.Header.Get("If-None-Match") == "*" {
+			f.mu.Unlock()
+			s3Error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		f.objects[key] = body
+		f.headers[key] = r.Header
+		if len(sum.value) > 0 {
+			f.checked++
+		}
+		f.mu.Unlock()
+
+		w.Header().Set(sum.header, sum.value)
+		w.Header().Set("ETag", `"etag"`)
+	case r.Method == http.MethodGet:
+		f.mu.Lock()
+		object, ok := f.objects[key]
+		f.mu.Unlock()
+
+		if !ok {
+			s3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+
+		w.Write(object)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+// assumeRole answers STS AssumeRole and AssumeRoleWithWebIdentity with credentials named after the action.
+func (f *fakeS3) assumeRole(w http.ResponseWriter, body []byte) {
+	values, _ := url.ParseQuery(string(body))
+	action := values.Get("Action")
+
+	f.mu.Lock()
+	f.sts = values
+	f.mu.Unlock()
+
+	fmt.Fprintf(w, `<%sResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><%sResult><Credentials><AccessKeyId>%s</AccessKeyId><SecretAccessKey>secret</SecretAccessKey><SessionToken>token</SessionToken><Expiration>2100-01-01T00:00:00Z</Expiration></Credentials></%sResult></%sResponse>`, action, action, action, action, action)
+}
+
+func (f *fakeS3) uploadPart(w http.ResponseWriter, r *http.Request, id string, body []byte) {
+	number, _ := strconv.Atoi(r.URL.Query().Get("partNumber"))
+
+	f.mu.Lock()
+	f.inflight++
+	if f.inflight > f.maxFlight {
+		f.maxFlight = f.inflight
+	}
+	fails := f.failParts[number]
+	if fails > 0 {
+		f.failParts[number]--
+	}
+	f.mu.Unlock()
+
+	defer func() {
+		f.mu.Lock()
+		f.inflight--
+		f.mu.Unlock()
+	}()
+
+	if fails != 0 {
+		s3Error(w, http.StatusInternalServerError, "InternalError")
+		return
+	}
+
+	sum, ok := checkSum(w, r, body)
+
+	if !ok {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	upload, found := f.uploads[id]
+
+	if !found {
+		s3Error(w, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+
+	upload[number] = body
+	f.parts = append(f.parts, r.Header)
+	if len(sum.value) > 0 {
+		f.checked++
+	}
+
+	w.Header().Set(sum.header, sum.value)
+	w.Header().Set("ETag", fmt.Sprintf(`"part-%d"`, number))
+}
+
+func (f *fakeS3) complete(w http.ResponseWriter, r *http.Request, key string, id string, body []byte) {
+	request := struct {
+		Parts []struct {
+			PartNumber     int
+			ChecksumCRC32C string
+			ChecksumSHA256 string
+		} `xml:"Part"`
+	}{}
+
+	if err := xml.Unmarshal(body, &request); err != nil {
+		s3Error(w, http.StatusBadRequest, "MalformedXML")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	upload, found := f.uploads[id]
+
+	if !found {
+		s3Error(w, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+
+	data := bytes.Buffer{}
+	var composite hash.Hash
+	field := ""
+
+	for i, part := range request.Parts {
+		content, ok := upload[part.PartNumber]
+
+		if !ok || part.PartNumber != i+1 {
+			s3Error(w, http.StatusBadRequest, "InvalidPart")
+			return
+		}
+
+		data.Write(content)
+
+		if len(part.ChecksumCRC32C) > 0 {
+			if composite == nil {
+				composite, field = crc32.New(crc32.MakeTable(crc32.Castagnoli)), "ChecksumCRC32C"
+			}
+			composite.Write(crcSum(content))
+		}
+
+		if len(part.ChecksumSHA256) > 0 {
+			if composite == nil {
+				composite, field = sha256.New(), "ChecksumSHA256"
+			}
+			composite.Write(shaSum(content))
+		}
+	}
+
+	f.objects[key] = data.Bytes()
+	delete(f.uploads, id)
+
+	checksum := ""
+	if composite != nil {
+		checksum = fmt.Sprintf("<%s>%s-%d</%s>", field, base64.StdEncoding.EncodeToString(composite.Sum(nil)), len(request.Parts), field)
+	}
+
+	fmt.Fprintf(w, "<CompleteMultipartUploadResult><Key>%s</Key><ETag>\"etag\"</ETag>%s</CompleteMultipartUploadResult>", key, checksum)
+}
+
+type checksum struct {
+	header string
+	value  string
+}
+
+// checkSum validates the checksum header of a request like S3, writing a BadDigest error when it does not match.
+func checkSum(w http.ResponseWriter, r *http.Request, body []byte) (checksum, bool) {
+	ret := checksum{header: "X-Amz-Checksum-Crc32c", value: r.Header.Get("X-Amz-Checksum-Crc32c")}
+	expected := base64.StdEncoding.EncodeToString(crcSum(body))
+
+	if len(ret.value) == 0 {
+		ret = checksum{header: "X-Amz-Checksum-Sha256", value: r.Header.Get("X-Amz-Checksum-Sha256")}
+		expected = base64.StdEncoding.EncodeToString(shaSum(body))
+	}
+
+	if len(ret.value) > 0 && ret.value != expected {
+		s3Error(w, http.StatusBadRequest, "BadDigest")
+		return ret, false
+	}
+
+	return ret, true
+}
+
+func crcSum(data []byte) []byte {
+	h := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func shaSum(data []byte) []byte {
+	h := sha256.Sum256(data)
+	return h[:]
+}
+
+func s3Error(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func prepareS3(t *testing.T, fake *fakeS3, settings map[string]string) writer.Writer {
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	w, err := newS3(server.URL, settings)
+
+	if err != nil {
+		t.Fatalf("Error initializing S3 writer: %s", err)
+	}
+
+	fake.mu.Lock()
+	fake.deleted = nil
+	fake.checked = 0
+	fake.mu.Unlock()
+
+	return w
+}
+
+func newS3(endpoint string, settings map[string]string) (writer.Writer, error) {
+	cfg := &config.Config{}
+	values := map[string]string{
+		"RecordType":        config.RecordTypeLog,
+		"WriterType":        config.WriterTypeAWSS3,
+		"S3BucketName":      "bucket",
+		"S3Region":          "us-east-1",
+		"S3Endpoint":        endpoint,
+		"S3STSEndpoint":     endpoint,
+		"S3RetryMaxBackoff": "10",
+		"S3CredentialMode":  config.S3CredentialModeStatic,
+		"S3AccessKeyID":     "static",
+		"S3SecretAccessKey": "secret",
+	}
+
+	for k, v := range settings {
+		values[k] = v
+	}
+
+	if err := cfg.Set(values); err != nil {
+		return nil, err
+	}
+
+	cfg.SetDefaults()
+
+	w := writer.New(context.Background(), cfg)
+
+	return w, w.Init()
+}
+
+func TestS3Credentials(t *testing.T) {
+	token := filepath.Join(t.TempDir(), "token")
+	os.WriteFile(token, []byte("oidc-token"), 0600)
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "chain")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	tests := []struct {
+		name      string
+		settings  map[string]string
+		accessKey string
+		sts       map[string]string
+	}{
+		{
+			name:      "static",
+			accessKey: "static",
+		},
+		{
+			name:      "default-chain",
+			settings:  map[string]string{"S3CredentialMode": config.S3CredentialModeDefaultChain},
+			accessKey: "chain",
+		},
+		{
+			name: "assume-role",
+			settings: map[string]string{
+				"S3CredentialMode":  config.S3CredentialModeAssumeRole,
+				"S3RoleARN":         "arn:aws:iam::000000000000:role/writer",
+				"S3ExternalID":      "external",
+				"S3SessionDuration": "1800",
+			},
+			accessKey: "AssumeRole",
+			sts:       map[string]string{"RoleArn": "arn:aws:iam::000000000000:role/writer", "ExternalId": "external", "DurationSeconds": "1800", "RoleSessionName": "writer"},
+		},
+		{
+			name: "web-identity",
+			settings: map[string]string{
+				"S3CredentialMode":       config.S3CredentialModeWebIdentity,
+				"S3RoleARN":              "arn:aws:iam::000000000000:role/irsa",
+				"S3WebIdentityTokenFile": token,
+			},
+			accessKey: "AssumeRoleWithWebIdentity",
+			sts:       map[string]string{"RoleArn": "arn:aws:iam::000000000000:role/irsa", "WebIdentityToken": "oidc-token"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := newFakeS3()
+			w := prepareS3(t, fake, test.settings)
+
+			if err := w.Write("log:capability:domain:service:application", bytes.NewReader(randomData(1024))); err != nil {
+				t.Fatalf("Error writing to S3: %s", err)
+			}
+
+			if fake.accessKey != test.accessKey {
+				t.Errorf("Expected requests signed with %s, got %s", test.accessKey, fake.accessKey)
+			}
+
+			for k, v := range test.sts {
+				if fake.sts.Get(k) != v {
+					t.Errorf("Expected STS %s %s, got %s", k, v, fake.sts.Get(k))
+				}
+			}
+		})
+	}
+}
+
+func TestS3CredentialsMisconfigured(t *testing.T) {
+	t.Setenv("AWS_ROLE_ARN", "")
+	t.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", "")
+
+	tests := map[string]map[string]string{
+		"static without keys":        {"S3CredentialMode": config.S3CredentialModeStatic, "S3AccessKeyID": ""},
+		"assume-role without role":   {"S3CredentialMode": config.S3CredentialModeAssumeRole},
+		"web-identity without token": {"S3CredentialMode": config.S3CredentialModeWebIdentity, "S3RoleARN": "arn:aws:iam::000000000000:role/irsa"},
+		"web-identity missing token": {"S3CredentialMode": config.S3CredentialModeWebIdentity, "S3RoleARN": "arn:aws:iam::000000000000:role/irsa", "S3WebIdentityTokenFile": filepath.Join(t.TempDir(), "missing")},
+		"unknown mode":               {"S3CredentialMode": "instance"},
+	}
+
+	for name, settings := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := &config.Config{}
+			values := map[string]string{
+				"WriterType":        config.WriterTypeAWSS3,
+				"S3BucketName":      "bucket",
+				"S3Region":          "us-east-1",
+				"S3AccessKeyID":     "static",
+				"S3SecretAccessKey": "secret",
+			}
+
+			for k, v := range settings {
+				values[k] = v
+			}
+
+			cfg.Set(values)
+			cfg.SetDefaults()
+
+			if err := writer.New(context.Background(), cfg).Init(); err == nil {
+				t.Errorf("Expected an error initializing the S3 writer")
+			}
+		})
+	}
+}
+
+// countedReader is the data of a file that knows how many records it has, as the receiver partitions.
+type countedReader struct {
+	*bytes.Reader
+	records int
+}
+
+func (r *countedReader) Records() int {
+	return r.records
+}
+
+func randomData(size int) []byte {
+	ret := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(ret)
+	return ret
+}
+
+func objects(fake *fakeS3) [][]byte {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	keys := make([]string, 0)
+	for k := range fake.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	ret := make([][]byte, 0)
+	for _, k := range keys {
+		ret = append(ret, fake.objects[k])
+	}
+
+	return ret
+}
+
+func TestS3PutObject(t *testing.T) {
+	fake := newFakeS3()
+	w := prepareS3(t, fake, nil)
+	data := randomData(1 << 20)
+
+	if err := w.Write("log:capability:domain:service:application", bytes.NewReader(data)); err != nil {
+		t.Fatalf("Error writing to S3: %s", err)
+	}
+
+	written := objects(fake)
+
+	if len(written) != 1 || !bytes.Equal(written[0], data) {
+		t.Fatalf("Expected one object with the written data, got %d objects", len(written))
+	}
+
+	if fake.nextID != 0 {
+		t.Errorf("Expected a single PutObject, got %d multipart uploads", fake.nextID)
+	}
+
+	if fake.checked != 1 {
+		t.Errorf("Expected the object to be sent with its checksum")
+	}
+}
+
+func TestS3Multipart(t *testing.T) {
+	fake := newFakeS3()
+	fake.failParts[2] = 1
+
+	w := prepareS3(t, fake, map[string]string{
+		"S3ChecksumAlgorithm":  config.S3ChecksumSHA256,
+		"S3MultipartThreshold": fmt.Sprintf("%d", 5<<20),
+		"S3PartSize":           fmt.Sprintf("%d", 5<<20),
+		"S3UploadConcurrency":  "3",
+	})
+
+	data := randomData(17 << 20)
+
+	if err := w.Write("log:capability:domain:service:application", bytes.NewReader(data)); err != nil {
+		t.Fatalf("Error writing to S3: %s", err)
+	}
+
+	written := objects(fake)
+
+	if len(written) != 1 || !bytes.Equal(written[0], data) {
+		t.Fatalf("Expected one object with the written data, got %d objects", len(written))
+	}
+
+	if len(fake.uploads) != 0 || fake.aborted != 0 {
+		t.Errorf("Expected the upload to be completed, got %d open and %d aborted", len(fake.uploads), fake.aborted)
+	}
+
+	if fake.checked != 4 {
+		t.Errorf("Expected 4 parts sent with their checksums, got %d", fake.checked)
+	}
+
+	if fake.failParts[2] != 0 {
+		t.Errorf("Expected the failed part to be retried")
+	}
+
+	if fake.maxFlight > 3 {
+		t.Errorf("Expected at most 3 parts at the same time, got %d", fake.maxFlight)
+	}
+
+	// Part buffers are reused by the next file
+	next := randomData(12 << 20)
+
+	if err := w.Write("log:capability:domain:service:application", bytes.NewReader(next)); err != nil {
+		t.Fatalf("Error writing to S3: %s", err)
+	}
+
+	found := 0
+
+	for _, object := range objects(fake) {
+		if bytes.Equal(object, data) || bytes.Equal(object, next) {
+			found++
+		}
+	}
+
+	if found != 2 {
+		t.Errorf("Expected both files intact after reusing part buffers, got %d", found)
+	}
+}
+
+func TestS3MultipartAbort(t *testing.T) {
+	fake := newFakeS3()
+	fake.failParts[3] = -1
+
+	w := prepareS3(t, fake, map[string]string{
+		"S3MaxAttempts":        "2",
+		"S3MultipartThreshold": fmt.Sprintf("%d", 5<<20),
+		"S3PartSize":           fmt.Sprintf("%d", 5<<20),
+	})
+
+	if err := w.Write("log:capability:domain:service:application", bytes.NewReader(randomData(22<<20))); err == nil {
+		t.Fatal("Expected an error writing to S3")
+	}
+
+	if len(objects(fake)) != 0 {
+		t.Error("Expected no object after a failed upload")
+	}
+
+	if fake.aborted != 1 || len(fake.uploads) != 0 {
+		t.Errorf("Expected the upload to be aborted, got %d open and %d aborted", len(fake.uploads), fake.aborted)
+	}
+}
+
+func TestS3ObjectOptions(t *testing.T) {
+	customerKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))
+	data := randomData(6 << 20)
+	sum := md5.Sum(data)
+
+	tests := []struct {
+		name     string
+		settings map[string]string
+		object   map[string]string
+		part     map[string]string
+	}{
+		{
+			name: "sse-kms",
+			settings: map[string]string{
+				"S3ServerSideEncryption": config.S3ServerSideEncryptionKMS,
+				"S3KMSKeyID":             "alias/data",
+				"S3StorageClass":         "standard_ia",
+				"S3Tags":                 "classification=internal,service={service}",
+				"S3Metadata":             "capability={capability},records={records},hash={hash}",
+			},
+			object: map[string]string{
+				"X-Amz-Server-Side-Encryption":                "aws:kms",
+				"X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id": "alias/data",
+				"X-Amz-Storage-Class":                         "STANDARD_IA",
+				"X-Amz-Tagging":                               "classification=internal&service=service",
+				"X-Amz-Meta-Capability":                       "capability",
+				"X-Amz-Meta-Records":                          "42",
+				"X-Amz-Meta-Hash":                             hex.EncodeToString(sum[:]),
+			},
+		},
+		{
+			name: "sse-c multipart",
+			settings: map[string]string{
+				"S3ServerSideEncryption": config.S3ServerSideEncryptionCustomer,
+				"S3SSECustomerKey":       customerKey,
+				"S3MultipartThreshold":   fmt.Sprintf("%d", 5<<20),
+				"S3PartSize":             fmt.Sprintf("%d", 5<<20),
+				"S3Tags":                 "domain={domain}",
+			},
+			object: map[string]string{
+				"X-Amz-Server-Side-Encryption-Customer-Algorithm": "AES256",
+				"X-Amz-Server-Side-Encryption-Customer-Key":       customerKey,
+				"X-Amz-Tagging": "domain=domain",
+			},
+			part: map[string]string{
+				"X-Amz-Server-Side-Encryption-Customer-Algorithm": "AES256",
+				"X-Amz-Server-Side-Encryption-Customer-Key":       customerKey,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := newFakeS3()
+			w := prepareS3(t, fake, test.settings)
+
+			if err := w.Write("capability:domain:service:application", &countedReader{Reader: bytes.NewReader(data), records: 42}); err != nil {
+				t.Fatalf("Error writing to S3: %s", err)
+			}
+
+			if len(fake.headers) != 1 {
+				t.Fatalf("Expected one object, got %d", len(fake.headers))
+			}
+
+			for _, headers := range fake.headers {
+				for k, v := range test.object {
+					if headers.Get(k) != v {
+						t.Errorf("Expected object header %s %q, got %q", k, v, headers.Get(k))
+					}
+				}
+			}
+
+			if len(test.part) > 0 && len(fake.parts) < 2 {
+				t.Fatalf("Expected a multipart upload, got %d parts", len(fake.parts))
+			}
+
+			for _, headers := range fake.parts {
+				for k, v := range test.part {
+					if headers.Get(k) != v {
+						t.Errorf("Expected part header %s %q, got %q", k, v, headers.Get(k))
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestS3ObjectOptionsInvalid(t *testing.T) {
+	tests := map[string]map[string]string{
+		"encryption":    {"S3ServerSideEncryption": "aes"},
+		"customer key":  {"S3ServerSideEncryption": config.S3ServerSideEncryptionCustomer, "S3SSECustomerKey": "short"},
+		"storage class": {"S3StorageClass": "cold"},
+		"tag":           {"S3Tags": "classification"},
+		"tag template":  {"S3Tags": "owner={owner}"},
+		"field":         {"S3Metadata": "level={field:level}"},
+		"too many tags": {"S3Tags": "a=1,b=2,c=3,d=4,e=5,f=6,g=7,h=8,i=9,j=10,k=11"},
+	}
+
+	for name, settings := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := &config.Config{WriterType: config.WriterTypeAWSS3}
+			cfg.Set(settings)
+
+			if writer.New(context.Background(), cfg) != nil {
+				t.Error("Expected no writer with invalid object options")
+			}
+		})
+	}
+}
+
+func TestS3CheckBucket(t *testing.T) {
+	tests := []struct {
+		name     string
+		bucket   int
+		deny     string
+		settings map[string]string
+		kind     string
+		created  bool
+	}{
+		{name: "ready"},
+		{name: "not found", bucket: http.StatusNotFound, kind: writer.ErrorKindNotFound},
+		{name: "create", bucket: http.StatusNotFound, settings: map[string]string{"S3CreateBucket": "true"}, created: true},
+		{name: "forbidden", bucket: http.StatusForbidden, settings: map[string]string{"S3CreateBucket": "true"}, kind: writer.ErrorKindForbidden},
+		{name: "probe denied", deny: "checks/", settings: map[string]string{"S3ProbePrefix": "checks/"}, kind: writer.ErrorKindForbidden},
+		{name: "network", kind: writer.ErrorKindNetwork},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := newFakeS3()
+			fake.bucket = test.bucket
+			fake.deny = test.deny
+
+			server := httptest.NewServer(fake)
+			defer server.Close()
+
+			if test.kind == writer.ErrorKindNetwork {
+				server.Close()
+			}
+
+			settings := map[string]string{"S3MaxAttempts": "1"}
+			for k, v := range test.settings {
+				settings[k] = v
+			}
+
+			w, err := newS3(server.URL, settings)
+
+			if writer.ErrorKind(err) != test.kind && (err != nil || len(test.kind) > 0) {
+				t.Fatalf("Expected %q error, got %v", test.kind, err)
+			}
+
+			if w.IsReady() != (err == nil) || writer.ErrorKind(w.Status()) != writer.ErrorKind(err) {
+				t.Errorf("Expected the writer status to be %v, got %v", err, w.Status())
+			}
+
+			if fake.created != test.created {
+				t.Errorf("Expected bucket created %v, got %v", test.created, fake.created)
+			}
+
+			if err == nil && (len(fake.deleted) != 1 || !strings.HasPrefix(fake.deleted[0], "_data2parquet/.probe-") || len(fake.objects) != 0) {
+				t.Errorf("Expected the probe object to be written and deleted, got %v", fake.deleted)
+			}
+		})
+	}
+}
+
+func TestS3StatusRecheck(t *testing.T) {
+	fake := newFakeS3()
+	w := prepareS3(t, fake, map[string]string{"S3MaxAttempts": "1", "S3ProbePrefix": "checks/", "WriterPathTemplate": "data/{id}.parquet", "WriterStatusInterval": "100"})
+
+	fake.mu.Lock()
+	fake.deny = "data/"
+	fake.mu.Unlock()
+
+	if err := w.Write("capability:domain:service:application", bytes.NewReader(randomData(1024))); writer.ErrorKind(err) != writer.ErrorKindForbidden {
+		t.Fatalf("Expected a forbidden write, got %v", err)
+	}
+
+	fake.mu.Lock()
+	fake.deny = ""
+	fake.mu.Unlock()
+
+	if w.IsReady() || fake.checked != 0 {
+		t.Errorf("Expected the writer not to be ready until WriterStatusInterval, checked %d times", fake.checked)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+
+	if !w.IsReady() || w.Status() != nil {
+		t.Errorf("Expected the writer to be ready after a new check, got %v", w.Status())
+	}
+}
+
+// fakeStorage is the part shared by the fakes of the GCS and Azure Blob APIs: requests are served one at a time with
+// their body, objects are kept by name, and fail answers every storage request with its status, as a missing or
+// denied bucket.
+type fakeStorage struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	fail    int
+}
+
+// serve starts a server calling handle with the lock held, closed at the end of the test or right away with closed,
+// to get network errors.
+func (f *fakeStorage) serve(t *testing.T, closed bool, handle func(w http.ResponseWriter, r *http.Request, body []byte)) string {
+	if f.objects == nil {
+		f.objects = make(map[string][]byte)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		handle(w, r, body)
+	}))
+
+	t.Cleanup(server.Close)
+
+	if closed {
+		server.Close()
+	}
+
+	return server.URL
+}
+
+// failingReader returns data then an error, as a conversion failing in the middle of a file.
+type failingReader struct {
+	data io.Reader
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	n, err := r.data.Read(p)
+
+	if err == io.EOF {
+		return n, fmt.Errorf("conversion failed")
+	}
+
+	return n, err
+}
+
+func newFile(t *testing.T, settings map[string]string) (writer.Writer, string) {
+	path := t.TempDir()
+	cfg := &config.Config{}
+	values := map[string]string{
+		"RecordType":     config.RecordTypeLog,
+		"WriterType":     config.WriterTypeFile,
+		"WriterFilePath": path,
+	}
+
+	for k, v := range settings {
+		values[k] = v
+	}
+
+	if err := cfg.Set(values); err != nil {
+		t.Fatalf("Error setting config: %s", err)
+	}
+
+	cfg.SetDefaults()
+
+	w := writer.New(context.Background(), cfg)
+
+	if w == nil {
+		return nil, path
+	}
+
+	if err := w.Init(); err != nil {
+		t.Fatalf("Error initializing file writer: %s", err)
+	}
+
+	return w, path
+}
+
+// walkFiles returns the files under path by their relative names.
+func walkFiles(t *testing.T, path string) map[string]os.FileInfo {
+	ret := make(map[string]os.FileInfo)
+
+	filepath.Walk(path, func(name string, info os.FileInfo, err error) error {
+		if err == nil && name != path {
+			rel, _ := filepath.Rel(path, name)
+			ret[rel] = info
+		}
+		return err
+	})
+
+	return ret
+}
+
+func TestPathTemplateFieldValues(t *testing.T) {
+	template, err := writer.NewPathTemplate("{field:env}/{id}.parquet")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tm := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	expected := map[string]string{
+		"prod":        "prod/id.parquet",
+		"":            "unknown/id.parquet",
+		".":           "%2E/id.parquet",
+		"..":          "%2E%2E/id.parquet",
+		"../../etc":   "..%2F..%2Fetc/id.parquet",
+		"a\\..\\b":    "a%5C..%5Cb/id.parquet",
+		"line\nbreak": "line%0Abreak/id.parquet",
+		"100%":        "100%25/id.parquet",
+		"v1.2":        "v1.2/id.parquet",
+	}
+
+	for value, path := range expected {
+		info := domain.NewRecordInfoFromKey(config.RecordTypeLog, domain.PartitionKey("capability:domain:service:application", tm, map[string]string{"env": value}))
+
+		if got := template.Render(info, "id", "", 0); got != path {
+			t.Errorf("Field value %q rendered as %q, expected %q", value, got, path)
+		}
+	}
+}
+
+func TestFileAtomicWrite(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+	}{
+		{name: "known path", template: "{service}/{id}.parquet"},
+		{name: "hashed path", template: "{service}/{id}-{hash}-{records}.parquet"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w, path := newFile(t, map[string]string{
+				"WriterPathTemplate": test.template,
+				"WriterFileMode":     "0640",
+				"WriterDirMode":      "0750",
+			})
+			data := randomData(64 << 10)
+
+			if err := w.Write("capability:domain:service:application", &countedReader{bytes.NewReader(data), 10}); err != nil {
+				t.Fatalf("Error writing file: %s", err)
+			}
+
+			if err := w.Write("capability:domain:service:application", &failingReader{bytes.NewReader(data)}); err == nil {
+				t.Fatalf("Expected the failed conversion to fail the write")
+			}
+
+			files := walkFiles(t, path)
+
+			if len(files) != 2 || files["service"] == nil || files["service"].Mode().Perm() != 0750 {
+				t.Fatalf("Expected only the service directory with 0750 and one file, got %v", files)
+			}
+
+			for name, info := range files {
+				if info.IsDir() {
+					continue
+				}
+
+				if strings.HasPrefix(filepath.Base(name), ".") || !strings.HasSuffix(name, ".parquet") {
+					t.Errorf("Expected no temporary file to be left, got %s", name)
+				}
+
+				if info.Mode().Perm() != 0640 {
+					t.Errorf("Expected file mode 0640, got %o", info.Mode().Perm())
+				}
+
+				written, _ := os.ReadFile(filepath.Join(path, name))
+
+				if !bytes.Equal(written, data) {
+					t.Errorf("Expected the file to have the written data")
+				}
+			}
+		})
+	}
+}
+
+func TestFileMarkers(t *testing.T) {
+	w, path := newFile(t, map[string]string{"WriterPathTemplate": "{service}/{id}.parquet", "WriterFileMarker": "manifest"})
+
+	for i := 1; i <= 2; i++ {
+		if err := w.Write("capability:domain:service:application", &countedReader{bytes.NewReader(randomData(i * 1024)), i}); err != nil {
+			t.Fatalf("Error writing file: %s", err)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(path, "service", "_manifest.json"))
+
+	if err != nil {
+		t.Fatalf("Error reading manifest: %s", err)
+	}
+
+	manifest := struct {
+		Files []struct {
+			Name    string `json:"name"`
+			Size    int64  `json:"size"`
+			Records int    `json:"records"`
+			MD5     string `json:"md5"`
+		} `json:"files"`
+	}{}
+
+	if err = json.Unmarshal(data, &manifest); err != nil || len(manifest.Files) != 2 {
+		t.Fatalf("Expected a manifest with two files, got %s", data)
+	}
+
+	for i, file := range manifest.Files {
+		written, _ := os.ReadFile(filepath.Join(path, "service", file.Name))
+		sum := md5.Sum(written)
+
+		if file.Size != int64((i+1)*1024) || file.Records != i+1 || file.MD5 != hex.EncodeToString(sum[:]) {
+			t.Errorf("Expected the manifest entry to describe %s, got %+v", file.Name, file)
+		}
+	}
+
+	w, path = newFile(t, map[string]string{"WriterPathTemplate": "{service}/{id}.parquet", "WriterFileMarker": "success"})
+
+	if err = w.Write("capability:domain:service:application", bytes.NewReader(randomData(1024))); err != nil {
+		t.Fatalf("Error writing file: %s", err)
+	}
+
+	if _, err = os.Stat(filepath.Join(path, "service", "_SUCCESS")); err != nil {
+		t.Errorf("Expected the _SUCCESS marker, got %s", err)
+	}
+
+	// A non empty directory where the marker goes makes it fail, the data file is kept and Write succeeds.
+	w, path = newFile(t, map[string]string{"WriterPathTemplate": "{service}/{id}.parquet", "WriterFileMarker": "success"})
+
+	if err = os.MkdirAll(filepath.Join(path, "service", "_SUCCESS", "blocked"), 0755); err != nil {
+		t.Fatalf("Error creating directory: %s", err)
+	}
+
+	if err = w.Write("capability:domain:service:application", bytes.NewReader(randomData(1024))); err != nil {
+		t.Errorf("Expected no error when only the marker fails, got %s", err)
+	}
+
+	if files, _ := filepath.Glob(filepath.Join(path, "service", "*.parquet")); len(files) != 1 {
+		t.Errorf("Expected the data file to be kept, got %v", files)
+	}
+
+	for _, settings := range []map[string]string{{"WriterFileMarker": "done"}, {"WriterFileMode": "rw-r--r--"}, {"WriterDirMode": "0999"}} {
+		if w, _ = newFile(t, settings); w != nil {
+			t.Errorf("Expected no writer with invalid options %v", settings)
+		}
+	}
+}
+
+func newFanOut(targets string, settings map[string]string) writer.Writer {
+	cfg := &config.Config{}
+	values := map[string]string{
+		"RecordType":    config.RecordTypeLog,
+		"WriterType":    config.WriterTypeFanOut,
+		"WriterTargets": targets,
+	}
+
+	for k, v := range settings {
+		values[k] = v
+	}
+
+	cfg.Set(values)
+	cfg.SetDefaults()
+
+	return writer.New(context.Background(), cfg)
+}
+
+func TestFanOutPolicies(t *testing.T) {
+	primary := t.TempDir()
+	blocked := filepath.Join(t.TempDir(), "blocked")
+	mirror := filepath.Join(blocked, "out")
+
+	// A regular file where the mirror directory goes makes its writes fail until it is removed.
+	if err := os.WriteFile(blocked, []byte{}, 0644); err != nil {
+		t.Fatalf("Error creating file: %s", err)
+	}
+
+	targets := fmt.Sprintf(`[{"name":"primary","settings":{"WriterFilePath":%q}},{"name":"mirror","policy":"best-effort","max_attempts":2,"settings":{"WriterFilePath":%q}}]`, primary, mirror)
+	w := newFanOut(targets, map[string]string{"WriterPathTemplate": "{service}/{id}-{records}.parquet"})
+
+	if w == nil {
+		t.Fatalf("Expected a fan-out writer")
+	}
+
+	if err := w.Init(); err != nil {
+		t.Fatalf("Error initializing fan-out writer: %s", err)
+	}
+
+	data := randomData(32 << 10)
+
+	if err := w.Write("capability:domain:service:application", &countedReader{bytes.NewReader(data), 3}); err != nil {
+		t.Fatalf("Expected best-effort failures to be ignored, got %s", err)
+	}
+
+	files := walkFiles(t, filepath.Join(primary, "service"))
+
+	if len(files) != 1 {
+		t.Fatalf("Expected one file in the primary target, got %d", len(files))
+	}
+
+	for name := range files {
+		written, _ := os.ReadFile(filepath.Join(primary, "service", name))
+
+		if !strings.HasSuffix(name, "-3.parquet") || !bytes.Equal(written, data) {
+			t.Errorf("Expected the data with 3 records, got %s with %d bytes", name, len(written))
+		}
+	}
+
+	stats := w.(*writer.FanOut).Targets()
+
+	if stats[1].Failures != 1 || stats[1].Retries != 1 || stats[1].ConsecutiveFailures != 1 || len(stats[1].LastError) == 0 {
+		t.Errorf("Expected a failure after a retry on the mirror, got %+v", stats[1])
+	}
+
+	if stats[0].Writes != 1 || stats[0].Failures != 0 {
+		t.Errorf("Expected a write on the primary, got %+v", stats[0])
+	}
+
+	os.Remove(blocked)
+
+	if err := w.Write("capability:domain:service:application", &countedReader{bytes.NewReader(data), 3}); err != nil {
+		t.Fatalf("Error writing file: %s", err)
+	}
+
+	stats = w.(*writer.FanOut).Targets()
+
+	if stats[1].Writes != 1 || stats[1].Recoveries != 1 || stats[1].ConsecutiveFailures != 0 {
+		t.Errorf("Expected the mirror to recover, got %+v", stats[1])
+	}
+
+	if len(walkFiles(t, filepath.Join(mirror, "service"))) != 1 || len(walkFiles(t, filepath.Join(primary, "service"))) != 2 {
+		t.Errorf("Expected the second file in both targets")
+	}
+
+	targets = fmt.Sprintf(`[{"name":"primary","settings":{"WriterFilePath":%q}},{"name":"mirror","max_attempts":1,"settings":{"WriterFilePath":%q}}]`, primary, filepath.Join(primary, "service"))
+	w = newFanOut(targets, map[string]string{"WriterPathTemplate": "{service}/{id}.parquet"})
+	os.WriteFile(filepath.Join(primary, "service", "service"), []byte{}, 0644)
+
+	if err := w.Write("capability:domain:service:application", bytes.NewReader(data)); err == nil || !strings.Contains(err.Error(), "writer target mirror") {
+		t.Errorf("Expected the failure of the required mirror, got %v", err)
+	}
+
+	// The replay of the same file only writes it to the mirror, the primary already stored it.
+	before, _ := filepath.Glob(filepath.Join(primary, "service", "*.parquet"))
+	os.Remove(filepath.Join(primary, "service", "service"))
+
+	if err := w.Write("capability:domain:service:application", bytes.NewReader(data)); err != nil {
+		t.Fatalf("Error replaying file: %s", err)
+	}
+
+	after, _ := filepath.Glob(filepath.Join(primary, "service", "*.parquet"))
+	mirrored, _ := filepath.Glob(filepath.Join(primary, "service", "service", "*.parquet"))
+
+	if len(after) != len(before) || len(mirrored) != 1 {
+		t.Errorf("Expected the replay only on the mirror, got %d files on the primary (%d before) and %d on the mirror", len(after), len(before), len(mirrored))
+	}
+
+	stats = w.(*writer.FanOut).Targets()
+
+	if stats[0].Writes != 1 || stats[1].Writes != 1 {
+		t.Errorf("Expected one write on each target, got %+v", stats)
+	}
+}
+
+func TestFanOutReadiness(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":{"message":"denied"}}`, http.StatusForbidden)
+	}))
+	defer server.Close()
+	t.Setenv("STORAGE_EMULATOR_HOST", strings.TrimPrefix(server.URL, "http://"))
+
+	for _, policy := range []string{"required", "best-effort"} {
+		t.Run(policy, func(t *testing.T) {
+			targets := fmt.Sprintf(`[{"name":"local","settings":{"WriterFilePath":%q}},{"name":"gcs","policy":%q,"settings":{"WriterType":"gcs","GCSBucketName":"bucket"}}]`, t.TempDir(), policy)
+			w := newFanOut(targets, nil)
+			err := w.Init()
+
+			if policy == "required" {
+				if err == nil || !strings.Contains(err.Error(), "writer target gcs") {
+					t.Errorf("Expected the init error of the gcs target, got %v", err)
+				}
+
+				if w.IsReady() || w.Status() == nil {
+					t.Errorf("Expected the writer not to be ready")
+				}
+
+				return
+			}
+
+			if err != nil || !w.IsReady() {
+				t.Errorf("Expected best-effort targets not to affect readiness, got %v", err)
+			}
+
+			stats := w.(*writer.FanOut).Targets()
+
+			if !stats[0].Ready || stats[1].Ready || len(stats[1].LastError) == 0 {
+				t.Errorf("Expected the gcs target not to be ready, got %+v", stats)
+			}
+		})
+	}
+
+	invalid := []string{
+		`[]`,
+		`[{"name":"loop","settings":{"WriterType":"fan-out"}}]`,
+		`[{"name":"bad","policy":"sometimes","settings":{}}]`,
+		`[{"name":"broken"`,
+	}
+
+	for _, targets := range invalid {
+		if w := newFanOut(targets, nil); w != nil {
+			t.Errorf("Expected no writer with targets %s", targets)
+		}
+	}
+}
+
+// tableRow is a record of the parquet files committed to tables.
+type tableRow struct {
+	Service string  `parquet:"name=service, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Level   *string `parquet:"name=level, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	Count   int64   `parquet:"name=count, type=INT64"`
+}
+
+func parquetData(t *testing.T, rows int) []byte {
+	buf := &bytes.Buffer{}
+	pw, err := pwriter.NewParquetWriterFromWriter(buf, new(tableRow), 1)
+
+	if err != nil {
+		t.Fatalf("Error creating parquet writer: %s", err)
+	}
+
+	for i := 0; i < rows; i++ {
+		level := "info"
+		pw.Write(tableRow{Service: "service", Level: &level, Count: int64(i)})
+	}
+
+	if err = pw.WriteStop(); err != nil {
+		t.Fatalf("Error writing parquet: %s", err)
+	}
+
+	return buf.Bytes()
+}
+
+// icebergMetadata has the parts of the table metadata checked by the tests.
+type icebergMetadata struct {
+	CurrentSnapshotID int64 `json:"current-snapshot-id"`
+	Schemas           []struct {
+		Fields []struct {
+			ID   int    `json:"id"`
+			Name string `json:"name"`
+		} `json:"fields"`
+	} `json:"schemas"`
+	Snapshots []struct {
+		SnapshotID       int64             `json:"snapshot-id"`
+		ParentSnapshotID *int64            `json:"parent-snapshot-id"`
+		Summary          map[string]string `json:"summary"`
+		ManifestList     string            `json:"manifest-list"`
+	} `json:"snapshots"`
+	PartitionSpecs []struct {
+		Fields []struct {
+			Name      string `json:"name"`
+			Transform string `json:"transform"`
+			SourceID  int    `json:"source-id"`
+		} `json:"fields"`
+	} `json:"partition-specs"`
+	MetadataLog []json.RawMessage `json:"metadata-log"`
+	Properties  map[string]string `json:"properties"`
+}
+
+func readIcebergMetadata(t *testing.T, data []byte) *icebergMetadata {
+	ret := &icebergMetadata{}
+
+	if err := json.Unmarshal(data, ret); err != nil {
+		t.Fatalf("Invalid iceberg metadata: %s", err)
+	}
+
+	return ret
+}
+
+func TestIcebergFileCommits(t *testing.T) {
+	w, path := newFile(t, map[string]string{
+		"WriterTableFormat":  config.WriterTableFormatIceberg,
+		"WriterTablePath":    "events",
+		"WriterPathTemplate": "data/{service}/{id}.parquet",
+	})
+
+	for i := 0; i < 2; i++ {
+		if err := w.Write("capability:domain:service:application", &countedReader{bytes.NewReader(parquetData(t, 10)), 10}); err != nil {
+			t.Fatalf("Error writing file: %s", err)
+		}
+	}
+
+	if err := w.Write("capability:domain:service:application", bytes.NewReader(randomData(1024))); err == nil {
+		t.Errorf("Expected a file that is not parquet not to be committed")
+	}
+
+	table := filepath.Join(path, "events")
+	data := walkFiles(t, filepath.Join(table, "data", "service"))
+
+	if len(data) != 2 {
+		t.Fatalf("Expected the two committed data files, got %d", len(data))
+	}
+
+	if hint, _ := os.ReadFile(filepath.Join(table, "metadata", "version-hint.text")); string(hint) != "2" {
+		t.Errorf("Expected version hint 2, got %q", hint)
+	}
+
+	content, err := os.ReadFile(filepath.Join(table, "metadata", "v2.metadata.json"))
+
+	if err != nil {
+		t.Fatalf("Error reading metadata: %s", err)
+	}
+
+	meta := readIcebergMetadata(t, content)
+
+	if len(meta.Snapshots) != 2 || meta.CurrentSnapshotID != meta.Snapshots[1].SnapshotID || meta.Snapshots[1].ParentSnapshotID == nil || *meta.Snapshots[1].ParentSnapshotID != meta.Snapshots[0].SnapshotID {
+		t.Fatalf("Expected two chained snapshots, got %+v", meta.Snapshots)
+	}
+
+	if summary := meta.Snapshots[1].Summary; summary["total-records"] != "20" || summary["total-data-files"] != "2" || summary["added-records"] != "10" {
+		t.Errorf("Expected the totals of both files, got %v", summary)
+	}
+
+	if len(meta.Schemas) != 1 || len(meta.Schemas[0].Fields) != 8 || meta.Schemas[0].Fields[0].Name != "service" || meta.Schemas[0].Fields[2].ID != 3 || meta.Schemas[0].Fields[3].Name != "capability" {
+		t.Errorf("Expected the schema of the parquet files and the partition columns, got %+v", meta.Schemas)
+	}
+
+	if spec := meta.PartitionSpecs[0].Fields; len(spec) != 5 || spec[0].Name != "capability" || spec[0].SourceID != 4 || spec[4].Name != "hour" || spec[4].Transform != "identity" {
+		t.Errorf("Expected the identity of the record info as partition spec, got %+v", meta.PartitionSpecs)
+	}
+
+	if !strings.Contains(meta.Properties["schema.name-mapping.default"], `"level"`) {
+		t.Errorf("Expected a name mapping of the columns, got %v", meta.Properties)
+	}
+
+	list, err := os.ReadFile(strings.TrimPrefix(meta.Snapshots[1].ManifestList, "file://"))
+
+	if err != nil || !bytes.HasPrefix(list, []byte("Obj\x01")) {
+		t.Fatalf("Expected an avro manifest list, got %v", err)
+	}
+
+	if manifests := strings.Count(string(list), "-m0.avro"); manifests != 2 {
+		t.Errorf("Expected the manifests of both snapshots in the list, got %d", manifests)
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(table, "metadata", "*-m0.avro"))
+	found := 0
+
+	for _, match := range matches {
+		manifest, _ := os.ReadFile(match)
+
+		for name := range data {
+			if bytes.Contains(manifest, []byte("file://"+filepath.ToSlash(filepath.Join(table, "data", "service", name)))) {
+				found++
+			}
+		}
+	}
+
+	if len(matches) != 2 || found != 2 {
+		t.Errorf("Expected a manifest of each data file, got %d manifests with %d files", len(matches), found)
+	}
+}
+
+func TestIcebergMaintenance(t *testing.T) {
+	w, path := newFile(t, map[string]string{
+		"WriterTableFormat":  config.WriterTableFormatIceberg,
+		"WriterPathTemplate": "data/{id}.parquet",
+	})
+	data := parquetData(t, 3)
+
+	if err := w.Write("capability:domain:service:application", bytes.NewReader(data)); err != nil {
+		t.Fatalf("Error writing file: %s", err)
+	}
+
+	// Properties set on the table by other engines are honored by the next commits.
+	first := filepath.Join(path, "metadata", "v1.metadata.json")
+	content, _ := os.ReadFile(first)
+	table := make(map[string]interface{})
+	json.Unmarshal(content, &table)
+	table["properties"].(map[string]interface{})["commit.manifest.min-count-to-merge"] = "3"
+	table["properties"].(map[string]interface{})["write.metadata.previous-versions-max"] = "2"
+	content, _ = json.Marshal(table)
+	os.WriteFile(first, content, 0644)
+
+	for i := 0; i < 3; i++ {
+		if err := w.Write("capability:domain:service:application", bytes.NewReader(data)); err != nil {
+			t.Fatalf("Error writing file: %s", err)
+		}
+	}
+
+	content, err := os.ReadFile(filepath.Join(path, "metadata", "v4.metadata.json"))
+
+	if err != nil {
+		t.Fatalf("Error reading metadata: %s", err)
+	}
+
+	meta := readIcebergMetadata(t, content)
+	last := meta.Snapshots[len(meta.Snapshots)-1]
+
+	if len(meta.Snapshots) != 2 || len(meta.MetadataLog) != 2 || last.Summary["total-data-files"] != "4" || last.Summary["total-records"] != "12" {
+		t.Errorf("Expected the last two snapshots with the four files, got %d snapshots and %d metadata files", len(meta.Snapshots), len(meta.MetadataLog))
+	}
+
+	list, _ := os.ReadFile(strings.TrimPrefix(last.ManifestList, "file://"))
+
+	if strings.Count(string(list), "-m0.avro") != 1 || strings.Count(string(list), "-m1.avro") != 1 {
+		t.Errorf("Expected the manifest of the file and a merged manifest in the list")
+	}
+
+	lists, _ := filepath.Glob(filepath.Join(path, "metadata", "snap-*.avro"))
+	manifests, _ := filepath.Glob(filepath.Join(path, "metadata", "*-m*.avro"))
+
+	if len(lists) != 2 || len(manifests) != 4 {
+		t.Errorf("Expected the files of expired snapshots to be removed, got %d manifest lists and %d manifests", len(lists), len(manifests))
+	}
+
+	merged := regexp.MustCompile(`file://[^\x00-\x20]*-m1\.avro`).Find(list)
+	manifest, _ := os.ReadFile(strings.TrimPrefix(string(merged), "file://"))
+	found := 0
+
+	for name := range walkFiles(t, filepath.Join(path, "data")) {
+		if bytes.Contains(manifest, []byte(filepath.ToSlash(filepath.Join(path, "data", name)))) {
+			found++
+		}
+	}
+
+	if !bytes.Contains(manifest, []byte(`{"name":"capability","type":["null","string"],"default":null,"field-id":1000}`)) {
+		t.Errorf("Expected the partition fields in the merged manifest")
+	}
+
+	if found != 3 {
+		t.Errorf("Expected the three previous files in the merged manifest, got %d", found)
+	}
+}
+
+func TestIcebergConcurrentCommits(t *testing.T) {
+	shared := t.TempDir()
+	settings := map[string]string{
+		"WriterFilePath":     shared,
+		"WriterTableFormat":  config.WriterTableFormatIceberg,
+		"WriterPathTemplate": "data/{id}.parquet",
+	}
+	writers := make([]writer.Writer, 2)
+
+	for i := range writers {
+		writers[i], _ = newFile(t, settings)
+	}
+
+	data := parquetData(t, 5)
+	wg := sync.WaitGroup{}
+
+	for _, w := range writers {
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+
+			go func(w writer.Writer) {
+				defer wg.Done()
+
+				if err := w.Write("capability:domain:service:application", bytes.NewReader(data)); err != nil {
+					t.Errorf("Error writing file: %s", err)
+				}
+			}(w)
+		}
+	}
+
+	wg.Wait()
+
+	if _, err := os.Stat(filepath.Join(shared, "metadata", "v9.metadata.json")); err == nil {
+		t.Errorf("Expected one version for each file")
+	}
+
+	content, err := os.ReadFile(filepath.Join(shared, "metadata", "v8.metadata.json"))
+
+	if err != nil {
+		t.Fatalf("Error reading metadata: %s", err)
+	}
+
+	meta := readIcebergMetadata(t, content)
+
+	if len(meta.Snapshots) != 8 || meta.Snapshots[7].Summary["total-data-files"] != "8" || meta.Snapshots[7].Summary["total-records"] != "40" {
+		t.Errorf("Expected the files of both writers in the table, got %d snapshots", len(meta.Snapshots))
+	}
+}
+
+func TestIcebergS3Commits(t *testing.T) {
+	fake := newFakeS3()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	settings := map[string]string{
+		"WriterTableFormat":  config.WriterTableFormatIceberg,
+		"WriterTablePath":    "/tables/events/",
+		"WriterPathTemplate": "data/{id}.parquet",
+	}
+	writers := make([]writer.Writer, 2)
+
+	for i := range writers {
+		var err error
+
+		if writers[i], err = newS3(server.URL, settings); err != nil {
+			t.Fatalf("Error initializing S3 writer: %s", err)
+		}
+	}
+
+	data := parquetData(t, 7)
+	wg := sync.WaitGroup{}
+
+	for _, w := range writers {
+		for i := 0; i < 3; i++ {
+			wg.Add(1)
+
+			go func(w writer.Writer) {
+				defer wg.Done()
+
+				if err := w.Write("capability:domain:service:application", bytes.NewReader(data)); err != nil {
+					t.Errorf("Error writing file: %s", err)
+				}
+			}(w)
+		}
+	}
+
+	wg.Wait()
+
+	fake.mu.Lock()
+	fake.deleted = nil
+	fake.mu.Unlock()
+
+	if err := writers[0].Write("capability:domain:service:application", bytes.NewReader(randomData(1024))); err == nil {
+		t.Errorf("Expected a file that is not parquet not to be committed")
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	if len(fake.deleted) != 1 || !strings.HasPrefix(fake.deleted[0], "tables/events/data/") {
+		t.Errorf("Expected the file that failed to commit to be deleted, got %v", fake.deleted)
+	}
+
+	if _, ok := fake.objects["tables/events/metadata/v7.metadata.json"]; ok {
+		t.Errorf("Expected one version for each file")
+	}
+
+	content, ok := fake.objects["tables/events/metadata/v6.metadata.json"]
+
+	if !ok {
+		t.Fatalf("Expected a version for each file")
+	}
+
+	meta := readIcebergMetadata(t, content)
+	last := meta.Snapshots[len(meta.Snapshots)-1]
+
+	if len(meta.Snapshots) != 6 || last.Summary["total-records"] != "42" || !strings.HasPrefix(last.ManifestList, "s3://bucket/tables/events/metadata/snap-") {
+		t.Errorf("Expected the files of both writers in the table, got %d snapshots and list %s", len(meta.Snapshots), last.ManifestList)
+	}
+
+	list, ok := fake.objects[strings.TrimPrefix(last.ManifestList, "s3://bucket/")]
+
+	if !ok || strings.Count(string(list), "s3://bucket/tables/events/metadata/") != 6 {
+		t.Errorf("Expected the manifests of all snapshots in the list")
+	}
+}
+
+// deltaLog reads the actions of a delta commit by their type.
+func deltaLog(t *testing.T, data []byte) map[string][]map[string]interface{} {
+	ret := make(map[string][]map[string]interface{})
+
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		action := make(map[string]map[string]interface{})
+
+		if err := json.Unmarshal([]byte(line), &action); err != nil {
+			t.Fatalf("Invalid delta commit line %s: %s", line, err)
+		}
+
+		for k, v := range action {
+			ret[k] = append(ret[k], v)
+		}
+	}
+
+	return ret
+}
+
+func TestDeltaFileCommits(t *testing.T) {
+	settings := map[string]string{
+		"WriterTableFormat":  config.WriterTableFormatDelta,
+		"WriterTablePath":    "events",
+		"WriterPathTemplate": "service={service}/dt={date}/{id}.parquet",
+	}
+	w, path := newFile(t, settings)
+	data := parquetData(t, 5)
+
+	for i := 0; i < 11; i++ {
+		if err := w.Write("capability:domain:service:application", bytes.NewReader(data)); err != nil {
+			t.Fatalf("Error writing file: %s", err)
+		}
+	}
+
+	if err := w.Write("capability:domain:service:application", bytes.NewReader(randomData(1024))); err == nil {
+		t.Errorf("Expected a file that is not parquet not to be committed")
+	}
+
+	log := filepath.Join(path, "events", "_delta_log")
+	first, err := os.ReadFile(filepath.Join(log, "00000000000000000000.json"))
+
+	if err != nil {
+		t.Fatalf("Error reading first commit: %s", err)
+	}
+
+	actions := deltaLog(t, first)
+
+	if len(actions["protocol"]) != 1 || len(actions["metaData"]) != 1 || len(actions["add"]) != 1 || len(actions["commitInfo"]) != 1 {
+		t.Fatalf("Expected protocol, metadata, commit info and add actions, got %v", actions)
+	}
+
+	meta := actions["metaData"][0]
+	schema := meta["schemaString"].(string)
+
+	if fmt.Sprint(meta["partitionColumns"]) != "[capability year month day hour]" || strings.Count(schema, `"name":"service"`) != 1 || !strings.Contains(schema, `{"name":"year","type":"integer"`) || !strings.Contains(schema, `{"name":"count","type":"long"`) {
+		t.Errorf("Expected the file columns and the partition columns in the schema, got %v", meta)
+	}
+
+	// Partition values come from the record key and event time, not from the directories of the path.
+	add := actions["add"][0]
+	values := add["partitionValues"].(map[string]interface{})
+
+	if values["capability"] != "capability" || len(values["year"].(string)) != 4 || values["service"] != nil || values["dt"] != nil || !strings.HasPrefix(add["path"].(string), "service=service/dt=") {
+		t.Errorf("Expected the partition values of the record info, got %v", add)
+	}
+
+	stats := make(map[string]map[string]interface{})
+	json.Unmarshal([]byte(add["stats"].(string)), &stats)
+
+	if stats["minValues"]["count"] != float64(0) || stats["maxValues"]["count"] != float64(4) || stats["nullCount"]["level"] != float64(0) || stats["minValues"]["service"] != "service" || stats["minValues"]["year"] != nil {
+		t.Errorf("Expected the column statistics without partition columns, got %v", stats)
+	}
+
+	files := walkFiles(t, log)
+
+	if files["00000000000000000010.checkpoint.parquet"] == nil || files["00000000000000000011.json"] != nil {
+		t.Fatalf("Expected eleven commits and a checkpoint of version 10, got %v", files)
+	}
+
+	if last, _ := os.ReadFile(filepath.Join(log, "_last_checkpoint")); string(last) != `{"size":13,"version":10}` {
+		t.Errorf("Expected the last checkpoint with the protocol, metadata and eleven files, got %s", last)
+	}
+
+	// Writers without the commits of the checkpoint load the table from it.
+	for i := 0; i < 10; i++ {
+		os.Remove(filepath.Join(log, fmt.Sprintf("%020d.json", i)))
+	}
+
+	settings["WriterFilePath"] = path
+	w, _ = newFile(t, settings)
+
+	if err := w.Write("capability:domain:service:application", bytes.NewReader(data)); err != nil {
+		t.Fatalf("Error writing file after the checkpoint: %s", err)
+	}
+
+	next, err := os.ReadFile(filepath.Join(log, "00000000000000000011.json"))
+
+	if err != nil {
+		t.Fatalf("Error reading commit after the checkpoint: %s", err)
+	}
+
+	if actions = deltaLog(t, next); len(actions["metaData"]) != 0 || len(actions["protocol"]) != 0 || len(actions["add"]) != 1 {
+		t.Errorf("Expected only the new file after the checkpoint, got %v", actions)
+	}
+}
+
+// tableRowExtended is a record with a column tableRow does not have.
+type tableRowExtended struct {
+	Service string `parquet:"name=service, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Count   int64  `parquet:"name=count, type=INT64"`
+	Host    string `parquet:"name=host, type=BYTE_ARRAY, convertedtype=UTF8"`
+}
+
+func TestDeltaS3Commits(t *testing.T) {
+	fake := newFakeS3()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	settings := map[string]string{
+		"WriterTableFormat":  config.WriterTableFormatDelta,
+		"WriterTablePath":    "tables/events",
+		"WriterPathTemplate": "capability={capability}/{id}.parquet",
+	}
+	writers := make([]writer.Writer, 2)
+
+	for i := range writers {
+		var err error
+
+		if writers[i], err = newS3(server.URL, settings); err != nil {
+			t.Fatalf("Error initializing S3 writer: %s", err)
+		}
+	}
+
+	data := parquetData(t, 3)
+	wg := sync.WaitGroup{}
+
+	for _, w := range writers {
+		for i := 0; i < 3; i++ {
+			wg.Add(1)
+
+			go func(w writer.Writer) {
+				defer wg.Done()
+
+				if err := w.Write("capability:domain:service:application", bytes.NewReader(data)); err != nil {
+					t.Errorf("Error writing file: %s", err)
+				}
+			}(w)
+		}
+	}
+
+	wg.Wait()
+
+	buf := &bytes.Buffer{}
+	pw, _ := pwriter.NewParquetWriterFromWriter(buf, new(tableRowExtended), 1)
+	pw.Write(tableRowExtended{Service: "service", Count: 1, Host: "host"})
+	pw.WriteStop()
+
+	if err := writers[1].Write("capability:domain:service:application", bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("Error writing file with a new column: %s", err)
+	}
+
+	// A table created by another engine with partition columns files don't have can't be appended.
+	fake.mu.Lock()
+	fake.objects["tables/regions/_delta_log/00000000000000000000.json"] = []byte(`{"protocol":{"minReaderVersion":1,"minWriterVersion":2}}
+{"metaData":{"id":"regions","format":{"provider":"parquet","options":{}},"schemaString":"{\"type\":\"struct\",\"fields\":[{\"name\":\"region\",\"type\":\"string\",\"nullable\":true,\"metadata\":{}}]}","partitionColumns":["region"],"configuration":{}}}`)
+	fake.mu.Unlock()
+
+	other, err := newS3(server.URL, map[string]string{
+		"WriterTableFormat":  config.WriterTableFormatDelta,
+		"WriterTablePath":    "tables/regions",
+		"WriterPathTemplate": "{id}.parquet",
+	})
+
+	if err != nil {
+		t.Fatalf("Error initializing S3 writer: %s", err)
+	}
+
+	if err = other.Write("capability:domain:service:application", bytes.NewReader(data)); err == nil || !strings.Contains(err.Error(), "partitioned by region") {
+		t.Errorf("Expected a file without the partitions of the table not to be committed, got %v", err)
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	paths := make(map[string]bool)
+
+	for version := 0; version < 7; version++ {
+		commit, ok := fake.objects[fmt.Sprintf("tables/events/_delta_log/%020d.json", version)]
+
+		if !ok {
+			t.Fatalf("Expected a commit for each file, version %d is missing", version)
+		}
+
+		for _, add := range deltaLog(t, commit)["add"] {
+			paths[add["path"].(string)] = true
+
+			if _, ok := fake.objects["tables/events/"+add["path"].(string)]; !ok {
+				t.Errorf("Expected the data file %s to exist", add["path"])
+			}
+		}
+	}
+
+	if _, ok := fake.objects["tables/events/_delta_log/00000000000000000007.json"]; ok || len(paths) != 7 {
+		t.Errorf("Expected seven commits with a file each, got %d files", len(paths))
+	}
+
+	last := deltaLog(t, fake.objects["tables/events/_delta_log/00000000000000000006.json"])
+
+	if len(last["metaData"]) != 1 || !strings.HasSuffix(last["metaData"][0]["schemaString"].(string), `{"name":"host","type":"string","nullable":true,"metadata":{}}]}`) {
+		t.Errorf("Expected the new column at the end of the schema, got %v", last["metaData"])
+	}
+}